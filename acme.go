@@ -0,0 +1,455 @@
+// acme.go
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========================= ACME (automatic certificates) =========================
+
+// With -tls-host, the server gets and renews its own certificate from an
+// ACME CA (Let's Encrypt by default) using the HTTP-01 challenge: the CA
+// fetches http://HOST/.well-known/acme-challenge/TOKEN, so port 80 must
+// reach this process, which also redirects other port-80 traffic to HTTPS.
+// The account key, certificate, and its key are kept in ACME_DIR and
+// reused across restarts.
+
+const (
+	letsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+	// Renew when the certificate has less than this left.
+	acmeRenewBefore = 30 * 24 * time.Hour
+
+	// How long to wait for the CA to validate and issue.
+	acmePollTimeout = 2 * time.Minute
+)
+
+// acmeClient talks to the CA; a hung CA must not hang issuance forever.
+var acmeClient = &http.Client{Timeout: 30 * time.Second}
+
+type acmeManager struct {
+	host      string
+	email     string
+	dir       string // cache directory
+	directory string // ACME directory URL
+
+	key  *ecdsa.PrivateKey // account key
+	kid  string            // account URL
+	urls struct {
+		NewNonce   string `json:"newNonce"`
+		NewAccount string `json:"newAccount"`
+		NewOrder   string `json:"newOrder"`
+	}
+	nonce string
+
+	mu     sync.Mutex
+	cert   *tls.Certificate
+	tokens map[string]string // HTTP-01 token → key authorization
+}
+
+func newACMEManager(host, email, dir, directory string) *acmeManager {
+	if directory == "" {
+		directory = letsEncryptURL
+	}
+	return &acmeManager{host: host, email: email, dir: dir, directory: directory, tokens: map[string]string{}}
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// loadOrCreateKey reads an EC key from path, generating and saving one if
+// the file does not exist.
+func loadOrCreateKey(path string) (*ecdsa.PrivateKey, error) {
+	if b, err := os.ReadFile(path); err == nil {
+		blk, _ := pem.Decode(b)
+		if blk == nil {
+			return nil, fmt.Errorf("%s: no PEM data", path)
+		}
+		return x509.ParseECPrivateKey(blk.Bytes)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600)
+}
+
+// jwk is the account key as a JWK, members in the order RFC 7638 hashes.
+func (m *acmeManager) jwk() string {
+	pad := func(n *big.Int) string { return b64(n.FillBytes(make([]byte, 32))) }
+	return fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, pad(m.key.X), pad(m.key.Y))
+}
+
+func (m *acmeManager) keyAuthorization(token string) string {
+	h := sha256.Sum256([]byte(m.jwk()))
+	return token + "." + b64(h[:])
+}
+
+func (m *acmeManager) fetchNonce() error {
+	resp, err := acmeClient.Head(m.urls.NewNonce)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if m.nonce = resp.Header.Get("Replay-Nonce"); m.nonce == "" {
+		return errors.New("acme: no nonce from " + m.urls.NewNonce)
+	}
+	return nil
+}
+
+// post sends a JWS-signed request (payload nil: POST-as-GET) and decodes
+// the JSON response into out (if not nil). A badNonce rejection is retried
+// once with the fresh nonce.
+func (m *acmeManager) post(url string, payload any, out any) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		if m.nonce == "" {
+			if err := m.fetchNonce(); err != nil {
+				return nil, nil, err
+			}
+		}
+		protected := fmt.Sprintf(`{"alg":"ES256","nonce":%q,"url":%q,`, m.nonce, url)
+		if m.kid != "" {
+			protected += fmt.Sprintf(`"kid":%q}`, m.kid)
+		} else {
+			protected += `"jwk":` + m.jwk() + `}`
+		}
+		body := ""
+		if payload != nil {
+			b, err := json.Marshal(payload)
+			if err != nil {
+				return nil, nil, err
+			}
+			body = b64(b)
+		}
+		signingInput := b64([]byte(protected)) + "." + body
+		digest := sha256.Sum256([]byte(signingInput))
+		r, s, err := ecdsa.Sign(rand.Reader, m.key, digest[:])
+		if err != nil {
+			return nil, nil, err
+		}
+		sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		jws, _ := json.Marshal(map[string]string{"protected": b64([]byte(protected)), "payload": body, "signature": b64(sig)})
+
+		resp, err := acmeClient.Post(url, "application/jose+json", bytes.NewReader(jws))
+		if err != nil {
+			return nil, nil, err
+		}
+		raw, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		m.nonce = resp.Header.Get("Replay-Nonce")
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.StatusCode >= 400 {
+			var prob struct {
+				Type   string `json:"type"`
+				Detail string `json:"detail"`
+			}
+			json.Unmarshal(raw, &prob)
+			if prob.Type == "urn:ietf:params:acme:error:badNonce" && attempt == 0 {
+				continue
+			}
+			return nil, nil, fmt.Errorf("acme: %s: %s %s", resp.Status, prob.Type, prob.Detail)
+		}
+		if out != nil {
+			if err := json.Unmarshal(raw, out); err != nil {
+				return nil, nil, fmt.Errorf("acme: decoding %s: %w", url, err)
+			}
+		}
+		return resp, raw, nil
+	}
+}
+
+// register loads the directory and the account (created on first use).
+func (m *acmeManager) register() error {
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return err
+	}
+	key, err := loadOrCreateKey(filepath.Join(m.dir, "account.key"))
+	if err != nil {
+		return err
+	}
+	m.key = key
+	resp, err := acmeClient.Get(m.directory)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("acme directory: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&m.urls); err != nil {
+		return err
+	}
+	acct := map[string]any{"termsOfServiceAgreed": true}
+	if m.email != "" {
+		acct["contact"] = []string{"mailto:" + m.email}
+	}
+	ar, _, err := m.post(m.urls.NewAccount, acct, nil)
+	if err != nil {
+		return err
+	}
+	if m.kid = ar.Header.Get("Location"); m.kid == "" {
+		return errors.New("acme: no account URL")
+	}
+	return nil
+}
+
+type acmeOrder struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+}
+
+// poll POST-as-GETs url into out until done reports true or time runs out.
+func (m *acmeManager) poll(url string, out any, done func() (bool, error)) error {
+	deadline := time.Now().Add(acmePollTimeout)
+	for {
+		if _, _, err := m.post(url, nil, out); err != nil {
+			return err
+		}
+		ok, err := done()
+		if ok || err != nil {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("acme: timed out waiting for %s", url)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// authorize completes the HTTP-01 challenge of one authorization.
+func (m *acmeManager) authorize(authzURL string) error {
+	var authz struct {
+		Status     string `json:"status"`
+		Challenges []struct {
+			Type   string `json:"type"`
+			URL    string `json:"url"`
+			Token  string `json:"token"`
+			Status string `json:"status"`
+		} `json:"challenges"`
+	}
+	if _, _, err := m.post(authzURL, nil, &authz); err != nil {
+		return err
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+	for _, ch := range authz.Challenges {
+		if ch.Type != "http-01" {
+			continue
+		}
+		m.mu.Lock()
+		m.tokens[ch.Token] = m.keyAuthorization(ch.Token)
+		m.mu.Unlock()
+		defer func() {
+			m.mu.Lock()
+			delete(m.tokens, ch.Token)
+			m.mu.Unlock()
+		}()
+		if _, _, err := m.post(ch.URL, struct{}{}, nil); err != nil {
+			return err
+		}
+		return m.poll(authzURL, &authz, func() (bool, error) {
+			switch authz.Status {
+			case "valid":
+				return true, nil
+			case "pending", "processing":
+				return false, nil
+			}
+			return false, fmt.Errorf("acme: authorization for %s is %s", m.host, authz.Status)
+		})
+	}
+	return errors.New("acme: CA offered no http-01 challenge")
+}
+
+// obtain orders, validates, and downloads a certificate for m.host, and
+// saves it with its key.
+func (m *acmeManager) obtain() error {
+	if m.kid == "" {
+		if err := m.register(); err != nil {
+			return err
+		}
+	}
+	var order acmeOrder
+	resp, _, err := m.post(m.urls.NewOrder, map[string]any{
+		"identifiers": []map[string]string{{"type": "dns", "value": m.host}},
+	}, &order)
+	if err != nil {
+		return err
+	}
+	orderURL := resp.Header.Get("Location")
+	for _, a := range order.Authorizations {
+		if err := m.authorize(a); err != nil {
+			return err
+		}
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.host},
+		DNSNames: []string{m.host},
+	}, certKey)
+	if err != nil {
+		return err
+	}
+	if _, _, err := m.post(order.Finalize, map[string]string{"csr": b64(csr)}, &order); err != nil {
+		return err
+	}
+	err = m.poll(orderURL, &order, func() (bool, error) {
+		switch order.Status {
+		case "valid":
+			return true, nil
+		case "pending", "ready", "processing":
+			return false, nil
+		}
+		return false, fmt.Errorf("acme: order for %s is %s", m.host, order.Status)
+	})
+	if err != nil {
+		return err
+	}
+	_, chain, err := m.post(order.Certificate, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := m.install(chain, keyPEM); err != nil {
+		return err
+	}
+	crt, key := m.certPaths()
+	if err := os.WriteFile(key, keyPEM, 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(crt, chain, 0o644)
+}
+
+func (m *acmeManager) certPaths() (string, string) {
+	return filepath.Join(m.dir, m.host+".crt"), filepath.Join(m.dir, m.host+".key")
+}
+
+// install makes the PEM chain and key the served certificate.
+func (m *acmeManager) install(chain, key []byte) error {
+	cert, err := tls.X509KeyPair(chain, key)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.cert = &cert
+	m.mu.Unlock()
+	return nil
+}
+
+// remaining returns how long the served certificate is valid (0: none).
+func (m *acmeManager) remaining() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert == nil || m.cert.Leaf == nil {
+		return 0
+	}
+	return time.Until(m.cert.Leaf.NotAfter)
+}
+
+// ensure loads the cached certificate and renews it when it is missing or
+// expires within acmeRenewBefore.
+func (m *acmeManager) ensure() error {
+	if m.remaining() == 0 {
+		crt, key := m.certPaths()
+		chain, err1 := os.ReadFile(crt)
+		keyPEM, err2 := os.ReadFile(key)
+		if err1 == nil && err2 == nil {
+			if err := m.install(chain, keyPEM); err != nil {
+				log.Printf("acme: ignoring cached certificate: %v", err)
+			}
+		}
+	}
+	if m.remaining() > acmeRenewBefore {
+		return nil
+	}
+	log.Printf("acme: requesting a certificate for %s", m.host)
+	if err := m.obtain(); err != nil {
+		return err
+	}
+	log.Printf("acme: certificate for %s valid for %s", m.host, m.remaining().Round(time.Hour))
+	return nil
+}
+
+// renewLoop re-checks the certificate twice a day.
+func (m *acmeManager) renewLoop() {
+	for range time.Tick(12 * time.Hour) {
+		if err := m.ensure(); err != nil {
+			log.Printf("acme: renewal failed: %v", err)
+		}
+	}
+}
+
+func (m *acmeManager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello.ServerName != "" && !strings.EqualFold(hello.ServerName, m.host) {
+		return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert == nil {
+		return nil, errors.New("certificate not ready")
+	}
+	return m.cert, nil
+}
+
+// httpHandler answers HTTP-01 challenges and redirects everything else to
+// https on httpsPort.
+func (m *acmeManager) httpHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := strings.CutPrefix(r.URL.Path, "/.well-known/acme-challenge/"); ok {
+			m.mu.Lock()
+			ka, found := m.tokens[token]
+			m.mu.Unlock()
+			if !found {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, ka)
+			return
+		}
+		target := "https://" + m.host
+		if httpsPort != 443 {
+			target += fmt.Sprintf(":%d", httpsPort)
+		}
+		http.Redirect(w, r, target+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
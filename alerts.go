@@ -0,0 +1,429 @@
+// alerts.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Alerts =========================
+
+// An alert rule watches one ticker for a gap of at least Gap % whose
+// historical bin expects the chosen strategy to earn at least
+// MinExpectancy % per trade. A background evaluator prices every rule's gap
+// each weekday premarket (ALERTS_AT, New York time) and records the rules
+// that fire, notifying the sinks of notify.go; POST /api/alerts/evaluate
+// runs it on demand.
+
+// File the rules and triggered alerts are kept in; ALERTS_FILE overrides it.
+var alertsFile = "alerts.json"
+
+// Guards alertsFile between concurrent requests and the evaluator.
+var alertsMu sync.Mutex
+
+// Premarket evaluation time (HH:MM New York); ALERTS_AT overrides it and
+// "off" disables the evaluator.
+var alertsAt = "09:00"
+
+// Most triggered alerts kept; the oldest are dropped first.
+const maxTriggered = 1000
+
+type AlertRule struct {
+	Name          string  `json:"name"`
+	Ticker        string  `json:"ticker"`
+	Gap           float64 `json:"gap"`            // trigger when |gap| ≥ this %
+	Side          string  `json:"side"`           // up | down | both
+	Strategy      string  `json:"strategy"`       // fade | follow
+	Horizon       string  `json:"horizon"`        // daily | 15m
+	MinExpectancy float64 `json:"min_expectancy"` // % per trade in the gap's bin
+	Years         int     `json:"years"`
+	Created       string  `json:"created"` // RFC3339
+}
+
+type Alert struct {
+	Rule           string  `json:"rule"`
+	Ticker         string  `json:"ticker"`
+	Date           string  `json:"date"` // NY session date
+	Triggered      string  `json:"triggered"`
+	GapPct         float64 `json:"gap_pct"`
+	PriceSource    string  `json:"price_source"`
+	Bin            string  `json:"bin"`
+	Sessions       int     `json:"sessions"`   // in the bin
+	Expectancy     float64 `json:"expectancy"` // % per trade, rule's strategy and horizon
+	Recommendation string  `json:"recommendation"`
+	Action         string  `json:"action"` // long | short for the rule's strategy
+	Strategy       string  `json:"strategy,omitempty"`
+	Horizon        string  `json:"horizon,omitempty"`
+
+	BinStats   *gapcore.BinStat   `json:"bin_stats,omitempty"` // the gap's bin, both horizons
+	BinStats15 *gapcore.BinStat15 `json:"bin_stats_15m,omitempty"`
+}
+
+type alertStore struct {
+	Rules     map[string]AlertRule `json:"rules"`
+	Triggered []Alert              `json:"triggered"` // oldest first
+}
+
+func loadAlerts() (alertStore, error) {
+	st := alertStore{Rules: map[string]AlertRule{}}
+	b, err := os.ReadFile(alertsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		return st, fmt.Errorf("%s: %w", alertsFile, err)
+	}
+	if st.Rules == nil {
+		st.Rules = map[string]AlertRule{}
+	}
+	return st, nil
+}
+
+// saveAlerts replaces the file atomically, like savePresets.
+func saveAlerts(st alertStore) error {
+	if n := len(st.Triggered); n > maxTriggered {
+		st.Triggered = st.Triggered[n-maxTriggered:]
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := alertsFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, alertsFile)
+}
+
+// parseAlertRule reads a rule from the query: ticker, gap, side, strategy,
+// horizon, minExpectancy, and years.
+func parseAlertRule(name string, q url.Values) (AlertRule, error) {
+	r := AlertRule{
+		Name:     name,
+		Ticker:   strings.ToUpper(strings.TrimSpace(q.Get("ticker"))),
+		Side:     "both",
+		Strategy: "fade",
+		Horizon:  "daily",
+		Years:    3,
+	}
+	if r.Ticker == "" {
+		return r, fmt.Errorf("ticker required")
+	}
+	if !gapcore.StorableTicker(r.Ticker) {
+		return r, errBadTicker
+	}
+	for _, f := range []struct {
+		key      string
+		dst      *float64
+		min, max float64
+	}{
+		{"gap", &r.Gap, 0.1, 100},
+		{"minExpectancy", &r.MinExpectancy, -100, 100},
+	} {
+		v := strings.TrimSpace(q.Get(f.key))
+		if v == "" {
+			return r, fmt.Errorf("%s required", f.key)
+		}
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil || x < f.min || x > f.max {
+			return r, fmt.Errorf("%s must be between %g and %g", f.key, f.min, f.max)
+		}
+		*f.dst = x
+	}
+	for _, f := range []struct {
+		key     string
+		dst     *string
+		allowed []string
+	}{
+		{"side", &r.Side, []string{"up", "down", "both"}},
+		{"strategy", &r.Strategy, []string{"fade", "follow"}},
+		{"horizon", &r.Horizon, []string{"daily", "15m"}},
+	} {
+		v := strings.ToLower(strings.TrimSpace(q.Get(f.key)))
+		if v == "" {
+			continue
+		}
+		ok := false
+		for _, a := range f.allowed {
+			ok = ok || v == a
+		}
+		if !ok {
+			return r, fmt.Errorf("%s must be one of %s", f.key, strings.Join(f.allowed, ", "))
+		}
+		*f.dst = v
+	}
+	if v := strings.TrimSpace(q.Get("years")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 5 {
+			return r, fmt.Errorf("years must be between 1 and 5")
+		}
+		r.Years = n
+	}
+	return r, nil
+}
+
+// checkRule prices the rule's gap and returns the alert when it fires.
+func checkRule(rule AlertRule) (Alert, bool, error) {
+	params, err := parseAnalyzeValues(url.Values{"ticker": {rule.Ticker}, "years": {strconv.Itoa(rule.Years)}})
+	if err != nil {
+		return Alert{}, false, err
+	}
+	setup, err := todaySetup(params)
+	if err != nil {
+		return Alert{}, false, err
+	}
+	if math.Abs(setup.GapPct) < rule.Gap || setup.Bin == "" ||
+		(rule.Side == "up" && setup.Direction < 0) || (rule.Side == "down" && setup.Direction > 0) {
+		return Alert{}, false, nil
+	}
+	a := Alert{
+		Rule:        rule.Name,
+		Ticker:      rule.Ticker,
+		Date:        time.Now().In(gapcore.NewYork).Format("2006-01-02"),
+		Triggered:   time.Now().UTC().Format(time.RFC3339),
+		GapPct:      setup.GapPct,
+		PriceSource: setup.PriceSource,
+		Bin:         setup.Bin,
+		Strategy:    rule.Strategy,
+		Horizon:     rule.Horizon,
+		BinStats:    setup.BinStats,
+		BinStats15:  setup.BinStats15,
+	}
+	switch {
+	case rule.Horizon == "daily" && setup.BinStats != nil:
+		b := setup.BinStats
+		a.Sessions, a.Recommendation = b.Count, b.Recommendation
+		a.Expectancy = b.FadeAvg
+		if rule.Strategy == "follow" {
+			a.Expectancy = b.FollowAvg
+		}
+	case rule.Horizon == "15m" && setup.BinStats15 != nil:
+		b := setup.BinStats15
+		a.Sessions, a.Recommendation = b.Count, b.Recommendation
+		a.Expectancy = b.FadeAvg
+		if rule.Strategy == "follow" {
+			a.Expectancy = b.FollowAvg
+		}
+	default:
+		return a, false, nil // no minute data behind the 0–15m bins
+	}
+	if a.Sessions == 0 || a.Expectancy < rule.MinExpectancy {
+		return a, false, nil
+	}
+	a.Action = action(strings.ToUpper(rule.Strategy), setup.Direction)
+	return a, true, nil
+}
+
+// evaluateAlerts checks every rule once and records the new alerts; a rule
+// fires at most once per session date. Rule errors are returned by name.
+func evaluateAlerts() ([]Alert, map[string]string, error) {
+	alertsMu.Lock()
+	st, err := loadAlerts()
+	alertsMu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, 0, len(st.Rules))
+	for n := range st.Rules {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var fired []Alert
+	failed := map[string]string{}
+	for _, n := range names {
+		a, ok, err := checkRule(st.Rules[n])
+		if err != nil {
+			failed[n] = err.Error()
+			continue
+		}
+		if ok {
+			fired = append(fired, a)
+		}
+	}
+
+	alertsMu.Lock()
+	defer alertsMu.Unlock()
+	if st, err = loadAlerts(); err != nil {
+		return nil, failed, err
+	}
+	seen := map[string]bool{}
+	for _, a := range st.Triggered {
+		seen[a.Rule+"|"+a.Date] = true
+	}
+	var added []Alert
+	for _, a := range fired {
+		if !seen[a.Rule+"|"+a.Date] {
+			added = append(added, a)
+		}
+	}
+	if len(added) > 0 {
+		st.Triggered = append(st.Triggered, added...)
+		if err := saveAlerts(st); err != nil {
+			return nil, failed, err
+		}
+		notifyAlerts(added)
+	}
+	return added, failed, nil
+}
+
+// runAlertEvaluator evaluates the rules at alertsAt every weekday.
+func runAlertEvaluator() {
+	at, err := time.Parse("15:04", alertsAt)
+	if err != nil {
+		log.Printf("alerts: ALERTS_AT %q is not HH:MM; evaluator disabled", alertsAt)
+		return
+	}
+	for {
+		time.Sleep(time.Until(nextWeekdayAt(at, time.Now())))
+		added, failed, err := evaluateAlerts()
+		if err != nil {
+			log.Printf("alerts: %v", err)
+			continue
+		}
+		for n, e := range failed {
+			log.Printf("alerts: rule %s: %s", n, e)
+		}
+		for _, a := range added {
+			log.Printf("alerts: %s fired: %s gap %.2f%%, %s expectancy %.3f%%", a.Rule, a.Ticker, a.GapPct, a.Bin, a.Expectancy)
+		}
+	}
+}
+
+// nextWeekdayAt returns the first weekday time of day at (New York) after
+// now.
+func nextWeekdayAt(at, now time.Time) time.Time {
+	now = now.In(gapcore.NewYork)
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, gapcore.NewYork)
+	for !next.After(now) || next.Weekday() == time.Saturday || next.Weekday() == time.Sunday {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// handleAlerts lists rules (GET), returns one (GET ?name=), saves one from
+// the query (POST; an existing name is replaced), or deletes one (DELETE).
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("name")
+	if r.Method != http.MethodGet || name != "" {
+		if !presetNameRE.MatchString(name) {
+			writeError(w, http.StatusBadRequest, "name must be 1-64 letters, digits, '.', '_' or '-'")
+			return
+		}
+	}
+	alertsMu.Lock()
+	defer alertsMu.Unlock()
+	st, err := loadAlerts()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var out any
+	switch r.Method {
+	case http.MethodGet:
+		if name != "" {
+			rule, ok := st.Rules[name]
+			if !ok {
+				writeError(w, http.StatusNotFound, fmt.Sprintf("unknown alert rule %q", name))
+				return
+			}
+			out = rule
+			break
+		}
+		list := make([]AlertRule, 0, len(st.Rules))
+		for _, rule := range st.Rules {
+			list = append(list, rule)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		out = list
+	case http.MethodPost:
+		rule, err := parseAlertRule(name, q)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		rule.Created = time.Now().UTC().Format(time.RFC3339)
+		st.Rules[name] = rule
+		out = rule
+	case http.MethodDelete:
+		if _, ok := st.Rules[name]; !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("unknown alert rule %q", name))
+			return
+		}
+		delete(st.Rules, name)
+		out = map[string]string{"deleted": name}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if r.Method != http.MethodGet {
+		if err := saveAlerts(st); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handleTriggered returns recorded alerts, newest first, optionally for one
+// rule (rule=) or from a session date on (since=YYYY-MM-DD).
+func handleTriggered(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	alertsMu.Lock()
+	st, err := loadAlerts()
+	alertsMu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	rule, since := q.Get("rule"), q.Get("since")
+	out := []Alert{}
+	for i := len(st.Triggered) - 1; i >= 0; i-- {
+		a := st.Triggered[i]
+		if (rule == "" || a.Rule == rule) && a.Date >= since {
+			out = append(out, a)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handleEvaluateAlerts runs the evaluator now (POST) and returns the alerts
+// it added and any rules that could not be checked.
+func handleEvaluateAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	added, failed, err := evaluateAlerts()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if added == nil {
+		added = []Alert{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"added": added, "failed": failed})
+}
@@ -0,0 +1,47 @@
+// apiversion.go
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= API Versioning =========================
+
+// The API lives under /api/v1/. Responses say which shape they have in
+// schema_version (and the Schema-Version header, for list responses), which
+// goes up whenever a field is removed or changes meaning; added fields do
+// not bump it. The unversioned /api/ paths of earlier releases still work:
+// they are served by v1 and marked deprecated, pointing at their successor.
+
+const (
+	apiPrefix = "/api/v1"
+
+	apiSchemaVersion = gapcore.SchemaVersionCurrent
+)
+
+// schemaVersion always encodes as apiSchemaVersion, so response structs
+// carry it without every constructor setting it.
+type schemaVersion = gapcore.SchemaVersion
+
+// withAPIVersion maps the unversioned /api/ paths onto v1 and stamps the
+// schema version on every API response.
+func withAPIVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, apiPrefix+"/") {
+			r.URL.Path = apiPrefix + strings.TrimPrefix(r.URL.Path, "/api")
+			r.URL.RawPath = ""
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", "<"+r.URL.Path+`>; rel="successor-version"`)
+		}
+		w.Header().Set("Schema-Version", strconv.Itoa(apiSchemaVersion))
+		next.ServeHTTP(w, r)
+	})
+}
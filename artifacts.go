@@ -0,0 +1,174 @@
+// artifacts.go
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Run Artifacts =========================
+
+// With ARTIFACTS_DIR (or -artifacts) set, every analysis — from the API,
+// the CLI, a batch, the TUI, or a scheduled job — is also saved as a
+// directory of its own, ARTIFACTS_DIR/YYYYMMDD-HHMMSS-TICKER (New York
+// time), holding:
+//
+//	config.json   the parameters, date range, and bins the run used
+//	result.json   the full response
+//	gaps.csv      the per-session points
+//	summary.csv   the daily and 0–15m summaries
+//
+// so any number quoted later can be traced to the run that produced it. A
+// run whose daily fetch failed leaves only config.json, with the error.
+
+// artifactsDir is the output root; "" saves nothing.
+var artifactsDir string
+
+type runConfig struct {
+	SchemaVersion schemaVersion  `json:"schema_version"`
+	CreatedAt     string         `json:"created_at"`
+	Provider      string         `json:"provider"`
+	Ticker        string         `json:"ticker"`
+	Years         int            `json:"years"`
+	From          string         `json:"from"`
+	To            string         `json:"to"`
+	MinGap        float64        `json:"min_gap"`
+	Bins          []runConfigBin `json:"bins"`
+	Winsorize     []float64      `json:"winsorize,omitempty"`
+	WalkForward   *runConfigWF   `json:"walk_forward,omitempty"`
+	Resample      bool           `json:"resample,omitempty"`
+	Costs         *gapcore.Costs `json:"costs,omitempty"`
+	Error         string         `json:"error,omitempty"`
+}
+
+type runConfigBin struct {
+	Label string  `json:"label"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+type runConfigWF struct {
+	TrainMonths int `json:"train_months"`
+	StepMonths  int `json:"step_months"`
+}
+
+// saveRunArtifacts writes the artifacts of one analysis; runErr is the
+// failed daily fetch, if it failed.
+func saveRunArtifacts(params gapcore.Params, resp gapcore.AnalyzeResponse, runErr error) error {
+	if !gapcore.StorableTicker(params.Ticker) {
+		return errBadTicker // never a path from an unchecked ticker
+	}
+	now := time.Now().In(gapcore.NewYork)
+	dir, err := makeRunDir(artifactsDir, now.Format("20060102-150405")+"-"+params.Ticker)
+	if err != nil {
+		return err
+	}
+
+	from, to := params.DateRange()
+	cfg := runConfig{
+		SchemaVersion: apiSchemaVersion,
+		CreatedAt:     now.Format(time.RFC3339),
+		Provider:      "polygon",
+		Ticker:        params.Ticker,
+		Years:         params.Years,
+		From:          from,
+		To:            to,
+		MinGap:        params.MinGap,
+		Resample:      params.Resample,
+	}
+	for _, b := range gapcore.DefaultBins(params.MinGap) {
+		cfg.Bins = append(cfg.Bins, runConfigBin{b.Label, b.Min, b.Max})
+	}
+	if params.Winsorize {
+		cfg.Winsorize = []float64{params.WinsorLo, params.WinsorHi}
+	}
+	if params.WalkForward {
+		cfg.WalkForward = &runConfigWF{params.TrainMonths, params.StepMonths}
+	}
+	if params.Costs.Commission != 0 || params.Costs.Slippage != 0 {
+		cfg.Costs = &params.Costs
+	}
+	if runErr != nil {
+		cfg.Error = runErr.Error()
+	}
+	if err := writeJSONOutput(filepath.Join(dir, "config.json"), cfg); err != nil {
+		return err
+	}
+	if runErr != nil {
+		return nil
+	}
+
+	resp.SchemaVersion = apiSchemaVersion
+	if err := writeJSONOutput(filepath.Join(dir, "result.json"), resp); err != nil {
+		return err
+	}
+	points := [][]string{gapCSVHeader}
+	for _, p := range resp.Data {
+		points = append(points, gapCSVRow(p))
+	}
+	summary := append([][]string{{"metric", "daily", "first_15m"}}, summaryRows(resp)...)
+	for name, rows := range map[string][][]string{"gaps.csv": points, "summary.csv": summary} {
+		if err := writeCSVFile(filepath.Join(dir, name), rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// makeRunDir creates root/name, or root/name-2, -3, … if it is taken (runs
+// in the same second).
+func makeRunDir(root, name string) (string, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return "", err
+	}
+	for i := 1; ; i++ {
+		dir := filepath.Join(root, name)
+		if i > 1 {
+			dir = fmt.Sprintf("%s-%d", dir, i)
+		}
+		err := os.Mkdir(dir, 0o755)
+		if err == nil {
+			return dir, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", err
+		}
+	}
+}
+
+func writeCSVFile(path string, rows [][]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	cw.WriteAll(rows)
+	if err := cw.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// recordRun saves the artifacts of an analysis if ARTIFACTS_DIR is set,
+// and mirrors its points into the warehouse if WAREHOUSE_DIR is, logging
+// rather than failing the analysis when they cannot be written.
+func recordRun(params gapcore.Params, resp gapcore.AnalyzeResponse, runErr error) {
+	if artifactsDir != "" {
+		if err := saveRunArtifacts(params, resp, runErr); err != nil {
+			log.Printf("artifacts: %s: %v", params.Ticker, err)
+		}
+	}
+	if warehouseDir != "" && runErr == nil && len(resp.Data) > 0 {
+		if err := warehouseGaps(params, resp); err != nil {
+			log.Printf("warehouse: %s: %v", params.Ticker, err)
+		}
+	}
+}
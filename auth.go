@@ -0,0 +1,95 @@
+// auth.go
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ========================= API Tokens =========================
+
+// Exposed beyond localhost, every /api/ call spends the owner's Polygon
+// quota. With tokens configured (API_TOKENS and/or API_TOKENS_FILE), /api/
+// — and /ws and gRPC, which run the same analyses — require one as
+// "Authorization: Bearer <token>". WebSocket and EventSource clients cannot
+// set headers, so access_token=<token> in the query is accepted too. The
+// page, the health probes, and CORS preflights stay open. No tokens: no
+// auth, as before.
+
+// loadTokens reads the comma-separated tokens in list plus the file's, one
+// per line (blank lines and # comments skipped).
+func loadTokens(list, file string) ([]string, error) {
+	var out []string
+	for _, t := range strings.Split(list, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			out = append(out, t)
+		}
+	}
+	if file == "" {
+		return out, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if t := strings.TrimSpace(sc.Text()); t != "" && !strings.HasPrefix(t, "#") {
+			out = append(out, t)
+		}
+	}
+	return out, sc.Err()
+}
+
+// authProtected reports whether path spends Polygon quota. The API spec is
+// open so clients can be generated before they have a token. (The -debug
+// pprof routes are not on this server at all; see debug.go.)
+func authProtected(path string) bool {
+	if path == apiPrefix+"/spec.json" {
+		return false
+	}
+	return strings.HasPrefix(path, "/api/") || path == "/ws" || path == grpcAnalyzePath
+}
+
+// requestToken returns the bearer token of r, or its access_token param.
+func requestToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+func validToken(tokens []string, got string) bool {
+	ok := false
+	for _, t := range tokens {
+		// Compare against every token so timing says nothing about which.
+		if subtle.ConstantTimeCompare([]byte(t), []byte(got)) == 1 {
+			ok = true
+		}
+	}
+	return ok && got != ""
+}
+
+// withAuth wraps next with token checks on the protected paths.
+func withAuth(next http.Handler, tokens []string) http.Handler {
+	if len(tokens) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authProtected(r.URL.Path) && !validToken(tokens, requestToken(r)) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gap-analyzer"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid API token")
+			return
+		}
+		// Keep the token out of presets, job queries, and ETags.
+		if q := r.URL.Query(); q.Has("access_token") {
+			q.Del("access_token")
+			r.URL.RawQuery = q.Encode()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,98 @@
+// backtest.go
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Backtest API =========================
+
+func handleBacktest(w http.ResponseWriter, r *http.Request) {
+	if err := applyPreset(r); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	params, err := parseAnalyzeParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	cfg, err := gapcore.ParseBacktestConfig(q, htbTickers)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cfg.Costs = params.Costs
+	if cfg.Sizing, err = gapcore.ParseSizing(q, cfg.Stop > 0 || cfg.StopPremarket || cfg.Trail > 0); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	csvOut := strings.EqualFold(q.Get("format"), "csv")
+	index := gapcore.ParseBenchmark(q.Get("benchmark"))
+	exits, err := gapcore.ParseExits(q, cfg.Entry)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	schemes, err := gapcore.ParseScaleSchemes(q, cfg.Units)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	out, err := gapcore.Backtest(r.Context(), provider, gapcore.BacktestRequest{
+		Params:     params,
+		Config:     cfg,
+		Index:      index,
+		Exits:      exits,
+		Schemes:    schemes,
+		TradesOnly: csvOut,
+	})
+	if err != nil {
+		writeFetchError(w, err)
+		return
+	}
+	recordBacktest(params, out)
+	if csvOut && out.Success {
+		writeTradesCSV(w, params.Ticker, out.Results)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+var tradeCSVHeader = []string{
+	"ticker", "date", "side", "direction", "gap_pct",
+	"entry_time", "entry_price", "exit_time", "exit_price", "exit_reason",
+	"stop_price", "r_multiple", "reentry", "shares", "pnl", "return_pct", "cost_pct", "hold_mins", "ambiguous",
+}
+
+// writeTradesCSV sends every simulated trade, all sides, as a CSV download.
+func writeTradesCSV(w http.ResponseWriter, ticker string, results []gapcore.BacktestResult) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s_backtest_trades.csv"`, ticker))
+	cw := csv.NewWriter(w)
+	cw.Write(tradeCSVHeader)
+	for _, res := range results {
+		for _, t := range res.Trades {
+			dir := "short"
+			if t.Long {
+				dir = "long"
+			}
+			cw.Write([]string{
+				ticker, t.Date, t.Side, dir, num(t.GapPct),
+				t.EntryTime, num(t.EntryPrice), t.ExitTime, num(t.ExitPrice), t.ExitReason,
+				num(t.StopPrice), num(t.RMultiple), strconv.Itoa(t.Reentry), strconv.Itoa(t.Shares), num(t.PnL), num(t.ReturnPct), num(t.CostPct),
+				strconv.Itoa(t.HoldMins), strconv.FormatBool(t.Ambiguous),
+			})
+		}
+	}
+	cw.Flush()
+}
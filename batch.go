@@ -0,0 +1,261 @@
+// batch.go
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Batch Analysis =========================
+
+// analyze -list FILE runs the analysis for every ticker in FILE (one or
+// more per line, # comments allowed), batchWorkers (or -jobs) at a time. Each
+// ticker's JSON goes to DIR/TICKER_gaps.json, and summary.csv lines the
+// tickers' summaries up one row per ticker, failures included, so a
+// watchlist can be screened from a cron job.
+
+const (
+	maxBatchTickers = 2000
+	batchWorkers    = 4
+	maxBatchWorkers = 16
+)
+
+// readTickerList reads a ticker-list file.
+func readTickerList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		b.WriteString(strings.Join(strings.Fields(line), ","))
+		b.WriteString(",")
+	}
+	tickers, err := parseTickers(b.String(), maxBatchTickers)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return tickers, nil
+}
+
+type batchResult struct {
+	ticker string
+	status string // ok | partial | failed
+	msg    string
+	rows   [][]string // summaryRows, nil when failed
+}
+
+// runBatch analyzes each ticker with params, writes its view to dir, and
+// writes the summary CSV to summaryPath. Tickers the checkpoint for key
+// records as done are not analyzed again. The error is for files that
+// could not be written or an interrupted run; failed tickers are reported
+// in the summary and counted in failed.
+func runBatch(tickers []string, params gapcore.Params, view resultView, dir, summaryPath string, workers int, key string, fresh bool) (failed int, err error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+	ckpt := loadCheckpoint(filepath.Join(dir, checkpointName), key, fresh)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	results := make([]batchResult, len(tickers))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for i, tk := range tickers {
+		out := filepath.Join(dir, tk+"_gaps.json")
+		if rows, ok := ckpt.done(tk); ok && fileExists(out) {
+			results[i] = batchResult{ticker: tk, status: "ok", rows: rows}
+			notef("%s: ok (earlier run)", tk)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, tk string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			res := batchResult{ticker: tk, status: "failed"}
+			defer func() {
+				if res.status != "ok" && ctx.Err() != nil {
+					res.status, res.msg = "failed", "interrupted"
+				}
+				notef("%s: %s", tk, cmp.Or(res.msg, res.status))
+				results[i] = res
+			}()
+			if ctx.Err() != nil {
+				return
+			}
+			p := params
+			p.Ticker = tk
+			resp, err := analyzeProgress(ctx, p, nil)
+			if err == nil {
+				var v any
+				if v, err = view.apply(resp); err == nil {
+					err = writeJSONOutput(out, v)
+				}
+			}
+			switch {
+			case err != nil:
+				res.msg = err.Error()
+			case !resp.Success:
+				res.status, res.msg, res.rows = "partial", resp.Error, summaryRows(resp)
+			default:
+				res.status, res.rows = "ok", summaryRows(resp)
+				if err := ckpt.add(tk, res.rows); err != nil {
+					log.Printf("checkpoint: %v", err)
+				}
+			}
+		}(i, tk)
+	}
+	wg.Wait()
+
+	f, err := os.Create(summaryPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	cw := csv.NewWriter(f)
+	header, cols := batchColumns(results)
+	cw.Write(header)
+	done := 0
+	for _, res := range results {
+		if res.status == "failed" {
+			failed++
+		}
+		row := []string{res.ticker, res.status, res.msg}
+		if res.rows == nil {
+			row = append(row, make([]string, len(header)-len(row))...)
+		} else {
+			for i, m := range res.rows {
+				if cols[2*i] {
+					row = append(row, m[1])
+				}
+				if cols[2*i+1] {
+					row = append(row, m[2])
+				}
+			}
+		}
+		cw.Write(row)
+		if res.status == "ok" {
+			done++
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return failed, err
+	}
+	if ctx.Err() != nil {
+		return failed, fmt.Errorf("interrupted with %d of %d tickers done; rerun the same command to resume", done, len(tickers))
+	}
+	if done == len(tickers) {
+		ckpt.remove()
+	}
+	return failed, nil
+}
+
+// batchColumns returns the summary CSV header and, per summaryRows cell
+// (daily, 0–15m), whether it is a column: any ticker has a value there.
+// Every ticker shares the parameters, so they have the same rows.
+func batchColumns(results []batchResult) ([]string, []bool) {
+	header := []string{"ticker", "status", "error"}
+	var names []string
+	var cols []bool
+	for _, res := range results {
+		if res.rows == nil {
+			continue
+		}
+		if cols == nil {
+			cols = make([]bool, 2*len(res.rows))
+			for _, m := range res.rows {
+				names = append(names, m[0])
+			}
+		}
+		for i, m := range res.rows {
+			cols[2*i] = cols[2*i] || m[1] != ""
+			cols[2*i+1] = cols[2*i+1] || m[2] != ""
+		}
+	}
+	for i, name := range names {
+		if cols[2*i] {
+			header = append(header, name)
+		}
+		if cols[2*i+1] {
+			header = append(header, name+"_15m")
+		}
+	}
+	return header, cols
+}
+
+// ========================= Batch Checkpoint =========================
+
+// A batch records each ticker it completes in DIR/.gap-analyzer-batch.json,
+// keyed by the run's parameters, so rerunning the same command after a
+// Ctrl-C, a rate limit, or a network failure skips the finished tickers
+// (whose JSON is still in DIR) and retries the rest. The file is removed
+// once every ticker succeeds; -fresh ignores it.
+
+const checkpointName = ".gap-analyzer-batch.json"
+
+type batchCheckpoint struct {
+	path string
+	mu   sync.Mutex
+
+	Key  string                `json:"key"`  // the analysis parameters, query-encoded
+	Done map[string][][]string `json:"done"` // ticker → summaryRows
+}
+
+// loadCheckpoint reads the checkpoint at path if it is for key, else
+// starts an empty one.
+func loadCheckpoint(path, key string, fresh bool) *batchCheckpoint {
+	c := &batchCheckpoint{path: path}
+	if b, err := os.ReadFile(path); err == nil && !fresh {
+		if json.Unmarshal(b, c) != nil || c.Key != key {
+			c.Done = nil
+		}
+	}
+	c.Key = key
+	if c.Done == nil {
+		c.Done = map[string][][]string{}
+	}
+	return c
+}
+
+func (c *batchCheckpoint) done(ticker string) ([][]string, bool) {
+	rows, ok := c.Done[ticker]
+	return rows, ok
+}
+
+// add records ticker as done and saves the file, replacing it atomically.
+func (c *batchCheckpoint) add(ticker string, rows [][]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Done[ticker] = rows
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+func (c *batchCheckpoint) remove() { os.Remove(c.path) }
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
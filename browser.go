@@ -0,0 +1,46 @@
+// browser.go
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// ========================= Browser =========================
+
+// serve opens the UI in a browser once it listens: with -browser (or
+// $BROWSER) if given, else the platform's opener — open on macOS, start on
+// Windows, xdg-open elsewhere. A machine without a display (a server, a
+// container, an SSH session) gets no browser rather than an error each
+// start, as does -no-browser.
+
+// browserCommand returns the command that opens u, or nil on a Unix
+// session without X11 or Wayland. custom is a command line (e.g.
+// "firefox --new-window") that gets u appended.
+func browserCommand(custom, u string) *exec.Cmd {
+	if f := strings.Fields(custom); len(f) > 0 {
+		return exec.Command(f[0], append(f[1:], u)...)
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", u)
+	case "windows":
+		// start's first quoted argument is the window title.
+		return exec.Command("cmd", "/c", "start", "", u)
+	}
+	if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return nil
+	}
+	return exec.Command("xdg-open", u)
+}
+
+// openBrowser opens u with browserCommand; no display is not an error.
+func openBrowser(custom, u string) error {
+	cmd := browserCommand(custom, u)
+	if cmd == nil {
+		return nil
+	}
+	return cmd.Start()
+}
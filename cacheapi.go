@@ -0,0 +1,153 @@
+// cacheapi.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Cache Management =========================
+
+// /api/v1/cache reports what the bar cache holds — entries, bars, and the
+// hit rate of the requests it has answered, for the daily ranges and the
+// minute sessions — and which sessions it covers per ticker, with the bar
+// store's coverage when BAR_STORE_DIR is set. DELETE ?ticker= purges one
+// ticker's bars from memory and from the store, so stale or corrupted data
+// is refetched on the next request; DELETE ?all=1 empties the in-memory
+// cache but leaves the store alone.
+
+// Bytes per cached bar: a Bar's six 8-byte fields.
+const barBytes = 6 * 8
+
+type CacheResponse struct {
+	Daily       CacheTier        `json:"daily"`
+	Minute      CacheTier        `json:"minute"`
+	ApproxBytes int              `json:"approx_bytes"` // bars held × bar size
+	Store       string           `json:"store,omitempty"`
+	Tickers     []TickerCoverage `json:"tickers"`
+}
+
+type CacheTier struct {
+	Entries int      `json:"entries"`
+	Bars    int      `json:"bars"`
+	Hits    int64    `json:"hits"`
+	Misses  int64    `json:"misses"`
+	HitRate *float64 `json:"hit_rate"` // %, null before the first request
+}
+
+type TickerCoverage struct {
+	Ticker         string                 `json:"ticker"`
+	DailyRanges    int                    `json:"daily_ranges"`
+	MinuteSessions int                    `json:"minute_sessions"`
+	FirstSession   string                 `json:"first_session,omitempty"` // of the cached minute sessions
+	LastSession    string                 `json:"last_session,omitempty"`
+	Stored         *gapcore.StoreCoverage `json:"stored,omitempty"`
+}
+
+type CachePurge struct {
+	Ticker         string `json:"ticker,omitempty"`
+	DailyRanges    int    `json:"daily_ranges"`
+	MinuteSessions int    `json:"minute_sessions"`
+	Store          bool   `json:"store"` // the ticker's stored bars were deleted
+}
+
+func cacheTier(c *gapcore.BarCache, hits, misses int64) CacheTier {
+	t := CacheTier{Entries: c.Len(), Bars: c.Bars(), Hits: hits, Misses: misses}
+	if n := hits + misses; n > 0 {
+		r := gapcore.Round1(100 * float64(hits) / float64(n))
+		t.HitRate = &r
+	}
+	return t
+}
+
+// cacheReport builds the report, for one ticker if ticker is set.
+func cacheReport(ticker string) CacheResponse {
+	n := provider.Counts()
+	out := CacheResponse{
+		Daily:  cacheTier(provider.DailyBars, n.DailyHits, n.DailyMisses),
+		Minute: cacheTier(provider.MinuteBars, n.MinuteHits, n.MinuteMisses),
+		Store:  barStore.Dir,
+	}
+	out.ApproxBytes = (out.Daily.Bars + out.Minute.Bars) * barBytes
+
+	byTicker := map[string]*TickerCoverage{}
+	cov := func(tk string) *TickerCoverage {
+		c, ok := byTicker[tk]
+		if !ok {
+			c = &TickerCoverage{Ticker: tk}
+			byTicker[tk] = c
+		}
+		return c
+	}
+	for _, k := range provider.DailyBars.Keys() {
+		cov(strings.SplitN(k, "|", 2)[0]).DailyRanges++
+	}
+	for _, k := range provider.MinuteBars.Keys() {
+		tk, date, _ := strings.Cut(k, "|")
+		c := cov(tk)
+		c.MinuteSessions++
+		if c.FirstSession == "" || date < c.FirstSession {
+			c.FirstSession = date
+		}
+		if date > c.LastSession {
+			c.LastSession = date
+		}
+	}
+	for _, tk := range barStore.Tickers() {
+		cov(tk)
+	}
+	out.Tickers = []TickerCoverage{}
+	for tk, c := range byTicker {
+		if ticker != "" && tk != ticker {
+			continue
+		}
+		if barStore.Dir != "" {
+			s := barStore.Coverage(tk)
+			c.Stored = &s
+		}
+		out.Tickers = append(out.Tickers, *c)
+	}
+	sort.Slice(out.Tickers, func(i, j int) bool { return out.Tickers[i].Ticker < out.Tickers[j].Ticker })
+	return out
+}
+
+// handleCache reports the cache (GET, optionally ?ticker=) or purges a
+// ticker (DELETE ?ticker=) or the whole in-memory cache (DELETE ?all=1).
+func handleCache(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ticker := strings.ToUpper(strings.TrimSpace(q.Get("ticker")))
+
+	var out any
+	switch r.Method {
+	case http.MethodGet:
+		out = cacheReport(ticker)
+	case http.MethodDelete:
+		if ticker == "" && q.Get("all") != "1" {
+			writeError(w, http.StatusBadRequest, "ticker required (all=1 empties the whole in-memory cache)")
+			return
+		}
+		p := CachePurge{Ticker: ticker}
+		prefix := ""
+		if ticker != "" {
+			prefix = ticker + "|"
+			if err := barStore.Forget(ticker); err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			p.Store = barStore.Dir != ""
+		}
+		p.DailyRanges = provider.DailyBars.DeletePrefix(prefix)
+		p.MinuteSessions = provider.MinuteBars.DeletePrefix(prefix)
+		out = p
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,192 @@
+// chatformat.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ========================= Slack & Discord Messages =========================
+
+// Sinks of type slack and discord post to a channel's incoming webhook, so
+// notifications arrive as chat messages rather than JSON: an alert as its
+// call with the gap's bin row for both horizons, a morning report as a
+// table of the gappers. The tables are monospaced code blocks, the one
+// layout both apps render alike, cut short to fit their message limits.
+
+// Longest code block sent: Slack section text holds 3,000 characters,
+// Discord embed descriptions 4,096.
+const (
+	slackTextLimit   = 3000
+	discordTextLimit = 4096
+)
+
+// Discord embed colors.
+const (
+	colorLong    = 0x00cc33
+	colorShort   = 0xff5f56
+	colorNeutral = 0xffbd2e
+)
+
+// chatMessage is a notification laid out for chat: a title, a table, and a
+// footnote.
+type chatMessage struct {
+	title  string
+	table  []string // rows, the first the header
+	note   string
+	action string // long | short | none, for color
+}
+
+func chatLayout(n notification) chatMessage {
+	switch {
+	case n.Alert != nil:
+		a := n.Alert
+		m := chatMessage{
+			title:  fmt.Sprintf("%s gapped %+.2f%% — %s", a.Ticker, a.GapPct, a.Recommendation),
+			note:   fmt.Sprintf("Alert %s · bin %s · %s %s expectancy %+.3f%% over %d sessions · priced from %s", a.Rule, a.Bin, a.Horizon, a.Strategy, a.Expectancy, a.Sessions, a.PriceSource),
+			action: a.Action,
+		}
+		if a.Action != "" && a.Action != "none" {
+			m.title += " → " + a.Action
+		}
+		rows := [][]string{{"HORIZON", "N", "CONT", "FADE", "FOLLOW", "CALL"}}
+		if b := a.BinStats; b != nil {
+			rows = append(rows, []string{"open→close", fmt.Sprint(b.Count), pctCell(b.ContinuationRate), retCell(b.FadeAvg), retCell(b.FollowAvg), b.Recommendation})
+		}
+		if b := a.BinStats15; b != nil {
+			rows = append(rows, []string{"09:30→09:45", fmt.Sprint(b.Count), pctCell(b.ContinuationRate), retCell(b.FadeAvg), retCell(b.FollowAvg), b.Recommendation})
+		}
+		if len(rows) > 1 {
+			m.table = alignColumns(rows)
+		}
+		return m
+	case n.Report != nil:
+		r := n.Report
+		m := chatMessage{
+			title: fmt.Sprintf("%s morning gaps, %s: %d of %d at least %.1f%%", r.Watchlist, r.Date, len(r.Gappers), len(r.Gappers)+r.Quiet+len(r.Failed), r.MinGap),
+			note:  "As of " + r.AsOf[11:16] + " ET · bins from the default 3-year analysis · research only",
+		}
+		if len(r.Failed) > 0 {
+			m.note += fmt.Sprintf(" · %d not priced", len(r.Failed))
+		}
+		if len(r.Gappers) == 0 {
+			return m
+		}
+		rows := [][]string{{"TICKER", "GAP", "BIN", "N", "CONT", "FADE", "FOLLOW", "CALL"}}
+		for _, g := range r.Gappers {
+			row := []string{g.Ticker, fmt.Sprintf("%+.2f%%", g.GapPct), g.Bin, "", "", "", "", g.Recommendation}
+			if b := g.BinStats; b != nil {
+				row[3], row[4], row[5], row[6] = fmt.Sprint(b.Count), pctCell(b.ContinuationRate), retCell(b.FadeAvg), retCell(b.FollowAvg)
+			}
+			if g.Action != "none" {
+				row[7] += " " + g.Action
+			}
+			rows = append(rows, row)
+		}
+		m.table = alignColumns(rows)
+		return m
+	}
+	return chatMessage{title: "Gap Analyzer test notification", note: "Sent " + n.Sent}
+}
+
+// codeBlock renders the table as a code block of at most limit
+// characters, dropping rows that don't fit.
+func (m chatMessage) codeBlock(limit int) string {
+	return m.fenced("```\n", "\n```", limit)
+}
+
+// fenced renders the table between open and close, at most limit
+// characters in all, dropping rows that don't fit.
+func (m chatMessage) fenced(open, close string, limit int) string {
+	if len(m.table) == 0 {
+		return ""
+	}
+	lines, more := m.table, 0
+	for {
+		s := open + strings.Join(lines, "\n")
+		if more > 0 {
+			s += fmt.Sprintf("\n… %d more", more)
+		}
+		s += close
+		if len([]rune(s)) <= limit || len(lines) <= 1 {
+			return s
+		}
+		lines = lines[:len(lines)-1]
+		more++
+	}
+}
+
+func slackBody(n notification) ([]byte, error) {
+	m := chatLayout(n)
+	blocks := []any{
+		map[string]any{"type": "header", "text": map[string]string{"type": "plain_text", "text": truncateRunes(m.title, 150)}},
+	}
+	if t := m.codeBlock(slackTextLimit); t != "" {
+		blocks = append(blocks, map[string]any{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": slackEscape(t)}})
+	}
+	blocks = append(blocks, map[string]any{"type": "context", "elements": []map[string]string{{"type": "mrkdwn", "text": slackEscape(m.note)}}})
+	return json.Marshal(map[string]any{"text": m.title, "blocks": blocks})
+}
+
+func discordBody(n notification) ([]byte, error) {
+	m := chatLayout(n)
+	color := colorNeutral
+	switch m.action {
+	case "long":
+		color = colorLong
+	case "short":
+		color = colorShort
+	}
+	embed := map[string]any{
+		"title":       truncateRunes(m.title, 256),
+		"description": m.codeBlock(discordTextLimit),
+		"color":       color,
+		"footer":      map[string]string{"text": truncateRunes(m.note, 2048)},
+	}
+	if n.Sent != "" {
+		embed["timestamp"] = n.Sent
+	}
+	return json.Marshal(map[string]any{"username": "Gap Analyzer", "embeds": []any{embed}})
+}
+
+// alignColumns pads each column to its widest cell.
+func alignColumns(rows [][]string) []string {
+	var widths []int
+	for _, r := range rows {
+		for i, c := range r {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], len([]rune(c)))
+		}
+	}
+	out := make([]string, len(rows))
+	for j, r := range rows {
+		var b strings.Builder
+		for i, c := range r {
+			if i > 0 {
+				b.WriteString("  ")
+			}
+			fmt.Fprintf(&b, "%-*s", widths[i], c)
+		}
+		out[j] = strings.TrimRight(b.String(), " ")
+	}
+	return out
+}
+
+func pctCell(v float64) string { return fmt.Sprintf("%.0f%%", v) }
+func retCell(v float64) string { return fmt.Sprintf("%+.2f", v) }
+
+// slackEscape escapes the characters Slack's mrkdwn reserves.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,556 @@
+// cli.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= CLI =========================
+
+// The binary runs as one of a few commands, each with its own flags:
+//
+//	gap-analyzer serve    -port 8083        web UI and API (the default)
+//	gap-analyzer analyze  -ticker TSLA      one analysis as JSON (-list FILE: a batch)
+//	gap-analyzer scan     -minGap 3         market-wide gap scan as JSON
+//	gap-analyzer backtest -ticker TSLA ...  rule-based backtest as JSON (or CSV)
+//	gap-analyzer export   -ticker TSLA -format xlsx
+//	gap-analyzer cache    -server URL       a running server's bar cache
+//	gap-analyzer cache    export FILE       the bar store as a portable archive (import FILE merges one)
+//	gap-analyzer cache    compact           apply the bar store's retention now
+//
+// The one-shot commands print to stdout (or -o FILE) and exit without a
+// server or browser, for scripts and cron jobs. Their flags are the query
+// parameters of the matching endpoint, taken from the API spec's parameter
+// lists and validated by the same parsers, so a flag means exactly what the
+// parameter does.
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	// Set here: runServeCmd's usage lists commands.
+	commands = []command{
+		{"serve", "run the web UI and API (the default)", runServeCmd},
+		{"analyze", "run one gap analysis and write its JSON", runAnalyzeCmd},
+		{"scan", "scan the market for today's (or a date's) gaps", runScanCmd},
+		{"backtest", "backtest trade rules on a ticker's gap sessions", runBacktestCmd},
+		{"export", "write an analysis as CSV, XLSX, Parquet, or PDF", runExportCmd},
+		{"tui", "explore a ticker's gaps in the terminal", runTUICmd},
+		{"cache", "show a running server's bar cache; export, import, or compact the bar store", runCacheCmd},
+		{"schedule", "run scans and analyses on a cron schedule", runScheduleCmd},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: gap-analyzer [-config FILE] [-profile NAME] [-quiet] <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun gap-analyzer <command> -h for a command's flags.\n")
+}
+
+// commandUsage prints the command list, then fs's flags.
+func commandUsage(fs *flag.FlagSet, name string) func() {
+	return func() {
+		usage()
+		fmt.Fprintf(os.Stderr, "\nFlags of %s:\n", name)
+		fs.PrintDefaults()
+	}
+}
+
+// setPolygonKey takes the key from flagKey, else POLYGON_API_KEY. A replay
+// needs none.
+func setPolygonKey(flagKey string) error {
+	polygon.APIKey = flagKey
+	if polygon.APIKey == "" {
+		polygon.APIKey = os.Getenv("POLYGON_API_KEY")
+	}
+	if polygon.APIKey == "" && replay == nil {
+		return configError(errors.New("missing POLYGON_API_KEY (flag or .env)"))
+	}
+	return nil
+}
+
+// apiFlagSet returns a flag set with one string flag per query parameter,
+// plus -apikey and -o.
+func apiFlagSet(name string, specs ...[]apiParam) (fs *flag.FlagSet, apiKey, out *string) {
+	fs = paramFlagSet(name, specs...)
+	apiKey = fs.String("apikey", "", "Polygon.io API key (overrides .env)")
+	out = fs.String("o", "", "write to this file instead of stdout")
+	return fs, apiKey, out
+}
+
+// paramFlagSet has a string flag per API parameter.
+func paramFlagSet(name string, specs ...[]apiParam) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	for _, p := range joinParams(specs...) {
+		if fs.Lookup(p.Name) != nil {
+			continue
+		}
+		desc := p.Desc
+		if len(p.Enum) > 0 {
+			desc += " (" + strings.Join(p.Enum, ", ") + ")"
+		}
+		if p.Required {
+			desc += " (required)"
+		}
+		fs.String(p.Name, "", desc)
+	}
+	return fs
+}
+
+// flagValues returns the flags set on the command line as query values.
+func flagValues(fs *flag.FlagSet, skip ...string) url.Values {
+	q := url.Values{}
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range skip {
+			if f.Name == s {
+				return
+			}
+		}
+		q.Set(f.Name, f.Value.String())
+	})
+	return q
+}
+
+// writeJSONOutput writes v indented to path, or to stdout if path is "".
+func writeJSONOutput(path string, v any) error {
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func runAnalyzeCmd(args []string) error {
+	fs, apiKey, out := apiFlagSet("analyze", tickerParam, analyzeParamSpecs, fieldsParam)
+	list := fs.String("list", "", "analyze every ticker in this file (one or more per line) instead of -ticker")
+	dir := fs.String("dir", ".", "with -list: directory for each ticker's TICKER_gaps.json")
+	jobs := fs.Int("jobs", batchWorkers, fmt.Sprintf("with -list: tickers analyzed at once (1-%d)", maxBatchWorkers))
+	fresh := fs.Bool("fresh", false, "with -list: start over instead of resuming an interrupted batch in -dir")
+	dryRun := fs.Bool("dry-run", false, "print the request plan (calls, estimated minutes) instead of running")
+	artifacts := fs.String("artifacts", "", "also save the run under this directory (overrides ARTIFACTS_DIR)")
+	format := fs.String("format", "json", "output format ("+strings.Join(textFormats, ", ")+"); table and markdown print the summary and bin tables")
+	fs.Usage = func() {
+		commandUsage(fs, "analyze")()
+		fmt.Fprintf(os.Stderr, "\nWith -list, -o names the summary CSV (default DIR/summary.csv).\n")
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	q := flagValues(fs, "apikey", "o", "list", "dir", "jobs", "format", "artifacts", "dry-run", "fresh")
+	if err := applyUserSettings(q); err != nil {
+		return configError(err)
+	}
+	params, err := parseAnalyzeValues(q)
+	if err != nil {
+		return configError(err)
+	}
+	var tickers []string
+	switch {
+	case *list != "" && params.Ticker != "":
+		return configError(errors.New("-ticker and -list are exclusive"))
+	case *list != "":
+		if tickers, err = readTickerList(*list); err != nil {
+			return configError(err)
+		}
+		if *jobs < 1 || *jobs > maxBatchWorkers {
+			return configError(fmt.Errorf("-jobs must be between 1 and %d", maxBatchWorkers))
+		}
+	case params.Ticker == "":
+		return configError(errors.New("-ticker or -list required"))
+	}
+	*format = strings.ToLower(*format)
+	switch {
+	case !contains(textFormats, *format):
+		return configError(fmt.Errorf("-format must be one of %s", strings.Join(textFormats, ", ")))
+	case *format != "json" && *list != "":
+		return configError(errors.New("-format " + *format + " needs a single -ticker"))
+	case *format != "json" && q.Has("fields"):
+		return configError(errors.New("-fields applies to JSON output only"))
+	}
+	view, err := parseResultView(q)
+	if err != nil {
+		return configError(err)
+	}
+	if *dryRun {
+		n := 1
+		if tickers != nil {
+			n = len(tickers)
+		}
+		return writeJSONOutput(*out, planRun(params, n))
+	}
+	if *artifacts != "" {
+		artifactsDir = *artifacts
+	}
+	if err := setPolygonKey(*apiKey); err != nil {
+		return err
+	}
+	if tickers != nil {
+		summary := *out
+		if summary == "" {
+			summary = filepath.Join(*dir, "summary.csv")
+		}
+		failed, err := runBatch(tickers, params, view, *dir, summary, *jobs, q.Encode(), *fresh)
+		if err != nil {
+			return err
+		}
+		notef("wrote %s", summary)
+		if failed > 0 {
+			return fmt.Errorf("%d of %d tickers failed or are partial", failed, len(tickers))
+		}
+		return nil
+	}
+	resp, err := analyze(params)
+	if err != nil {
+		return err
+	}
+	if *format != "json" {
+		err = writeTextReport(*out, *format, resp)
+	} else {
+		var v any
+		if v, err = view.apply(resp); err == nil {
+			err = writeJSONOutput(*out, v)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.Summary.Sessions == 0:
+		msg := resp.Error
+		if msg == "" {
+			msg = fmt.Sprintf("no gaps of %.2f%% or more", params.MinGap)
+		}
+		return noDataError(fmt.Errorf("%s: %s", params.Ticker, msg))
+	case !resp.Success:
+		return &exitError{code: exitProvider, err: fmt.Errorf("%s: %s", params.Ticker, resp.Error)}
+	}
+	return nil
+}
+
+func runScanCmd(args []string) error {
+	fs, apiKey, out := apiFlagSet("scan", opParams("getScan"))
+	fs.Usage = commandUsage(fs, "scan")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	p, err := parseScanParams(flagValues(fs, "apikey", "o"))
+	if err != nil {
+		return configError(err)
+	}
+	if err := setPolygonKey(*apiKey); err != nil {
+		return err
+	}
+	resp, err := scan(p)
+	if err != nil {
+		return err
+	}
+	if err := writeJSONOutput(*out, resp); err != nil {
+		return err
+	}
+	if resp.Matched == 0 {
+		return noDataError(fmt.Errorf("no gaps on %s", resp.Date))
+	}
+	return nil
+}
+
+func runBacktestCmd(args []string) error {
+	fs, apiKey, out := apiFlagSet("backtest", opParams("getBacktest"), []apiParam{
+		{Name: "format", Type: "string", Desc: "csv writes the trade list instead of JSON", Enum: []string{"csv"}},
+	})
+	fs.Usage = commandUsage(fs, "backtest")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if err := setPolygonKey(*apiKey); err != nil {
+		return err
+	}
+	return runHandler(handleBacktest, flagValues(fs, "apikey", "o"), *out, false)
+}
+
+func runExportCmd(args []string) error {
+	fs, apiKey, out := apiFlagSet("export", tickerParam, analyzeParamSpecs, sheetParam, []apiParam{
+		{Name: "format", Type: "string", Desc: "file format (default csv)", Enum: []string{"csv", "xlsx", "parquet", "pdf"}},
+	})
+	fs.Usage = func() {
+		commandUsage(fs, "export")()
+		fmt.Fprintf(os.Stderr, "\nWithout -o the file is named as the download would be, e.g. TSLA_gaps.xlsx.\n")
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	q := flagValues(fs, "apikey", "o")
+	h := handleAnalyze
+	switch q.Get("format") {
+	case "":
+		q.Set("format", "csv")
+	case "csv", "xlsx", "parquet":
+	case "pdf":
+		q.Del("format")
+		h = handleReportPDF
+	default:
+		return errors.New("-format must be csv, xlsx, parquet, or pdf")
+	}
+	if err := setPolygonKey(*apiKey); err != nil {
+		return err
+	}
+	return runHandler(h, q, *out, true)
+}
+
+// runCacheCmd asks a running server (whose memory holds the cache) for
+// its readiness report and prints the cache line.
+func runCacheCmd(args []string) error {
+	if len(args) > 0 && (args[0] == "export" || args[0] == "import") {
+		return runCacheArchiveCmd(args[0], args[1:])
+	}
+	if len(args) > 0 && args[0] == "compact" {
+		return runCacheCompactCmd(args[1:])
+	}
+	fs := flag.NewFlagSet("cache", flag.ContinueOnError)
+	server := fs.String("server", "http://localhost:8083", "base URL of the running server")
+	fs.Usage = commandUsage(fs, "cache")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	cl := &http.Client{Timeout: 30 * time.Second}
+	resp, err := cl.Get(strings.TrimRight(*server, "/") + "/readyz")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var ready ReadyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ready); err != nil {
+		return fmt.Errorf("%s: %v", *server, err)
+	}
+	c, ok := ready.Checks["stores"]
+	if !ok {
+		return fmt.Errorf("%s: no cache report", *server)
+	}
+	fmt.Println(c.Detail)
+	return nil
+}
+
+// runCacheArchiveCmd runs "cache export FILE" (the bar store as a gzipped
+// tar) or "cache import FILE" (merge one into the store); FILE - is stdout
+// or stdin.
+func runCacheArchiveCmd(sub string, args []string) error {
+	fs := flag.NewFlagSet("cache "+sub, flag.ContinueOnError)
+	dir := fs.String("dir", "", "bar store directory (overrides BAR_STORE_DIR)")
+	var tickers *string
+	if sub == "export" {
+		tickers = fs.String("tickers", "", "comma-separated tickers to export (default all)")
+	}
+	fs.Usage = commandUsage(fs, "cache "+sub+" FILE")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return configError(fmt.Errorf("cache %s needs one FILE (- for std%s)", sub, map[string]string{"export": "out", "import": "in"}[sub]))
+	}
+	store := &gapcore.Stored{Dir: barStore.Dir}
+	if *dir != "" {
+		store.Dir = *dir
+	}
+	if store.Dir == "" {
+		return configError(errors.New("no bar store: set BAR_STORE_DIR or -dir"))
+	}
+	name := fs.Arg(0)
+
+	if sub == "export" {
+		var list []string
+		if *tickers != "" {
+			var err error
+			if list, err = parseTickers(*tickers, math.MaxInt); err != nil {
+				return configError(err)
+			}
+		}
+		out := io.WriteCloser(nopCloser{os.Stdout})
+		if name != "-" {
+			f, err := os.Create(name)
+			if err != nil {
+				return err
+			}
+			out = f
+		}
+		st, err := store.Export(out, list)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		notef("exported %d tickers (%d daily ranges, %d minute sessions) from %s", st.Tickers, st.DailyRanges, st.MinuteSessions, store.Dir)
+		return nil
+	}
+	in := io.Reader(os.Stdin)
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	st, err := store.Import(in)
+	if err != nil {
+		return err
+	}
+	notef("imported %d tickers (%d daily ranges, %d minute sessions, %d already stored) into %s", st.Tickers, st.DailyRanges, st.MinuteSessions, st.Skipped, store.Dir)
+	return nil
+}
+
+// runCacheCompactCmd runs "cache compact": one compaction of the bar store
+// under its configured retention, or the one the flags give.
+func runCacheCompactCmd(args []string) error {
+	fs := flag.NewFlagSet("cache compact", flag.ContinueOnError)
+	dir := fs.String("dir", "", "bar store directory (overrides BAR_STORE_DIR)")
+	years := fs.Int("minute-years", barStore.Retention.MinuteYears, "drop minute sessions older than this many years, 0 keeps all (overrides BAR_STORE_MINUTE_YEARS)")
+	maxMB := fs.Int64("max-mb", barStore.Retention.MaxBytes>>20, "evict least recently used minute sessions until the store fits, 0 for no cap (overrides BAR_STORE_MAX_MB)")
+	fs.Usage = commandUsage(fs, "cache compact")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	store := &gapcore.Stored{Dir: barStore.Dir, Retention: gapcore.Retention{MinuteYears: *years, MaxBytes: *maxMB << 20}}
+	if *dir != "" {
+		store.Dir = *dir
+	}
+	if store.Dir == "" {
+		return configError(errors.New("no bar store: set BAR_STORE_DIR or -dir"))
+	}
+	if *years < 0 || *maxMB < 0 {
+		return configError(errors.New("-minute-years and -max-mb must not be negative"))
+	}
+	st, err := store.Compact()
+	if err != nil {
+		return err
+	}
+	notef("%s: %s", store.Dir, compactSummary(st))
+	return nil
+}
+
+// opParams returns the query parameters of the API operation id.
+func opParams(id string) []apiParam {
+	for _, op := range apiOps {
+		if op.ID == id {
+			return op.Params
+		}
+	}
+	return nil
+}
+
+// cliResponse is an http.ResponseWriter that sends a handler's body to a
+// file (created on the first write) or stdout, and keeps error bodies.
+type cliResponse struct {
+	header http.Header
+	status int
+	path   string
+	named  bool // name the file after Content-Disposition if path is ""
+	out    io.WriteCloser
+	errBuf bytes.Buffer
+}
+
+func (c *cliResponse) Header() http.Header { return c.header }
+
+func (c *cliResponse) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *cliResponse) Write(b []byte) (int, error) {
+	c.WriteHeader(http.StatusOK)
+	if c.status >= 400 {
+		return c.errBuf.Write(b)
+	}
+	if c.out == nil {
+		path := c.path
+		if path == "" && c.named {
+			_, ps, _ := mime.ParseMediaType(c.header.Get("Content-Disposition"))
+			path = ps["filename"]
+		}
+		if path == "" {
+			c.out = nopCloser{os.Stdout}
+		} else {
+			f, err := os.Create(path)
+			if err != nil {
+				return 0, err
+			}
+			c.out, c.path = f, path
+		}
+	}
+	return c.out.Write(b)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// runHandler serves q to h in-process and writes the response body to path
+// (stdout if "", or the download's file name if named). An error response
+// comes back as its message.
+func runHandler(h http.HandlerFunc, q url.Values, path string, named bool) error {
+	r, err := http.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	c := &cliResponse{header: http.Header{}, path: path, named: named}
+	h(c, r)
+	if c.out != nil {
+		if err := c.out.Close(); err != nil {
+			return err
+		}
+		if c.path != "" && c.path != path {
+			notef("wrote %s", c.path)
+		}
+	}
+	if c.status >= 400 {
+		var e errorResponse
+		if json.Unmarshal(c.errBuf.Bytes(), &e) == nil && e.Error.Message != "" {
+			return apiExitError(e.Error.Code, errors.New(e.Error.Message))
+		}
+		return fmt.Errorf("%s", strings.TrimSpace(c.errBuf.String()))
+	}
+	return nil
+}
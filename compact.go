@@ -0,0 +1,67 @@
+// compact.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Bar Store Compaction =========================
+
+// With BAR_STORE_DIR and a retention limit set — BAR_STORE_MINUTE_YEARS,
+// BAR_STORE_MAX_MB, or both — the server compacts the bar store every
+// weekday at compactAt, after the cache warmer has added the day's
+// sessions (see gapcore.Stored.Compact). `cache compact` runs one
+// compaction by hand.
+
+// Compaction time (HH:MM New York); COMPACT_AT overrides it and "off"
+// disables the compactor.
+var compactAt = "20:45"
+
+// setStoreRetention reads the bar store's retention limits.
+func setStoreRetention() error {
+	if v := strings.TrimSpace(os.Getenv("BAR_STORE_MINUTE_YEARS")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("BAR_STORE_MINUTE_YEARS must be whole years (0 keeps all), got %q", v)
+		}
+		barStore.Retention.MinuteYears = n
+	}
+	if v := strings.TrimSpace(os.Getenv("BAR_STORE_MAX_MB")); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("BAR_STORE_MAX_MB must be megabytes (0 for no cap), got %q", v)
+		}
+		barStore.Retention.MaxBytes = n << 20
+	}
+	return nil
+}
+
+// runStoreCompactor compacts the bar store at compactAt every weekday.
+func runStoreCompactor() {
+	at, err := time.Parse("15:04", compactAt)
+	if err != nil {
+		log.Printf("compact: COMPACT_AT %q is not HH:MM; compactor disabled", compactAt)
+		return
+	}
+	for {
+		time.Sleep(time.Until(nextWeekdayAt(at, time.Now())))
+		st, err := barStore.Compact()
+		if err != nil {
+			log.Printf("compact: %v", err)
+			continue
+		}
+		log.Printf("compact: %s", compactSummary(st))
+	}
+}
+
+func compactSummary(st gapcore.CompactStats) string {
+	return fmt.Sprintf("%d minute sessions expired, %d evicted, %.1f MB freed; %d sessions, %.1f MB left",
+		st.Expired, st.Evicted, float64(st.FreedBytes)/(1<<20), st.Sessions, float64(st.Bytes)/(1<<20))
+}
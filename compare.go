@@ -0,0 +1,148 @@
+// compare.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Ticker Comparison =========================
+
+// A comparison runs the /api/gaps analysis for a few tickers at once and
+// lines their summaries and bin tables up side by side. Every ticker uses
+// the same minGap, so the bin tables share labels.
+
+const (
+	maxCompareTickers = 10
+	compareWorkers    = 4 // concurrent analyses; each fetches its own minute bars
+)
+
+type CompareSummary struct {
+	Ticker    string            `json:"ticker"`
+	Error     string            `json:"error,omitempty"` // intraday fetch failure; daily stats still set
+	Summary   gapcore.Summary   `json:"summary"`
+	Summary15 gapcore.Summary15 `json:"summary_15m"`
+}
+
+type CompareCell struct {
+	Ticker             string  `json:"ticker"`
+	Count              int     `json:"count"`
+	ContinuationRate   float64 `json:"continuation_rate"`
+	ContinuationShrunk float64 `json:"continuation_rate_shrunk"`
+	GapFillRate        float64 `json:"gap_fill_rate"` // by the close, or by 09:45 in bins_15m
+	FadeAvg            float64 `json:"fade_avg"`
+	FollowAvg          float64 `json:"follow_avg"`
+	Recommendation     string  `json:"recommendation"`
+}
+
+type CompareBin struct {
+	Label string        `json:"label"`
+	Cells []CompareCell `json:"cells"` // in tickers order
+}
+
+type CompareResponse struct {
+	SchemaVersion schemaVersion     `json:"schema_version"`
+	Success       bool              `json:"success"`
+	Error         string            `json:"error,omitempty"`
+	Tickers       []string          `json:"tickers"` // compared, in request order
+	Years         int               `json:"years"`
+	MinGap        float64           `json:"min_gap"`
+	Failed        map[string]string `json:"failed,omitempty"` // ticker → error
+	Summaries     []CompareSummary  `json:"summaries"`
+	Bins          []CompareBin      `json:"bins"`
+	Bins15        []CompareBin      `json:"bins_15m"`
+}
+
+// compareBins lines up each ticker's cells (ticker → bin label → cell)
+// under the shared bin labels; a ticker without a bin gets an empty cell.
+func compareBins(labels []string, cells map[string]map[string]CompareCell, tickers []string) []CompareBin {
+	out := make([]CompareBin, len(labels))
+	for i, lab := range labels {
+		out[i] = CompareBin{Label: lab}
+		for _, tk := range tickers {
+			c, ok := cells[tk][lab]
+			if !ok {
+				c = CompareCell{Recommendation: "NEUTRAL"}
+			}
+			c.Ticker = tk
+			out[i].Cells = append(out[i].Cells, c)
+		}
+	}
+	return out
+}
+
+func handleCompare(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	params, err := parseAnalyzeValues(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tickers, err := basketParam(q, maxCompareTickers)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results := make([]gapcore.AnalyzeResponse, len(tickers))
+	errs := make([]error, len(tickers))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, compareWorkers)
+	for i, tk := range tickers {
+		wg.Add(1)
+		go func(i int, tk string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			p := params
+			p.Ticker = tk
+			results[i], errs[i] = analyze(p)
+		}(i, tk)
+	}
+	wg.Wait()
+
+	out := CompareResponse{
+		Success: true,
+		Years:   params.Years,
+		MinGap:  params.MinGap,
+		Failed:  map[string]string{},
+	}
+	daily, first15 := map[string]map[string]CompareCell{}, map[string]map[string]CompareCell{}
+	for i, tk := range tickers {
+		if errs[i] != nil {
+			out.Failed[tk] = errs[i].Error()
+			continue
+		}
+		resp := results[i]
+		out.Tickers = append(out.Tickers, tk)
+		out.Summaries = append(out.Summaries, CompareSummary{Ticker: tk, Error: resp.Error, Summary: resp.Summary, Summary15: resp.Summary15})
+		daily[tk], first15[tk] = map[string]CompareCell{}, map[string]CompareCell{}
+		for _, b := range resp.Bins {
+			daily[tk][b.Label] = CompareCell{
+				Count: b.Count, ContinuationRate: b.ContinuationRate, ContinuationShrunk: b.ContinuationShrunk,
+				GapFillRate: b.GapFillRate, FadeAvg: b.FadeAvg, FollowAvg: b.FollowAvg, Recommendation: b.Recommendation,
+			}
+		}
+		for _, b := range resp.Bins15 {
+			first15[tk][b.Label] = CompareCell{
+				Count: b.Count, ContinuationRate: b.ContinuationRate, ContinuationShrunk: b.ContinuationShrunk,
+				GapFillRate: b.GapFillBy0945Rate, FadeAvg: b.FadeAvg, FollowAvg: b.FollowAvg, Recommendation: b.Recommendation,
+			}
+		}
+	}
+	var labels []string
+	for _, b := range gapcore.DefaultBins(params.MinGap) {
+		labels = append(labels, b.Label)
+	}
+	out.Bins = compareBins(labels, daily, out.Tickers)
+	out.Bins15 = compareBins(labels, first15, out.Tickers)
+	if len(out.Tickers) == 0 {
+		out.Success = false
+		out.Error = "no ticker could be analyzed"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
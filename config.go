@@ -0,0 +1,491 @@
+// config.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Config File =========================
+
+// Settings can also come from gap-analyzer.yaml (or .yml, or .toml) in the
+// working directory, or the file -config or GAP_ANALYZER_CONFIG names:
+//
+//	provider:
+//	  name: polygon
+//	  api_key: abc123
+//	server:
+//	  port: 9000
+//	analysis:
+//	  bins: [0.5, 1, 2, 4]
+//	paths:
+//	  watchlists: /var/lib/gaps/watchlists.json
+//
+// Each key stands in for an environment variable (configKeys), and only
+// fills it when the environment (or .env) leaves it unset, so a flag beats
+// the environment, which beats the file. The parser reads the subset of
+// YAML and TOML that configs like this need: nested sections, scalars, and
+// lists of scalars.
+//
+// Named profiles bundle settings for one data subscription or another,
+// chosen per run with -profile NAME (or GAP_ANALYZER_PROFILE):
+//
+//	profiles:
+//	  free:
+//	    provider:
+//	      api_key: abc123
+//	      rate_limit: 5
+//	  paid:
+//	    provider:
+//	      api_key: def456
+//
+// A profile's keys are the file's keys, and unlike them they override the
+// environment: picking a profile is as explicit as a flag. Flags still win.
+
+// configKeys maps section.key to the environment variable it sets.
+var configKeys = map[string]string{
+	"provider.api_key":       "POLYGON_API_KEY",
+	"provider.rate_limit":    "POLYGON_RATE_LIMIT",
+	"provider.stream":        "POLYGON_STREAM",
+	"server.port":            "PORT",
+	"server.cors":            "CORS_ORIGINS",
+	"server.tls_cert":        "TLS_CERT",
+	"server.tls_key":         "TLS_KEY",
+	"server.tls_host":        "TLS_HOST",
+	"server.acme_email":      "ACME_EMAIL",
+	"server.acme_directory":  "ACME_DIRECTORY",
+	"server.api_tokens_file": "API_TOKENS_FILE",
+	"server.rate_limit":      "RATE_LIMIT",
+	"server.rate_burst":      "RATE_BURST",
+	"server.browser":         "BROWSER",
+	"server.alerts_at":       "ALERTS_AT",
+	"server.warm_at":         "WARM_AT",
+	"server.compact_at":      "COMPACT_AT",
+	"server.report_at":       "REPORT_AT",
+	"server.precompute":      "PRECOMPUTE_TICKERS",
+	"server.dashboard":       "DASHBOARD_WATCHLIST",
+	"server.dashboard_every": "DASHBOARD_EVERY",
+	"smtp.host":              "SMTP_HOST",
+	"smtp.port":              "SMTP_PORT",
+	"smtp.user":              "SMTP_USER",
+	"smtp.password":          "SMTP_PASSWORD",
+	"smtp.from":              "SMTP_FROM",
+	"telegram.token":         "TELEGRAM_BOT_TOKEN",
+	"telegram.chats":         "TELEGRAM_CHATS",
+	"analysis.bins":          "GAP_BINS",
+	"analysis.htb":           "HTB_TICKERS",
+	"paths.presets":          "PRESETS_FILE",
+	"paths.watchlists":       "WATCHLISTS_FILE",
+	"paths.settings":         "SETTINGS_FILE",
+	"paths.alerts":           "ALERTS_FILE",
+	"paths.notify":           "NOTIFY_FILE",
+	"paths.acme_cache":       "ACME_DIR",
+	"paths.schedule":         "SCHEDULE_FILE",
+	"paths.runs":             "SCHEDULE_DIR",
+	"paths.artifacts":        "ARTIFACTS_DIR",
+	"paths.bars":             "BAR_STORE_DIR",
+	"cache.minute_years":     "BAR_STORE_MINUTE_YEARS",
+	"cache.max_mb":           "BAR_STORE_MAX_MB",
+	"paths.warehouse":        "WAREHOUSE_DIR",
+	"paths.response_log":     "RESPONSE_LOG",
+	"paths.replay_log":       "REPLAY_LOG",
+}
+
+// configProviders are the supported provider.name values.
+var configProviders = []string{"polygon"}
+
+var configNames = []string{"gap-analyzer.yaml", "gap-analyzer.yml", "gap-analyzer.toml"}
+
+// globalFlags are the flags taken before or after the command.
+type globalFlags struct {
+	config  string // -config FILE
+	profile string // -profile NAME
+	quiet   bool   // -quiet
+}
+
+// splitGlobalFlags removes -config FILE, -profile NAME (or -config=FILE,
+// -profile=NAME), and -quiet from args, which may come before or after the
+// command.
+func splitGlobalFlags(args []string) (globalFlags, []string, error) {
+	var g globalFlags
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, val, hasVal := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		switch {
+		case !strings.HasPrefix(a, "-"):
+			rest = append(rest, a)
+		case name == "quiet":
+			g.quiet = true
+			if hasVal {
+				var err error
+				if g.quiet, err = strconv.ParseBool(val); err != nil {
+					return g, nil, fmt.Errorf("-quiet=%s: want true or false", val)
+				}
+			}
+		case name == "config" || name == "profile":
+			if !hasVal {
+				if i+1 == len(args) {
+					return g, nil, fmt.Errorf("-%s needs a value", name)
+				}
+				i++
+				val = args[i]
+			}
+			if name == "config" {
+				g.config = val
+			} else {
+				g.profile = val
+			}
+		default:
+			rest = append(rest, a)
+		}
+	}
+	return g, rest, nil
+}
+
+// loadConfig reads the config file at path, else GAP_ANALYZER_CONFIG, else
+// the first of configNames present, and sets the environment variables it
+// stands in for that are unset, then those of profile (else
+// GAP_ANALYZER_PROFILE), if any, whether set or not. No file is not an
+// error unless one or a profile was named.
+func loadConfig(path, profile string) error {
+	if path == "" {
+		path = os.Getenv("GAP_ANALYZER_CONFIG")
+	}
+	if profile == "" {
+		profile = os.Getenv("GAP_ANALYZER_PROFILE")
+	}
+	if path == "" {
+		for _, n := range configNames {
+			if _, err := os.Stat(n); err == nil {
+				path = n
+				break
+			}
+		}
+	}
+	if path == "" {
+		if profile != "" {
+			return fmt.Errorf("profile %q needs a config file (%s)", profile, strings.Join(configNames, ", "))
+		}
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var kv map[string]string
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		kv, err = parseTOMLConfig(string(data))
+	} else {
+		kv, err = parseYAMLConfig(string(data))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	top := map[string]string{}
+	profiles := map[string]map[string]string{}
+	for k, v := range kv {
+		rest, ok := strings.CutPrefix(k, "profiles.")
+		if !ok {
+			top[k] = v
+			continue
+		}
+		name, key, ok := strings.Cut(rest, ".")
+		if !ok {
+			return fmt.Errorf("%s: %s: want profiles.NAME.section.key", path, k)
+		}
+		if profiles[name] == nil {
+			profiles[name] = map[string]string{}
+		}
+		profiles[name][key] = v
+	}
+	env, err := configEnv(top)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for k, v := range env {
+		if _, set := os.LookupEnv(k); !set {
+			os.Setenv(k, v)
+		}
+	}
+	names := make([]string, 0, len(profiles))
+	for n, p := range profiles {
+		names = append(names, n)
+		if _, err := configEnv(p); err != nil {
+			return fmt.Errorf("%s: profile %s: %v", path, n, err)
+		}
+	}
+	if profile == "" {
+		return nil
+	}
+	p, ok := profiles[profile]
+	if !ok {
+		sort.Strings(names)
+		return fmt.Errorf("%s: no profile %q (profiles: %s)", path, profile, strings.Join(names, ", "))
+	}
+	env, _ = configEnv(p)
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	return nil
+}
+
+// configEnv checks the section.key settings in kv and returns the
+// environment variables they set.
+func configEnv(kv map[string]string) (map[string]string, error) {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	env := map[string]string{}
+	for _, k := range keys {
+		if k == "provider.name" {
+			if !contains(configProviders, kv[k]) {
+				return nil, fmt.Errorf("provider.name must be one of %s", strings.Join(configProviders, ", "))
+			}
+			continue
+		}
+		e, ok := configKeys[k]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %s", k)
+		}
+		env[e] = kv[k]
+	}
+	return env, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseYAMLConfig reads "key: value" lines, nested by indentation under
+// "section:" lines, with [a, b] or "- a" lists.
+func parseYAMLConfig(src string) (map[string]string, error) {
+	kv := map[string]string{}
+	type level struct {
+		indent int
+		key    string
+	}
+	var stack []level // enclosing sections, outermost first
+	last := ""
+	for n, line := range strings.Split(src, "\n") {
+		text := strings.TrimRight(stripComment(line), " \t\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		indent := len(text) - len(strings.TrimLeft(text, " \t"))
+		text = strings.TrimSpace(text)
+		if item, ok := strings.CutPrefix(text, "- "); ok {
+			if last == "" {
+				return nil, fmt.Errorf("line %d: list item without a key", n+1)
+			}
+			v, err := configScalar(item)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n+1, err)
+			}
+			if kv[last] != "" {
+				v = kv[last] + "," + v
+			}
+			kv[last] = v
+			continue
+		}
+		key, val, ok := strings.Cut(text, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want key: value", n+1)
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		bare := key
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		for i := len(stack) - 1; i >= 0; i-- {
+			key = stack[i].key + "." + key
+		}
+		if val == "" {
+			stack = append(stack, level{indent, bare})
+			last = key
+			continue
+		}
+		v, err := configValue(val)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+1, err)
+		}
+		kv[key], last = v, key
+	}
+	return kv, nil
+}
+
+// parseTOMLConfig reads "key = value" lines under "[section]" headers.
+func parseTOMLConfig(src string) (map[string]string, error) {
+	kv := map[string]string{}
+	section := ""
+	for n, line := range strings.Split(src, "\n") {
+		text := strings.TrimSpace(stripComment(line))
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section = strings.TrimSpace(text[1 : len(text)-1])
+			continue
+		}
+		key, val, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want key = value", n+1)
+		}
+		key = strings.TrimSpace(key)
+		if section != "" {
+			key = section + "." + key
+		}
+		v, err := configValue(strings.TrimSpace(val))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+1, err)
+		}
+		kv[key] = v
+	}
+	return kv, nil
+}
+
+// stripComment drops a # comment that is not inside quotes.
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// configValue reads a scalar, or a [a, b] list as "a,b".
+func configValue(s string) (string, error) {
+	if !strings.HasPrefix(s, "[") {
+		return configScalar(s)
+	}
+	if !strings.HasSuffix(s, "]") {
+		return "", errors.New("unterminated list")
+	}
+	var items []string
+	for _, it := range strings.Split(s[1:len(s)-1], ",") {
+		if it = strings.TrimSpace(it); it == "" {
+			continue
+		}
+		v, err := configScalar(it)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, v)
+	}
+	return strings.Join(items, ","), nil
+}
+
+// configScalar unquotes a quoted string; other scalars are taken as is.
+func configScalar(s string) (string, error) {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return s[1 : len(s)-1], nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("bad string %s", s)
+		}
+		return v, nil
+	}
+	return s, nil
+}
+
+// setProviderRate sets the Polygon request cap from POLYGON_RATE_LIMIT
+// (requests per minute), if set.
+func setProviderRate() error {
+	v := os.Getenv("POLYGON_RATE_LIMIT")
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || n < 0 {
+		return fmt.Errorf("POLYGON_RATE_LIMIT must be requests per minute (0 for no cap), got %q", v)
+	}
+	polygon.RateLimit = n
+	return nil
+}
+
+// replay answers every bar request when REPLAY_LOG is set.
+var replay *gapcore.Replay
+
+// setResponseLog records Polygon's responses to RESPONSE_LOG or, with
+// REPLAY_LOG set, answers bar requests from that log alone: the bar store
+// is bypassed and no API key is needed.
+func setResponseLog() error {
+	if path := os.Getenv("REPLAY_LOG"); path != "" {
+		rp, err := gapcore.LoadReplay(path)
+		if err != nil {
+			return fmt.Errorf("REPLAY_LOG: %v", err)
+		}
+		replay = rp
+		barStore.Provider, barStore.Dir = rp, ""
+		return nil
+	}
+	if path := os.Getenv("RESPONSE_LOG"); path != "" {
+		l, err := gapcore.OpenResponseLog(path)
+		if err != nil {
+			return fmt.Errorf("RESPONSE_LOG: %v", err)
+		}
+		polygon.Log = l
+	}
+	return nil
+}
+
+// htbTickers is the hard-to-borrow list (HTB_TICKERS) of backtests that
+// do not pass htb.
+var htbTickers []string
+
+// setBinEdges sets the gap bins from GAP_BINS ("0.5,1,1.5"), if set.
+func setBinEdges() error {
+	v := os.Getenv("GAP_BINS")
+	if v == "" {
+		return nil
+	}
+	edges, err := parseBinEdges(v)
+	if err != nil {
+		return fmt.Errorf("GAP_BINS %v", err)
+	}
+	gapcore.BinEdges = edges
+	return nil
+}
+
+// parseBinEdges reads comma-separated bin edges.
+func parseBinEdges(v string) ([]float64, error) {
+	var edges []float64
+	for _, f := range strings.Split(v, ",") {
+		x, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil || x <= 0 || x >= 99 || (len(edges) > 0 && x <= edges[len(edges)-1]) {
+			return nil, fmt.Errorf("must be increasing gap percentages between 0 and 99, got %q", v)
+		}
+		edges = append(edges, x)
+	}
+	return edges, nil
+}
@@ -0,0 +1,61 @@
+// cors.go
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ========================= CORS =========================
+
+// Browsers only let a page on another origin (a separately hosted frontend,
+// a Jupyter notebook) read /api/ responses if the server opts in. With
+// -cors (or CORS_ORIGINS) set to a list of origins, /api/ answers their
+// preflights and marks its responses readable by them; "*" allows any
+// origin. Without it no CORS headers are sent, as before.
+
+// allowedOrigins are the server's CORS origins; /ws accepts them too.
+var allowedOrigins map[string]bool
+
+// corsOrigins parses a comma-separated origin list.
+func corsOrigins(s string) map[string]bool {
+	out := map[string]bool{}
+	for _, o := range strings.Split(s, ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			out[o] = true
+		}
+	}
+	return out
+}
+
+// withCORS wraps next with CORS handling of /api/ for origins.
+func withCORS(next http.Handler, origins map[string]bool) http.Handler {
+	if len(origins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if !origins["*"] && !origins[origin] {
+			next.ServeHTTP(w, r) // the browser blocks the read
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Expose-Headers", "ETag, Content-Disposition, Schema-Version, Deprecation, Link")
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			if rh := r.Header.Get("Access-Control-Request-Headers"); rh != "" {
+				h.Set("Access-Control-Allow-Headers", rh)
+			}
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,142 @@
+// cron.go
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ========================= Cron Expressions =========================
+
+// Scheduled jobs run on five-field cron expressions — minute, hour, day of
+// month, month, weekday — read in New York time: "45 8 * * mon-fri" is
+// 08:45 ET every weekday. A field is *, a value, a range a-b, or a list of
+// them, each optionally stepped with /n; weekdays and months may be named
+// (mon, jan). As in cron, when both day fields are restricted a day
+// matching either one runs.
+
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64 // bit i set: value i matches
+	domAny, dowAny                bool
+}
+
+var cronMonths = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+
+var cronDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func parseCron(s string) (cronSpec, error) {
+	f := strings.Fields(s)
+	if len(f) != 5 {
+		return cronSpec{}, fmt.Errorf("cron %q: want 5 fields (minute hour day month weekday)", s)
+	}
+	var c cronSpec
+	var err error
+	for _, fd := range []struct {
+		dst         *uint64
+		src         string
+		first, last int
+		names       []string
+	}{
+		{&c.minute, f[0], 0, 59, nil},
+		{&c.hour, f[1], 0, 23, nil},
+		{&c.dom, f[2], 1, 31, nil},
+		{&c.month, f[3], 1, 12, cronMonths},
+		{&c.dow, f[4], 0, 7, cronDays},
+	} {
+		if *fd.dst, err = parseCronField(fd.src, fd.first, fd.last, fd.names); err != nil {
+			return cronSpec{}, fmt.Errorf("cron %q: %v", s, err)
+		}
+	}
+	if c.dow&(1<<7) != 0 { // 7 is Sunday too
+		c.dow |= 1
+	}
+	c.domAny, c.dowAny = f[2] == "*", f[4] == "*"
+	return c, nil
+}
+
+func parseCronField(s string, first, last int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			step = n
+		}
+		lo, hi := first, last
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = cronValue(a, first, last, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = cronValue(b, first, last, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = last
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("bad range %q", rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func cronValue(s string, first, last int, names []string) (int, error) {
+	for i, n := range names {
+		if n != "" && strings.EqualFold(s, n) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < first || v > last {
+		return 0, fmt.Errorf("%q is not between %d and %d", s, first, last)
+	}
+	return v, nil
+}
+
+func (c cronSpec) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// next returns the first minute after t that c matches, in t's location,
+// or the zero time if none does within five years (e.g. "0 0 31 2 *").
+func (c cronSpec) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
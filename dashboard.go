@@ -0,0 +1,223 @@
+// dashboard.go
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Premarket Dashboard =========================
+
+// With DASHBOARD_WATCHLIST (or serve -dashboard) naming a watchlist, the
+// server keeps a live table of that list's gaps through the premarket:
+// every DASHBOARD_EVERY seconds (default 60) from 07:00 to the 09:30 open,
+// New York time, on NYSE trading days, it fetches all the list's snapshots
+// in one request and prices each gap the way /api/v1/today does.
+// /api/v1/dashboard returns the latest table, largest gap first, and
+// /dashboard is a page that shows it and keeps itself current.
+//
+// With the Polygon stream on (POLYGON_STREAM, see stream.go), the table is
+// also repriced from the streamed trades every couple of seconds between
+// refreshes.
+//
+// Each ticker's historical analysis is run once per session date and
+// reused (see watchlistSetups). The watchlist is reread on every refresh,
+// so tickers added to it join the table at the next one.
+
+//go:embed web/dashboard.html
+var dashboardHTML string
+
+var (
+	// dashboardWatchlist names the watched list; "" disables the dashboard.
+	dashboardWatchlist string
+	// dashboardEvery is the premarket refresh interval.
+	dashboardEvery = time.Minute
+)
+
+// The premarket window the dashboard refreshes in (New York).
+const (
+	dashboardFromMins  = 7 * 60
+	dashboardUntilMins = 9*60 + 30
+	// With the stream up, how often the table is repriced from it between
+	// refreshes.
+	dashboardReprice = 2 * time.Second
+)
+
+type DashboardResponse struct {
+	SchemaVersion  schemaVersion   `json:"schema_version"`
+	Watchlist      string          `json:"watchlist"`
+	Live           bool            `json:"live"`              // refreshing now: a trading day's premarket
+	Updated        string          `json:"updated,omitempty"` // last refresh, RFC3339 in New York
+	NextRefresh    string          `json:"next_refresh"`
+	RefreshSeconds int             `json:"refresh_seconds"`
+	Streaming      bool            `json:"streaming"`       // repriced from the Polygon stream between refreshes
+	Error          string          `json:"error,omitempty"` // the last refresh's, when it failed; rows are from the one before
+	Rows           []TodayResponse `json:"rows"`            // largest |gap| first
+}
+
+var (
+	dashboardMu        sync.Mutex // guards dashboard
+	dashboard          = DashboardResponse{Rows: []TodayResponse{}}
+	dashboardRefreshMu sync.Mutex                           // one refresh at a time; guards dashboardSnaps
+	dashboardSnaps     = map[string]polygonTickerSnapshot{} // the last refresh's
+)
+
+// setDashboard reads the dashboard's watchlist and refresh interval.
+func setDashboard(watchlist string) error {
+	if watchlist == "" {
+		watchlist = os.Getenv("DASHBOARD_WATCHLIST")
+	}
+	if watchlist != "" && !presetNameRE.MatchString(watchlist) {
+		return fmt.Errorf("dashboard watchlist %q is not a watchlist name", watchlist)
+	}
+	dashboardWatchlist = watchlist
+	if v := strings.TrimSpace(os.Getenv("DASHBOARD_EVERY")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 5 {
+			return fmt.Errorf("DASHBOARD_EVERY must be at least 5 seconds, got %q", v)
+		}
+		dashboardEvery = time.Duration(n) * time.Second
+	}
+	return nil
+}
+
+// inPremarket reports whether t is in a trading day's refresh window.
+func inPremarket(t time.Time) bool {
+	t = t.In(gapcore.NewYork)
+	mins := t.Hour()*60 + t.Minute()
+	return mins >= dashboardFromMins && mins < dashboardUntilMins && gapcore.TradingDay(t)
+}
+
+// nextDashboardRefresh returns when the refresh after one at now is due:
+// dashboardEvery later within the window, else the next window's start.
+func nextDashboardRefresh(now time.Time) time.Time {
+	if next := now.Add(dashboardEvery); inPremarket(next) {
+		return next
+	}
+	now = now.In(gapcore.NewYork)
+	next := time.Date(now.Year(), now.Month(), now.Day(), dashboardFromMins/60, dashboardFromMins%60, 0, 0, gapcore.NewYork)
+	for !next.After(now) || !gapcore.TradingDay(next) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// runDashboard refreshes the dashboard through every premarket.
+func runDashboard() {
+	for {
+		if inPremarket(time.Now()) {
+			if err := refreshDashboard(context.Background()); err != nil {
+				log.Printf("dashboard: %v", err)
+			}
+		}
+		next := nextDashboardRefresh(time.Now())
+		for quoteStream.connected() && inPremarket(time.Now()) && time.Until(next) > dashboardReprice {
+			time.Sleep(dashboardReprice)
+			repriceDashboard()
+		}
+		time.Sleep(time.Until(next))
+	}
+}
+
+// repriceDashboard reprices the table from the streamed trades over the
+// last refresh's snapshots, without a request; a row without the day's
+// analysis waits for the next refresh. It skips its turn while a refresh
+// runs.
+func repriceDashboard() {
+	if !dashboardRefreshMu.TryLock() {
+		return
+	}
+	defer dashboardRefreshMu.Unlock()
+	dashboardMu.Lock()
+	rows := slices.Clone(dashboard.Rows)
+	dashboardMu.Unlock()
+	for i, row := range rows {
+		if out, ok := setupRow(context.Background(), row.Ticker, dashboardSnaps, false); ok {
+			rows[i] = out
+		}
+	}
+	sortSetups(rows)
+	dashboardMu.Lock()
+	dashboard.Rows = rows
+	dashboardMu.Unlock()
+}
+
+// refreshDashboard reprices the watchlist's gaps. The error is a failed
+// watchlist read or snapshot fetch, which leaves the previous rows; a
+// ticker whose price or analysis fails gets a row with its error.
+func refreshDashboard(ctx context.Context) error {
+	dashboardRefreshMu.Lock()
+	defer dashboardRefreshMu.Unlock()
+	err := func() error {
+		tickers, err := watchlistTickers(dashboardWatchlist)
+		if err != nil {
+			return err
+		}
+		rows, snaps, err := watchlistSetups(ctx, tickers)
+		if err != nil {
+			return err
+		}
+		dashboardSnaps = snaps
+		dashboardMu.Lock()
+		dashboard.Rows, dashboard.Error = rows, ""
+		dashboard.Updated = time.Now().In(gapcore.NewYork).Format(time.RFC3339)
+		dashboardMu.Unlock()
+		return nil
+	}()
+	if err != nil {
+		dashboardMu.Lock()
+		dashboard.Error = err.Error()
+		dashboardMu.Unlock()
+	}
+	return err
+}
+
+// handleDashboard returns the dashboard's latest table, refreshing it first
+// if it has never been refreshed (outside the premarket, say). A failed
+// refresh is the table's error rather than the response's status.
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if dashboardWatchlist == "" {
+		writeError(w, http.StatusNotFound, "no dashboard: DASHBOARD_WATCHLIST is not set")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	dashboardMu.Lock()
+	never := dashboard.Updated == ""
+	dashboardMu.Unlock()
+	if never {
+		refreshDashboard(r.Context()) // a failure is the table's error
+	}
+	now := time.Now()
+	dashboardMu.Lock()
+	out := dashboard
+	dashboardMu.Unlock()
+	out.Watchlist = dashboardWatchlist
+	out.Live = inPremarket(now)
+	out.NextRefresh = nextDashboardRefresh(now).In(gapcore.NewYork).Format(time.RFC3339)
+	out.RefreshSeconds = int(dashboardEvery / time.Second)
+	out.Streaming = quoteStream.connected()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(out)
+}
+
+func handleDashboardPage(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, dashboardHTML)
+}
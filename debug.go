@@ -0,0 +1,34 @@
+// debug.go
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+)
+
+// ========================= Profiling =========================
+
+// With -debug the standard net/http/pprof handlers are served on a listener
+// of their own at debugAddr, bound to loopback, never on the API port:
+// /debug/pprof/cmdline shows the argv, -apikey included, and a CPU profile
+// keeps a request open for its whole duration. They are off by default.
+
+// debugAddr is where -debug serves pprof; reach it remotely over SSH.
+const debugAddr = "127.0.0.1:6060"
+
+// servePprof serves the pprof handlers on debugAddr in the background.
+func servePprof() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	go func() {
+		log.Printf("pprof enabled on http://%s/debug/pprof/", debugAddr)
+		if err := http.ListenAndServe(debugAddr, mux); err != nil {
+			log.Printf("pprof: %v", err)
+		}
+	}()
+}
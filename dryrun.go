@@ -0,0 +1,74 @@
+// dryrun.go
+package main
+
+import (
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Dry Run =========================
+
+// analyze -dry-run prints the request plan instead of running it, so a
+// free-tier user can tell whether a 5-year small-cap batch is feasible
+// before spending the afternoon on it. An analysis makes one daily request
+// per ticker and one minute request per gap session; which sessions gap is
+// only known from the daily bars, so without fetching anything the plan
+// counts every weekday in the range — the most the run can take. The time
+// estimate divides the requests by POLYGON_RATE_LIMIT; the free tier's 5
+// requests a minute is always shown for comparison.
+
+const freeTierRate = 5 // Polygon free tier, requests per minute
+
+type dryRunPlan struct {
+	SchemaVersion   schemaVersion `json:"schema_version"`
+	Tickers         int           `json:"tickers"`
+	From            string        `json:"from"`
+	To              string        `json:"to"`
+	Sessions        int           `json:"sessions"` // weekdays in the range, per ticker
+	DailyCalls      int           `json:"daily_calls"`
+	MinuteCallsMax  int           `json:"minute_calls_max"`
+	TotalCallsMax   int           `json:"total_calls_max"`
+	RateLimit       int           `json:"rate_limit"`            // requests per minute, 0 for no cap
+	EstMinutes      *float64      `json:"est_minutes,omitempty"` // at rate_limit
+	FreeTierMinutes float64       `json:"free_tier_minutes"`     // at 5 requests a minute
+}
+
+// planRun counts the requests analyzing tickers with params can make.
+func planRun(params gapcore.Params, tickers int) dryRunPlan {
+	from, to := params.DateRange()
+	p := dryRunPlan{
+		SchemaVersion: apiSchemaVersion,
+		Tickers:       tickers,
+		From:          from,
+		To:            to,
+		Sessions:      weekdaysBetween(from, to),
+		DailyCalls:    tickers,
+		RateLimit:     polygon.RateLimit,
+	}
+	p.MinuteCallsMax = p.Sessions * tickers
+	p.TotalCallsMax = p.DailyCalls + p.MinuteCallsMax
+	if p.RateLimit > 0 {
+		m := gapcore.Round1(float64(p.TotalCallsMax) / float64(p.RateLimit))
+		p.EstMinutes = &m
+	}
+	p.FreeTierMinutes = gapcore.Round1(float64(p.TotalCallsMax) / freeTierRate)
+	return p
+}
+
+// weekdaysBetween counts Monday–Friday dates from from to to (YYYY-MM-DD),
+// inclusive.
+func weekdaysBetween(from, to string) int {
+	t, err1 := time.Parse("2006-01-02", from)
+	end, err2 := time.Parse("2006-01-02", to)
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	n := 0
+	for ; !t.After(end); t = t.AddDate(0, 0, 1) {
+		if wd := t.Weekday(); wd != time.Saturday && wd != time.Sunday {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,330 @@
+// email.go
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ========================= Email =========================
+
+// A sink of type email mails its notifications through the SMTP server in
+// SMTP_HOST, to the addresses of a mailto: URL (mailto:a@x.com,b@y.com).
+// Each message is multipart/alternative: an HTML part, for the morning
+// report a table of the gappers with their bin stats and recommended play,
+// and a plain-text part with the same table as the chat sinks send it.
+// Port 465 speaks TLS from the start; any other port upgrades with
+// STARTTLS when the server offers it, and SMTP_USER/SMTP_PASSWORD log in
+// with PLAIN auth. A network error or 4xx reply is retried like a webhook's
+// 5xx; a 5xx reply is final.
+
+// smtpConfig is the outgoing mail server; Host is empty when none is set.
+type smtpConfig struct {
+	Host, Port string
+	User, Pass string
+	From       string
+}
+
+var smtpServer smtpConfig
+
+const smtpTimeout = 30 * time.Second
+
+// setSMTP reads SMTP_HOST, SMTP_PORT (default 587), SMTP_USER,
+// SMTP_PASSWORD, and SMTP_FROM (default SMTP_USER).
+func setSMTP() error {
+	c := smtpConfig{
+		Host: strings.TrimSpace(os.Getenv("SMTP_HOST")),
+		Port: strings.TrimSpace(os.Getenv("SMTP_PORT")),
+		User: os.Getenv("SMTP_USER"),
+		Pass: os.Getenv("SMTP_PASSWORD"),
+		From: strings.TrimSpace(os.Getenv("SMTP_FROM")),
+	}
+	if c.Host == "" {
+		return nil
+	}
+	if c.Port == "" {
+		c.Port = "587"
+	}
+	if p, err := strconv.Atoi(c.Port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("SMTP_PORT must be a port number, got %q", c.Port)
+	}
+	if c.From == "" {
+		c.From = c.User
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("SMTP_FROM (or SMTP_USER) must be an email address, got %q", c.From)
+	}
+	smtpServer = c
+	return nil
+}
+
+// mailRecipients returns the addresses of a mailto: URL.
+func mailRecipients(rawURL string) ([]string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "mailto" || u.Opaque == "" {
+		return nil, fmt.Errorf("url must be a mailto: URL")
+	}
+	to, err := url.PathUnescape(u.Opaque)
+	if err != nil {
+		return nil, fmt.Errorf("url must be a mailto: URL")
+	}
+	list, err := mail.ParseAddressList(to)
+	if err != nil {
+		return nil, fmt.Errorf("mailto: %v", err)
+	}
+	out := make([]string, len(list))
+	for i, a := range list {
+		out[i] = a.Address
+	}
+	return out, nil
+}
+
+// mail sends one delivery attempt, returning the SMTP reply code of a
+// refusal (0 otherwise) and whether another attempt may succeed.
+func (s NotifySink) mail(n notification) (int, bool, error) {
+	if smtpServer.Host == "" {
+		return 0, false, errors.New("SMTP_HOST is not set")
+	}
+	to, err := mailRecipients(s.URL)
+	if err != nil {
+		return 0, false, err
+	}
+	msg, err := emailMessage(n, to)
+	if err != nil {
+		return 0, false, err
+	}
+	err = sendMail(smtpServer, to, msg)
+	var te *textproto.Error
+	if errors.As(err, &te) {
+		return te.Code, te.Code/100 == 4, err
+	}
+	return 0, err != nil, err
+}
+
+// sendMail delivers msg to the recipients through c.
+func sendMail(c smtpConfig, to []string, msg []byte) error {
+	addr := net.JoinHostPort(c.Host, c.Port)
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	var err error
+	if c.Port == "465" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: c.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(2 * smtpTimeout))
+	cl, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer cl.Close()
+	if ok, _ := cl.Extension("STARTTLS"); ok && c.Port != "465" {
+		if err := cl.StartTLS(&tls.Config{ServerName: c.Host}); err != nil {
+			return err
+		}
+	}
+	if c.User != "" {
+		if err := cl.Auth(smtp.PlainAuth("", c.User, c.Pass, c.Host)); err != nil {
+			return err
+		}
+	}
+	from, _ := mail.ParseAddress(c.From)
+	if err := cl.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := cl.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := cl.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return cl.Quit()
+}
+
+// emailMessage renders n as a MIME message to the recipients.
+func emailMessage(n notification, to []string) ([]byte, error) {
+	m := chatLayout(n)
+	var page bytes.Buffer
+	if err := emailTemplate.Execute(&page, emailPage(n, m)); err != nil {
+		return nil, err
+	}
+	text := m.title + "\n\n"
+	if len(m.table) > 0 {
+		text += strings.Join(m.table, "\n") + "\n\n"
+	}
+	text += m.note + "\n"
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct{ ctype, content string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", page.String()},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.ctype},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qw := quotedprintable.NewWriter(pw)
+		qw.Write([]byte(part.content))
+		qw.Close()
+	}
+	mw.Close()
+
+	var id [12]byte
+	rand.Read(id[:])
+	from, _ := mail.ParseAddress(smtpServer.From)
+	var msg bytes.Buffer
+	for _, h := range [][2]string{
+		{"From", from.String()},
+		{"To", strings.Join(to, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.title)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", "<" + hex.EncodeToString(id[:]) + "@" + from.Address[strings.LastIndex(from.Address, "@")+1:] + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()},
+	} {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// emailRow is a table row of the HTML message.
+type emailRow struct {
+	Ticker, Gap, Price, Bin      string
+	Sessions, Cont, Fade, Follow string
+	Call, Action, Call15, Why    string
+
+	GapStyle, FadeStyle, FollowStyle template.CSS // red or green by sign
+}
+
+type emailView struct {
+	Title, Note string
+	Report      bool
+	Rows        []emailRow
+	Failed      map[string]string
+}
+
+// emailPage fills the HTML template for n.
+func emailPage(n notification, m chatMessage) emailView {
+	v := emailView{Title: m.title, Note: m.note}
+	switch {
+	case n.Report != nil:
+		v.Report = true
+		for _, g := range n.Report.Gappers {
+			v.Rows = append(v.Rows, emailRowOf(g))
+		}
+		v.Failed = n.Report.Failed
+	case n.Alert != nil:
+		a := n.Alert
+		row := TodayResponse{
+			Ticker: a.Ticker, GapPct: a.GapPct, Bin: a.Bin, BinStats: a.BinStats, BinStats15: a.BinStats15,
+			Recommendation: a.Recommendation, Action: a.Action,
+		}
+		if a.BinStats != nil {
+			row.RecommendationWhy = a.BinStats.RecommendationWhy
+		}
+		if a.BinStats15 != nil {
+			row.Recommendation15 = a.BinStats15.Recommendation
+		}
+		v.Rows = append(v.Rows, emailRowOf(row))
+	}
+	return v
+}
+
+func emailRowOf(g TodayResponse) emailRow {
+	r := emailRow{
+		Ticker: g.Ticker, Gap: fmt.Sprintf("%+.2f%%", g.GapPct), Bin: g.Bin,
+		Sessions: "—", Cont: "—", Fade: "—", Follow: "—", Call15: "—",
+		Call: g.Recommendation, Action: g.Action, Why: g.RecommendationWhy,
+		GapStyle: signStyle(g.GapPct),
+	}
+	if g.Price > 0 {
+		r.Price = fmt.Sprintf("%.2f", g.Price)
+	}
+	if b := g.BinStats; b != nil {
+		r.Sessions, r.Cont = strconv.Itoa(b.Count), fmt.Sprintf("%.1f%%", b.ContinuationRate)
+		r.Fade, r.Follow = fmt.Sprintf("%+.2f%%", b.FadeAvg), fmt.Sprintf("%+.2f%%", b.FollowAvg)
+		r.FadeStyle, r.FollowStyle = signStyle(b.FadeAvg), signStyle(b.FollowAvg)
+	}
+	if g.BinStats15 != nil {
+		r.Call15 = g.Recommendation15
+		if g.Action15 != "" {
+			r.Call15 += " (" + g.Action15 + ")"
+		}
+	}
+	return r
+}
+
+func signStyle(v float64) template.CSS {
+	switch {
+	case v > 0:
+		return "color:#0a7d2c"
+	case v < 0:
+		return "color:#c0392b"
+	}
+	return ""
+}
+
+// Inline styles only: mail clients drop <style> blocks.
+var emailTemplate = template.Must(template.New("email").Funcs(template.FuncMap{
+	"headers": func() []string {
+		return []string{"Ticker", "Gap", "Price", "Bin", "Sessions", "Cont. rate", "Fade avg", "Follow avg", "Call", "15m call"}
+	},
+}).Parse(`<!DOCTYPE html>
+<html><body style="font-family:Helvetica,Arial,sans-serif;color:#222;margin:0;padding:16px">
+<h2 style="margin:0 0 12px">{{.Title}}</h2>
+{{if .Rows}}<table style="border-collapse:collapse;font-size:14px">
+<tr style="background:#f2f2f2">{{range $h := headers}}<th style="border:1px solid #ccc;padding:6px 8px;text-align:left">{{$h}}</th>{{end}}</tr>
+{{range .Rows}}<tr>
+<td style="border:1px solid #ccc;padding:6px 8px"><b>{{.Ticker}}</b></td>
+<td style="border:1px solid #ccc;padding:6px 8px;{{.GapStyle}}">{{.Gap}}</td>
+<td style="border:1px solid #ccc;padding:6px 8px">{{.Price}}</td>
+<td style="border:1px solid #ccc;padding:6px 8px">{{.Bin}}</td>
+<td style="border:1px solid #ccc;padding:6px 8px">{{.Sessions}}</td>
+<td style="border:1px solid #ccc;padding:6px 8px">{{.Cont}}</td>
+<td style="border:1px solid #ccc;padding:6px 8px;{{.FadeStyle}}">{{.Fade}}</td>
+<td style="border:1px solid #ccc;padding:6px 8px;{{.FollowStyle}}">{{.Follow}}</td>
+<td style="border:1px solid #ccc;padding:6px 8px"><b>{{.Call}}</b> {{.Action}}</td>
+<td style="border:1px solid #ccc;padding:6px 8px">{{.Call15}}</td>
+</tr>{{if .Why}}
+<tr><td colspan="10" style="border:1px solid #ccc;padding:4px 8px;font-size:12px;color:#666">{{.Why}}</td></tr>{{end}}
+{{end}}</table>{{else if .Report}}<p>No tickers made the report.</p>{{end}}
+{{if .Failed}}<p style="font-size:13px">Not priced:{{range $t, $e := .Failed}} {{$t}} ({{$e}});{{end}}</p>{{end}}
+<p style="font-size:12px;color:#666">{{.Note}}</p>
+</body></html>
+`))
@@ -0,0 +1,111 @@
+// errors.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Error Responses =========================
+
+// Every failed API call answers with the same JSON body,
+//
+//	{"schema_version": 1, "error": {"code": "...", "message": "...", "provider_status": 429}}
+//
+// so a client can branch on code — a bad parameter, an unknown ticker, our
+// rate limit, or Polygon failing — instead of parsing plaintext. message is
+// for humans and may change; provider_status is Polygon's HTTP status when
+// the failure came from Polygon.
+
+type apiError struct {
+	Code           string `json:"code"`
+	Message        string `json:"message"`
+	ProviderStatus int    `json:"provider_status,omitempty"`
+}
+
+type errorResponse struct {
+	SchemaVersion schemaVersion `json:"schema_version"`
+	Error         apiError      `json:"error"`
+}
+
+// errorCodes names the codes of plain HTTP failures.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:           "invalid_request",
+	http.StatusUnauthorized:         "unauthorized",
+	http.StatusNotFound:             "not_found",
+	http.StatusMethodNotAllowed:     "method_not_allowed",
+	http.StatusConflict:             "conflict",
+	http.StatusUnsupportedMediaType: "unsupported_media_type",
+	http.StatusUpgradeRequired:      "upgrade_required",
+	http.StatusTooManyRequests:      "rate_limited",
+	http.StatusInternalServerError:  "internal_error",
+	http.StatusBadGateway:           "provider_error",
+	http.StatusServiceUnavailable:   "unavailable",
+}
+
+// writeError sends msg with the code for status.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	code, ok := errorCodes[status]
+	if !ok {
+		code = "error"
+	}
+	writeErrorCode(w, status, code, msg, 0)
+}
+
+func writeErrorCode(w http.ResponseWriter, status int, code, msg string, providerStatus int) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: apiError{Code: code, Message: msg, ProviderStatus: providerStatus}})
+}
+
+// writeFetchError sends a failed data fetch, telling an unknown ticker,
+// Polygon throttling, Polygon rejecting the API key, and Polygon being down
+// apart.
+func writeFetchError(w http.ResponseWriter, err error) {
+	var pe *gapcore.ProviderError
+	switch {
+	case errors.Is(err, gapcore.ErrUnknownTicker):
+		writeErrorCode(w, http.StatusNotFound, "unknown_ticker", err.Error(), 0)
+	case errors.As(err, &pe):
+		status, code := http.StatusBadGateway, "provider_error"
+		switch {
+		case pe.Status == http.StatusNotFound:
+			status, code = http.StatusNotFound, "unknown_ticker"
+		case pe.Status == http.StatusTooManyRequests:
+			status, code = http.StatusServiceUnavailable, "provider_rate_limited"
+			if pe.RetryAfter != "" {
+				w.Header().Set("Retry-After", pe.RetryAfter)
+			}
+		case pe.Status == http.StatusUnauthorized || pe.Status == http.StatusForbidden:
+			code = "provider_auth"
+		case pe.Status >= 500:
+			code = "provider_unavailable"
+		}
+		writeErrorCode(w, status, code, err.Error(), pe.Status)
+	default:
+		code, msg := "provider_error", err.Error()
+		var ne net.Error
+		if errors.As(err, &ne) {
+			code = "provider_unavailable" // unreachable or timed out
+		}
+		// The request URL carries the Polygon key.
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			msg = "polygon: " + ue.Err.Error()
+		}
+		writeErrorCode(w, http.StatusBadGateway, code, msg, 0)
+	}
+}
+
+// handleUnknownAPI answers API paths no route matches.
+func handleUnknownAPI(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "unknown endpoint "+r.URL.Path)
+}
@@ -0,0 +1,49 @@
+// etag.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= ETags =========================
+
+// An /api/gaps response is fully determined by its parameters and the bars
+// it was computed from, so its ETag hashes the query, the lookback range,
+// and the last daily bar (date, close, and volume, which move while today's
+// session is open). The bars come from the cache; when they are cached, a
+// matching If-None-Match is answered with 304 before anything is fetched or
+// recomputed.
+
+// gapsETag returns the ETag of /api/gaps for params and query q, or "" when
+// the daily bars are not cached.
+func gapsETag(params gapcore.Params, q url.Values) string {
+	from, to := params.DateRange()
+	bars, ok := provider.DailyBars.Get(params.Ticker + "|" + from + "|" + to)
+	if !ok || len(bars) == 0 {
+		return ""
+	}
+	last := bars[len(bars)-1]
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s|%s|%d|%g|%g|", params.Ticker, from, to, last.T, last.C, last.V)
+	h.Write([]byte(q.Encode()))
+	return `"` + hex.EncodeToString(h.Sum(nil)[:12]) + `"`
+}
+
+// etagMatches reports whether r's If-None-Match lists etag (weakly
+// compared, as RFC 9110 asks for If-None-Match) or is "*".
+func etagMatches(r *http.Request, etag string) bool {
+	for _, t := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+// exit.go
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Exit Codes =========================
+
+// Commands exit with a status a shell script can branch on:
+//
+//	0  ok
+//	1  anything else (a file could not be written, the server stopped)
+//	2  no data: an unknown ticker, no gap sessions, a scan with no hits
+//	3  provider error: Polygon failed, throttled, or was unreachable
+//	4  config error: bad flags or parameters, a missing API key, a bad config file
+//
+// -quiet (before or after the command) drops the progress and "wrote"
+// lines and the server's log on stderr; the error a command fails with is
+// still printed.
+
+const (
+	exitFailure  = 1
+	exitNoData   = 2
+	exitProvider = 3
+	exitConfig   = 4
+)
+
+// exitError is an error with the status it exits with. silent means it was
+// already reported (the flag package prints its own errors).
+type exitError struct {
+	code   int
+	err    error
+	silent bool
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func configError(err error) error { return &exitError{code: exitConfig, err: err} }
+func noDataError(err error) error { return &exitError{code: exitNoData, err: err} }
+
+// exitCode classifies err.
+func exitCode(err error) int {
+	var ee *exitError
+	var pe *gapcore.ProviderError
+	var ne net.Error
+	var ue *url.Error
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &ee):
+		return ee.code
+	case errors.Is(err, gapcore.ErrUnknownTicker):
+		return exitNoData
+	case errors.As(err, &pe), errors.As(err, &ne), errors.As(err, &ue):
+		return exitProvider
+	}
+	return exitFailure
+}
+
+// apiExitError is the error for an API error code, as runHandler gets
+// them back from a handler.
+func apiExitError(code string, err error) error {
+	switch {
+	case code == "unknown_ticker":
+		return noDataError(err)
+	case strings.HasPrefix(code, "provider_"):
+		return &exitError{code: exitProvider, err: err}
+	case code == "invalid_request":
+		return configError(err)
+	}
+	return err
+}
+
+// parseFlags parses args into fs, a flag.ContinueOnError set; -h comes
+// back as flag.ErrHelp.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return &exitError{code: exitConfig, err: err, silent: true}
+}
+
+// ========================= Quiet Mode =========================
+
+// notes receives the CLI's progress and "wrote" lines; -quiet discards it.
+var notes io.Writer = os.Stderr
+
+func notef(format string, a ...any) { fmt.Fprintf(notes, format+"\n", a...) }
+
+// setQuiet silences notes and the log.
+func setQuiet() {
+	notes = io.Discard
+	log.SetOutput(io.Discard)
+}
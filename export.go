@@ -0,0 +1,110 @@
+// export.go
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= CSV Export =========================
+
+// The /api/gaps analysis as CSV: the per-session points by default, or the
+// daily and 0–15m summaries side by side with sheet=summary, one file per
+// sheet so each loads straight into a data frame.
+
+var gapCSVHeader = []string{
+	"date", "dow", "gap_pct", "direction", "bin", "prev_close", "open", "close",
+	"daily_return_pct", "same_dir", "filled", "prev_return_pct", "prev_rvol",
+	"ret_15m_pct", "filled_by_0945",
+}
+
+func gapCSVRow(p gapcore.GapPoint) []string {
+	return []string{
+		p.Date, p.DayOfWeek, num(p.GapPct), strconv.Itoa(p.Direction), p.Bin,
+		num(p.PrevClose), num(p.Open), num(p.Close),
+		num(p.DailyReturnPct), strconv.Itoa(p.SameDir), strconv.Itoa(p.Filled),
+		num(p.PrevReturnPct), num(p.PrevRVOL),
+		num(p.Ret15mPct), strconv.Itoa(p.FilledBy0945),
+	}
+}
+
+func num(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+
+// summaryRows lays the daily and 0–15m summaries out as metric rows.
+func summaryRows(resp gapcore.AnalyzeResponse) [][]string {
+	s, s15 := resp.Summary, resp.Summary15
+	rows := [][]string{
+		{"sessions", strconv.Itoa(s.Sessions), strconv.Itoa(s15.Sessions)},
+		{"continuation_rate", num(s.ContinuationRate), num(s15.ContinuationRate)},
+		{"continuation_ci_low", num(s.ContinuationCI.Low), num(s15.ContinuationCI.Low)},
+		{"continuation_ci_high", num(s.ContinuationCI.High), num(s15.ContinuationCI.High)},
+		{"fade_avg", num(s.FadeAvg), num(s15.FadeAvg)},
+		{"follow_avg", num(s.FollowAvg), num(s15.FollowAvg)},
+		{"fade_win_rate", num(s.FadeStats.WinRate), num(s15.FadeStats.WinRate)},
+		{"follow_win_rate", num(s.FollowStats.WinRate), num(s15.FollowStats.WinRate)},
+		{"follow_vs_fade_t_p_value", num(s.FollowVsFade.TPValue), num(s15.FollowVsFade.TPValue)},
+		{"best_strategy", s.BestStrategy, s15.BestStrategy},
+		{"expected_return", num(s.ExpectedReturn), num(s15.ExpectedReturn)},
+		{"gap_ups", strconv.Itoa(s.GapUps), ""},
+		{"gap_downs", strconv.Itoa(s.GapDowns), ""},
+		{"mean_gap", num(s.MeanGap), ""},
+		{"max_gap_up", num(s.MaxGapUp), ""},
+		{"max_gap_down", num(s.MaxGapDown), ""},
+		{"gap_fill_by_0945_rate", "", num(s15.GapFillBy0945Rate)},
+	}
+	if resp.SummaryNet != nil && resp.Summary15Net != nil {
+		n, n15 := resp.SummaryNet, resp.Summary15Net
+		rows = append(rows,
+			[]string{"net_avg_cost", num(n.AvgCost), num(n15.AvgCost)},
+			[]string{"net_fade_avg", num(n.FadeAvg), num(n15.FadeAvg)},
+			[]string{"net_follow_avg", num(n.FollowAvg), num(n15.FollowAvg)},
+			[]string{"net_best_strategy", n.BestStrategy, n15.BestStrategy},
+		)
+	}
+	return rows
+}
+
+// parseSheet reads the CSV sheet name; points by default.
+func parseSheet(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "":
+		return "points", nil
+	case "points", "summary":
+		return s, nil
+	}
+	return "", fmt.Errorf("sheet must be points or summary")
+}
+
+// writeGapsCSV sends one sheet of resp as a CSV download.
+func writeGapsCSV(w http.ResponseWriter, sheet string, resp gapcore.AnalyzeResponse) {
+	if !resp.Success && len(resp.Data) == 0 {
+		writeError(w, 502, resp.Error)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s_gaps_%s.csv"`, resp.Ticker, sheet))
+	cw := csv.NewWriter(w)
+	if sheet == "summary" {
+		cw.Write([]string{"metric", "daily", "first_15m"})
+		cw.WriteAll(summaryRows(resp))
+		return
+	}
+	cw.Write(gapCSVHeader)
+	for _, p := range resp.Data {
+		cw.Write(gapCSVRow(p))
+	}
+	cw.Flush()
+}
+
+// handleGapsCSV is /api/gaps with format=csv implied.
+func handleGapsCSV(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	q.Set("format", "csv")
+	r.URL.RawQuery = q.Encode()
+	handleAnalyze(w, r)
+}
@@ -0,0 +1,108 @@
+// fields.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Field Selection & Paging =========================
+
+// fields= trims the /api/gaps JSON to the listed top-level fields
+// (schema_version, success, and error are always kept), and limit/offset page through the data array
+// so a lightweight client can skip the per-session points or read them a
+// page at a time. A paged response says where it is with data_total,
+// data_offset, and next_offset (absent on the last page).
+
+// Largest page of gap points.
+const maxDataLimit = 5000
+
+type resultView struct {
+	fields map[string]bool // nil: all
+	limit  int             // 0: no paging
+	offset int
+}
+
+func parseResultView(q url.Values) (resultView, error) {
+	var v resultView
+	if s := strings.TrimSpace(q.Get("fields")); s != "" {
+		rt := reflect.TypeOf(gapcore.AnalyzeResponse{})
+		v.fields = map[string]bool{"schema_version": true, "success": true, "error": true}
+		for _, f := range strings.Split(s, ",") {
+			f = strings.TrimSpace(f)
+			if _, ok := jsonField(rt, f); !ok {
+				return v, fmt.Errorf("unknown field %q", f)
+			}
+			v.fields[f] = true
+		}
+	}
+	for _, p := range []struct {
+		key      string
+		dst      *int
+		min, max int
+	}{
+		{"limit", &v.limit, 1, maxDataLimit},
+		{"offset", &v.offset, 0, 1 << 30},
+	} {
+		s := strings.TrimSpace(q.Get(p.key))
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < p.min || n > p.max {
+			return v, fmt.Errorf("%s must be between %d and %d", p.key, p.min, p.max)
+		}
+		*p.dst = n
+	}
+	if v.offset > 0 && v.limit == 0 {
+		v.limit = maxDataLimit
+	}
+	return v, nil
+}
+
+func (v resultView) all() bool { return v.fields == nil && v.limit == 0 }
+
+// apply returns resp as selected and paged by v, keeping the field order
+// of AnalyzeResponse.
+func (v resultView) apply(resp gapcore.AnalyzeResponse) (any, error) {
+	if v.all() {
+		return resp, nil
+	}
+	total := len(resp.Data)
+	if v.limit > 0 {
+		lo, hi := min(v.offset, total), min(v.offset+v.limit, total)
+		resp.Data = resp.Data[lo:hi]
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	out := &gqlObject{}
+	rt := reflect.TypeOf(resp)
+	for i := 0; i < rt.NumField(); i++ {
+		name, _, _ := strings.Cut(rt.Field(i).Tag.Get("json"), ",")
+		raw, ok := m[name]
+		if !ok || (v.fields != nil && !v.fields[name]) {
+			continue
+		}
+		out.set(name, raw)
+		if name == "data" && v.limit > 0 {
+			out.set("data_total", total)
+			out.set("data_offset", v.offset)
+			if v.offset+v.limit < total {
+				out.set("next_offset", v.offset+v.limit)
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,1126 @@
+// gapcore/analyze.go
+package gapcore
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ========================= Gap Analysis Types =========================
+
+type GapPoint struct {
+	Date           string  `json:"date"`             // YYYY-MM-DD (NY session date)
+	GapPct         float64 `json:"gap_pct"`          // (open-prevClose)/prevClose * 100
+	DailyReturnPct float64 `json:"daily_return_pct"` // (close-open)/open * 100
+	Direction      int     `json:"direction"`        // 1 gap-up, -1 gap-down
+	SameDir        int     `json:"same_dir"`         // 1 continuation (close dir == gap dir)
+	Filled         int     `json:"filled"`           // gap filled intraday (daily window)
+	Bin            string  `json:"bin"`              // gap bin label
+	Open           float64 `json:"open,omitempty"`
+	Close          float64 `json:"close,omitempty"`
+	PrevClose      float64 `json:"prev_close,omitempty"`
+	DayOfWeek      string  `json:"dow,omitempty"`       // Mon..Fri
+	PrevReturnPct  float64 `json:"prev_return_pct"`     // prior session (close-open)/open * 100
+	PrevRVOL       float64 `json:"prev_rvol,omitempty"` // prior session volume / 20-session avg
+
+	// 0–15m snapshot (to 09:45 ET) — from 1-minute bars
+	Ret15mPct    float64 `json:"ret_15m_pct,omitempty"`    // (09:45 - 09:30) / 09:30 * 100
+	FilledBy0945 int     `json:"filled_by_0945,omitempty"` // gap filled within first 15m
+}
+
+type BinStat struct {
+	Label              string     `json:"label"`
+	Count              int        `json:"count"`
+	ContinuationRate   float64    `json:"continuation_rate"`
+	ContinuationCI     Interval   `json:"continuation_ci"`          // 95% Wilson
+	ContinuationShrunk float64    `json:"continuation_rate_shrunk"` // beta-binomial toward overall
+	GapFillRate        float64    `json:"gap_fill_rate"`
+	FadeAvg            float64    `json:"fade_avg"`
+	FollowAvg          float64    `json:"follow_avg"`
+	FadeCI             Interval   `json:"fade_ci"`   // 95% bootstrap
+	FollowCI           Interval   `json:"follow_ci"` // 95% bootstrap
+	FollowVsFade       MeanTest   `json:"follow_vs_fade"`
+	FadeMoments        Moments    `json:"fade_moments"`
+	FollowMoments      Moments    `json:"follow_moments"`
+	FadeStats          TradeStats `json:"fade_stats"`
+	FollowStats        TradeStats `json:"follow_stats"`
+	Recommendation     string     `json:"recommendation"` // FOLLOW | FADE | NEUTRAL
+	RecommendationWhy  string     `json:"recommendation_why"`
+}
+
+type SideStat struct {
+	Count            int      `json:"count"`
+	ContinuationRate float64  `json:"continuation_rate"`
+	ContinuationCI   Interval `json:"continuation_ci"` // 95% Wilson
+	FadeAvg          float64  `json:"fade_avg"`
+	FollowAvg        float64  `json:"follow_avg"`
+	FadeMoments      Moments  `json:"fade_moments"`
+	FollowMoments    Moments  `json:"follow_moments"`
+}
+
+type DowStat struct {
+	Count              int      `json:"count"`
+	ContinuationRate   float64  `json:"continuation_rate"`
+	ContinuationCI     Interval `json:"continuation_ci"`          // 95% Wilson
+	ContinuationShrunk float64  `json:"continuation_rate_shrunk"` // beta-binomial toward overall
+	FadeAvg            float64  `json:"fade_avg"`
+	FollowAvg          float64  `json:"follow_avg"`
+}
+
+type Summary struct {
+	Sessions         int        `json:"sessions"`
+	ContinuationRate float64    `json:"continuation_rate"`
+	ContinuationCI   Interval   `json:"continuation_ci"` // 95% Wilson
+	GapUps           int        `json:"gap_ups"`
+	GapDowns         int        `json:"gap_downs"`
+	MeanGap          float64    `json:"mean_gap"`
+	MaxGapUp         float64    `json:"max_gap_up"`
+	MaxGapDown       float64    `json:"max_gap_down"`
+	FadeAvg          float64    `json:"fade_avg"`
+	FollowAvg        float64    `json:"follow_avg"`
+	FadeCI           Interval   `json:"fade_ci"`   // 95% bootstrap
+	FollowCI         Interval   `json:"follow_ci"` // 95% bootstrap
+	FollowVsFade     MeanTest   `json:"follow_vs_fade"`
+	FadeStats        TradeStats `json:"fade_stats"`
+	FollowStats      TradeStats `json:"follow_stats"`
+	BestStrategy     string     `json:"best_strategy"`
+	ExpectedReturn   float64    `json:"expected_return"`
+}
+
+type Summary15 struct {
+	Sessions          int        `json:"sessions"`
+	ContinuationRate  float64    `json:"continuation_rate"`     // to 09:45
+	ContinuationCI    Interval   `json:"continuation_ci"`       // 95% Wilson
+	FadeAvg           float64    `json:"fade_avg"`              // avg % per trade (0–15m)
+	FollowAvg         float64    `json:"follow_avg"`            // avg % per trade (0–15m)
+	FadeCI            Interval   `json:"fade_ci"`               // 95% bootstrap (0–15m)
+	FollowCI          Interval   `json:"follow_ci"`             // 95% bootstrap (0–15m)
+	FollowVsFade      MeanTest   `json:"follow_vs_fade"`        // 0–15m
+	FadeStats         TradeStats `json:"fade_stats"`            // 0–15m
+	FollowStats       TradeStats `json:"follow_stats"`          // 0–15m
+	BestStrategy      string     `json:"best_strategy"`         // FADE/FOLLOW/NEUTRAL (0–15m)
+	ExpectedReturn    float64    `json:"expected_return"`       // best strategy expected (0–15m)
+	GapFillBy0945Rate float64    `json:"gap_fill_by_0945_rate"` // %
+}
+
+type BinStat15 struct {
+	Label              string     `json:"label"`
+	Count              int        `json:"count"`
+	ContinuationRate   float64    `json:"continuation_rate"`        // to 09:45
+	ContinuationCI     Interval   `json:"continuation_ci"`          // 95% Wilson
+	ContinuationShrunk float64    `json:"continuation_rate_shrunk"` // beta-binomial toward overall
+	GapFillBy0945Rate  float64    `json:"gap_fill_by_0945_rate"`    // %
+	FadeAvg            float64    `json:"fade_avg"`                 // 0–15m
+	FollowAvg          float64    `json:"follow_avg"`               // 0–15m
+	FadeCI             Interval   `json:"fade_ci"`                  // 95% bootstrap (0–15m)
+	FollowCI           Interval   `json:"follow_ci"`                // 95% bootstrap (0–15m)
+	FollowVsFade       MeanTest   `json:"follow_vs_fade"`           // 0–15m
+	FadeMoments        Moments    `json:"fade_moments"`
+	FollowMoments      Moments    `json:"follow_moments"`
+	FadeStats          TradeStats `json:"fade_stats"`
+	FollowStats        TradeStats `json:"follow_stats"`
+	Recommendation     string     `json:"recommendation"` // FOLLOW | FADE | NEUTRAL
+	RecommendationWhy  string     `json:"recommendation_why"`
+}
+
+// Histograms are pre-binned distributions of the daily sample.
+type Histograms struct {
+	GapPct   Histogram `json:"gap_pct"`   // signed gap %
+	FadeUp   Histogram `json:"fade_up"`   // fade % per trade, gap-ups
+	FadeDown Histogram `json:"fade_down"` // fade % per trade, gap-downs
+}
+
+// Bin widths (percentage points) for the response histograms.
+const (
+	gapHistWidth    = 0.25
+	returnHistWidth = 0.25
+)
+
+// CumRisk holds risk-adjusted metrics of the cum_fade/cum_follow curves.
+type CumRisk struct {
+	Fade   RiskStats `json:"fade"`
+	Follow RiskStats `json:"follow"`
+}
+
+// SchemaVersionCurrent is the shape of the response structs. It goes up
+// whenever a field is removed or changes meaning; added fields do not bump
+// it.
+const SchemaVersionCurrent = 1
+
+// SchemaVersion always encodes as SchemaVersionCurrent, so response structs
+// carry it without every constructor setting it.
+type SchemaVersion int
+
+func (SchemaVersion) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Itoa(SchemaVersionCurrent)), nil
+}
+
+type AnalyzeResponse struct {
+	SchemaVersion SchemaVersion `json:"schema_version"`
+	Success       bool          `json:"success"`
+	Error         string        `json:"error,omitempty"`
+	Ticker        string        `json:"ticker"`
+	Years         int           `json:"years"`
+	MinGap        float64       `json:"min_gap"`
+	Winsorize     []float64     `json:"winsorize,omitempty"` // [lo, hi] percentiles applied
+	Data          []GapPoint    `json:"data"`
+
+	// Daily analytics
+	Summary           Summary            `json:"summary"`
+	Bins              []BinStat          `json:"bins"`
+	ByDOW             map[string]DowStat `json:"by_dow"`
+	UpSide            SideStat           `json:"gap_up"`
+	DownSide          SideStat           `json:"gap_down"`
+	DOWTest           ChiSquareTest      `json:"dow_test"`                     // continuation vs weekday
+	SummaryWinsorized *Summary           `json:"summary_winsorized,omitempty"` // winsorize=lo,hi only
+	GapRegression     Regression         `json:"gap_regression"`               // daily return on signed gap
+	Markov            MarkovAnalysis     `json:"markov"`                       // outcome → next outcome
+	Histograms        Histograms         `json:"histograms"`
+	FadeACF           Autocorrelation    `json:"fade_autocorr"` // daily fade returns, lags 1..10
+	Deciles           Deciles            `json:"deciles"`       // by |gap| decile, per side
+
+	CumDates    []string     `json:"cum_dates"`
+	CumFade     []float64    `json:"cum_fade"`
+	CumFollow   []float64    `json:"cum_follow"`
+	CumRisk     CumRisk      `json:"cum_risk"`
+	MonteCarlo  *MonteCarlo  `json:"monte_carlo,omitempty"`  // resample=1 only
+	WalkForward *WalkForward `json:"walk_forward,omitempty"` // walkForward=1 only
+
+	// 0–15m analytics (from 1-minute bars)
+	Summary15           Summary15          `json:"summary_15m"`
+	Bins15              []BinStat15        `json:"bins_15m"`
+	ByDOW15             map[string]DowStat `json:"by_dow_15m"`
+	UpSide15            SideStat           `json:"gap_up_15m"`
+	DownSide15          SideStat           `json:"gap_down_15m"`
+	DOWTest15           ChiSquareTest      `json:"dow_test_15m"`
+	Summary15Winsorized *Summary15         `json:"summary_15m_winsorized,omitempty"`
+
+	// After commission/slippage (when either is set)
+	SummaryNet   *NetSummary `json:"summary_net,omitempty"`
+	Summary15Net *NetSummary `json:"summary_15m_net,omitempty"`
+
+	// Per-trade follow returns behind the summaries (fade is the negation),
+	// kept for follow-up computations such as winsorization.
+	followRets   []float64
+	followRets15 []float64
+	dates15      []string // session date of each followRets15 entry
+}
+
+// ========================= Helpers =========================
+
+func Sign(x float64) int {
+	if x > 0 {
+		return 1
+	}
+	if x < 0 {
+		return -1
+	}
+	return 0
+}
+func Rate(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return Round1(float64(n) / float64(d) * 100.0)
+}
+func avg(sum float64, n int) float64 {
+	if n == 0 {
+		return 0
+	}
+	return Round3(sum / float64(n))
+}
+
+// Loaded once; toNY runs for every minute bar in the backtester.
+var NewYork, _ = time.LoadLocation("America/New_York")
+
+func ToNY(t time.Time) time.Time {
+	return t.In(NewYork)
+}
+func dateNY(tms int64) string {
+	return ToNY(time.UnixMilli(tms)).Format("2006-01-02")
+}
+func weekdayNY(tms int64) string {
+	return ToNY(time.UnixMilli(tms)).Weekday().String()[:3] // Mon Tue Wed Thu Fri
+}
+
+// Polygon daily 't' is 00:00 UTC of the session; in NY this shows as previous calendar date.
+// Shift +24h in NY to label by the actual RTH session date (date of the 09:30 open).
+func sessionDateNYFromDaily(tms int64) string {
+	return ToNY(time.UnixMilli(tms)).Add(24 * time.Hour).Format("2006-01-02")
+}
+func sessionWeekdayNYFromDaily(tms int64) string {
+	return ToNY(time.UnixMilli(tms)).Add(24 * time.Hour).Weekday().String()[:3]
+}
+
+// Sessions averaged for relative volume.
+const rvolLookback = 20
+
+var Weekdays = []string{"Mon", "Tue", "Wed", "Thu", "Fri"}
+
+// GapBin is a range of |gap| percentages, [Min, Max).
+type GapBin struct {
+	Min   float64
+	Max   float64
+	Label string
+}
+
+// BinEdges are the |gap| % boundaries between the gap bins: the first bin
+// runs from the minimum gap to BinEdges[0], the last from the final edge
+// up. Set it before analyzing to bin differently.
+var BinEdges = []float64{0.5, 1.0, 1.5}
+
+// DefaultBins returns the gap bins starting at minGap.
+func DefaultBins(minGap float64) []GapBin {
+	start := minGap
+	if start < 0.1 {
+		start = 0.1
+	}
+	bins := make([]GapBin, 0, len(BinEdges)+1)
+	lo, loLabel := start, fmt.Sprintf("%.1f", start)
+	for _, e := range BinEdges {
+		bins = append(bins, GapBin{Min: lo, Max: e, Label: loLabel + "–" + edgeLabel(e) + "%"})
+		lo, loLabel = e, edgeLabel(e)
+	}
+	return append(bins, GapBin{Min: lo, Max: 99.0, Label: ">" + loLabel + "%"})
+}
+
+// edgeLabel formats a bin edge with at least one decimal (1 → "1.0").
+func edgeLabel(e float64) string {
+	s := strconv.FormatFloat(e, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// LabelFor returns the label of the bin holding absGap.
+func LabelFor(absGap float64, bins []GapBin) string {
+	for _, b := range bins {
+		if absGap >= b.Min && absGap < b.Max {
+			return b.Label
+		}
+	}
+	return "other"
+}
+
+// Fewest sessions a bin needs before it can carry a FOLLOW/FADE call.
+const MinRecSessions = 20
+
+// recommend turns a bin's continuation count into FOLLOW/FADE/NEUTRAL. A call
+// needs enough sessions and a 95% Wilson interval that excludes 50%; the
+// second return value explains the decision.
+func recommend(cont, count int) (string, string) {
+	if count < MinRecSessions {
+		return "NEUTRAL", fmt.Sprintf("only %d sessions (need %d)", count, MinRecSessions)
+	}
+	ci := wilson(cont, count)
+	desc := fmt.Sprintf("continuation %.1f%% (95%% CI %.1f–%.1f%%, n=%d)", Rate(cont, count), ci.Low, ci.High, count)
+	switch {
+	case ci.Low > 50:
+		return "FOLLOW", desc + " is above 50%"
+	case ci.High < 50:
+		return "FADE", desc + " is below 50%"
+	}
+	return "NEUTRAL", desc + " includes 50%"
+}
+
+func Round1(f float64) float64 { return math.Round(f*10) / 10 }
+func Round2(f float64) float64 { return math.Round(f*100) / 100 }
+func Round3(f float64) float64 { return math.Round(f*1000) / 1000 }
+
+// Params are the inputs of an analysis (and of a backtest's session
+// selection).
+type Params struct {
+	Ticker string
+	Years  int
+	MinGap float64
+
+	// Winsorization (winsorize=1,99): clip per-trade returns to these
+	// percentiles for the extra summary_winsorized blocks.
+	Winsorize          bool
+	WinsorLo, WinsorHi float64
+
+	// Walk-forward mode (walkForward=1): training window and step in months.
+	WalkForward bool
+	TrainMonths int
+	StepMonths  int
+
+	// Monte Carlo resampling (resample=1): 1,000 resampled equity paths
+	// per strategy, left out unless asked for.
+	Resample bool
+
+	// Per-trade costs for the net summaries and the backtester.
+	Costs Costs
+}
+
+// DateRange returns the from/to dates (YYYY-MM-DD) covering the lookback.
+func (p Params) DateRange() (string, string) {
+	now := time.Now()
+	return now.AddDate(-p.Years, 0, 0).Format("2006-01-02"), now.Format("2006-01-02")
+}
+
+// ========================= Analysis =========================
+
+// Pass 1: compute daily analytics and return the list of gap sessions we’ll need minute data for.
+func AnalyzeDaily(daily []Bar, minGap float64, years int, ticker string) (AnalyzeResponse, []GapPoint) {
+	resp := AnalyzeResponse{
+		Success: true,
+		Ticker:  ticker,
+		Years:   years,
+		MinGap:  minGap,
+	}
+	if len(daily) < 2 {
+		resp.Success = false
+		resp.Error = "not enough data"
+		return resp, nil
+	}
+
+	bins := DefaultBins(minGap)
+	type agg struct {
+		count, cont, filled int
+		sumFade, sumFollow  float64
+		rets                []float64 // per-trade follow returns
+	}
+	binAgg := map[string]*agg{}
+	for _, b := range bins {
+		binAgg[b.Label] = &agg{}
+	}
+	upAgg := agg{}
+	downAgg := agg{}
+	dowAgg := map[string]*agg{"Mon": {}, "Tue": {}, "Wed": {}, "Thu": {}, "Fri": {}}
+
+	points := make([]GapPoint, 0, len(daily)-1)
+
+	var fadeSum, followSum float64
+	var contCount int
+	var upCount, downCount int
+	var meanAbsGap float64
+	var maxGapUp, maxGapDown float64
+	var cumFade, cumFollow float64
+	var cumDates []string
+	var cumFadeArr, cumFollowArr []float64
+	var followRets []float64
+	var dayIdx []int // index into daily of each gap session
+
+	for i := 1; i < len(daily); i++ {
+		prev := daily[i-1]
+		day := daily[i]
+
+		prevClose := prev.C
+		open := day.O
+		close := day.C
+
+		if prevClose <= 0 || open <= 0 {
+			continue
+		}
+		gapPct := (open - prevClose) / prevClose * 100.0
+		if math.Abs(gapPct) < minGap {
+			continue
+		}
+		dr := (close - open) / open * 100.0
+		dir := Sign(gapPct)
+
+		same := 0
+		if Sign(dr) == dir && dir != 0 && dr != 0 {
+			same = 1
+		}
+		filled := 0
+		if (dir == 1 && day.L <= prevClose) || (dir == -1 && day.H >= prevClose) {
+			filled = 1
+		}
+
+		absGap := math.Abs(gapPct)
+		bin := LabelFor(absGap, bins)
+		dow := sessionWeekdayNYFromDaily(day.T)
+
+		followRet := float64(dir) * dr
+		fadeRet := -float64(dir) * dr
+
+		if dir == 1 {
+			upCount++
+		} else if dir == -1 {
+			downCount++
+		}
+		if same == 1 {
+			contCount++
+		}
+		meanAbsGap += absGap
+		if gapPct > maxGapUp {
+			maxGapUp = gapPct
+		}
+		if gapPct < maxGapDown {
+			maxGapDown = gapPct
+		}
+
+		followSum += followRet
+		fadeSum += fadeRet
+		followRets = append(followRets, followRet)
+		dayIdx = append(dayIdx, i)
+
+		sessDate := sessionDateNYFromDaily(day.T)
+		cumDates = append(cumDates, sessDate)
+		cumFollow += followRet
+		cumFade += fadeRet
+		cumFollowArr = append(cumFollowArr, Round3(cumFollow))
+		cumFadeArr = append(cumFadeArr, Round3(cumFade))
+
+		if ba := binAgg[bin]; ba != nil {
+			ba.count++
+			ba.sumFollow += followRet
+			ba.sumFade += fadeRet
+			ba.rets = append(ba.rets, followRet)
+			if same == 1 {
+				ba.cont++
+			}
+			if filled == 1 {
+				ba.filled++
+			}
+		}
+		if dir == 1 {
+			upAgg.count++
+			upAgg.sumFollow += followRet
+			upAgg.sumFade += fadeRet
+			upAgg.rets = append(upAgg.rets, followRet)
+			if same == 1 {
+				upAgg.cont++
+			}
+		} else {
+			downAgg.count++
+			downAgg.sumFollow += followRet
+			downAgg.sumFade += fadeRet
+			downAgg.rets = append(downAgg.rets, followRet)
+			if same == 1 {
+				downAgg.cont++
+			}
+		}
+		if da := dowAgg[dow]; da != nil {
+			da.count++
+			da.sumFollow += followRet
+			da.sumFade += fadeRet
+			if same == 1 {
+				da.cont++
+			}
+		}
+
+		// Prior-session context: its own return and its volume relative to
+		// the rvolLookback sessions before it (known before the gap opens).
+		prevRet := 0.0
+		if prev.O > 0 {
+			prevRet = (prev.C - prev.O) / prev.O * 100.0
+		}
+		prevRVOL := 0.0
+		if i-1 >= rvolLookback {
+			var sumV float64
+			for _, b := range daily[i-1-rvolLookback : i-1] {
+				sumV += b.V
+			}
+			if sumV > 0 {
+				prevRVOL = prev.V / (sumV / rvolLookback)
+			}
+		}
+
+		points = append(points, GapPoint{
+			Date:           sessDate,
+			GapPct:         Round3(gapPct),
+			DailyReturnPct: Round3(dr),
+			Direction:      dir,
+			SameDir:        same,
+			Filled:         filled,
+			Bin:            bin,
+			Open:           open,
+			Close:          close,
+			PrevClose:      prevClose,
+			DayOfWeek:      dow,
+			PrevReturnPct:  Round3(prevRet),
+			PrevRVOL:       Round2(prevRVOL),
+		})
+	}
+
+	// Fill response (daily portion)
+	total := len(points)
+	var contRate, fadeAvg, followAvg float64
+	if total > 0 {
+		contRate = float64(contCount) / float64(total) * 100.0
+		fadeAvg = fadeSum / float64(total)
+		followAvg = followSum / float64(total)
+	}
+
+	best := "NEUTRAL"
+	exp := 0.0
+	if followAvg > fadeAvg {
+		best = "FOLLOW"
+		exp = followAvg
+	} else if fadeAvg > followAvg {
+		best = "FADE"
+		exp = fadeAvg
+	}
+	meanAbsGapPct := 0.0
+	if total > 0 {
+		meanAbsGapPct = meanAbsGap / float64(total)
+	}
+
+	resp.Data = points
+	gx := make([]float64, len(points))
+	gy := make([]float64, len(points))
+	for i, p := range points {
+		gx[i], gy[i] = p.GapPct, p.DailyReturnPct
+	}
+	resp.GapRegression = regress(gx, gy)
+	outcomes := make([]int, len(points))
+	for i, p := range points {
+		outcomes[i] = p.SameDir
+	}
+	resp.Markov = MarkovAnalysis{
+		Consecutive: markov(outcomes, func(int) bool { return true }),
+		NextDay:     markov(outcomes, func(i int) bool { return dayIdx[i] == dayIdx[i-1]+1 }),
+	}
+	resp.followRets = followRets
+	resp.CumDates = cumDates
+	resp.CumFade = cumFadeArr
+	resp.CumFollow = cumFollowArr
+	followCI := bootstrapMeanCI(followRets)
+	resp.Summary = Summary{
+		Sessions:         total,
+		ContinuationRate: Round1(contRate),
+		ContinuationCI:   wilson(contCount, total),
+		GapUps:           upCount,
+		GapDowns:         downCount,
+		MeanGap:          Round2(meanAbsGapPct),
+		MaxGapUp:         Round2(maxGapUp),
+		MaxGapDown:       Round2(maxGapDown),
+		FadeAvg:          Round3(fadeAvg),
+		FollowAvg:        Round3(followAvg),
+		FadeCI:           negated(followCI),
+		FollowCI:         followCI,
+		FollowVsFade:     meanTest(followRets),
+		FadeStats:        TradeStatsOf(negate(followRets)),
+		FollowStats:      TradeStatsOf(followRets),
+		BestStrategy:     best,
+		ExpectedReturn:   Round3(exp),
+	}
+
+	// Bins (daily)
+	outBins := make([]BinStat, 0, len(bins))
+	for _, b := range bins {
+		ba := binAgg[b.Label]
+		if ba == nil || ba.count == 0 {
+			outBins = append(outBins, BinStat{Label: b.Label})
+			continue
+		}
+		cr := float64(ba.cont) / float64(ba.count) * 100.0
+		gr := float64(ba.filled) / float64(ba.count) * 100.0
+		fa := ba.sumFade / float64(ba.count)
+		fo := ba.sumFollow / float64(ba.count)
+		foCI := bootstrapMeanCI(ba.rets)
+		foM := moments(ba.rets)
+		rec, why := recommend(ba.cont, ba.count)
+		outBins = append(outBins, BinStat{
+			Label:              b.Label,
+			Count:              ba.count,
+			ContinuationRate:   Round1(cr),
+			ContinuationCI:     wilson(ba.cont, ba.count),
+			ContinuationShrunk: shrunkRate(ba.cont, ba.count, contRate/100),
+			GapFillRate:        Round1(gr),
+			FadeAvg:            Round3(fa),
+			FollowAvg:          Round3(fo),
+			FadeCI:             negated(foCI),
+			FollowCI:           foCI,
+			FollowVsFade:       meanTest(ba.rets),
+			FadeMoments:        foM.negated(),
+			FollowMoments:      foM,
+			FadeStats:          TradeStatsOf(negate(ba.rets)),
+			FollowStats:        TradeStatsOf(ba.rets),
+			Recommendation:     rec,
+			RecommendationWhy:  why,
+		})
+	}
+	sort.Slice(outBins, func(i, j int) bool { return i < j })
+	resp.Bins = outBins
+
+	resp.UpSide = SideStat{
+		Count:            upAgg.count,
+		ContinuationRate: Rate(upAgg.cont, upAgg.count),
+		ContinuationCI:   wilson(upAgg.cont, upAgg.count),
+		FadeAvg:          avg(upAgg.sumFade, upAgg.count),
+		FollowAvg:        avg(upAgg.sumFollow, upAgg.count),
+		FadeMoments:      moments(upAgg.rets).negated(),
+		FollowMoments:    moments(upAgg.rets),
+	}
+	resp.DownSide = SideStat{
+		Count:            downAgg.count,
+		ContinuationRate: Rate(downAgg.cont, downAgg.count),
+		ContinuationCI:   wilson(downAgg.cont, downAgg.count),
+		FadeAvg:          avg(downAgg.sumFade, downAgg.count),
+		FollowAvg:        avg(downAgg.sumFollow, downAgg.count),
+		FadeMoments:      moments(downAgg.rets).negated(),
+		FollowMoments:    moments(downAgg.rets),
+	}
+	resp.FadeACF = autocorrelation(negate(followRets))
+	resp.Deciles = decilesBySide(points)
+	resp.CumRisk = CumRisk{
+		Fade:   RiskStatsOf(negate(followRets), cumDates),
+		Follow: RiskStatsOf(followRets, cumDates),
+	}
+	resp.Histograms = Histograms{
+		GapPct:   histogram(gx, gapHistWidth),
+		FadeUp:   histogram(negate(upAgg.rets), returnHistWidth),
+		FadeDown: histogram(negate(downAgg.rets), returnHistWidth),
+	}
+
+	resp.ByDOW = map[string]DowStat{}
+	var dowCont, dowCount []int
+	for _, k := range Weekdays {
+		dowCont = append(dowCont, dowAgg[k].cont)
+		dowCount = append(dowCount, dowAgg[k].count)
+	}
+	resp.DOWTest = chiSquareRates(dowCont, dowCount)
+	for k, v := range dowAgg {
+		resp.ByDOW[k] = DowStat{
+			Count:              v.count,
+			ContinuationRate:   Rate(v.cont, v.count),
+			ContinuationCI:     wilson(v.cont, v.count),
+			ContinuationShrunk: shrunkRate(v.cont, v.count, contRate/100),
+			FadeAvg:            avg(v.sumFade, v.count),
+			FollowAvg:          avg(v.sumFollow, v.count),
+		}
+	}
+
+	return resp, points
+}
+
+// bestOf picks the strategy with the higher average return.
+func bestOf(fadeAvg, followAvg float64) (string, float64) {
+	if followAvg > fadeAvg {
+		return "FOLLOW", followAvg
+	} else if fadeAvg > followAvg {
+		return "FADE", fadeAvg
+	}
+	return "NEUTRAL", 0
+}
+
+// winsorizedSummary recomputes the return-based fields of s from follow
+// returns clipped to the [lo, hi] percentiles; counts and rates are unchanged.
+func winsorizedSummary(s Summary, followRets []float64, lo, hi float64) Summary {
+	w := winsorize(followRets, lo, hi)
+	mean, _ := MeanStd(w)
+	ci := bootstrapMeanCI(w)
+	s.FollowAvg, s.FadeAvg = Round3(mean), Round3(-mean)
+	s.FollowCI, s.FadeCI = ci, negated(ci)
+	s.FollowVsFade = meanTest(w)
+	s.FollowStats, s.FadeStats = TradeStatsOf(w), TradeStatsOf(negate(w))
+	best, exp := bestOf(-mean, mean)
+	s.BestStrategy, s.ExpectedReturn = best, Round3(exp)
+	return s
+}
+
+// winsorizedSummary15 is winsorizedSummary for the 0–15m window.
+func winsorizedSummary15(s Summary15, followRets []float64, lo, hi float64) Summary15 {
+	w := winsorize(followRets, lo, hi)
+	mean, _ := MeanStd(w)
+	ci := bootstrapMeanCI(w)
+	s.FollowAvg, s.FadeAvg = Round3(mean), Round3(-mean)
+	s.FollowCI, s.FadeCI = ci, negated(ci)
+	s.FollowVsFade = meanTest(w)
+	s.FollowStats, s.FadeStats = TradeStatsOf(w), TradeStatsOf(negate(w))
+	best, exp := bestOf(-mean, mean)
+	s.BestStrategy, s.ExpectedReturn = best, Round3(exp)
+	return s
+}
+
+// Snapshot15 measures a session's first 15 minutes from its minute bars:
+// the 09:30→09:45 return (%) and whether the gap filled by 09:45. ok is false
+// when the bars do not cover the window.
+func Snapshot15(p GapPoint, mins []Bar) (float64, int, bool) {
+	// Filter to RTH first 15 minutes: 09:30..09:44 (NY)
+	rth := make([]Bar, 0, 16)
+	for _, b := range mins {
+		ny := ToNY(time.UnixMilli(b.T))
+		if ny.Hour() == 9 && ny.Minute() >= 30 && ny.Minute() <= 44 {
+			rth = append(rth, b)
+		}
+	}
+	if len(rth) == 0 {
+		// Fallback: if provider stamps differently, try using the last minute whose time <= 09:45
+		for _, b := range mins {
+			ny := ToNY(time.UnixMilli(b.T))
+			if ny.Hour() == 9 && ny.Minute() <= 45 {
+				rth = append(rth, b)
+			}
+		}
+	}
+	if len(rth) == 0 {
+		return 0, 0, false
+	}
+
+	// 09:30 open (fallback to daily open if the 09:30 minute is missing)
+	var open0930 float64
+	for _, b := range rth {
+		ny := ToNY(time.UnixMilli(b.T))
+		if ny.Minute() == 30 {
+			open0930 = b.O
+			break
+		}
+	}
+	if open0930 == 0 {
+		open0930 = p.Open // fallback to daily open
+	}
+	if open0930 <= 0 {
+		return 0, 0, false
+	}
+
+	// 09:45 close ≈ close of the last minute before 09:45 (typically the 09:44 bar).
+	close0945 := rth[len(rth)-1].C
+
+	// Gap-fill by 09:45 within the rth slice
+	filled0945 := 0
+	if p.Direction == 1 {
+		for _, b := range rth {
+			if b.L <= p.PrevClose {
+				filled0945 = 1
+				break
+			}
+		}
+	} else if p.Direction == -1 {
+		for _, b := range rth {
+			if b.H >= p.PrevClose {
+				filled0945 = 1
+				break
+			}
+		}
+	}
+
+	return (close0945 - open0930) / open0930 * 100.0, filled0945, true
+}
+
+// Pass 2: compute 0–15m analytics from 1-minute bars for the selected gap dates.
+func analyzeFirst15(resp *AnalyzeResponse, minutesByDate map[string][]Bar) {
+	if resp == nil {
+		return
+	}
+	pts := resp.Data
+	if len(pts) == 0 {
+		return
+	}
+
+	bins := DefaultBins(resp.MinGap)
+	type agg15 struct {
+		count, cont, filledBy0945 int
+		sumFade, sumFollow        float64
+		rets                      []float64 // per-trade follow returns (0–15m)
+	}
+	binAgg15 := map[string]*agg15{}
+	for _, b := range bins {
+		binAgg15[b.Label] = &agg15{}
+	}
+	upAgg15 := agg15{}
+	downAgg15 := agg15{}
+	dowAgg15 := map[string]*agg15{"Mon": {}, "Tue": {}, "Wed": {}, "Thu": {}, "Fri": {}}
+
+	var fadeSum15, followSum15 float64
+	var contCount15, filledBy0945Count, sessions15 int
+	var followRets15 []float64
+	var dates15 []string
+
+	for i := range pts {
+		p := &pts[i]
+		mins := minutesByDate[p.Date]
+		if len(mins) == 0 {
+			// No intraday data for this date — leave 0–15m empty for this point
+			continue
+		}
+
+		ret15, filled0945, ok := Snapshot15(*p, mins)
+		if !ok {
+			continue
+		}
+		cont15 := 0
+		if Sign(ret15) == p.Direction && p.Direction != 0 && ret15 != 0 {
+			cont15 = 1
+		}
+
+		followRet15 := float64(p.Direction) * ret15
+		fadeRet15 := -float64(p.Direction) * ret15
+
+		followSum15 += followRet15
+		fadeSum15 += fadeRet15
+		followRets15 = append(followRets15, followRet15)
+		dates15 = append(dates15, p.Date)
+		contCount15 += cont15
+		filledBy0945Count += filled0945
+		sessions15++
+
+		// Update per-bin / side / DOW aggregates
+		ba := binAgg15[p.Bin]
+		if ba == nil {
+			ba = &agg15{}
+			binAgg15[p.Bin] = ba
+		}
+		ba.count++
+		ba.sumFollow += followRet15
+		ba.sumFade += fadeRet15
+		ba.rets = append(ba.rets, followRet15)
+		if cont15 == 1 {
+			ba.cont++
+		}
+		if filled0945 == 1 {
+			ba.filledBy0945++
+		}
+
+		if p.Direction == 1 {
+			upAgg15.count++
+			upAgg15.sumFollow += followRet15
+			upAgg15.sumFade += fadeRet15
+			upAgg15.rets = append(upAgg15.rets, followRet15)
+			if cont15 == 1 {
+				upAgg15.cont++
+			}
+			if filled0945 == 1 {
+				upAgg15.filledBy0945++
+			}
+		} else {
+			downAgg15.count++
+			downAgg15.sumFollow += followRet15
+			downAgg15.sumFade += fadeRet15
+			downAgg15.rets = append(downAgg15.rets, followRet15)
+			if cont15 == 1 {
+				downAgg15.cont++
+			}
+			if filled0945 == 1 {
+				downAgg15.filledBy0945++
+			}
+		}
+		da := dowAgg15[p.DayOfWeek]
+		if da == nil {
+			da = &agg15{}
+			dowAgg15[p.DayOfWeek] = da
+		}
+		da.count++
+		da.sumFollow += followRet15
+		da.sumFade += fadeRet15
+		if cont15 == 1 {
+			da.cont++
+		}
+		if filled0945 == 1 {
+			da.filledBy0945++
+		}
+
+		// Write back per‑point snapshot
+		p.Ret15mPct = Round3(ret15)
+		p.FilledBy0945 = filled0945
+	}
+
+	// Summaries
+	var contRate15, fadeAvg15, followAvg15, fill0945Rate float64
+	if sessions15 > 0 {
+		contRate15 = float64(contCount15) / float64(sessions15) * 100.0
+		fadeAvg15 = fadeSum15 / float64(sessions15)
+		followAvg15 = followSum15 / float64(sessions15)
+		fill0945Rate = float64(filledBy0945Count) / float64(sessions15) * 100.0
+	}
+	best15 := "NEUTRAL"
+	exp15 := 0.0
+	if followAvg15 > fadeAvg15 {
+		best15 = "FOLLOW"
+		exp15 = followAvg15
+	} else if fadeAvg15 > followAvg15 {
+		best15 = "FADE"
+		exp15 = fadeAvg15
+	}
+
+	resp.followRets15 = followRets15
+	resp.dates15 = dates15
+	followCI15 := bootstrapMeanCI(followRets15)
+	resp.Summary15 = Summary15{
+		Sessions:          sessions15,
+		ContinuationRate:  Round1(contRate15),
+		ContinuationCI:    wilson(contCount15, sessions15),
+		FadeAvg:           Round3(fadeAvg15),
+		FollowAvg:         Round3(followAvg15),
+		FadeCI:            negated(followCI15),
+		FollowCI:          followCI15,
+		FollowVsFade:      meanTest(followRets15),
+		FadeStats:         TradeStatsOf(negate(followRets15)),
+		FollowStats:       TradeStatsOf(followRets15),
+		BestStrategy:      best15,
+		ExpectedReturn:    Round3(exp15),
+		GapFillBy0945Rate: Round1(fill0945Rate),
+	}
+
+	// Bins — 0–15m
+	outBins15 := make([]BinStat15, 0, len(bins))
+	for _, b := range bins {
+		ba := binAgg15[b.Label]
+		if ba == nil || ba.count == 0 {
+			outBins15 = append(outBins15, BinStat15{Label: b.Label})
+			continue
+		}
+		cr := float64(ba.cont) / float64(ba.count) * 100.0
+		gr := float64(ba.filledBy0945) / float64(ba.count) * 100.0
+		fa := ba.sumFade / float64(ba.count)
+		fo := ba.sumFollow / float64(ba.count)
+		foCI := bootstrapMeanCI(ba.rets)
+		foM := moments(ba.rets)
+		rec, why := recommend(ba.cont, ba.count)
+		outBins15 = append(outBins15, BinStat15{
+			Label:              b.Label,
+			Count:              ba.count,
+			ContinuationRate:   Round1(cr),
+			ContinuationCI:     wilson(ba.cont, ba.count),
+			ContinuationShrunk: shrunkRate(ba.cont, ba.count, contRate15/100),
+			GapFillBy0945Rate:  Round1(gr),
+			FadeAvg:            Round3(fa),
+			FollowAvg:          Round3(fo),
+			FadeCI:             negated(foCI),
+			FollowCI:           foCI,
+			FollowVsFade:       meanTest(ba.rets),
+			FadeMoments:        foM.negated(),
+			FollowMoments:      foM,
+			FadeStats:          TradeStatsOf(negate(ba.rets)),
+			FollowStats:        TradeStatsOf(ba.rets),
+			Recommendation:     rec,
+			RecommendationWhy:  why,
+		})
+	}
+	sort.Slice(outBins15, func(i, j int) bool { return i < j })
+	resp.Bins15 = outBins15
+
+	resp.UpSide15 = SideStat{
+		Count:            upAgg15.count,
+		ContinuationRate: Rate(upAgg15.cont, upAgg15.count),
+		ContinuationCI:   wilson(upAgg15.cont, upAgg15.count),
+		FadeAvg:          avg(upAgg15.sumFade, upAgg15.count),
+		FollowAvg:        avg(upAgg15.sumFollow, upAgg15.count),
+		FadeMoments:      moments(upAgg15.rets).negated(),
+		FollowMoments:    moments(upAgg15.rets),
+	}
+	resp.DownSide15 = SideStat{
+		Count:            downAgg15.count,
+		ContinuationRate: Rate(downAgg15.cont, downAgg15.count),
+		ContinuationCI:   wilson(downAgg15.cont, downAgg15.count),
+		FadeAvg:          avg(downAgg15.sumFade, downAgg15.count),
+		FollowAvg:        avg(downAgg15.sumFollow, downAgg15.count),
+		FadeMoments:      moments(downAgg15.rets).negated(),
+		FollowMoments:    moments(downAgg15.rets),
+	}
+
+	resp.ByDOW15 = map[string]DowStat{}
+	var dowCont15, dowCount15 []int
+	for _, k := range Weekdays {
+		dowCont15 = append(dowCont15, dowAgg15[k].cont)
+		dowCount15 = append(dowCount15, dowAgg15[k].count)
+	}
+	resp.DOWTest15 = chiSquareRates(dowCont15, dowCount15)
+	for k, v := range dowAgg15 {
+		resp.ByDOW15[k] = DowStat{
+			Count:              v.count,
+			ContinuationRate:   Rate(v.cont, v.count),
+			ContinuationCI:     wilson(v.cont, v.count),
+			ContinuationShrunk: shrunkRate(v.cont, v.count, contRate15/100),
+			FadeAvg:            avg(v.sumFade, v.count),
+			FollowAvg:          avg(v.sumFollow, v.count),
+		}
+	}
+
+	// write back updated points
+	resp.Data = pts
+}
+
+// ProgressEvent is one step of an analysis: Done of Total units of Stage
+// (daily | minute | analysis) are finished.
+type ProgressEvent struct {
+	Stage string `json:"stage"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+}
+
+// Analyze runs the full gap analysis of params.Ticker on prov's bars,
+// reporting each stage, and each session of the minute-bar fetch, to
+// progress (if not nil). The error is a failed daily fetch; a failed
+// intraday fetch returns the daily results with Success false and the
+// reason in Error. Canceling ctx cuts the minute fetch short, like an
+// intraday failure.
+func Analyze(ctx context.Context, prov Provider, params Params, progress func(ProgressEvent)) (AnalyzeResponse, error) {
+	ticker, years, minGap := params.Ticker, params.Years, params.MinGap
+	from, to := params.DateRange()
+	report := func(stage string, done, total int) {
+		if progress != nil {
+			progress(ProgressEvent{Stage: stage, Done: done, Total: total})
+		}
+	}
+
+	// Step 1: daily analytics
+	report("daily", 0, 1)
+	daily, err := prov.Daily(ctx, ticker, from, to)
+	if err != nil {
+		return AnalyzeResponse{}, err
+	}
+	if len(daily) == 0 {
+		return AnalyzeResponse{}, UnknownTicker(ticker)
+	}
+	resp, points := AnalyzeDaily(daily, minGap, years, ticker)
+	if params.WalkForward {
+		resp.WalkForward = walkForward(points, minGap, params.TrainMonths, params.StepMonths)
+	}
+	if params.Resample {
+		resp.MonteCarlo = &MonteCarlo{
+			Fade:   monteCarlo(negate(resp.followRets), 1),
+			Follow: monteCarlo(resp.followRets, 2),
+		}
+	}
+	if params.Winsorize {
+		sw := winsorizedSummary(resp.Summary, resp.followRets, params.WinsorLo, params.WinsorHi)
+		resp.SummaryWinsorized = &sw
+		resp.Winsorize = []float64{params.WinsorLo, params.WinsorHi}
+	}
+
+	// Collect the specific session dates that passed the daily filter
+	dates := make([]string, 0, len(points))
+	seen := map[string]bool{}
+	for _, p := range points {
+		if !seen[p.Date] {
+			seen[p.Date] = true
+			dates = append(dates, p.Date)
+		}
+	}
+	sort.Strings(dates)
+	report("daily", 1, 1)
+
+	// Step 2: fetch 1m bars only for those dates
+	minutesByDate := make(map[string][]Bar, len(dates))
+	report("minute", 0, len(dates))
+	next := 0
+	err = EachMinute(ctx, prov, ticker, dates, func(d string, bars []Bar) {
+		minutesByDate[d] = bars
+		for next < len(dates) && dates[next] <= d {
+			next++
+		}
+		report("minute", next, len(dates))
+	})
+	if err != nil {
+		// Don’t fail the entire request; return daily results with a clear error message
+		resp.Success = false
+		resp.Error = "intraday fetch failed: " + err.Error()
+		return resp, nil
+	}
+
+	// Step 3: compute 0–15m analytics from those 1m bars
+	report("analysis", 0, 1)
+	analyzeFirst15(&resp, minutesByDate)
+
+	if params.Winsorize {
+		sw15 := winsorizedSummary15(resp.Summary15, resp.followRets15, params.WinsorLo, params.WinsorHi)
+		resp.Summary15Winsorized = &sw15
+	}
+	if !params.Costs.zero() {
+		net := netSummary(params.Costs, resp.followRets, dailyCosts(params.Costs, points, minutesByDate))
+		net15 := netSummary(params.Costs, resp.followRets15, costs15(params.Costs, resp, minutesByDate))
+		resp.SummaryNet, resp.Summary15Net = &net, &net15
+	}
+	report("analysis", 1, 1)
+	return resp, nil
+}
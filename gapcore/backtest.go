@@ -0,0 +1,989 @@
+// gapcore/backtest.go
+package gapcore
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ========================= Backtest =========================
+
+// The backtester replays each gap session's minute bars and trades it with
+// explicit entry and exit rules, instead of the idealized open→close proxy
+// used by /api/gaps. Returns are % of entry price, summed (no compounding).
+
+// BacktestConfig are the trade rules. Zero stop/target disables that exit.
+type BacktestConfig struct {
+	Sides    []string `json:"sides"`     // fade and/or follow
+	Entry    string   `json:"entry"`     // 0930 (open) | 0945 | vwap
+	Units    string   `json:"units"`     // pct | atr
+	Stop     float64  `json:"stop"`      // adverse distance from entry, in Units
+	Target   float64  `json:"target"`    // favorable distance from entry, in Units
+	ExitTime string   `json:"exit_time"` // HH:MM ET; 16:00 = session close
+
+	// StopPremarket puts the stop at the premarket extreme against the
+	// trade (the high for shorts, the low for longs) instead of Stop.
+	StopPremarket bool `json:"stop_premarket"`
+	// TargetFill puts the fade target at the prior close (the gap fill);
+	// follow trades keep Target.
+	TargetFill bool `json:"target_fill"`
+	// First15 trades the 09:45 entry only when the 09:30→09:45 move
+	// confirms the gap (moved in its direction) or rejects it (moved
+	// against it); "" trades every session.
+	First15 string `json:"first15,omitempty"`
+	// Reentries re-takes a stopped-out trade up to this many times per
+	// Session (see reentry.go); /api/backtest results only.
+	Reentries int `json:"reentries,omitempty"`
+	// HTB lists hard-to-borrow tickers: their short trades are not taken.
+	HTB []string `json:"htb,omitempty"`
+	// Ambiguity decides a bar that touches both stop and target:
+	// stop (conservative), target, or open (the level nearer the bar's open).
+	Ambiguity string `json:"ambiguity"`
+	// Trail is a trailing-stop distance behind the best price since entry,
+	// in TrailUnits (pct | usd | atr). It ratchets only after each bar
+	// closes, so a bar never stops out against its own extreme.
+	Trail      float64 `json:"trail"`
+	TrailUnits string  `json:"trail_units"`
+	// Costs are deducted from every trade's return.
+	Costs Costs `json:"costs"`
+	// Sizing compounds whole-share positions instead of summing % returns.
+	Sizing Sizing `json:"sizing"`
+
+	fillFrac float64 // scale-out legs: fade target at this fraction of the remaining gap
+	noShort  bool    // the ticker is on HTB
+}
+
+// Trade is one simulated round trip.
+type Trade struct {
+	Ticker     string  `json:"ticker,omitempty"` // portfolio runs
+	Date       string  `json:"date"`
+	Side       string  `json:"side"` // fade | follow
+	Long       bool    `json:"long"`
+	GapPct     float64 `json:"gap_pct"`
+	EntryTime  string  `json:"entry_time"` // RFC3339, New York
+	EntryPrice float64 `json:"entry_price"`
+	ExitTime   string  `json:"exit_time"`
+	ExitPrice  float64 `json:"exit_price"`
+	ExitReason string  `json:"exit_reason"` // stop | trail | target | time | close
+	ReturnPct  float64 `json:"return_pct"`  // net of CostPct
+	CostPct    float64 `json:"cost_pct"`    // round-trip commission + slippage, % of entry
+	HoldMins   int     `json:"hold_mins"`
+	Ambiguous  bool    `json:"ambiguous,omitempty"`  // exit bar touched both stop and target
+	StopPrice  float64 `json:"stop_price,omitempty"` // initial stop (fixed or trail at entry); 0 without one
+	RMultiple  float64 `json:"r_multiple,omitempty"` // ReturnPct in units of the entry-to-stop risk
+	Reentry    int     `json:"reentry,omitempty"`    // 1.. for re-entries after a stop-out
+
+	// Sized runs only (see Sizing).
+	Shares int     `json:"shares,omitempty"`
+	PnL    float64 `json:"pnl,omitempty"` // $ net of costs
+
+	atr float64 // session ATR, for ATR sizing
+}
+
+// GapFillStats split fade-to-prior-close trades by whether the gap filled.
+// AdjExpectancy re-weights the two averages by the Wilson lower bound of the
+// fill rate, a conservative expectancy for a sample that got lucky on fills.
+type GapFillStats struct {
+	Trades        int      `json:"trades"`
+	FillRate      float64  `json:"fill_rate"` // % of trades exiting at the prior close
+	FillRateCI    Interval `json:"fill_rate_ci"`
+	AvgFilled     float64  `json:"avg_filled"`   // % per filled trade
+	AvgUnfilled   float64  `json:"avg_unfilled"` // % per stopped / timed-out trade
+	Expectancy    float64  `json:"expectancy"`
+	AdjExpectancy float64  `json:"adj_expectancy"`
+}
+
+// R-multiple histogram bin width.
+const rBinWidth = 0.5
+
+// RMultiples restate the trades that had an initial stop in units of the risk
+// taken (entry to stop), net of costs: -1R is a full stop-out.
+type RMultiples struct {
+	Trades     int       `json:"trades"`
+	Expectancy float64   `json:"expectancy"` // R per trade
+	WinRate    float64   `json:"win_rate"`
+	AvgWin     float64   `json:"avg_win"`
+	AvgLoss    float64   `json:"avg_loss"`
+	Median     float64   `json:"median"`
+	Total      float64   `json:"total"` // sum of R
+	Histogram  Histogram `json:"histogram"`
+}
+
+type BacktestResult struct {
+	Side           string            `json:"side"`
+	Trades         []Trade           `json:"trades"`
+	Dates          []string          `json:"dates"`
+	Equity         []float64         `json:"equity"`            // cumulative % per trade
+	Drawdown       []float64         `json:"drawdown"`          // % points below the running peak of Equity
+	Episodes       []DrawdownEpisode `json:"drawdown_episodes"` // deepest 5
+	Stats          TradeStats        `json:"stats"`
+	Risk           RiskStats         `json:"risk"`
+	ExitReasons    map[string]int    `json:"exit_reasons"`
+	AvgHoldMinutes float64           `json:"avg_hold_minutes"`
+	AmbiguousBars  int               `json:"ambiguous_bars"`     // exits decided by cfg.Ambiguity
+	Untraded       int               `json:"untraded"`           // sessions with bars the rules could not trade
+	Filtered       int               `json:"filtered,omitempty"` // sessions passed on by first15 or the HTB list
+	GapFill        *GapFillStats     `json:"gap_fill,omitempty"`
+	RMultiples     *RMultiples       `json:"r_multiples,omitempty"` // runs with a stop or trail
+	Reentry        *ReentryStats     `json:"reentry,omitempty"`
+
+	// Sized runs only: $ equity after each trade (aligned with Dates), its
+	// drawdown as % of the running peak, and the deepest episodes.
+	Capital         []float64         `json:"capital,omitempty"`
+	CapitalDrawdown []float64         `json:"capital_drawdown,omitempty"`
+	CapitalEpisodes []DrawdownEpisode `json:"capital_drawdown_episodes,omitempty"`
+	Compounded      *Compounded       `json:"compounded,omitempty"`
+
+	// Buy-and-hold of the ticker and the index over the same dates.
+	Benchmarks []Benchmark `json:"benchmarks,omitempty"`
+}
+
+type BacktestResponse struct {
+	SchemaVersion SchemaVersion    `json:"schema_version"`
+	Success       bool             `json:"success"`
+	Error         string           `json:"error,omitempty"`
+	Ticker        string           `json:"ticker"`
+	Years         int              `json:"years"`
+	MinGap        float64          `json:"min_gap"`
+	Config        BacktestConfig   `json:"config"`
+	Skipped       int              `json:"skipped"` // gap sessions without usable minute bars
+	Results       []BacktestResult `json:"results"`
+
+	// Same rules re-run at each requested exit time (exits=HH:MM,...).
+	Horizons []Horizon `json:"horizons,omitempty"`
+	// Scale-out schemes (scale=...), each blended per side.
+	ScaleOuts []ScaleOut `json:"scale_outs,omitempty"`
+	// With a trailing stop: the same rules with and without it.
+	TrailComparison []TrailComparison `json:"trail_comparison,omitempty"`
+}
+
+// TrailComparison sets the trailing stop against the fixed rules (trail off,
+// target kept), over all trades and over gap-and-go days (the session closed
+// in the gap direction).
+type TrailComparison struct {
+	Side             string     `json:"side"`
+	Trailing         TradeStats `json:"trailing"`
+	Fixed            TradeStats `json:"fixed"`
+	GapAndGoTrailing TradeStats `json:"gap_and_go_trailing"`
+	GapAndGoFixed    TradeStats `json:"gap_and_go_fixed"`
+}
+
+// Horizon summarizes one side's trades for one exit time.
+type Horizon struct {
+	ExitTime       string            `json:"exit_time"`
+	Side           string            `json:"side"`
+	Stats          TradeStats        `json:"stats"`
+	ExitReasons    map[string]int    `json:"exit_reasons"`
+	AvgHoldMinutes float64           `json:"avg_hold_minutes"`
+	MaxDrawdown    float64           `json:"max_drawdown"` // % points, cumulative curve
+	Episodes       []DrawdownEpisode `json:"drawdown_episodes"`
+}
+
+func defaultBacktestConfig() BacktestConfig {
+	return BacktestConfig{Sides: []string{"fade", "follow"}, Entry: "0930", Units: "pct", ExitTime: "16:00", Ambiguity: "stop", TrailUnits: "pct"}
+}
+
+// Distances in ATR units are capped lower than percentages.
+const (
+	maxStopPct = 50.0
+	maxStopATR = 10.0
+)
+
+// ParseBacktestConfig reads side, entry, first15, units, stop, target,
+// trail, reentry, htb, ambiguity and exit from q. Without htb in q, the
+// hard-to-borrow list is defaultHTB.
+func ParseBacktestConfig(q url.Values, defaultHTB []string) (BacktestConfig, error) {
+	cfg := defaultBacktestConfig()
+	switch s := strings.ToLower(strings.TrimSpace(q.Get("side"))); s {
+	case "", "both":
+	case "fade", "follow":
+		cfg.Sides = []string{s}
+	default:
+		return cfg, fmt.Errorf("side must be fade, follow, or both")
+	}
+	switch e := strings.TrimSpace(q.Get("entry")); e {
+	case "":
+	case "0930", "0945", "vwap":
+		cfg.Entry = e
+	default:
+		return cfg, fmt.Errorf("entry must be 0930, 0945, or vwap")
+	}
+	switch f := strings.ToLower(strings.TrimSpace(q.Get("first15"))); f {
+	case "":
+	case "confirm", "reject":
+		if cfg.Entry != "0945" && q.Get("entry") != "" {
+			return cfg, fmt.Errorf("first15 needs the 0945 entry")
+		}
+		cfg.First15, cfg.Entry = f, "0945"
+	default:
+		return cfg, fmt.Errorf("first15 must be confirm or reject")
+	}
+	switch u := strings.ToLower(strings.TrimSpace(q.Get("units"))); u {
+	case "":
+	case "pct", "atr":
+		cfg.Units = u
+	default:
+		return cfg, fmt.Errorf("units must be pct or atr")
+	}
+	// stop=premarket and target=fill are levels rather than distances.
+	cfg.StopPremarket = strings.EqualFold(strings.TrimSpace(q.Get("stop")), "premarket")
+	cfg.TargetFill = strings.EqualFold(strings.TrimSpace(q.Get("target")), "fill")
+	for _, f := range []struct {
+		key string
+		dst *float64
+	}{{"stop", &cfg.Stop}, {"target", &cfg.Target}} {
+		if v := strings.TrimSpace(q.Get(f.key)); v != "" && !(f.key == "stop" && cfg.StopPremarket) && !(f.key == "target" && cfg.TargetFill) {
+			x, err := strconv.ParseFloat(v, 64)
+			if err != nil || !ValidDistance(x, cfg.Units) {
+				return cfg, DistanceError(f.key, cfg.Units)
+			}
+			*f.dst = x
+		}
+	}
+	if v := strings.TrimSpace(q.Get("trail")); v != "" {
+		switch u := strings.ToLower(strings.TrimSpace(q.Get("trailUnits"))); u {
+		case "":
+		case "pct", "usd", "atr":
+			cfg.TrailUnits = u
+		default:
+			return cfg, fmt.Errorf("trailUnits must be pct, usd, or atr")
+		}
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil || x < 0 || (cfg.TrailUnits != "usd" && !ValidDistance(x, cfg.TrailUnits)) {
+			return cfg, fmt.Errorf("trail must be a non-negative distance in %s", cfg.TrailUnits)
+		}
+		cfg.Trail = x
+	}
+	if v := strings.TrimSpace(q.Get("reentry")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxReentries {
+			return cfg, fmt.Errorf("reentry must be between 0 and %d", maxReentries)
+		}
+		cfg.Reentries = n
+	}
+	if _, ok := q["htb"]; ok {
+		cfg.HTB = ParseHTB(q.Get("htb"))
+	} else {
+		cfg.HTB = append([]string(nil), defaultHTB...)
+	}
+	cfg.noShort = cfg.hardToBorrow(q.Get("ticker"))
+	switch a := strings.ToLower(strings.TrimSpace(q.Get("ambiguity"))); a {
+	case "":
+	case "stop", "target", "open":
+		cfg.Ambiguity = a
+	default:
+		return cfg, fmt.Errorf("ambiguity must be stop, target, or open")
+	}
+	if v := strings.TrimSpace(q.Get("exit")); v != "" {
+		if _, err := parseClock(v); err != nil {
+			return cfg, err
+		}
+		cfg.ExitTime = v
+	}
+	if err := checkExitAfterEntry(cfg.Entry, cfg.ExitTime); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// ParseHTB reads a comma-separated hard-to-borrow list, upper-cased.
+func ParseHTB(list string) []string {
+	var out []string
+	for _, t := range strings.Split(list, ",") {
+		if t = strings.ToUpper(strings.TrimSpace(t)); t != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// hardToBorrow reports whether ticker is on cfg.HTB.
+func (cfg BacktestConfig) hardToBorrow(ticker string) bool {
+	ticker = strings.ToUpper(strings.TrimSpace(ticker))
+	for _, t := range cfg.HTB {
+		if t == ticker {
+			return true
+		}
+	}
+	return false
+}
+
+// ForTicker returns cfg for trading ticker, with no shorts if it is on
+// cfg.HTB.
+func (cfg BacktestConfig) ForTicker(ticker string) BacktestConfig {
+	cfg.noShort = cfg.hardToBorrow(ticker)
+	return cfg
+}
+
+func checkExitAfterEntry(entry, exit string) error {
+	if exitMin, _ := parseClock(exit); entry == "0945" && exitMin <= 9*60+45 {
+		return fmt.Errorf("exit %s must be after the 09:45 entry", exit)
+	}
+	return nil
+}
+
+// Most exit times compared in one request.
+const maxHorizons = 12
+
+// ParseExits reads exits=HH:MM,... for the holding-horizon comparison.
+func ParseExits(q url.Values, entry string) ([]string, error) {
+	v := strings.TrimSpace(q.Get("exits"))
+	if v == "" {
+		return nil, nil
+	}
+	parts := strings.Split(v, ",")
+	if len(parts) > maxHorizons {
+		return nil, fmt.Errorf("exits: at most %d times", maxHorizons)
+	}
+	out := make([]string, 0, len(parts))
+	for _, e := range parts {
+		e = strings.TrimSpace(e)
+		if _, err := parseClock(e); err != nil {
+			return nil, err
+		}
+		if err := checkExitAfterEntry(entry, e); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func ValidDistance(x float64, units string) bool {
+	if units == "atr" {
+		return x >= 0 && x <= maxStopATR
+	}
+	return x >= 0 && x <= maxStopPct
+}
+
+func DistanceError(key, units string) error {
+	if units == "atr" {
+		return fmt.Errorf("%s must be an ATR multiple between 0 and %g", key, maxStopATR)
+	}
+	return fmt.Errorf("%s must be a percentage between 0 and %g", key, maxStopPct)
+}
+
+// parseClock parses HH:MM into minutes after midnight, limited to the session.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: want HH:MM", s)
+	}
+	m := t.Hour()*60 + t.Minute()
+	if m <= 9*60+30 || m > 16*60 {
+		return 0, fmt.Errorf("time %q must be after 09:30 and no later than 16:00", s)
+	}
+	return m, nil
+}
+
+// minuteOfDayNY returns a bar's start as minutes after midnight, New York.
+func minuteOfDayNY(tms int64) int {
+	ny := ToNY(time.UnixMilli(tms))
+	return ny.Hour()*60 + ny.Minute()
+}
+
+// Sessions in the average true range.
+const atrPeriod = 14
+
+// atrByDate maps each session date to the simple average true range of the
+// atrPeriod sessions before it, so the value is known at that day's open.
+func atrByDate(daily []Bar) map[string]float64 {
+	out := make(map[string]float64, len(daily))
+	trs := make([]float64, 0, len(daily))
+	for i, b := range daily {
+		if len(trs) >= atrPeriod {
+			var sum float64
+			for _, tr := range trs[len(trs)-atrPeriod:] {
+				sum += tr
+			}
+			out[sessionDateNYFromDaily(b.T)] = sum / atrPeriod
+		}
+		tr := b.H - b.L
+		if i > 0 {
+			pc := daily[i-1].C
+			tr = math.Max(tr, math.Max(math.Abs(b.H-pc), math.Abs(b.L-pc)))
+		}
+		trs = append(trs, tr)
+	}
+	return out
+}
+
+// Session is what the simulator needs for one gap date.
+type Session struct {
+	bars          []Bar   // regular session, time order
+	pmHigh, pmLow float64 // 04:00–09:29 ET extremes; 0 without premarket bars
+	atr           float64 // ATR known at the open; 0 without enough history
+	spread        float64 // spreadProxy of the session, for spread slippage
+}
+
+// premarketRange returns the high and low of the 04:00–09:29 ET bars.
+func premarketRange(mins []Bar) (float64, float64) {
+	var hi, lo float64
+	for _, b := range mins {
+		if m := minuteOfDayNY(b.T); m < 4*60 || m >= 9*60+30 {
+			continue
+		}
+		if hi == 0 || b.H > hi {
+			hi = b.H
+		}
+		if lo == 0 || b.L < lo {
+			lo = b.L
+		}
+	}
+	return hi, lo
+}
+
+// rthBars returns the regular-session (09:30–15:59 ET) bars in time order.
+func rthBars(mins []Bar) []Bar {
+	out := make([]Bar, 0, 390)
+	for _, b := range mins {
+		if m := minuteOfDayNY(b.T); m >= 9*60+30 && m < 16*60 {
+			out = append(out, b)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].T < out[j].T })
+	return out
+}
+
+func fmtNY(tms int64) string { return ToNY(time.UnixMilli(tms)).Format(time.RFC3339) }
+
+// simulateTrade replays one session. When a bar touches both the stop and
+// the target, cfg.Ambiguity decides which filled first. ok is false when the
+// rules cannot be applied to the session (see BacktestResult.Untraded).
+func simulateTrade(p GapPoint, s Session, cfg BacktestConfig, side string) (Trade, bool) {
+	long := (side == "follow") == (p.Direction == 1)
+	return simulateFrom(p, s, cfg, side, entryBar(s.bars, cfg, long))
+}
+
+// entryBar returns the index of the bar whose open fills cfg.Entry, or -1.
+func entryBar(bars []Bar, cfg BacktestConfig, long bool) int {
+	if cfg.Entry == "vwap" {
+		return vwapCross(bars, long, 0)
+	}
+	entryMin := 9*60 + 30
+	if cfg.Entry == "0945" {
+		entryMin = 9*60 + 45
+	}
+	for i, b := range bars {
+		if minuteOfDayNY(b.T) >= entryMin {
+			return i
+		}
+	}
+	return -1
+}
+
+// simulateFrom enters at the open of bars[start] and manages the trade to
+// its exit.
+func simulateFrom(p GapPoint, s Session, cfg BacktestConfig, side string, start int) (Trade, bool) {
+	bars := s.bars
+	exitMin, _ := parseClock(cfg.ExitTime)
+	long := (side == "follow") == (p.Direction == 1)
+	dir := 1.0
+	if !long {
+		dir = -1
+	}
+	needATR := cfg.Units == "atr" || (cfg.Trail > 0 && cfg.TrailUnits == "atr")
+	if start < 0 || start >= len(bars) || bars[start].O <= 0 || (needATR && s.atr <= 0) || minuteOfDayNY(bars[start].T) >= exitMin {
+		return Trade{}, false
+	}
+	entry := bars[start].O
+	t := Trade{
+		Date:       p.Date,
+		Side:       side,
+		Long:       long,
+		GapPct:     p.GapPct,
+		EntryTime:  fmtNY(bars[start].T),
+		EntryPrice: entry,
+		atr:        s.atr,
+	}
+	dist := func(x float64) float64 {
+		if cfg.Units == "atr" {
+			return x * s.atr
+		}
+		return entry * x / 100
+	}
+	var fixedStop, target float64
+	if cfg.Stop > 0 {
+		fixedStop = entry - dir*dist(cfg.Stop)
+	}
+	if cfg.StopPremarket {
+		fixedStop = s.pmLow
+		if !long {
+			fixedStop = s.pmHigh
+		}
+		// No premarket, or entry already beyond the extreme.
+		if fixedStop == 0 || dir*(entry-fixedStop) <= 0 {
+			return Trade{}, false
+		}
+	}
+	if cfg.Target > 0 {
+		target = entry + dir*dist(cfg.Target)
+	}
+	if (cfg.TargetFill || cfg.fillFrac > 0) && side == "fade" {
+		// The gap already filled before entry: nothing left to fade.
+		if dir*(p.PrevClose-entry) <= 0 {
+			return Trade{}, false
+		}
+		target = p.PrevClose
+		if cfg.fillFrac > 0 {
+			target = entry + cfg.fillFrac*(p.PrevClose-entry)
+		}
+	}
+
+	var trail float64
+	if cfg.Trail > 0 {
+		switch cfg.TrailUnits {
+		case "usd":
+			trail = cfg.Trail
+		case "atr":
+			trail = cfg.Trail * s.atr
+		default:
+			trail = entry * cfg.Trail / 100
+		}
+	}
+	best := entry // most favorable price seen so far
+	t.StopPrice = fixedStop
+	if lvl := entry - dir*trail; trail > 0 && (fixedStop == 0 || dir*(lvl-fixedStop) > 0) {
+		t.StopPrice = lvl
+	}
+
+	exitAt := func(b Bar, price float64, reason string) {
+		t.ExitTime = fmtNY(b.T)
+		t.ExitPrice = price
+		t.ExitReason = reason
+	}
+	for i := start; i < len(bars) && t.ExitReason == ""; i++ {
+		b := bars[i]
+		if i > start && minuteOfDayNY(b.T) >= exitMin {
+			exitAt(b, b.O, "time")
+			break
+		}
+		// The tighter of the fixed stop and the trail is live for this bar.
+		stop, stopReason := fixedStop, "stop"
+		if trail > 0 {
+			if lvl := best - dir*trail; stop == 0 || dir*(lvl-stop) > 0 {
+				stop, stopReason = lvl, "trail"
+			}
+		}
+		// Fill at the level, or at the open if the bar opened beyond it.
+		// A bar that opened beyond either level reached that one first.
+		hitStop := stop > 0 && ((long && b.L <= stop) || (!long && b.H >= stop))
+		hitTarget := target > 0 && ((long && b.H >= target) || (!long && b.L <= target))
+		openPastStop := hitStop && ((long && b.O < stop) || (!long && b.O > stop))
+		openPastTarget := hitTarget && ((long && b.O > target) || (!long && b.O < target))
+		if hitStop && hitTarget && !openPastStop && !openPastTarget {
+			t.Ambiguous = true
+			switch cfg.Ambiguity {
+			case "target":
+				hitStop = false
+			case "open":
+				hitStop = math.Abs(b.O-stop) <= math.Abs(b.O-target)
+			}
+		}
+		switch {
+		case openPastStop:
+			exitAt(b, b.O, stopReason)
+		case openPastTarget:
+			exitAt(b, b.O, "target")
+		case hitStop:
+			exitAt(b, stop, stopReason)
+		case hitTarget:
+			exitAt(b, target, "target")
+		}
+		if long {
+			best = math.Max(best, b.H)
+		} else {
+			best = math.Min(best, b.L)
+		}
+	}
+	if t.ExitReason == "" {
+		last := bars[len(bars)-1]
+		t.ExitTime = fmtNY(last.T + 60_000)
+		t.ExitPrice = last.C
+		t.ExitReason = "close"
+	}
+	cost := cfg.Costs.roundTripPct(entry, t.ExitPrice, s.spread)
+	if !long {
+		cost += cfg.Costs.shortPct(entry)
+	}
+	t.CostPct = Round3(cost)
+	t.ReturnPct = Round3(dir*(t.ExitPrice-entry)/entry*100 - t.CostPct)
+	if t.StopPrice > 0 {
+		t.RMultiple = Round2(t.ReturnPct / (math.Abs(entry-t.StopPrice) / entry * 100))
+	}
+	et, _ := time.Parse(time.RFC3339, t.EntryTime)
+	xt, _ := time.Parse(time.RFC3339, t.ExitTime)
+	t.HoldMins = int(xt.Sub(et).Minutes())
+	return t, true
+}
+
+// vwapCross returns the bar after the first close at or after bars[from]
+// that crosses the session VWAP in the trade's direction (a reclaim for
+// longs, a loss for shorts), having closed on the other side before; -1 if
+// it never does.
+func vwapCross(bars []Bar, long bool, from int) int {
+	want := 1
+	if !long {
+		want = -1
+	}
+	var pv, vol float64
+	prev := 0
+	for i, b := range bars {
+		pv += (b.H + b.L + b.C) / 3 * b.V
+		vol += b.V
+		if vol == 0 {
+			continue
+		}
+		side := Sign(b.C - pv/vol)
+		if side == want && prev == -want && i >= from {
+			if i+1 < len(bars) {
+				return i + 1
+			}
+			return -1
+		}
+		if side != 0 {
+			prev = side
+		}
+	}
+	return -1
+}
+
+// SessionBars prepares each gap date with regular-session bars; skipped
+// counts sessions with no usable minute data.
+func SessionBars(points []GapPoint, minutesByDate map[string][]Bar, atr map[string]float64) (map[string]Session, int) {
+	out := make(map[string]Session, len(points))
+	skipped := 0
+	for _, p := range points {
+		mins := minutesByDate[p.Date]
+		rb := rthBars(mins)
+		if len(rb) == 0 {
+			skipped++
+			continue
+		}
+		hi, lo := premarketRange(mins)
+		out[p.Date] = Session{bars: rb, pmHigh: hi, pmLow: lo, atr: atr[p.Date], spread: spreadProxy(rb)}
+	}
+	return out, skipped
+}
+
+// first15Passes applies cfg.First15 to a session: the move from the 09:30
+// open to the last close before 09:45 must be in the gap direction (confirm)
+// or against it (reject). A flat or missing first 15 minutes passes neither.
+func first15Passes(p GapPoint, s Session, cfg BacktestConfig) bool {
+	if cfg.First15 == "" {
+		return true
+	}
+	last := -1
+	for i, b := range s.bars {
+		if minuteOfDayNY(b.T) >= 9*60+45 {
+			break
+		}
+		last = i
+	}
+	if last < 0 {
+		return false
+	}
+	move := Sign(s.bars[last].C - s.bars[0].O)
+	if cfg.First15 == "confirm" {
+		return move == p.Direction
+	}
+	return move == -p.Direction
+}
+
+// eligible applies the session filters that pass on a trade rather than fail
+// to simulate it: first15 and the hard-to-borrow list.
+func eligible(p GapPoint, s Session, cfg BacktestConfig, side string) bool {
+	if long := (side == "follow") == (p.Direction == 1); !long && cfg.noShort {
+		return false
+	}
+	return first15Passes(p, s, cfg)
+}
+
+// SimulateSide trades every session for one side, in date order, and counts
+// the sessions the rules could not be applied to. Sessions that are not
+// eligible are neither traded nor counted.
+func SimulateSide(points []GapPoint, sessions map[string]Session, cfg BacktestConfig, side string) ([]Trade, int) {
+	var trades []Trade
+	untraded := 0
+	for _, p := range points {
+		s, ok := sessions[p.Date]
+		if !ok || !eligible(p, s, cfg, side) {
+			continue
+		}
+		if t, ok := simulateTrade(p, s, cfg, side); ok {
+			trades = append(trades, t)
+		} else {
+			untraded++
+		}
+	}
+	return trades, untraded
+}
+
+func ReturnsOf(trades []Trade) []float64 {
+	rets := make([]float64, len(trades))
+	for i, t := range trades {
+		rets[i] = t.ReturnPct
+	}
+	return rets
+}
+
+// runBacktest simulates every gap session for each configured side.
+func runBacktest(points []GapPoint, minutesByDate map[string][]Bar, atr map[string]float64, cfg BacktestConfig) ([]BacktestResult, int) {
+	sessions, skipped := SessionBars(points, minutesByDate, atr)
+	var results []BacktestResult
+	for _, side := range cfg.Sides {
+		res := BacktestResult{Side: side, ExitReasons: map[string]int{}}
+		res.Trades, res.Untraded = SimulateSide(points, sessions, cfg, side)
+		res.Filtered = len(sessions) - len(res.Trades) - res.Untraded
+		if cfg.Reentries > 0 {
+			var st ReentryStats
+			res.Trades, st = addReentries(points, sessions, cfg, res.Trades)
+			res.Reentry = &st
+		}
+		var cum float64
+		var hold int
+		for _, t := range res.Trades {
+			cum += t.ReturnPct
+			res.Dates = append(res.Dates, t.Date)
+			res.Equity = append(res.Equity, Round3(cum))
+			res.ExitReasons[t.ExitReason]++
+			hold += t.HoldMins
+			if t.Ambiguous {
+				res.AmbiguousBars++
+			}
+		}
+		rets := ReturnsOf(res.Trades)
+		res.Drawdown, res.Episodes = Drawdowns(res.Equity, res.Dates, 0)
+		res.Stats = TradeStatsOf(rets)
+		res.Risk = RiskStatsOf(rets, res.Dates)
+		res.AvgHoldMinutes = Round1(float64(hold) / float64(max(1, len(res.Trades))))
+		if cfg.TargetFill && side == "fade" {
+			gf := gapFillStats(res.Trades)
+			res.GapFill = &gf
+		}
+		res.RMultiples = rMultiples(res.Trades)
+		if cfg.Sizing.Model != "" {
+			curve, c := applySizing(cfg.Sizing, res.Trades)
+			res.Capital, res.Compounded = curve, &c
+			res.CapitalDrawdown, res.CapitalEpisodes = Drawdowns(curve, res.Dates, cfg.Sizing.Capital)
+		}
+		results = append(results, res)
+	}
+	return results, skipped
+}
+
+func gapFillStats(trades []Trade) GapFillStats {
+	gf := GapFillStats{Trades: len(trades)}
+	var filled int
+	var sumFilled, sumOther float64
+	for _, t := range trades {
+		if t.ExitReason == "target" {
+			filled++
+			sumFilled += t.ReturnPct
+		} else {
+			sumOther += t.ReturnPct
+		}
+	}
+	gf.FillRate = Rate(filled, len(trades))
+	gf.FillRateCI = wilson(filled, len(trades))
+	gf.AvgFilled = avg(sumFilled, filled)
+	gf.AvgUnfilled = avg(sumOther, len(trades)-filled)
+	gf.Expectancy = avg(sumFilled+sumOther, len(trades))
+	p := gf.FillRateCI.Low / 100
+	gf.AdjExpectancy = Round3(p*gf.AvgFilled + (1-p)*gf.AvgUnfilled)
+	return gf
+}
+
+// rMultiples summarizes the trades that had an initial stop; nil if none did.
+func rMultiples(trades []Trade) *RMultiples {
+	var rs []float64
+	for _, t := range trades {
+		if t.StopPrice > 0 {
+			rs = append(rs, t.RMultiple)
+		}
+	}
+	if len(rs) == 0 {
+		return nil
+	}
+	ts := TradeStatsOf(rs)
+	rm := &RMultiples{
+		Trades:     len(rs),
+		Expectancy: ts.Expectancy,
+		WinRate:    ts.WinRate,
+		AvgWin:     ts.AvgWin,
+		AvgLoss:    ts.AvgLoss,
+		Histogram:  histogram(rs, rBinWidth),
+	}
+	for _, r := range rs {
+		rm.Total += r
+	}
+	rm.Total = Round2(rm.Total)
+	sort.Float64s(rs)
+	rm.Median = Round3(quantileSorted(rs, 0.5))
+	return rm
+}
+
+// runHorizons re-runs cfg at each exit time so holding periods can be
+// compared with the same stop/target handling.
+func runHorizons(points []GapPoint, minutesByDate map[string][]Bar, atr map[string]float64, cfg BacktestConfig, exits []string) []Horizon {
+	sessions, _ := SessionBars(points, minutesByDate, atr)
+	var out []Horizon
+	for _, exit := range exits {
+		c := cfg
+		c.ExitTime = exit
+		for _, side := range cfg.Sides {
+			trades, _ := SimulateSide(points, sessions, c, side)
+			h := Horizon{ExitTime: exit, Side: side, Stats: TradeStatsOf(ReturnsOf(trades)), ExitReasons: map[string]int{}}
+			var hold int
+			var cum float64
+			equity := make([]float64, len(trades))
+			dates := make([]string, len(trades))
+			for i, t := range trades {
+				h.ExitReasons[t.ExitReason]++
+				hold += t.HoldMins
+				cum += t.ReturnPct
+				equity[i], dates[i] = Round3(cum), t.Date
+			}
+			_, h.Episodes = Drawdowns(equity, dates, 0)
+			h.MaxDrawdown = Round3(MaxDrawdown(equity))
+			h.AvgHoldMinutes = Round1(float64(hold) / float64(max(1, len(trades))))
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// compareTrailing re-runs cfg without the trailing stop and compares both,
+// overall and on gap-and-go days.
+func compareTrailing(points []GapPoint, minutesByDate map[string][]Bar, atr map[string]float64, cfg BacktestConfig) []TrailComparison {
+	sessions, _ := SessionBars(points, minutesByDate, atr)
+	fixed := cfg
+	fixed.Trail = 0
+	gapAndGo := make(map[string]bool, len(points))
+	for _, p := range points {
+		gapAndGo[p.Date] = p.SameDir == 1
+	}
+	// Only sessions both variants traded (an ATR trail skips early sessions).
+	split := func(trades []Trade, keep map[string]bool) (TradeStats, TradeStats) {
+		var all, goRets []float64
+		for _, t := range trades {
+			if !keep[t.Date] {
+				continue
+			}
+			all = append(all, t.ReturnPct)
+			if gapAndGo[t.Date] {
+				goRets = append(goRets, t.ReturnPct)
+			}
+		}
+		return TradeStatsOf(all), TradeStatsOf(goRets)
+	}
+	var out []TrailComparison
+	for _, side := range cfg.Sides {
+		tr, _ := SimulateSide(points, sessions, cfg, side)
+		fx, _ := SimulateSide(points, sessions, fixed, side)
+		inTr, both := map[string]bool{}, map[string]bool{}
+		for _, t := range tr {
+			inTr[t.Date] = true
+		}
+		for _, t := range fx {
+			both[t.Date] = inTr[t.Date]
+		}
+		c := TrailComparison{Side: side}
+		c.Trailing, c.GapAndGoTrailing = split(tr, both)
+		c.Fixed, c.GapAndGoFixed = split(fx, both)
+		out = append(out, c)
+	}
+	return out
+}
+
+// BacktestInputs fetches daily and minute bars for a backtest-style request
+// and returns the gap sessions, their minute bars, and per-session ATR.
+// A non-empty analysis error means there were no sessions to simulate.
+func BacktestInputs(ctx context.Context, prov Provider, params Params) ([]GapPoint, map[string][]Bar, map[string]float64, string, error) {
+	from, to := params.DateRange()
+	daily, err := prov.Daily(ctx, params.Ticker, from, to)
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
+	resp, points := AnalyzeDaily(daily, params.MinGap, params.Years, params.Ticker)
+	if !resp.Success {
+		return nil, nil, nil, resp.Error, nil
+	}
+	dates := make([]string, 0, len(points))
+	for _, p := range points {
+		dates = append(dates, p.Date)
+	}
+	minutesByDate, err := MinuteBars(ctx, prov, params.Ticker, dates)
+	if err != nil {
+		return nil, nil, nil, "", fmt.Errorf("intraday fetch failed: %w", err)
+	}
+	return points, minutesByDate, atrByDate(daily), "", nil
+}
+
+// BacktestRequest is a backtest: the sessions params selects, traded by
+// Config.
+type BacktestRequest struct {
+	Params Params
+	Config BacktestConfig
+
+	Index   string        // extra benchmark ticker ("": the ticker only)
+	Exits   []string      // HH:MM exit times for Horizons
+	Schemes []ScaleScheme // for ScaleOuts
+
+	TradesOnly bool // results only: no benchmarks, horizons, or scale-outs
+}
+
+// Backtest trades req's gap sessions on prov's bars. The error is a failed
+// fetch; no sessions to simulate returns Success false and the reason in
+// Error.
+func Backtest(ctx context.Context, prov Provider, req BacktestRequest) (BacktestResponse, error) {
+	params, cfg := req.Params, req.Config
+	points, minutesByDate, atr, analysisErr, err := BacktestInputs(ctx, prov, params)
+	if err != nil {
+		return BacktestResponse{}, err
+	}
+	out := BacktestResponse{
+		Success: analysisErr == "",
+		Error:   analysisErr,
+		Ticker:  params.Ticker,
+		Years:   params.Years,
+		MinGap:  params.MinGap,
+		Config:  cfg,
+	}
+	if !out.Success {
+		return out, nil
+	}
+	out.Results, out.Skipped = runBacktest(points, minutesByDate, atr, cfg)
+	if req.TradesOnly {
+		return out, nil
+	}
+	// A failed index fetch only drops that benchmark.
+	var indexPx dayPrices
+	if from, to := params.DateRange(); req.Index != "" && req.Index != params.Ticker {
+		if daily, err := prov.Daily(ctx, req.Index, from, to); err == nil {
+			indexPx = dailyPrices(daily)
+		}
+	}
+	ownPx := gapPrices(points)
+	for i, res := range out.Results {
+		if b, ok := benchmark(params.Ticker, ownPx, res, cfg.Sizing); ok {
+			out.Results[i].Benchmarks = append(out.Results[i].Benchmarks, b)
+		}
+		if b, ok := benchmark(req.Index, indexPx, res, cfg.Sizing); ok {
+			out.Results[i].Benchmarks = append(out.Results[i].Benchmarks, b)
+		}
+	}
+	out.Horizons = runHorizons(points, minutesByDate, atr, cfg, req.Exits)
+	out.ScaleOuts = runScaleOuts(points, minutesByDate, atr, cfg, req.Schemes)
+	if cfg.Trail > 0 {
+		out.TrailComparison = compareTrailing(points, minutesByDate, atr, cfg)
+	}
+	return out, nil
+}
@@ -0,0 +1,240 @@
+// gapcore/backtest_test.go
+package gapcore
+
+import (
+	"math"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const testDate = "2024-03-05"
+
+// minuteBars lays out O, H, L, C bars one minute apart from 09:30 New York
+// on date.
+func minuteBars(date string, ohlc ...[4]float64) []Bar {
+	d, _ := time.ParseInLocation("2006-01-02", date, NewYork)
+	open := d.Add(9*time.Hour + 30*time.Minute)
+	out := make([]Bar, len(ohlc))
+	for i, x := range ohlc {
+		out[i] = Bar{T: open.Add(time.Duration(i) * time.Minute).UnixMilli(), O: x[0], H: x[1], L: x[2], C: x[3], V: 1000}
+	}
+	return out
+}
+
+// nyClock is date's hh:mm in New York, as Trade times print it.
+func nyClock(date, hhmm string) string {
+	t, _ := time.ParseInLocation("2006-01-02 15:04", date+" "+hhmm, NewYork)
+	return t.Format(time.RFC3339)
+}
+
+func TestSimulateTradeExits(t *testing.T) {
+	// Every session enters at 100 on the 09:30 open. A gap up makes follow
+	// long and fade short.
+	flat := [4]float64{100, 100.5, 99.5, 100}
+	tests := []struct {
+		name      string
+		side      string
+		cfg       func(*BacktestConfig)
+		bars      [][4]float64
+		reason    string
+		exit      float64
+		exitAt    string
+		ret       float64
+		ambiguous bool
+	}{
+		{
+			name:   "stop before target",
+			side:   "follow",
+			cfg:    func(c *BacktestConfig) { c.Stop, c.Target = 1, 2 },
+			bars:   [][4]float64{flat, {100, 100.2, 98.8, 99}, {99, 103, 99, 102}},
+			reason: "stop", exit: 99, exitAt: "09:31", ret: -1,
+		},
+		{
+			name:   "target",
+			side:   "follow",
+			cfg:    func(c *BacktestConfig) { c.Stop, c.Target = 1, 2 },
+			bars:   [][4]float64{flat, {100, 102.5, 99.5, 102}},
+			reason: "target", exit: 102, exitAt: "09:31", ret: 2,
+		},
+		{
+			name:   "short stop",
+			side:   "fade",
+			cfg:    func(c *BacktestConfig) { c.Stop, c.Target = 1, 2 },
+			bars:   [][4]float64{flat, {100, 101.5, 99.8, 101}},
+			reason: "stop", exit: 101, exitAt: "09:31", ret: -1,
+		},
+		{
+			name:   "opens through the stop",
+			side:   "follow",
+			cfg:    func(c *BacktestConfig) { c.Stop, c.Target = 1, 2 },
+			bars:   [][4]float64{flat, {98.5, 99, 98, 98.5}},
+			reason: "stop", exit: 98.5, exitAt: "09:31", ret: -1.5,
+		},
+		{
+			name:   "ambiguous, stop first",
+			side:   "follow",
+			cfg:    func(c *BacktestConfig) { c.Stop, c.Target, c.Ambiguity = 1, 2, "stop" },
+			bars:   [][4]float64{flat, {100, 102.5, 98.5, 101}},
+			reason: "stop", exit: 99, exitAt: "09:31", ret: -1, ambiguous: true,
+		},
+		{
+			name:   "ambiguous, target first",
+			side:   "follow",
+			cfg:    func(c *BacktestConfig) { c.Stop, c.Target, c.Ambiguity = 1, 2, "target" },
+			bars:   [][4]float64{flat, {100, 102.5, 98.5, 101}},
+			reason: "target", exit: 102, exitAt: "09:31", ret: 2, ambiguous: true,
+		},
+		{
+			name:   "ambiguous, open nearer the stop",
+			side:   "follow",
+			cfg:    func(c *BacktestConfig) { c.Stop, c.Target, c.Ambiguity = 1, 2, "open" },
+			bars:   [][4]float64{flat, {100, 102.5, 98.5, 101}},
+			reason: "stop", exit: 99, exitAt: "09:31", ret: -1, ambiguous: true,
+		},
+		{
+			name:   "ambiguous, open nearer the target",
+			side:   "follow",
+			cfg:    func(c *BacktestConfig) { c.Stop, c.Target, c.Ambiguity = 1, 2, "open" },
+			bars:   [][4]float64{flat, {101.8, 102.5, 98.5, 101}},
+			reason: "target", exit: 102, exitAt: "09:31", ret: 2, ambiguous: true,
+		},
+		{
+			// The trail only moves up after a bar closes: 09:31 reaches 103
+			// but its low of 100.5 is tested against the 100 left by 09:30's
+			// high, and 09:32 stops out at 103 − 1.
+			name:   "trail ratchets",
+			side:   "follow",
+			cfg:    func(c *BacktestConfig) { c.Trail = 1 },
+			bars:   [][4]float64{{100, 101, 99.5, 100.8}, {100.8, 103, 100.5, 102.8}, {102.8, 102.9, 101.5, 101.8}},
+			reason: "trail", exit: 102, exitAt: "09:32", ret: 2,
+		},
+		{
+			name:   "trail behind the fixed stop",
+			side:   "follow",
+			cfg:    func(c *BacktestConfig) { c.Stop, c.Trail = 1, 2 },
+			bars:   [][4]float64{{100, 100.5, 99.5, 100.2}, {100.2, 100.4, 98.5, 99}},
+			reason: "stop", exit: 99, exitAt: "09:31", ret: -1,
+		},
+		{
+			name:   "exit time",
+			side:   "follow",
+			cfg:    func(c *BacktestConfig) { c.Stop, c.ExitTime = 1, "09:32" },
+			bars:   [][4]float64{flat, {100, 100.8, 99.6, 100.6}, {100.6, 101, 100.1, 100.9}},
+			reason: "time", exit: 100.6, exitAt: "09:32", ret: 0.6,
+		},
+		{
+			name:   "end of day",
+			side:   "follow",
+			cfg:    func(c *BacktestConfig) { c.Stop, c.Target = 1, 2 },
+			bars:   [][4]float64{flat, {100, 100.8, 99.6, 100.6}, {100.6, 101, 100.1, 100.9}},
+			reason: "close", exit: 100.9, exitAt: "09:33", ret: 0.9,
+		},
+	}
+	p := GapPoint{Date: testDate, GapPct: 1, Direction: 1, PrevClose: 99}
+	for _, tt := range tests {
+		cfg := defaultBacktestConfig()
+		tt.cfg(&cfg)
+		tr, ok := simulateTrade(p, Session{bars: minuteBars(testDate, tt.bars...)}, cfg, tt.side)
+		if !ok {
+			t.Errorf("%s: not traded", tt.name)
+			continue
+		}
+		if tr.ExitReason != tt.reason || math.Abs(tr.ExitPrice-tt.exit) > 1e-9 || tr.ExitTime != nyClock(testDate, tt.exitAt) {
+			t.Errorf("%s: exit %s at %v, %s; want %s at %v, %s", tt.name, tr.ExitReason, tr.ExitPrice, tr.ExitTime, tt.reason, tt.exit, nyClock(testDate, tt.exitAt))
+		}
+		if math.Abs(tr.ReturnPct-tt.ret) > 1e-9 {
+			t.Errorf("%s: return %v, want %v", tt.name, tr.ReturnPct, tt.ret)
+		}
+		if tr.Ambiguous != tt.ambiguous {
+			t.Errorf("%s: ambiguous %v, want %v", tt.name, tr.Ambiguous, tt.ambiguous)
+		}
+	}
+}
+
+func TestWithReentries(t *testing.T) {
+	// Stopped out at 99 on the first bar; 09:31 closes back above the 100
+	// entry, so the re-entry takes 09:32's open and rides to the close.
+	s := Session{bars: minuteBars(testDate,
+		[4]float64{100, 100.2, 98.8, 99},
+		[4]float64{99, 100.6, 98.9, 100.5},
+		[4]float64{100.6, 101, 100.4, 100.8},
+		[4]float64{100.8, 101, 100.7, 100.9},
+	)}
+	p := GapPoint{Date: testDate, GapPct: 1, Direction: 1, PrevClose: 99}
+	cfg := defaultBacktestConfig()
+	cfg.Stop, cfg.Reentries = 1, 2
+	first, ok := simulateTrade(p, s, cfg, "follow")
+	if !ok || first.ExitReason != "stop" {
+		t.Fatalf("first trade: %+v, %v", first, ok)
+	}
+	trades := withReentries(p, s, cfg, first)
+	if len(trades) != 2 {
+		t.Fatalf("%d trades, want the first and one re-entry", len(trades))
+	}
+	re := trades[1]
+	if re.Reentry != 1 || re.EntryPrice != 100.6 || re.EntryTime != nyClock(testDate, "09:32") || re.ExitReason != "close" {
+		t.Errorf("re-entry: %+v", re)
+	}
+}
+
+func TestRunBacktest(t *testing.T) {
+	day2 := "2024-03-06"
+	points := []GapPoint{
+		{Date: testDate, GapPct: 1, Direction: 1, PrevClose: 99},
+		{Date: day2, GapPct: 1, Direction: 1, PrevClose: 99},
+		{Date: "2024-03-07", GapPct: 1, Direction: 1, PrevClose: 99}, // no bars
+	}
+	premarket := Bar{T: minuteBars(testDate, [4]float64{})[0].T - 90*60_000, O: 90, H: 90, L: 90, C: 90}
+	minutes := map[string][]Bar{
+		testDate: append([]Bar{premarket}, minuteBars(testDate, [4]float64{100, 100.5, 99.5, 100}, [4]float64{100, 100.2, 98.8, 99})...),
+		day2:     minuteBars(day2, [4]float64{100, 100.5, 99.5, 100}, [4]float64{100, 102.5, 98.5, 101}),
+	}
+	cfg := defaultBacktestConfig()
+	cfg.Sides = []string{"follow"}
+	cfg.Stop, cfg.Target, cfg.Ambiguity = 1, 2, "target"
+	results, skipped := runBacktest(points, minutes, nil, cfg)
+	if skipped != 1 || len(results) != 1 {
+		t.Fatalf("skipped %d, %d results", skipped, len(results))
+	}
+	res := results[0]
+	if len(res.Trades) != 2 || res.ExitReasons["stop"] != 1 || res.ExitReasons["target"] != 1 {
+		t.Errorf("exit reasons %v over %d trades", res.ExitReasons, len(res.Trades))
+	}
+	if res.AmbiguousBars != 1 {
+		t.Errorf("%d ambiguous bars, want 1", res.AmbiguousBars)
+	}
+	if n := len(res.Equity); n != 2 || res.Equity[n-1] != 1 {
+		t.Errorf("equity %v, want [-1 1]", res.Equity)
+	}
+}
+
+func TestRunScaleOuts(t *testing.T) {
+	// Fading a gap up from 98: the half leg covers at 99 on 09:30, the fill
+	// leg at 98 on 09:31, for 0.5·1% + 0.5·2% blended.
+	points := []GapPoint{{Date: testDate, GapPct: 2, Direction: 1, PrevClose: 98}}
+	minutes := map[string][]Bar{testDate: minuteBars(testDate,
+		[4]float64{100, 100.2, 98.9, 99.2},
+		[4]float64{99.2, 99.3, 97.9, 98.1},
+		[4]float64{98.1, 98.3, 98, 98.2},
+	)}
+	schemes, err := ParseScaleSchemes(url.Values{"scale": {"50@half,50@fill"}}, "pct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultBacktestConfig()
+	cfg.Sides = []string{"fade"}
+	out := runScaleOuts(points, minutes, nil, cfg, schemes)
+	if len(out) != 1 {
+		t.Fatalf("%d scale-outs, want 1", len(out))
+	}
+	so := out[0]
+	if so.Stats.Trades != 1 || math.Abs(so.Stats.Expectancy-1.5) > 1e-9 {
+		t.Errorf("blended %+v, want one trade at 1.5%%", so.Stats)
+	}
+	for i, want := range []float64{1, 2} {
+		if l := so.Legs[i]; l.HitRate != 100 || math.Abs(l.AvgReturn-want) > 1e-9 {
+			t.Errorf("leg %s: hit %v%%, avg %v; want 100%%, %v", l.Target, l.HitRate, l.AvgReturn, want)
+		}
+	}
+}
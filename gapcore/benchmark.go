@@ -0,0 +1,95 @@
+// gapcore/benchmark.go
+package gapcore
+
+import (
+	"math"
+	"strings"
+)
+
+// ========================= Benchmarks =========================
+
+// A benchmark buys at the open of a result's first trade date and holds,
+// marked at the close of every trade date, so it lines up point for point
+// with the strategy's equity (and, for sized runs, its starting capital).
+
+// Index benchmark fetched when the request does not name one.
+const defaultBenchmark = "SPY"
+
+type Benchmark struct {
+	Ticker       string    `json:"ticker"`
+	Equity       []float64 `json:"equity"`            // % return since the first open, aligned with Dates
+	Capital      []float64 `json:"capital,omitempty"` // sized runs: the same on the starting capital, $
+	TotalReturn  float64   `json:"total_return"`      // %
+	MaxDrawdown  float64   `json:"max_drawdown"`      // % below peak value
+	ExcessReturn float64   `json:"excess_return"`     // strategy total return − TotalReturn, % points
+}
+
+// dayPrices maps a session date to its daily open and close.
+type dayPrices map[string][2]float64
+
+func dailyPrices(daily []Bar) dayPrices {
+	out := make(dayPrices, len(daily))
+	for _, b := range daily {
+		out[sessionDateNYFromDaily(b.T)] = [2]float64{b.O, b.C}
+	}
+	return out
+}
+
+// gapPrices covers the gap sessions only, which is every date a result
+// trades, so the ticker's own benchmark needs no extra fetch.
+func gapPrices(points []GapPoint) dayPrices {
+	out := make(dayPrices, len(points))
+	for _, p := range points {
+		out[p.Date] = [2]float64{p.Open, p.Close}
+	}
+	return out
+}
+
+// ParseBenchmark returns the index benchmark to fetch; "" for none.
+func ParseBenchmark(s string) string {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	switch s {
+	case "":
+		return defaultBenchmark
+	case "NONE", "0":
+		return ""
+	}
+	return s
+}
+
+// benchmark builds ticker's buy-and-hold curve over res.Dates; ok is false
+// when the first date has no price.
+func benchmark(ticker string, px dayPrices, res BacktestResult, sz Sizing) (Benchmark, bool) {
+	if len(res.Dates) == 0 {
+		return Benchmark{}, false
+	}
+	first, ok := px[res.Dates[0]]
+	if !ok || first[0] <= 0 {
+		return Benchmark{}, false
+	}
+	b := Benchmark{Ticker: ticker, Equity: make([]float64, len(res.Dates))}
+	value := make([]float64, len(res.Dates))
+	last := first[0]
+	for i, d := range res.Dates {
+		if p, ok := px[d]; ok && p[1] > 0 {
+			last = p[1]
+		}
+		value[i] = 100 * last / first[0]
+		b.Equity[i] = Round3(value[i] - 100)
+	}
+	_, eps := Drawdowns(value, res.Dates, 100)
+	for _, ep := range eps {
+		b.MaxDrawdown = math.Max(b.MaxDrawdown, ep.Depth)
+	}
+	b.TotalReturn = b.Equity[len(b.Equity)-1]
+	strategy := res.Equity[len(res.Equity)-1]
+	if sz.Model != "" {
+		b.Capital = make([]float64, len(value))
+		for i, v := range value {
+			b.Capital[i] = Round2(sz.Capital * v / 100)
+		}
+		strategy = res.Compounded.TotalReturn
+	}
+	b.ExcessReturn = Round3(strategy - b.TotalReturn)
+	return b, true
+}
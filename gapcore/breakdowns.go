@@ -0,0 +1,79 @@
+// gapcore/breakdowns.go
+package gapcore
+
+import (
+	"math"
+	"sort"
+)
+
+// ========================= Breakdowns =========================
+
+// DecileStat summarizes the gap sessions in one |gap| decile.
+type DecileStat struct {
+	Decile           int     `json:"decile"` // 1 = smallest gaps
+	MinGap           float64 `json:"min_gap"`
+	MaxGap           float64 `json:"max_gap"` // |gap| %, inclusive
+	Count            int     `json:"count"`
+	AvgReturn        float64 `json:"avg_return"` // daily (close-open)/open %
+	FollowAvg        float64 `json:"follow_avg"`
+	FadeAvg          float64 `json:"fade_avg"`
+	ContinuationRate float64 `json:"continuation_rate"`
+}
+
+type Deciles struct {
+	GapUp   []DecileStat `json:"gap_up"`
+	GapDown []DecileStat `json:"gap_down"`
+}
+
+// gapDeciles splits points (already one gap direction) into ten equal-count
+// groups by |gap| and reports returns for each; fewer groups when n < 10.
+func gapDeciles(points []GapPoint) []DecileStat {
+	n := len(points)
+	if n == 0 {
+		return nil
+	}
+	sorted := append([]GapPoint(nil), points...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return math.Abs(sorted[i].GapPct) < math.Abs(sorted[j].GapPct)
+	})
+	groups := 10
+	if n < groups {
+		groups = n
+	}
+	out := make([]DecileStat, 0, groups)
+	for g := 0; g < groups; g++ {
+		lo, hi := g*n/groups, (g+1)*n/groups
+		part := sorted[lo:hi]
+		var sumRet, sumFollow float64
+		var cont int
+		for _, p := range part {
+			sumRet += p.DailyReturnPct
+			sumFollow += float64(p.Direction) * p.DailyReturnPct
+			cont += p.SameDir
+		}
+		out = append(out, DecileStat{
+			Decile:           g + 1,
+			MinGap:           Round3(math.Abs(part[0].GapPct)),
+			MaxGap:           Round3(math.Abs(part[len(part)-1].GapPct)),
+			Count:            len(part),
+			AvgReturn:        avg(sumRet, len(part)),
+			FollowAvg:        avg(sumFollow, len(part)),
+			FadeAvg:          avg(-sumFollow, len(part)),
+			ContinuationRate: Rate(cont, len(part)),
+		})
+	}
+	return out
+}
+
+// decilesBySide runs gapDeciles separately for gap-ups and gap-downs.
+func decilesBySide(points []GapPoint) Deciles {
+	var ups, downs []GapPoint
+	for _, p := range points {
+		if p.Direction == 1 {
+			ups = append(ups, p)
+		} else if p.Direction == -1 {
+			downs = append(downs, p)
+		}
+	}
+	return Deciles{GapUp: gapDeciles(ups), GapDown: gapDeciles(downs)}
+}
@@ -0,0 +1,133 @@
+// gapcore/cache.go
+package gapcore
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========================= Bar Cache =========================
+
+// A BarCache keeps provider responses in memory, shared by everything that
+// reads through the same Cached provider. Entries expire after their TTL
+// (or never), and the oldest are dropped once the cache holds limit.
+
+const (
+	dailyCacheTTL = 10 * time.Minute
+
+	// Hour (New York) the extended session ends.
+	extendedClose = 20
+
+	// Most daily ranges kept.
+	maxCachedRanges = 1000
+
+	// Most minute-bar sessions kept; the oldest are dropped first.
+	maxCachedSessions = 20000
+)
+
+type cacheEntry struct {
+	bars    []Bar
+	expires time.Time // zero: never
+}
+
+// BarCache holds bars by key; it is safe for concurrent use.
+type BarCache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	order   []string // insertion order, for eviction
+	limit   int
+}
+
+// NewBarCache returns an empty cache holding at most limit entries.
+func NewBarCache(limit int) *BarCache {
+	return &BarCache{entries: map[string]cacheEntry{}, limit: limit}
+}
+
+// Get returns key's bars unless missing or expired.
+func (c *BarCache) Get(key string) ([]Bar, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || (!e.expires.IsZero() && time.Now().After(e.expires)) {
+		return nil, false
+	}
+	return e.bars, true
+}
+
+// Put stores bars under key for ttl (0: no expiry).
+func (c *BarCache) Put(key string, bars []Bar, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := cacheEntry{bars: bars}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	if _, ok := c.entries[key]; !ok {
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = e
+	for len(c.order) > c.limit {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// Len returns the number of entries, expired ones included.
+func (c *BarCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Keys returns the keys of the unexpired entries, oldest first.
+func (c *BarCache) Keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	keys := make([]string, 0, len(c.order))
+	for _, k := range c.order {
+		if e := c.entries[k]; e.expires.IsZero() || now.Before(e.expires) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Bars returns the number of bars held, expired entries included.
+func (c *BarCache) Bars() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, e := range c.entries {
+		n += len(e.bars)
+	}
+	return n
+}
+
+// DeletePrefix drops every entry whose key starts with prefix and returns
+// how many it dropped.
+func (c *BarCache) DeletePrefix(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kept := c.order[:0]
+	for _, k := range c.order {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.entries, k)
+		} else {
+			kept = append(kept, k)
+		}
+	}
+	n := len(c.order) - len(kept)
+	c.order = kept
+	return n
+}
+
+// sessionFinished reports whether a NY session's bars are final: its date
+// lies before today, or it is today and the extended session (whose bars
+// the minute fetch includes) closed at 20:00.
+func sessionFinished(date string) bool {
+	now := time.Now().In(NewYork)
+	today := now.Format("2006-01-02")
+	return date < today || date == today && now.Hour() >= extendedClose
+}
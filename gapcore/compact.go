@@ -0,0 +1,122 @@
+// gapcore/compact.go
+package gapcore
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ========================= Bar Store Retention =========================
+
+// Minute files are most of a bar store — a year of one ticker's sessions is
+// tens of megabytes — so across hundreds of tickers it grows without bound
+// unless compacted. Compact drops the minute sessions older than
+// Retention.MinuteYears, then, while the store is over Retention.MaxBytes,
+// the least recently used minute sessions: a session read from the store
+// has its modification time bumped, so it marks the last use. Daily files
+// are small and are always kept. Compaction also clears out the temporary
+// files a crash can leave beside a store file. A removed session is simply
+// refetched if an analysis needs it again.
+
+// Retention limits a bar store; zero values keep everything.
+type Retention struct {
+	MinuteYears int   // drop minute sessions older than this many years
+	MaxBytes    int64 // cap on the whole store, enforced on minute sessions
+}
+
+func (r Retention) Zero() bool { return r.MinuteYears <= 0 && r.MaxBytes <= 0 }
+
+// CompactStats is what a compaction removed and left.
+type CompactStats struct {
+	Expired    int   `json:"expired"`     // minute sessions past MinuteYears
+	Evicted    int   `json:"evicted"`     // minute sessions dropped for MaxBytes
+	FreedBytes int64 `json:"freed_bytes"` // including stray temporary files
+	Bytes      int64 `json:"bytes"`       // store size afterwards
+	Sessions   int   `json:"minute_sessions"`
+}
+
+// Temporary files this old are strays from an interrupted write.
+const strayTmpAge = time.Hour
+
+type minuteFile struct {
+	path string
+	date string
+	size int64
+	used time.Time
+}
+
+// Compact applies s.Retention to the store.
+func (s *Stored) Compact() (CompactStats, error) {
+	var st CompactStats
+	if s.Dir == "" {
+		return st, nil
+	}
+	var files []minuteFile
+	err := filepath.WalkDir(s.Dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed meanwhile
+		}
+		if strings.HasSuffix(path, ".tmp") {
+			if time.Since(info.ModTime()) > strayTmpAge && os.Remove(path) == nil {
+				st.FreedBytes += info.Size()
+			}
+			return nil
+		}
+		st.Bytes += info.Size()
+		if filepath.Base(filepath.Dir(path)) == "minute" && minuteFileName(d.Name()) {
+			files = append(files, minuteFile{path, strings.TrimSuffix(d.Name(), ".json"), info.Size(), info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return st, err
+	}
+
+	remove := func(f minuteFile) bool {
+		if os.Remove(f.path) != nil {
+			return false
+		}
+		st.FreedBytes += f.size
+		st.Bytes -= f.size
+		return true
+	}
+	kept := files[:0]
+	if y := s.Retention.MinuteYears; y > 0 {
+		cutoff := time.Now().In(NewYork).AddDate(-y, 0, 0).Format("2006-01-02")
+		for _, f := range files {
+			if f.date < cutoff && remove(f) {
+				st.Expired++
+				continue
+			}
+			kept = append(kept, f)
+		}
+		files = kept
+	}
+	if limit := s.Retention.MaxBytes; limit > 0 && st.Bytes > limit {
+		sort.Slice(files, func(i, j int) bool { return files[i].used.Before(files[j].used) })
+		left := files[:0]
+		for _, f := range files {
+			if st.Bytes > limit && remove(f) {
+				st.Evicted++
+				continue
+			}
+			left = append(left, f)
+		}
+		files = left
+	}
+	st.Sessions = len(files)
+	return st, nil
+}
+
+// touch marks a store file as just used, for least-recently-used eviction.
+func touch(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
@@ -0,0 +1,178 @@
+// gapcore/costs.go
+package gapcore
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ========================= Trading Costs =========================
+
+// Costs are charged on both the entry and the exit and expressed as % of the
+// entry price, so they subtract directly from the per-trade % returns.
+
+type Costs struct {
+	Commission    float64 `json:"commission"`     // $ per share, each side
+	Slippage      float64 `json:"slippage"`       // each side, in SlippageUnits
+	SlippageUnits string  `json:"slippage_units"` // cents | bps | spread
+	// Short trades only, in the backtester.
+	Locate     float64 `json:"locate,omitempty"`      // $ per share
+	BorrowRate float64 `json:"borrow_rate,omitempty"` // annual %, charged for one day
+}
+
+func (c Costs) zero() bool { return c.Commission == 0 && c.Slippage == 0 }
+
+// roundTripPct is the cost of one round trip as % of entry. spread is the
+// Session's estimated bid/ask spread in $ (see spreadProxy).
+func (c Costs) roundTripPct(entry, exit, spread float64) float64 {
+	if entry <= 0 {
+		return 0
+	}
+	side := func(px float64) float64 {
+		cost := c.Commission
+		switch c.SlippageUnits {
+		case "bps":
+			cost += px * c.Slippage / 10000
+		case "spread":
+			cost += spread * c.Slippage
+		default:
+			cost += c.Slippage / 100
+		}
+		return cost
+	}
+	return (side(entry) + side(exit)) / entry * 100
+}
+
+// shortPct is the locate and one day of borrow as % of entry.
+func (c Costs) shortPct(entry float64) float64 {
+	if entry <= 0 {
+		return 0
+	}
+	return c.Locate/entry*100 + c.BorrowRate/360
+}
+
+// spreadProxy estimates a session's spread as the median high-low range of
+// its regular-session minute bars; quotes are not fetched.
+func spreadProxy(rth []Bar) float64 {
+	if len(rth) == 0 {
+		return 0
+	}
+	rs := make([]float64, len(rth))
+	for i, b := range rth {
+		rs[i] = b.H - b.L
+	}
+	sort.Float64s(rs)
+	return quantileSorted(rs, 0.5)
+}
+
+// ParseCosts reads commission, slippage, slippageUnits, locate and borrow
+// from q.
+func ParseCosts(q url.Values) (Costs, error) {
+	c := Costs{SlippageUnits: "cents"}
+	if v := strings.TrimSpace(q.Get("commission")); v != "" {
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil || x < 0 || x > 1 {
+			return c, fmt.Errorf("commission must be $ per share between 0 and 1")
+		}
+		c.Commission = x
+	}
+	switch u := strings.ToLower(strings.TrimSpace(q.Get("slippageUnits"))); u {
+	case "":
+	case "cents", "bps", "spread":
+		c.SlippageUnits = u
+	default:
+		return c, fmt.Errorf("slippageUnits must be cents, bps, or spread")
+	}
+	if v := strings.TrimSpace(q.Get("slippage")); v != "" {
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil || x < 0 || x > 100 {
+			return c, fmt.Errorf("slippage must be between 0 and 100 %s", c.SlippageUnits)
+		}
+		c.Slippage = x
+	}
+	if v := strings.TrimSpace(q.Get("locate")); v != "" {
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil || x < 0 || x > 5 {
+			return c, fmt.Errorf("locate must be $ per share between 0 and 5")
+		}
+		c.Locate = x
+	}
+	if v := strings.TrimSpace(q.Get("borrow")); v != "" {
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil || x < 0 || x > 1000 {
+			return c, fmt.Errorf("borrow must be an annual %% between 0 and 1000")
+		}
+		c.BorrowRate = x
+	}
+	return c, nil
+}
+
+// NetSummary restates a summary's fade/follow figures after costs.
+type NetSummary struct {
+	Costs          Costs      `json:"costs"`
+	AvgCost        float64    `json:"avg_cost"` // % per round trip
+	FadeAvg        float64    `json:"fade_avg"`
+	FollowAvg      float64    `json:"follow_avg"`
+	FadeStats      TradeStats `json:"fade_stats"`
+	FollowStats    TradeStats `json:"follow_stats"`
+	BestStrategy   string     `json:"best_strategy"`
+	ExpectedReturn float64    `json:"expected_return"`
+}
+
+// netSummary charges costPct[i] against both the fade and the follow side of
+// trade i (follow return followRets[i]).
+func netSummary(c Costs, followRets, costPct []float64) NetSummary {
+	fade := make([]float64, len(followRets))
+	follow := make([]float64, len(followRets))
+	var sumCost, sumFade, sumFollow float64
+	for i, r := range followRets {
+		follow[i] = r - costPct[i]
+		fade[i] = -r - costPct[i]
+		sumCost += costPct[i]
+		sumFade += fade[i]
+		sumFollow += follow[i]
+	}
+	n := len(followRets)
+	ns := NetSummary{
+		Costs:       c,
+		AvgCost:     avg(sumCost, n),
+		FadeAvg:     avg(sumFade, n),
+		FollowAvg:   avg(sumFollow, n),
+		FadeStats:   TradeStatsOf(fade),
+		FollowStats: TradeStatsOf(follow),
+	}
+	ns.BestStrategy, ns.ExpectedReturn = bestOf(ns.FadeAvg, ns.FollowAvg)
+	// After costs both sides can lose; NEUTRAL then means "don't trade".
+	if ns.ExpectedReturn <= 0 {
+		ns.BestStrategy, ns.ExpectedReturn = "NEUTRAL", 0
+	}
+	return ns
+}
+
+// dailyCosts returns per-session round-trip cost % for the daily open→close
+// trade of each point.
+func dailyCosts(c Costs, points []GapPoint, minutesByDate map[string][]Bar) []float64 {
+	out := make([]float64, len(points))
+	for i, p := range points {
+		out[i] = c.roundTripPct(p.Open, p.Close, spreadProxy(rthBars(minutesByDate[p.Date])))
+	}
+	return out
+}
+
+// costs15 returns per-trade cost % for the 0–15m trades, pricing both sides
+// at the session open (the move by 09:45 is small next to the price).
+func costs15(c Costs, resp AnalyzeResponse, minutesByDate map[string][]Bar) []float64 {
+	byDate := make(map[string]GapPoint, len(resp.Data))
+	for _, p := range resp.Data {
+		byDate[p.Date] = p
+	}
+	out := make([]float64, len(resp.dates15))
+	for i, d := range resp.dates15 {
+		p := byDate[d]
+		out[i] = c.roundTripPct(p.Open, p.Open, spreadProxy(rthBars(minutesByDate[d])))
+	}
+	return out
+}
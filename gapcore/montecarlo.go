@@ -0,0 +1,114 @@
+// gapcore/montecarlo.go
+package gapcore
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// ========================= Monte Carlo =========================
+
+// Resampled equity paths: each path draws len(trades) returns with
+// replacement and sums them in order, the same way the cum curves are built.
+
+const mcPaths = 1000
+
+// Percentiles summarizes a simulated distribution.
+type Percentiles struct {
+	P5  float64 `json:"p5"`
+	P25 float64 `json:"p25"`
+	P50 float64 `json:"p50"`
+	P75 float64 `json:"p75"`
+	P95 float64 `json:"p95"`
+}
+
+// Bands are per-trade percentile envelopes of the simulated cumulative paths.
+type Bands struct {
+	P5  []float64 `json:"p5"`
+	P50 []float64 `json:"p50"`
+	P95 []float64 `json:"p95"`
+}
+
+type MonteCarloResult struct {
+	Paths       int         `json:"paths"`
+	Terminal    Percentiles `json:"terminal"`     // final cumulative %
+	MaxDrawdown Percentiles `json:"max_drawdown"` // % points below running peak
+	LossProb    float64     `json:"loss_prob"`    // % of paths ending below 0
+	Bands       Bands       `json:"bands"`
+}
+
+type MonteCarlo struct {
+	Fade   MonteCarloResult `json:"fade"`
+	Follow MonteCarloResult `json:"follow"`
+}
+
+func percentilesOf(sorted []float64) Percentiles {
+	return Percentiles{
+		P5:  Round3(quantileSorted(sorted, 0.05)),
+		P25: Round3(quantileSorted(sorted, 0.25)),
+		P50: Round3(quantileSorted(sorted, 0.50)),
+		P75: Round3(quantileSorted(sorted, 0.75)),
+		P95: Round3(quantileSorted(sorted, 0.95)),
+	}
+}
+
+// MaxDrawdown returns the largest drop of a cumulative series below its
+// running peak (starting from 0).
+func MaxDrawdown(cum []float64) float64 {
+	var peak, dd float64
+	for _, v := range cum {
+		if v > peak {
+			peak = v
+		}
+		if peak-v > dd {
+			dd = peak - v
+		}
+	}
+	return dd
+}
+
+// monteCarlo resamples trades (per-trade % returns) into mcPaths equity paths.
+func monteCarlo(trades []float64, seed int64) MonteCarloResult {
+	n := len(trades)
+	out := MonteCarloResult{Paths: mcPaths}
+	if n == 0 {
+		return out
+	}
+	rng := rand.New(rand.NewSource(seed))
+	paths := make([][]float64, mcPaths)
+	terminal := make([]float64, mcPaths)
+	dds := make([]float64, mcPaths)
+	var losses int
+	for p := range paths {
+		path := make([]float64, n)
+		var cum float64
+		for i := range path {
+			cum += trades[rng.Intn(n)]
+			path[i] = cum
+		}
+		paths[p] = path
+		terminal[p] = cum
+		dds[p] = MaxDrawdown(path)
+		if cum < 0 {
+			losses++
+		}
+	}
+	sort.Float64s(terminal)
+	sort.Float64s(dds)
+	out.Terminal = percentilesOf(terminal)
+	out.MaxDrawdown = percentilesOf(dds)
+	out.LossProb = Rate(losses, mcPaths)
+
+	col := make([]float64, mcPaths)
+	out.Bands = Bands{P5: make([]float64, n), P50: make([]float64, n), P95: make([]float64, n)}
+	for i := 0; i < n; i++ {
+		for p := range paths {
+			col[p] = paths[p][i]
+		}
+		sort.Float64s(col)
+		out.Bands.P5[i] = Round3(quantileSorted(col, 0.05))
+		out.Bands.P50[i] = Round3(quantileSorted(col, 0.50))
+		out.Bands.P95[i] = Round3(quantileSorted(col, 0.95))
+	}
+	return out
+}
@@ -0,0 +1,272 @@
+// gapcore/polygon.go
+package gapcore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ========================= Providers =========================
+
+// Analyses read bars through a Provider. Polygon fetches them from
+// Polygon.io; Cached puts a bar cache in front of any Provider, so callers
+// that analyze several tickers (or re-run one with other parameters) do not
+// refetch the same bars. Bars are unadjusted, so gaps are the literal tape.
+
+// Bar is one daily or 1-minute bar.
+type Bar struct {
+	T int64   `json:"t"` // ms epoch (for intraday: start of minute)
+	O float64 `json:"o"`
+	H float64 `json:"h"`
+	L float64 `json:"l"`
+	C float64 `json:"c"`
+	V float64 `json:"v"`
+}
+
+// Provider supplies bars. Daily returns the regular-session daily bars from
+// from to to (YYYY-MM-DD, inclusive); Minute returns one NY session's
+// 1-minute bars.
+type Provider interface {
+	Daily(ctx context.Context, ticker, from, to string) ([]Bar, error)
+	Minute(ctx context.Context, ticker, date string) ([]Bar, error)
+}
+
+// ProviderError is a non-200 answer from the provider.
+type ProviderError struct {
+	Status     int
+	Text       string // e.g. "429 Too Many Requests"
+	RetryAfter string
+}
+
+func (e *ProviderError) Error() string { return "polygon: " + e.Text }
+
+// NewProviderError describes resp, a non-200 answer.
+func NewProviderError(resp *http.Response) error {
+	return &ProviderError{Status: resp.StatusCode, Text: resp.Status, RetryAfter: resp.Header.Get("Retry-After")}
+}
+
+// ErrUnknownTicker is a daily fetch that came back empty: Polygon answers
+// 200 with no bars for symbols it doesn't know.
+var ErrUnknownTicker = errors.New("unknown ticker or no daily bars")
+
+// UnknownTicker returns ErrUnknownTicker for ticker.
+func UnknownTicker(ticker string) error {
+	return fmt.Errorf("%w: %s", ErrUnknownTicker, ticker)
+}
+
+// Polygon fetches bars from Polygon.io with APIKey. A nil Client means
+// http.DefaultClient.
+type Polygon struct {
+	APIKey string
+	Client *http.Client
+
+	// RateLimit caps requests per minute (5 on Polygon's free tier),
+	// spacing them evenly; 0 only paces minute requests mildly.
+	RateLimit int
+
+	// Log, if set, records every response (see ResponseLog).
+	Log *ResponseLog
+
+	mu      sync.Mutex
+	minutes int       // minute requests made, for pacing
+	next    time.Time // earliest start of the next request under RateLimit
+}
+
+// wait blocks until RateLimit allows another request.
+func (p *Polygon) wait(ctx context.Context) error {
+	if p.RateLimit <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	now := time.Now()
+	start := p.next
+	if start.Before(now) {
+		start = now
+	}
+	p.next = start.Add(time.Minute / time.Duration(p.RateLimit))
+	p.mu.Unlock()
+	if start == now {
+		return nil
+	}
+	t := time.NewTimer(start.Sub(now))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// get fetches url, the kind (daily | minute) request for ticker's bars from
+// from to to.
+func (p *Polygon) get(ctx context.Context, kind, ticker, from, to, url string) ([]Bar, error) {
+	if err := p.wait(ctx); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	cl := p.Client
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var body []byte
+	if p.Log != nil {
+		if body, err = io.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+		p.record(kind, ticker, from, to, resp, body)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, NewProviderError(resp)
+	}
+	var pr struct {
+		Results []Bar `json:"results"`
+	}
+	if p.Log != nil {
+		err = json.Unmarshal(body, &pr)
+	} else {
+		err = json.NewDecoder(resp.Body).Decode(&pr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pr.Results, nil
+}
+
+func (p *Polygon) Daily(ctx context.Context, ticker, from, to string) ([]Bar, error) {
+	return p.get(ctx, "daily", ticker, from, to, fmt.Sprintf(
+		"https://api.polygon.io/v2/aggs/ticker/%s/range/1/day/%s/%s?adjusted=false&sort=asc&apiKey=%s",
+		ticker, from, to, p.APIKey,
+	))
+}
+
+func (p *Polygon) Minute(ctx context.Context, ticker, date string) ([]Bar, error) {
+	bars, err := p.get(ctx, "minute", ticker, date, date, fmt.Sprintf(
+		"https://api.polygon.io/v2/aggs/ticker/%s/range/1/minute/%s/%s?adjusted=false&sort=asc&limit=50000&apiKey=%s",
+		ticker, date, date, p.APIKey,
+	))
+	if p.RateLimit > 0 {
+		return bars, err
+	}
+	// Be nice to the API (mild pacing).
+	p.mu.Lock()
+	p.minutes++
+	pause := p.minutes%5 == 0
+	p.mu.Unlock()
+	if pause {
+		time.Sleep(200 * time.Millisecond)
+	}
+	return bars, err
+}
+
+// Cached is a Provider behind DailyBars and MinuteBars. Minute bars of a
+// finished session never change; a daily range that ends today is
+// refetched after dailyCacheTTL so the latest session shows up.
+type Cached struct {
+	Provider
+	DailyBars, MinuteBars *BarCache
+
+	dailyHits, dailyMisses, minuteHits, minuteMisses atomic.Int64
+}
+
+// CacheCounts are the requests a Cached provider answered from its caches
+// (hits) and passed on (misses) since it was made.
+type CacheCounts struct {
+	DailyHits, DailyMisses, MinuteHits, MinuteMisses int64
+}
+
+func (c *Cached) Counts() CacheCounts {
+	return CacheCounts{c.dailyHits.Load(), c.dailyMisses.Load(), c.minuteHits.Load(), c.minuteMisses.Load()}
+}
+
+// NewCached returns p behind new caches.
+func NewCached(p Provider) *Cached {
+	return &Cached{
+		Provider:   p,
+		DailyBars:  NewBarCache(maxCachedRanges),
+		MinuteBars: NewBarCache(maxCachedSessions),
+	}
+}
+
+func (c *Cached) Daily(ctx context.Context, ticker, from, to string) ([]Bar, error) {
+	key := ticker + "|" + from + "|" + to
+	if bars, ok := c.DailyBars.Get(key); ok {
+		c.dailyHits.Add(1)
+		return bars, nil
+	}
+	c.dailyMisses.Add(1)
+	bars, err := c.Provider.Daily(ctx, ticker, from, to)
+	if err != nil {
+		return nil, err
+	}
+	ttl := dailyCacheTTL
+	if sessionFinished(to) {
+		ttl = 0
+	}
+	c.DailyBars.Put(key, bars, ttl)
+	return bars, nil
+}
+
+func (c *Cached) Minute(ctx context.Context, ticker, date string) ([]Bar, error) {
+	key := ticker + "|" + date
+	if bars, ok := c.MinuteBars.Get(key); ok {
+		c.minuteHits.Add(1)
+		return bars, nil
+	}
+	c.minuteMisses.Add(1)
+	bars, err := c.Provider.Minute(ctx, ticker, date)
+	if err == nil && sessionFinished(date) {
+		c.MinuteBars.Put(key, bars, 0)
+	}
+	return bars, err
+}
+
+// EachMinute fetches the dates' 1-minute bars in order and hands each date
+// to fn as soon as it arrives; dates the provider fails on are skipped.
+// Canceling ctx stops the loop with ctx's error, as does a failed request.
+func EachMinute(ctx context.Context, prov Provider, ticker string, dates []string, fn func(date string, bars []Bar)) error {
+	for _, d := range dates {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		bars, err := prov.Minute(ctx, ticker, d)
+		var pe *ProviderError
+		switch {
+		case errors.As(err, &pe):
+			// Skip this date if the provider returns an error for that day
+		case err != nil:
+			return err
+		default:
+			fn(d, bars)
+		}
+	}
+	return nil
+}
+
+// MinuteBars returns the dates' 1-minute bars by date, as EachMinute
+// fetches them.
+func MinuteBars(ctx context.Context, prov Provider, ticker string, dates []string) (map[string][]Bar, error) {
+	out := make(map[string][]Bar, len(dates))
+	err := EachMinute(ctx, prov, ticker, dates, func(d string, bars []Bar) {
+		out[d] = bars
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,275 @@
+// gapcore/quality.go
+package gapcore
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// ========================= Data Quality =========================
+
+// Audit checks the bars an analysis is built on: trading days the daily
+// series skips, sessions that traded no volume, gap sessions whose minute
+// bars are absent or miss the opening 15 minutes, and prints that look
+// wrong — daily bars whose high/low don't contain their open/close,
+// one-day spikes that fully revert, and regular-session minute bars
+// outside their day's range. Minute data is only checked for the gap
+// sessions, the ones the 0–15m statistics read.
+
+// Issue kinds.
+const (
+	IssueMissingSession = "missing_session" // NYSE trading day with no daily bar
+	IssueZeroVolume     = "zero_volume"
+	IssueMissingMinute  = "missing_minute" // gap session with no minute bars
+	IssueNoOpening      = "no_opening"     // minute bars, but none 09:30–09:44
+	IssueBadPrint       = "bad_print"
+)
+
+// A close that moves more than spikeMove and then moves back to within
+// spikeRevert of where it started is a suspected bad print, not news (an
+// unadjusted split jumps once and stays).
+const (
+	spikeMove   = 0.20
+	spikeRevert = 0.05
+)
+
+// Regular-session minute bars may stray this far outside the day's
+// high/low before they count as bad prints.
+const minuteRangeTolerance = 0.01
+
+type QualityIssue struct {
+	Date   string `json:"date"`
+	Kind   string `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type QualityReport struct {
+	SchemaVersion SchemaVersion  `json:"schema_version"`
+	Ticker        string         `json:"ticker"`
+	Years         int            `json:"years"`
+	MinGap        float64        `json:"min_gap"`
+	From          string         `json:"from"` // first and last daily session
+	To            string         `json:"to"`
+	Sessions      int            `json:"sessions"`          // daily bars
+	Expected      int            `json:"expected_sessions"` // NYSE trading days From..To
+	GapSessions   int            `json:"gap_sessions"`      // minute data checked
+	Counts        map[string]int `json:"counts"`            // issues by kind
+	CleanPct      float64        `json:"clean_pct"`         // % of expected sessions without an issue
+	MinuteCover   float64        `json:"minute_coverage"`   // % of gap sessions with an opening 15m
+	Issues        []QualityIssue `json:"issues"`            // by date
+}
+
+// Audit fetches params.Ticker's daily bars over the lookback, and the
+// minute bars of its gap sessions, from prov and reports their issues.
+func Audit(ctx context.Context, prov Provider, params Params) (QualityReport, error) {
+	from, to := params.DateRange()
+	daily, err := prov.Daily(ctx, params.Ticker, from, to)
+	if err != nil {
+		return QualityReport{}, err
+	}
+	if len(daily) == 0 {
+		return QualityReport{}, UnknownTicker(params.Ticker)
+	}
+	_, points := AnalyzeDaily(daily, params.MinGap, params.Years, params.Ticker)
+	dates := make([]string, len(points))
+	byDate := make(map[string]GapPoint, len(points))
+	for i, p := range points {
+		dates[i] = p.Date
+		byDate[p.Date] = p
+	}
+	minutes := make(map[string][]Bar, len(dates))
+	err = EachMinute(ctx, prov, params.Ticker, dates, func(d string, bars []Bar) {
+		minutes[d] = bars
+	})
+	if err != nil {
+		return QualityReport{}, err
+	}
+
+	out := QualityReport{
+		Ticker:      params.Ticker,
+		Years:       params.Years,
+		MinGap:      params.MinGap,
+		Sessions:    len(daily),
+		GapSessions: len(dates),
+		Counts:      map[string]int{},
+		Issues:      []QualityIssue{},
+	}
+	add := func(date, kind, detail string) {
+		out.Issues = append(out.Issues, QualityIssue{Date: date, Kind: kind, Detail: detail})
+		out.Counts[kind]++
+	}
+
+	// Daily bars
+	have := make(map[string]Bar, len(daily))
+	dayOf := make([]string, len(daily))
+	for i, b := range daily {
+		d := sessionDateNYFromDaily(b.T)
+		dayOf[i] = d
+		have[d] = b
+		if b.V == 0 {
+			add(d, IssueZeroVolume, "")
+		}
+		if b.L <= 0 || b.H < math.Max(b.O, b.C) || b.L > math.Min(b.O, b.C) {
+			add(d, IssueBadPrint, fmt.Sprintf("daily O %g H %g L %g C %g is inconsistent", b.O, b.H, b.L, b.C))
+		}
+	}
+	for i := 1; i+1 < len(daily); i++ {
+		c0, c1, c2 := daily[i-1].C, daily[i].C, daily[i+1].C
+		if c0 <= 0 || c1 <= 0 {
+			continue
+		}
+		move, back := c1/c0-1, c2/c1-1
+		if math.Abs(move) > spikeMove && math.Abs(back) > spikeMove && Sign(move) != Sign(back) && math.Abs(c2/c0-1) < spikeRevert {
+			add(dayOf[i], IssueBadPrint, fmt.Sprintf("close %g spikes %+.1f%% and reverts the next session", c1, move*100))
+		}
+	}
+
+	// Trading days without a bar
+	out.From, out.To = dayOf[0], dayOf[len(dayOf)-1]
+	first, _ := time.Parse("2006-01-02", out.From)
+	last, _ := time.Parse("2006-01-02", out.To)
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		if !TradingDay(d) {
+			continue
+		}
+		out.Expected++
+		if s := d.Format("2006-01-02"); !hasBar(have, s) {
+			add(s, IssueMissingSession, "")
+		}
+	}
+
+	// Minute bars of the gap sessions
+	opening := 0
+	for _, d := range dates {
+		bars, ok := minutes[d]
+		if !ok || len(bars) == 0 {
+			add(d, IssueMissingMinute, "")
+			continue
+		}
+		if _, _, ok := Snapshot15(byDate[d], bars); ok {
+			opening++
+		} else {
+			add(d, IssueNoOpening, fmt.Sprintf("%d minute bars, none in the opening 15m", len(bars)))
+		}
+		day := have[d]
+		for _, b := range bars {
+			if !regularMinute(b.T) {
+				continue
+			}
+			if b.H > day.H*(1+minuteRangeTolerance) || b.L < day.L*(1-minuteRangeTolerance) {
+				add(d, IssueBadPrint, fmt.Sprintf("%s minute H %g L %g outside the day's %g–%g",
+					ToNY(time.UnixMilli(b.T)).Format("15:04"), b.H, b.L, day.L, day.H))
+				break
+			}
+		}
+	}
+	if len(dates) > 0 {
+		out.MinuteCover = Round1(100 * float64(opening) / float64(len(dates)))
+	}
+
+	sort.SliceStable(out.Issues, func(i, j int) bool { return out.Issues[i].Date < out.Issues[j].Date })
+	bad := map[string]bool{}
+	for _, is := range out.Issues {
+		bad[is.Date] = true
+	}
+	if out.Expected > 0 {
+		out.CleanPct = Round1(100 * float64(max(out.Expected-len(bad), 0)) / float64(out.Expected))
+	}
+	return out, nil
+}
+
+func hasBar(have map[string]Bar, date string) bool {
+	_, ok := have[date]
+	return ok
+}
+
+// regularMinute reports whether the minute starting at tms (ms epoch) is in
+// the 09:30–16:00 New York session.
+func regularMinute(tms int64) bool {
+	ny := ToNY(time.UnixMilli(tms))
+	m := ny.Hour()*60 + ny.Minute()
+	return m >= 9*60+30 && m < 16*60
+}
+
+// ========================= Trading Calendar =========================
+
+// Full-day NYSE closures outside the regular holiday rules.
+var specialClosures = map[string]bool{
+	"2018-12-05": true, // President George H. W. Bush
+	"2025-01-09": true, // President Jimmy Carter
+}
+
+// TradingDay reports whether the NYSE is open on d's calendar date.
+func TradingDay(d time.Time) bool {
+	if wd := d.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		return false
+	}
+	s := d.Format("2006-01-02")
+	return !specialClosures[s] && !nyseHoliday(d)
+}
+
+// nyseHoliday reports whether d is a regular NYSE holiday (as observed).
+func nyseHoliday(d time.Time) bool {
+	y, m, day := d.Date()
+	on := func(hm time.Month, hd int) bool { return m == hm && day == hd }
+	// Fixed-date holidays move to Friday when on a Saturday and Monday when
+	// on a Sunday — except New Year's Day, which is not made up on the
+	// Friday before.
+	observed := func(hm time.Month, hd int) bool {
+		h := time.Date(y, hm, hd, 0, 0, 0, 0, time.UTC)
+		switch h.Weekday() {
+		case time.Saturday:
+			h = h.AddDate(0, 0, -1)
+		case time.Sunday:
+			h = h.AddDate(0, 0, 1)
+		}
+		return h.Month() == m && h.Day() == day
+	}
+	nth := func(hm time.Month, wd time.Weekday, n int) bool {
+		if m != hm || d.Weekday() != wd {
+			return false
+		}
+		if n < 0 { // last
+			return day+7 > daysIn(y, hm)
+		}
+		return (day-1)/7 == n-1
+	}
+	switch {
+	case on(time.January, 1) || (on(time.January, 2) && d.Weekday() == time.Monday):
+		return true
+	case nth(time.January, time.Monday, 3), // Martin Luther King Jr. Day
+		nth(time.February, time.Monday, 3),   // Washington's Birthday
+		nth(time.May, time.Monday, -1),       // Memorial Day
+		nth(time.September, time.Monday, 1),  // Labor Day
+		nth(time.November, time.Thursday, 4): // Thanksgiving
+		return true
+	case y >= 2022 && observed(time.June, 19): // Juneteenth
+		return true
+	case observed(time.July, 4), observed(time.December, 25):
+		return true
+	}
+	gf := easter(y).AddDate(0, 0, -2) // Good Friday
+	return m == gf.Month() && day == gf.Day()
+}
+
+func daysIn(y int, m time.Month) int {
+	return time.Date(y, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// easter returns Easter Sunday of year y (anonymous Gregorian algorithm).
+func easter(y int) time.Time {
+	a, b, c := y%19, y/100, y%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return time.Date(y, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,112 @@
+// gapcore/reentry.go
+package gapcore
+
+// ========================= Re-Entry =========================
+
+// After a stop-out the backtester can take the same trade again, up to
+// cfg.Reentries times per session, once price re-crosses the entry trigger:
+// the original entry price for the 09:30 and 09:45 entries, a fresh VWAP
+// cross for the vwap entry. Each re-entry is filled at the next bar's open
+// and managed with the same stop, target, trail and time exit.
+
+// Most re-entries allowed per session.
+const maxReentries = 3
+
+// ReentryStats compares the run with and without its re-entries.
+type ReentryStats struct {
+	Reentries         int        `json:"reentries"` // re-entry trades taken
+	Sessions          int        `json:"sessions"`  // sessions with at least one
+	Without           TradeStats `json:"without"`   // first entries only
+	With              TradeStats `json:"with"`      // every trade
+	ReentryOnly       TradeStats `json:"reentry_only"`
+	PerSessionWithout float64    `json:"per_session_without"` // % per traded session
+	PerSessionWith    float64    `json:"per_session_with"`
+}
+
+// reentryBar finds the bar that re-enters a trade stopped out at bars[k].
+func reentryBar(bars []Bar, cfg BacktestConfig, t Trade, trigger float64, k int) int {
+	if cfg.Entry == "vwap" {
+		return vwapCross(bars, t.Long, k+1)
+	}
+	dir := 1.0
+	if !t.Long {
+		dir = -1
+	}
+	// A trail can stop out on the favorable side of the trigger; price must
+	// then come back through it before it can re-cross.
+	beyond := dir*(t.ExitPrice-trigger) <= 0
+	for i := k + 1; i < len(bars)-1; i++ {
+		if dir*(bars[i].C-trigger) <= 0 {
+			beyond = true
+		} else if beyond {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// withReentries returns first, followed by up to cfg.Reentries re-entries.
+func withReentries(p GapPoint, s Session, cfg BacktestConfig, first Trade) []Trade {
+	out := []Trade{first}
+	t := first
+	for n := 1; n <= cfg.Reentries && (t.ExitReason == "stop" || t.ExitReason == "trail"); n++ {
+		k := -1
+		for i, b := range s.bars {
+			if fmtNY(b.T) == t.ExitTime {
+				k = i
+				break
+			}
+		}
+		if k < 0 {
+			break
+		}
+		next, ok := simulateFrom(p, s, cfg, t.Side, reentryBar(s.bars, cfg, t, first.EntryPrice, k))
+		if !ok {
+			break
+		}
+		next.Reentry = n
+		out = append(out, next)
+		t = next
+	}
+	return out
+}
+
+// addReentries expands trades (one per session, in date order) with their
+// re-entries and summarizes the difference.
+func addReentries(points []GapPoint, sessions map[string]Session, cfg BacktestConfig, trades []Trade) ([]Trade, ReentryStats) {
+	byDate := make(map[string]GapPoint, len(points))
+	for _, p := range points {
+		byDate[p.Date] = p
+	}
+	var all []Trade
+	var firstRets, allRets, reRets []float64
+	var st ReentryStats
+	for _, t := range trades {
+		legs := withReentries(byDate[t.Date], sessions[t.Date], cfg, t)
+		all = append(all, legs...)
+		firstRets = append(firstRets, t.ReturnPct)
+		for _, l := range legs {
+			allRets = append(allRets, l.ReturnPct)
+			if l.Reentry > 0 {
+				reRets = append(reRets, l.ReturnPct)
+			}
+		}
+		if len(legs) > 1 {
+			st.Sessions++
+		}
+	}
+	st.Reentries = len(reRets)
+	st.Without = TradeStatsOf(firstRets)
+	st.With = TradeStatsOf(allRets)
+	st.ReentryOnly = TradeStatsOf(reRets)
+	var sumFirst, sumAll float64
+	for _, r := range firstRets {
+		sumFirst += r
+	}
+	for _, r := range allRets {
+		sumAll += r
+	}
+	st.PerSessionWithout = avg(sumFirst, len(trades))
+	st.PerSessionWith = avg(sumAll, len(trades))
+	return all, st
+}
@@ -0,0 +1,180 @@
+// gapcore/replay.go
+package gapcore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ========================= Response Log & Replay =========================
+
+// A ResponseLog records every answer Polygon gives — the raw body, with the
+// request it answered but not the URL, which holds the API key — as one
+// JSON line appended to a file that is never rewritten. Replay serves bars
+// from such a log and nothing else, so an analysis re-run against it sees
+// exactly the bytes the original run did: research stays reproducible after
+// the provider revises its data, and a change to the analysis can be
+// debugged offline against the inputs that exposed it.
+
+// LoggedResponse is one line of a response log.
+type LoggedResponse struct {
+	Time       time.Time       `json:"time"`
+	Kind       string          `json:"kind"` // daily | minute
+	Ticker     string          `json:"ticker"`
+	From       string          `json:"from"` // a minute request's session date
+	To         string          `json:"to"`
+	Status     int             `json:"status"`
+	StatusText string          `json:"status_text,omitempty"` // non-200 only
+	Body       json.RawMessage `json:"body"`                  // a body that isn't JSON is logged as a string
+}
+
+// ResponseLog appends LoggedResponses to a file.
+type ResponseLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenResponseLog opens path for appending, creating it if needed.
+func OpenResponseLog(path string) (*ResponseLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &ResponseLog{f: f}, nil
+}
+
+// Append writes r as one line.
+func (l *ResponseLog) Append(r LoggedResponse) error {
+	if !json.Valid(r.Body) {
+		r.Body, _ = json.Marshal(string(r.Body))
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.f.Write(append(b, '\n'))
+	return err
+}
+
+// record logs one response to p.Log, if set. A failed write is dropped:
+// the log is a by-product of the request, not part of it.
+func (p *Polygon) record(kind, ticker, from, to string, resp *http.Response, body []byte) {
+	if p.Log == nil {
+		return
+	}
+	r := LoggedResponse{Time: time.Now().UTC(), Kind: kind, Ticker: ticker, From: from, To: to, Status: resp.StatusCode, Body: body}
+	if resp.StatusCode != http.StatusOK {
+		r.StatusText = resp.Status
+	}
+	p.Log.Append(r)
+}
+
+// Replay is a Provider answering from a response log. A request is
+// answered by the latest successful response to the same request or, if it
+// never succeeded, by its latest error. A daily range the log has no
+// response for is cut from every daily bar logged for the ticker, so an
+// analysis whose lookback has since moved still runs; a minute session the
+// log lacks fails as a 404, which analyses skip like any session the
+// provider has no bars for.
+type Replay struct {
+	Path string
+
+	daily  map[string]LoggedResponse // ticker|from|to
+	minute map[string]LoggedResponse // ticker|date
+	bars   map[string][]Bar          // every logged daily bar, by ticker
+}
+
+// LoadReplay reads the response log at path. Lines that don't parse, such
+// as one cut short by a crash, are skipped.
+func LoadReplay(path string) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rp := &Replay{
+		Path:   path,
+		daily:  map[string]LoggedResponse{},
+		minute: map[string]LoggedResponse{},
+		bars:   map[string][]Bar{},
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 1<<20), 1<<30) // a minute session is ~100KB, a long daily range more
+	for sc.Scan() {
+		var r LoggedResponse
+		if json.Unmarshal(sc.Bytes(), &r) != nil {
+			continue
+		}
+		switch r.Kind {
+		case "daily":
+			keepLatest(rp.daily, r.Ticker+"|"+r.From+"|"+r.To, r)
+			if bars, err := r.bars(); err == nil {
+				rp.bars[r.Ticker] = mergeBars(rp.bars[r.Ticker], bars)
+			}
+		case "minute":
+			keepLatest(rp.minute, r.Ticker+"|"+r.From, r)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rp, nil
+}
+
+// keepLatest sets m[key] to r unless that would replace a success with an
+// error.
+func keepLatest(m map[string]LoggedResponse, key string, r LoggedResponse) {
+	if old, ok := m[key]; ok && old.Status == http.StatusOK && r.Status != http.StatusOK {
+		return
+	}
+	m[key] = r
+}
+
+// Responses returns the number of daily and minute requests the log
+// answers.
+func (rp *Replay) Responses() (daily, minute int) {
+	return len(rp.daily), len(rp.minute)
+}
+
+func (rp *Replay) Daily(_ context.Context, ticker, from, to string) ([]Bar, error) {
+	if r, ok := rp.daily[ticker+"|"+from+"|"+to]; ok {
+		return r.bars()
+	}
+	var out []Bar
+	for _, b := range rp.bars[ticker] {
+		if date := sessionDateNYFromDaily(b.T); date >= from && date <= to {
+			out = append(out, b)
+		}
+	}
+	return out, nil
+}
+
+func (rp *Replay) Minute(_ context.Context, ticker, date string) ([]Bar, error) {
+	r, ok := rp.minute[ticker+"|"+date]
+	if !ok {
+		return nil, &ProviderError{Status: http.StatusNotFound, Text: "404 not in replay log " + rp.Path}
+	}
+	return r.bars()
+}
+
+// bars decodes r as Polygon.get does its response.
+func (r LoggedResponse) bars() ([]Bar, error) {
+	if r.Status != http.StatusOK {
+		return nil, &ProviderError{Status: r.Status, Text: r.StatusText}
+	}
+	var pr struct {
+		Results []Bar `json:"results"`
+	}
+	if err := json.Unmarshal(r.Body, &pr); err != nil {
+		return nil, err
+	}
+	return pr.Results, nil
+}
@@ -0,0 +1,172 @@
+// gapcore/scaleout.go
+package gapcore
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ========================= Scale-Out Exits =========================
+
+// A scale-out scheme splits the position into legs that share the entry, the
+// stop, the trail and the time exit but take profit at different levels.
+// Legs are independent once filled, so each is simulated as its own trade
+// and the blended return is the size-weighted sum of the legs.
+
+// Limits on scale=... parameters.
+const (
+	maxScaleSchemes = 6
+	maxScaleLegs    = 4
+)
+
+// ScaleLeg is one slice of the position. Target is "half" (half the gap to
+// the prior close), "fill" (the prior close), "none" (rides to stop or time),
+// or a distance in the backtest's units. Gap targets only apply to fades;
+// follow legs with them have no target.
+type ScaleLeg struct {
+	Size   float64 `json:"size"` // % of the position
+	Target string  `json:"target"`
+
+	distance float64
+	fillFrac float64
+}
+
+type ScaleScheme struct {
+	Name string     `json:"name"` // as requested, e.g. 50@half,50@fill
+	Legs []ScaleLeg `json:"legs"`
+}
+
+// ScaleLegStats reports how often a leg reached its target and what it made.
+type ScaleLegStats struct {
+	Size      float64 `json:"size"`
+	Target    string  `json:"target"`
+	HitRate   float64 `json:"hit_rate"`   // % of trades exiting at the leg's target
+	AvgReturn float64 `json:"avg_return"` // % per trade on the leg alone
+}
+
+type ScaleOut struct {
+	Scheme      string          `json:"scheme"`
+	Side        string          `json:"side"`
+	Stats       TradeStats      `json:"stats"` // blended % per trade
+	Legs        []ScaleLegStats `json:"legs"`
+	MaxDrawdown float64         `json:"max_drawdown"` // % points, cumulative blended curve
+	Untraded    int             `json:"untraded"`     // sessions at least one leg could not trade
+}
+
+// ParseScaleSchemes reads every scale=SIZE@TARGET,... value from q. Sizes
+// are % of the position and must add up to 100.
+func ParseScaleSchemes(q url.Values, units string) ([]ScaleScheme, error) {
+	var out []ScaleScheme
+	for _, v := range q["scale"] {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		sc := ScaleScheme{Name: v}
+		var total float64
+		for _, leg := range strings.Split(v, ",") {
+			size, target, ok := strings.Cut(strings.TrimSpace(leg), "@")
+			if !ok {
+				return nil, fmt.Errorf("scale leg %q must be SIZE@TARGET", leg)
+			}
+			l := ScaleLeg{Target: strings.ToLower(strings.TrimSpace(target))}
+			x, err := strconv.ParseFloat(strings.TrimSpace(size), 64)
+			if err != nil || x <= 0 || x > 100 {
+				return nil, fmt.Errorf("scale leg %q: size must be a %% between 0 and 100", leg)
+			}
+			l.Size = x
+			total += x
+			switch l.Target {
+			case "half":
+				l.fillFrac = 0.5
+			case "fill":
+				l.fillFrac = 1
+			case "none":
+			default:
+				d, err := strconv.ParseFloat(l.Target, 64)
+				if err != nil || !ValidDistance(d, units) {
+					return nil, fmt.Errorf("scale leg %q: target must be half, fill, none, or a distance in %s", leg, units)
+				}
+				l.distance = d
+			}
+			sc.Legs = append(sc.Legs, l)
+		}
+		if len(sc.Legs) > maxScaleLegs {
+			return nil, fmt.Errorf("at most %d legs per scale scheme", maxScaleLegs)
+		}
+		if total < 99.99 || total > 100.01 {
+			return nil, fmt.Errorf("scale scheme %q: sizes must add up to 100", v)
+		}
+		out = append(out, sc)
+	}
+	if len(out) > maxScaleSchemes {
+		return nil, fmt.Errorf("at most %d scale schemes", maxScaleSchemes)
+	}
+	return out, nil
+}
+
+// runScaleOuts simulates every scheme for each configured side. Each leg
+// replaces cfg's target with its own; stop, trail and exit time are shared.
+func runScaleOuts(points []GapPoint, minutesByDate map[string][]Bar, atr map[string]float64, cfg BacktestConfig, schemes []ScaleScheme) []ScaleOut {
+	sessions, _ := SessionBars(points, minutesByDate, atr)
+	var out []ScaleOut
+	for _, sc := range schemes {
+		for _, side := range cfg.Sides {
+			so := ScaleOut{Scheme: sc.Name, Side: side}
+			legTrades := make([]map[string]Trade, len(sc.Legs))
+			for i, l := range sc.Legs {
+				c := cfg
+				c.Target, c.TargetFill, c.fillFrac = l.distance, false, l.fillFrac
+				trades, _ := SimulateSide(points, sessions, c, side)
+				legTrades[i] = make(map[string]Trade, len(trades))
+				for _, t := range trades {
+					legTrades[i][t.Date] = t
+				}
+			}
+			hits := make([]int, len(sc.Legs))
+			sums := make([]float64, len(sc.Legs))
+			var rets []float64
+			for _, p := range points {
+				if s, ok := sessions[p.Date]; !ok || !eligible(p, s, cfg, side) {
+					continue
+				}
+				var blended float64
+				all := true
+				for i, l := range sc.Legs {
+					t, ok := legTrades[i][p.Date]
+					if !ok {
+						all = false
+						break
+					}
+					blended += l.Size / 100 * t.ReturnPct
+				}
+				if !all {
+					so.Untraded++
+					continue
+				}
+				for i := range sc.Legs {
+					t := legTrades[i][p.Date]
+					sums[i] += t.ReturnPct
+					if t.ExitReason == "target" {
+						hits[i]++
+					}
+				}
+				rets = append(rets, Round3(blended))
+			}
+			so.Stats = TradeStatsOf(rets)
+			so.MaxDrawdown = Round3(MaxDrawdown(Cumulative(rets)))
+			for i, l := range sc.Legs {
+				so.Legs = append(so.Legs, ScaleLegStats{
+					Size:      l.Size,
+					Target:    l.Target,
+					HitRate:   Rate(hits[i], len(rets)),
+					AvgReturn: avg(sums[i], len(rets)),
+				})
+			}
+			out = append(out, so)
+		}
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+// gapcore/sequence.go
+package gapcore
+
+import "math"
+
+// ========================= Sequence Analysis =========================
+
+// Transition is one cell of a two-state outcome transition matrix.
+type Transition struct {
+	From        string  `json:"from"` // CONT | REV
+	To          string  `json:"to"`
+	Count       int     `json:"count"`
+	Probability float64 `json:"probability"` // % of From that moved to To
+}
+
+type MarkovStats struct {
+	Pairs        int           `json:"pairs"`
+	Transitions  []Transition  `json:"transitions"`
+	Independence ChiSquareTest `json:"independence"` // does From change the odds of CONT next?
+}
+
+// MarkovAnalysis looks at whether one gap session's outcome predicts the next
+// one's. Consecutive pairs any number of days apart; NextDay only pairs gap
+// sessions on back-to-back trading days.
+type MarkovAnalysis struct {
+	Consecutive MarkovStats `json:"consecutive"`
+	NextDay     MarkovStats `json:"next_day"`
+}
+
+var outcomeLabels = [2]string{"REV", "CONT"}
+
+// markov builds the transition matrix from outcomes (1 continuation, 0 not)
+// using only the pairs where keep(i) is true for the transition into i.
+func markov(outcomes []int, keep func(i int) bool) MarkovStats {
+	var counts [2][2]int
+	var out MarkovStats
+	for i := 1; i < len(outcomes); i++ {
+		if !keep(i) {
+			continue
+		}
+		counts[outcomes[i-1]][outcomes[i]]++
+		out.Pairs++
+	}
+	for from := 1; from >= 0; from-- {
+		rowTotal := counts[from][0] + counts[from][1]
+		for to := 1; to >= 0; to-- {
+			out.Transitions = append(out.Transitions, Transition{
+				From:        outcomeLabels[from],
+				To:          outcomeLabels[to],
+				Count:       counts[from][to],
+				Probability: Rate(counts[from][to], rowTotal),
+			})
+		}
+	}
+	out.Independence = chiSquareRates(
+		[]int{counts[1][1], counts[0][1]},
+		[]int{counts[1][0] + counts[1][1], counts[0][0] + counts[0][1]},
+	)
+	return out
+}
+
+// Number of lags reported in autocorrelation diagnostics.
+const maxACFLag = 10
+
+type ACFLag struct {
+	Lag         int     `json:"lag"`
+	R           float64 `json:"r"`
+	Significant bool    `json:"significant"` // |r| beyond the 95% white-noise bound
+}
+
+// Autocorrelation diagnoses serial dependence in a return series (ordered by
+// date). Ljung-Box tests all lags jointly.
+type Autocorrelation struct {
+	N                int      `json:"n"`
+	Bound            float64  `json:"bound"` // ±1.96/√n
+	Lags             []ACFLag `json:"lags"`
+	LjungBoxQ        float64  `json:"ljung_box_q"`
+	LjungBoxP        float64  `json:"ljung_box_p"`
+	SerialDependence bool     `json:"serial_dependence"` // Ljung-Box p < 0.05
+}
+
+func autocorrelation(xs []float64) Autocorrelation {
+	n := len(xs)
+	out := Autocorrelation{N: n, LjungBoxP: 1}
+	maxLag := maxACFLag
+	if n-1 < maxLag {
+		maxLag = n - 1
+	}
+	if maxLag < 1 {
+		return out
+	}
+	mean, _ := MeanStd(xs)
+	var denom float64
+	for _, x := range xs {
+		denom += (x - mean) * (x - mean)
+	}
+	if denom == 0 {
+		return out
+	}
+	bound := z95 / math.Sqrt(float64(n))
+	out.Bound = Round3(bound)
+	var q float64
+	for k := 1; k <= maxLag; k++ {
+		var num float64
+		for t := k; t < n; t++ {
+			num += (xs[t] - mean) * (xs[t-k] - mean)
+		}
+		r := num / denom
+		q += r * r / float64(n-k)
+		out.Lags = append(out.Lags, ACFLag{Lag: k, R: Round3(r), Significant: math.Abs(r) > bound})
+	}
+	q *= float64(n) * float64(n+2)
+	out.LjungBoxQ = Round3(q)
+	out.LjungBoxP = Round3(gammaQ(float64(maxLag)/2, q/2))
+	out.SerialDependence = out.LjungBoxP < 0.05
+	return out
+}
@@ -0,0 +1,130 @@
+// gapcore/sizing.go
+package gapcore
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ========================= Position Sizing =========================
+
+// Unsized backtests add up % returns. A sizing model instead buys whole
+// shares out of a running account, so gains and losses compound and the
+// drawdown is measured on dollars.
+
+// Sizing selects the model. Model "" leaves the run unsized.
+type Sizing struct {
+	Model   string  `json:"model,omitempty"`    // fixed_dollar | fixed_fractional | atr
+	Capital float64 `json:"capital,omitempty"`  // starting equity, $
+	Dollars float64 `json:"dollars,omitempty"`  // fixed_dollar: notional per trade
+	RiskPct float64 `json:"risk_pct,omitempty"` // fixed_fractional / atr: % of equity at risk
+	ATRMult float64 `json:"atr_mult,omitempty"` // atr: risk per share = ATRMult × ATR
+}
+
+// Intraday buying power cap (Reg T day-trading margin), as a multiple of equity.
+const MaxIntradayLeverage = 4.0
+
+// Compounded summarizes a sized run.
+type Compounded struct {
+	Model        string  `json:"model"`
+	StartCapital float64 `json:"start_capital"`
+	EndCapital   float64 `json:"end_capital"`
+	TotalReturn  float64 `json:"total_return"` // % on start capital
+	MaxDrawdown  float64 `json:"max_drawdown"` // % below peak equity
+	AvgExposure  float64 `json:"avg_exposure"` // position notional, % of equity at entry
+	Unfilled     int     `json:"unfilled"`     // trades sized to zero shares
+}
+
+// ParseSizing reads sizing, capital, dollars, risk and atrMult from q.
+// fixed_fractional needs a stop to measure risk; hasStop reports whether the
+// rules define one.
+func ParseSizing(q url.Values, hasStop bool) (Sizing, error) {
+	sz := Sizing{}
+	switch m := strings.ToLower(strings.TrimSpace(q.Get("sizing"))); m {
+	case "", "none":
+		return sz, nil
+	case "fixed_dollar", "fixed_fractional", "atr":
+		sz.Model = m
+	default:
+		return sz, fmt.Errorf("sizing must be fixed_dollar, fixed_fractional, or atr")
+	}
+	sz.Capital, sz.Dollars, sz.RiskPct, sz.ATRMult = 100000, 10000, 1, 1
+	for _, f := range []struct {
+		key      string
+		dst      *float64
+		min, max float64
+	}{
+		{"capital", &sz.Capital, 100, 1e9},
+		{"dollars", &sz.Dollars, 1, 1e9},
+		{"risk", &sz.RiskPct, 0.01, 100},
+		{"atrMult", &sz.ATRMult, 0.1, 20},
+	} {
+		if v := strings.TrimSpace(q.Get(f.key)); v != "" {
+			x, err := strconv.ParseFloat(v, 64)
+			if err != nil || x < f.min || x > f.max {
+				return sz, fmt.Errorf("%s must be between %g and %g", f.key, f.min, f.max)
+			}
+			*f.dst = x
+		}
+	}
+	if sz.Model == "fixed_fractional" && !hasStop {
+		return sz, fmt.Errorf("fixed_fractional sizing needs a stop, premarket stop, or trail")
+	}
+	return sz, nil
+}
+
+// Shares sizes one trade from the current equity.
+func (sz Sizing) Shares(equity float64, t Trade) int {
+	var n float64
+	switch sz.Model {
+	case "fixed_dollar":
+		n = sz.Dollars / t.EntryPrice
+	case "fixed_fractional":
+		if risk := math.Abs(t.EntryPrice - t.StopPrice); t.StopPrice > 0 && risk > 0 {
+			n = equity * sz.RiskPct / 100 / risk
+		}
+	case "atr":
+		if t.atr > 0 {
+			n = equity * sz.RiskPct / 100 / (sz.ATRMult * t.atr)
+		}
+	}
+	n = math.Min(n, equity*MaxIntradayLeverage/t.EntryPrice)
+	if n < 1 {
+		return 0
+	}
+	return int(n)
+}
+
+// applySizing fills Shares and PnL on trades in order and returns the $
+// equity after each trade with the run summary.
+func applySizing(sz Sizing, trades []Trade) ([]float64, Compounded) {
+	c := Compounded{Model: sz.Model, StartCapital: sz.Capital}
+	equity, peak := sz.Capital, sz.Capital
+	var dd, exposure float64
+	curve := make([]float64, len(trades))
+	for i := range trades {
+		t := &trades[i]
+		if equity > 0 {
+			t.Shares = sz.Shares(equity, *t)
+		}
+		if t.Shares == 0 {
+			c.Unfilled++
+		} else {
+			notional := float64(t.Shares) * t.EntryPrice
+			exposure += notional / equity * 100
+			t.PnL = Round2(notional * t.ReturnPct / 100)
+			equity += t.PnL
+		}
+		peak = math.Max(peak, equity)
+		dd = math.Max(dd, (peak-equity)/peak*100)
+		curve[i] = Round2(equity)
+	}
+	c.EndCapital = Round2(equity)
+	c.TotalReturn = Round3((equity - sz.Capital) / sz.Capital * 100)
+	c.MaxDrawdown = Round3(dd)
+	c.AvgExposure = avg(exposure, len(trades)-c.Unfilled)
+	return curve, c
+}
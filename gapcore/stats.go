@@ -0,0 +1,747 @@
+// gapcore/stats.go
+package gapcore
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// ========================= Statistics =========================
+
+// z-score for a two-sided 95% interval.
+const z95 = 1.959964
+
+// Interval is a confidence interval, expressed in the same units as the
+// statistic it accompanies (percent for rates, % per trade for averages).
+type Interval struct {
+	Low  float64 `json:"low"`
+	High float64 `json:"high"`
+}
+
+// wilson returns the 95% Wilson score interval for n successes out of d
+// trials, in percent. Unlike the normal approximation it stays inside [0,100]
+// and widens sensibly for small samples.
+func wilson(n, d int) Interval {
+	if d == 0 {
+		return Interval{}
+	}
+	p := float64(n) / float64(d)
+	fd := float64(d)
+	z2 := z95 * z95
+	denom := 1 + z2/fd
+	center := (p + z2/(2*fd)) / denom
+	half := z95 * math.Sqrt(p*(1-p)/fd+z2/(4*fd*fd)) / denom
+	return Interval{
+		Low:  Round1(math.Max(0, center-half) * 100.0),
+		High: Round1(math.Min(1, center+half) * 100.0),
+	}
+}
+
+// Number of resamples used for bootstrap intervals.
+const bootstrapResamples = 2000
+
+// bootstrapMeanCI returns a 95% percentile-bootstrap interval for the mean of
+// xs. The generator is seeded so that repeated requests give identical output.
+func bootstrapMeanCI(xs []float64) Interval {
+	n := len(xs)
+	if n < 2 {
+		return Interval{}
+	}
+	rng := rand.New(rand.NewSource(1))
+	means := make([]float64, bootstrapResamples)
+	for b := range means {
+		var sum float64
+		for i := 0; i < n; i++ {
+			sum += xs[rng.Intn(n)]
+		}
+		means[b] = sum / float64(n)
+	}
+	sort.Float64s(means)
+	return Interval{
+		Low:  Round3(quantileSorted(means, 0.025)),
+		High: Round3(quantileSorted(means, 0.975)),
+	}
+}
+
+// negated mirrors an interval around zero; fade returns are the exact negative
+// of follow returns, so their intervals are too.
+func negated(iv Interval) Interval {
+	return Interval{Low: -iv.High, High: -iv.Low}
+}
+
+// quantileSorted linearly interpolates the q-th quantile of an ascending slice.
+func quantileSorted(xs []float64, q float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	pos := q * float64(len(xs)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	if lo == hi {
+		return xs[lo]
+	}
+	return xs[lo] + (xs[hi]-xs[lo])*(pos-float64(lo))
+}
+
+// MeanTest tests whether the average per-trade follow return differs from
+// zero. Fade returns are the exact negative of follow returns, so the same
+// test decides fade vs follow.
+type MeanTest struct {
+	TStat       float64 `json:"t_stat"`
+	TPValue     float64 `json:"t_p_value"`    // two-sided Student t
+	Positive    int     `json:"positive"`     // trades where follow made money
+	Negative    int     `json:"negative"`     // trades where fade made money
+	SignPValue  float64 `json:"sign_p_value"` // two-sided exact binomial, ties dropped
+	Significant bool    `json:"significant"`  // both p-values < 0.05
+}
+
+func MeanStd(xs []float64) (mean, sd float64) {
+	n := len(xs)
+	if n == 0 {
+		return 0, 0
+	}
+	for _, x := range xs {
+		mean += x
+	}
+	mean /= float64(n)
+	if n < 2 {
+		return mean, 0
+	}
+	var ss float64
+	for _, x := range xs {
+		ss += (x - mean) * (x - mean)
+	}
+	return mean, math.Sqrt(ss / float64(n-1))
+}
+
+// meanTest runs a one-sample t-test and a sign test of xs against zero.
+func meanTest(xs []float64) MeanTest {
+	var mt MeanTest
+	for _, x := range xs {
+		if x > 0 {
+			mt.Positive++
+		} else if x < 0 {
+			mt.Negative++
+		}
+	}
+	mt.TPValue, mt.SignPValue = 1, 1
+	n := len(xs)
+	if n >= 2 {
+		mean, sd := MeanStd(xs)
+		if sd > 0 {
+			t := mean / (sd / math.Sqrt(float64(n)))
+			mt.TStat = Round3(t)
+			mt.TPValue = Round3(studentTwoSidedP(t, float64(n-1)))
+		}
+	}
+	if k, m := mt.Positive, mt.Positive+mt.Negative; m > 0 {
+		lo := binomCDF(k, m)       // P(X <= k)
+		hi := 1 - binomCDF(k-1, m) // P(X >= k)
+		mt.SignPValue = Round3(math.Min(1, 2*math.Min(lo, hi)))
+	}
+	mt.Significant = mt.TPValue < 0.05 && mt.SignPValue < 0.05
+	return mt
+}
+
+// studentTwoSidedP returns P(|T| >= |t|) for Student's t with df degrees of freedom.
+func studentTwoSidedP(t, df float64) float64 {
+	return betaInc(df/2, 0.5, df/(df+t*t))
+}
+
+// binomCDF returns P(X <= k) for X ~ Binomial(n, 0.5).
+func binomCDF(k, n int) float64 {
+	if k < 0 {
+		return 0
+	}
+	if k >= n {
+		return 1
+	}
+	return betaInc(float64(n-k), float64(k+1), 0.5)
+}
+
+// betaInc is the regularized incomplete beta function I_x(a, b), evaluated
+// with Lentz's continued fraction (Numerical Recipes 6.4).
+func betaInc(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	la, _ := math.Lgamma(a + b)
+	lb, _ := math.Lgamma(a)
+	lc, _ := math.Lgamma(b)
+	front := math.Exp(la - lb - lc + a*math.Log(x) + b*math.Log(1-x))
+	if x < (a+1)/(a+b+2) {
+		return front * betaCF(a, b, x) / a
+	}
+	return 1 - front*betaCF(b, a, 1-x)/b
+}
+
+func betaCF(a, b, x float64) float64 {
+	const eps, tiny = 1e-12, 1e-300
+	qab, qap, qam := a+b, a+1, a-1
+	c, d := 1.0, 1-qab*x/qap
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= 300; m++ {
+		fm := float64(m)
+		aa := fm * (b - fm) * x / ((qam + 2*fm) * (a + 2*fm))
+		d = 1 + aa*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + aa/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+		aa = -(a + fm) * (qab + fm) * x / ((a + 2*fm) * (qap + 2*fm))
+		d = 1 + aa*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + aa/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < eps {
+			break
+		}
+	}
+	return h
+}
+
+// ChiSquareTest is a test of independence between a grouping (e.g. weekday)
+// and continuation.
+type ChiSquareTest struct {
+	Stat        float64 `json:"chi2"`
+	DF          int     `json:"df"`
+	PValue      float64 `json:"p_value"`
+	Significant bool    `json:"significant"`  // p < 0.05
+	LowExpected bool    `json:"low_expected"` // some expected cell < 5; treat p with care
+}
+
+// chiSquareRates tests whether the success rate succ[i]/totals[i] is the same
+// across groups (a 2×k contingency table). Empty groups are dropped.
+func chiSquareRates(succ, totals []int) ChiSquareTest {
+	var s, t []float64
+	var sumS, sumT float64
+	for i := range totals {
+		if totals[i] == 0 {
+			continue
+		}
+		s = append(s, float64(succ[i]))
+		t = append(t, float64(totals[i]))
+		sumS += float64(succ[i])
+		sumT += float64(totals[i])
+	}
+	out := ChiSquareTest{PValue: 1}
+	if len(t) < 2 || sumS == 0 || sumS == sumT {
+		return out
+	}
+	p := sumS / sumT
+	var chi2 float64
+	for i := range t {
+		for _, c := range [][2]float64{{s[i], t[i] * p}, {t[i] - s[i], t[i] * (1 - p)}} {
+			obs, exp := c[0], c[1]
+			if exp < 5 {
+				out.LowExpected = true
+			}
+			chi2 += (obs - exp) * (obs - exp) / exp
+		}
+	}
+	out.DF = len(t) - 1
+	out.Stat = Round3(chi2)
+	out.PValue = Round3(gammaQ(float64(out.DF)/2, chi2/2))
+	out.Significant = out.PValue < 0.05
+	return out
+}
+
+// gammaQ is the regularized upper incomplete gamma function Q(a, x), which
+// gives the chi-square survival function as Q(df/2, chi2/2).
+func gammaQ(a, x float64) float64 {
+	if x <= 0 {
+		return 1
+	}
+	lg, _ := math.Lgamma(a)
+	if x < a+1 {
+		// series for P(a, x)
+		sum, del, ap := 1/a, 1/a, a
+		for n := 0; n < 500; n++ {
+			ap++
+			del *= x / ap
+			sum += del
+			if math.Abs(del) < math.Abs(sum)*1e-12 {
+				break
+			}
+		}
+		return 1 - sum*math.Exp(-x+a*math.Log(x)-lg)
+	}
+	// continued fraction for Q(a, x)
+	const tiny = 1e-300
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for i := 1; i < 500; i++ {
+		an := -float64(i) * (float64(i) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < 1e-12 {
+			break
+		}
+	}
+	return math.Exp(-x+a*math.Log(x)-lg) * h
+}
+
+// Moments describes the shape of a per-trade return distribution (% per trade).
+type Moments struct {
+	Median   float64 `json:"median"`
+	StdDev   float64 `json:"std_dev"`
+	Skew     float64 `json:"skew"`
+	Kurtosis float64 `json:"kurtosis"` // excess; 0 for a normal distribution
+}
+
+// moments computes median, sample standard deviation, and the (population)
+// skewness and excess kurtosis of xs.
+func moments(xs []float64) Moments {
+	n := len(xs)
+	if n == 0 {
+		return Moments{}
+	}
+	sorted := append([]float64(nil), xs...)
+	sort.Float64s(sorted)
+	mean, sd := MeanStd(xs)
+	var m2, m3, m4 float64
+	for _, x := range xs {
+		d := x - mean
+		m2 += d * d
+		m3 += d * d * d
+		m4 += d * d * d * d
+	}
+	m2 /= float64(n)
+	m3 /= float64(n)
+	m4 /= float64(n)
+	out := Moments{
+		Median: Round3(quantileSorted(sorted, 0.5)),
+		StdDev: Round3(sd),
+	}
+	if m2 > 0 {
+		out.Skew = Round3(m3 / math.Pow(m2, 1.5))
+		out.Kurtosis = Round3(m4/(m2*m2) - 3)
+	}
+	return out
+}
+
+// negated returns the moments of -X: the fade view of follow returns.
+func (m Moments) negated() Moments {
+	return Moments{Median: -m.Median, StdDev: m.StdDev, Skew: -m.Skew, Kurtosis: m.Kurtosis}
+}
+
+// Strength of the beta prior used for shrinkage, in pseudo-sessions.
+const shrinkPriorSessions = 20.0
+
+// shrunkRate is the posterior-mean rate (%) of n successes in d trials under a
+// Beta prior centred on prior (a fraction) worth shrinkPriorSessions
+// observations. Sparse cells are pulled toward prior; large ones barely move.
+func shrunkRate(n, d int, prior float64) float64 {
+	a := prior * shrinkPriorSessions
+	return Round1((float64(n) + a) / (float64(d) + shrinkPriorSessions) * 100.0)
+}
+
+// XY is a point on a fitted curve.
+type XY struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Regression is an OLS fit of y on x plus a LOESS smooth for plotting.
+type Regression struct {
+	N         int     `json:"n"`
+	Slope     float64 `json:"slope"`
+	Intercept float64 `json:"intercept"`
+	R2        float64 `json:"r2"`
+	Fitted    []XY    `json:"fitted"` // OLS line at min/max x
+	Smooth    []XY    `json:"smooth"` // LOESS curve on an even grid
+}
+
+// Points on the LOESS grid and the fraction of data in each local fit.
+const (
+	loessGrid = 40
+	loessSpan = 0.5
+)
+
+func regress(xs, ys []float64) Regression {
+	out := Regression{N: len(xs)}
+	if len(xs) < 3 {
+		return out
+	}
+	slope, icpt, r2 := linearFit(xs, ys, nil)
+	out.Slope, out.Intercept, out.R2 = Round3(slope), Round3(icpt), Round3(r2)
+	lo, hi := xs[0], xs[0]
+	for _, x := range xs {
+		lo, hi = math.Min(lo, x), math.Max(hi, x)
+	}
+	out.Fitted = []XY{{Round3(lo), Round3(icpt + slope*lo)}, {Round3(hi), Round3(icpt + slope*hi)}}
+	out.Smooth = loess(xs, ys, lo, hi)
+	return out
+}
+
+// linearFit is a (optionally weighted) least-squares line. w may be nil.
+func linearFit(xs, ys, w []float64) (slope, intercept, r2 float64) {
+	var sw, sx, sy float64
+	for i := range xs {
+		wi := 1.0
+		if w != nil {
+			wi = w[i]
+		}
+		sw += wi
+		sx += wi * xs[i]
+		sy += wi * ys[i]
+	}
+	if sw == 0 {
+		return 0, 0, 0
+	}
+	mx, my := sx/sw, sy/sw
+	var sxx, sxy, syy float64
+	for i := range xs {
+		wi := 1.0
+		if w != nil {
+			wi = w[i]
+		}
+		dx, dy := xs[i]-mx, ys[i]-my
+		sxx += wi * dx * dx
+		sxy += wi * dx * dy
+		syy += wi * dy * dy
+	}
+	if sxx == 0 {
+		return 0, my, 0
+	}
+	slope = sxy / sxx
+	intercept = my - slope*mx
+	if syy > 0 {
+		r2 = sxy * sxy / (sxx * syy)
+	}
+	return slope, intercept, r2
+}
+
+// loess evaluates a tricube-weighted local linear fit on an even grid over
+// [lo, hi], each fit using the nearest loessSpan fraction of points.
+func loess(xs, ys []float64, lo, hi float64) []XY {
+	n := len(xs)
+	k := int(math.Ceil(loessSpan * float64(n)))
+	if k < 3 || hi <= lo {
+		return nil
+	}
+	out := make([]XY, 0, loessGrid)
+	dist := make([]float64, n)
+	sorted := make([]float64, n)
+	w := make([]float64, n)
+	for g := 0; g < loessGrid; g++ {
+		x0 := lo + (hi-lo)*float64(g)/float64(loessGrid-1)
+		for i, x := range xs {
+			dist[i] = math.Abs(x - x0)
+		}
+		copy(sorted, dist)
+		sort.Float64s(sorted)
+		h := sorted[k-1]
+		if h == 0 {
+			h = 1e-9
+		}
+		for i, d := range dist {
+			u := d / h
+			if u < 1 {
+				t := 1 - u*u*u
+				w[i] = t * t * t
+			} else {
+				w[i] = 0
+			}
+		}
+		slope, icpt, _ := linearFit(xs, ys, w)
+		out = append(out, XY{X: Round3(x0), Y: Round3(icpt + slope*x0)})
+	}
+	return out
+}
+
+// Histogram holds counts over contiguous bins [Edges[i], Edges[i+1]).
+type Histogram struct {
+	Width  float64   `json:"width"`
+	Edges  []float64 `json:"edges"` // len(Counts)+1
+	Counts []int     `json:"counts"`
+}
+
+// histogram bins xs with a fixed width, edges aligned to multiples of width so
+// that distributions from different requests line up.
+func histogram(xs []float64, width float64) Histogram {
+	h := Histogram{Width: width}
+	if len(xs) == 0 || width <= 0 {
+		return h
+	}
+	lo, hi := xs[0], xs[0]
+	for _, x := range xs {
+		lo, hi = math.Min(lo, x), math.Max(hi, x)
+	}
+	first := int(math.Floor(lo / width))
+	last := int(math.Floor(hi / width))
+	h.Counts = make([]int, last-first+1)
+	for k := first; k <= last+1; k++ {
+		h.Edges = append(h.Edges, Round3(float64(k)*width))
+	}
+	for _, x := range xs {
+		h.Counts[int(math.Floor(x/width))-first]++
+	}
+	return h
+}
+
+// negate returns a copy of xs with every sign flipped (follow → fade).
+func negate(xs []float64) []float64 {
+	out := make([]float64, len(xs))
+	for i, x := range xs {
+		out[i] = -x
+	}
+	return out
+}
+
+// Cumulative returns the running sum of xs, rounded like the cum curves.
+func Cumulative(xs []float64) []float64 {
+	out := make([]float64, len(xs))
+	var c float64
+	for i, x := range xs {
+		c += x
+		out[i] = Round3(c)
+	}
+	return out
+}
+
+// TradeStats describes a strategy's per-trade results (% per trade).
+type TradeStats struct {
+	Trades       int     `json:"trades"`
+	WinRate      float64 `json:"win_rate"` // %
+	AvgWin       float64 `json:"avg_win"`
+	AvgLoss      float64 `json:"avg_loss"`      // negative
+	ProfitFactor float64 `json:"profit_factor"` // gross win / gross loss; 0 if no losers
+	Expectancy   float64 `json:"expectancy"`    // % per trade
+	KellyFull    float64 `json:"kelly_full"`    // fraction of capital, 0 when the edge is negative or unsized
+	KellyHalf    float64 `json:"kelly_half"`
+	KellyWhy     string  `json:"kelly_why,omitempty"` // why Kelly is unsized (0)
+}
+
+func TradeStatsOf(rets []float64) TradeStats {
+	ts := TradeStats{Trades: len(rets)}
+	if len(rets) == 0 {
+		return ts
+	}
+	var wins, losses int
+	var grossWin, grossLoss float64
+	for _, r := range rets {
+		if r > 0 {
+			wins++
+			grossWin += r
+		} else if r < 0 {
+			losses++
+			grossLoss += r
+		}
+	}
+	n := float64(len(rets))
+	ts.WinRate = Rate(wins, len(rets))
+	ts.AvgWin = avg(grossWin, wins)
+	ts.AvgLoss = avg(grossLoss, losses)
+	if grossLoss < 0 {
+		ts.ProfitFactor = Round2(grossWin / -grossLoss)
+	}
+	// win% × avg win + loss% × avg loss, which reduces to the mean.
+	ts.Expectancy = Round3((grossWin + grossLoss) / n)
+	switch {
+	case len(rets) < MinRecSessions:
+		ts.KellyWhy = fmt.Sprintf("only %d trades (need %d)", len(rets), MinRecSessions)
+	case losses == 0:
+		ts.KellyWhy = "no losing trades: payoff ratio undefined"
+	default:
+		ts.KellyFull = Round3(kelly(float64(wins)/n, ts.AvgWin, ts.AvgLoss))
+		ts.KellyHalf = Round3(ts.KellyFull / 2)
+	}
+	return ts
+}
+
+// RiskStats are risk-adjusted metrics of a per-trade return series and its
+// running sum. Ratios are annualized by the observed trade frequency.
+type RiskStats struct {
+	Sharpe        float64 `json:"sharpe"`
+	Sortino       float64 `json:"sortino"`
+	MaxDrawdown   float64 `json:"max_drawdown"` // % points below running peak of the cum curve
+	MaxDDPeak     string  `json:"max_dd_peak,omitempty"`
+	MaxDDTrough   string  `json:"max_dd_trough,omitempty"`
+	TradesPerYear float64 `json:"trades_per_year"`
+}
+
+// RiskStatsOf computes RiskStats for trades dated by dates (YYYY-MM-DD, ascending).
+func RiskStatsOf(trades []float64, dates []string) RiskStats {
+	var rs RiskStats
+	n := len(trades)
+	if n < 2 || len(dates) != n {
+		return rs
+	}
+	first, err1 := time.Parse("2006-01-02", dates[0])
+	last, err2 := time.Parse("2006-01-02", dates[n-1])
+	if err1 != nil || err2 != nil {
+		return rs
+	}
+	years := last.Sub(first).Hours() / 24 / 365.25
+	if years <= 0 {
+		return rs
+	}
+	tpy := float64(n) / years
+	rs.TradesPerYear = Round1(tpy)
+
+	mean, sd := MeanStd(trades)
+	if sd > 0 {
+		rs.Sharpe = Round2(mean / sd * math.Sqrt(tpy))
+	}
+	var down float64
+	for _, r := range trades {
+		if r < 0 {
+			down += r * r
+		}
+	}
+	if dd := math.Sqrt(down / float64(n)); dd > 0 {
+		rs.Sortino = Round2(mean / dd * math.Sqrt(tpy))
+	}
+
+	var cum, peak, maxDD float64
+	peakIdx, ddPeak, ddTrough := -1, -1, -1
+	for i, r := range trades {
+		cum += r
+		if cum > peak {
+			peak, peakIdx = cum, i
+		}
+		if peak-cum > maxDD {
+			maxDD, ddPeak, ddTrough = peak-cum, peakIdx, i
+		}
+	}
+	rs.MaxDrawdown = Round3(maxDD)
+	if ddTrough >= 0 {
+		rs.MaxDDTrough = dates[ddTrough]
+		if ddPeak >= 0 {
+			rs.MaxDDPeak = dates[ddPeak]
+		} else {
+			rs.MaxDDPeak = dates[0] // drawdown from the starting flat line
+		}
+	}
+	return rs
+}
+
+// Drawdown episodes reported per equity curve.
+const topDrawdowns = 5
+
+// DrawdownEpisode is one stretch below a prior equity peak. Recovery is empty
+// when the curve had not regained the peak by the last trade.
+type DrawdownEpisode struct {
+	Peak     string  `json:"peak"`
+	Trough   string  `json:"trough"`
+	Recovery string  `json:"recovery,omitempty"`
+	Depth    float64 `json:"depth"`  // same units as the drawdown series
+	Trades   int     `json:"trades"` // trades from peak to recovery (or the end)
+	Days     int     `json:"days"`   // calendar days from peak to recovery (or the end)
+}
+
+// Drawdowns returns the running drawdown of an equity series aligned with
+// dates, and its deepest episodes. With start 0 the series is a cumulative %
+// curve and drawdowns are % points below the peak; otherwise it is $ equity
+// starting at start and drawdowns are % of the peak.
+func Drawdowns(equity []float64, dates []string, start float64) ([]float64, []DrawdownEpisode) {
+	dd := make([]float64, len(equity))
+	var eps []DrawdownEpisode
+	var cur *DrawdownEpisode
+	peak, peakIdx := start, -1
+	closeEp := func(end int) {
+		cur.Trades = end - peakIdx
+		from := dates[max(peakIdx, 0)]
+		to := dates[end]
+		if cur.Recovery != "" {
+			to = cur.Recovery
+		}
+		if a, err1 := time.Parse("2006-01-02", from); err1 == nil {
+			if b, err2 := time.Parse("2006-01-02", to); err2 == nil {
+				cur.Days = int(b.Sub(a).Hours() / 24)
+			}
+		}
+		eps = append(eps, *cur)
+		cur = nil
+	}
+	for i, v := range equity {
+		if v >= peak {
+			if cur != nil {
+				cur.Recovery = dates[i]
+				closeEp(i)
+			}
+			peak, peakIdx = v, i
+			continue
+		}
+		d := peak - v
+		if start != 0 {
+			d = d / peak * 100
+		}
+		dd[i] = Round3(d)
+		if cur == nil {
+			cur = &DrawdownEpisode{Peak: dates[max(peakIdx, 0)]} // from the start line
+		}
+		if dd[i] > cur.Depth {
+			cur.Depth, cur.Trough = dd[i], dates[i]
+		}
+	}
+	if cur != nil {
+		closeEp(len(equity) - 1)
+	}
+	sort.SliceStable(eps, func(i, j int) bool { return eps[i].Depth > eps[j].Depth })
+	if len(eps) > topDrawdowns {
+		eps = eps[:topDrawdowns]
+	}
+	return dd, eps
+}
+
+// kelly returns the Kelly fraction f = p − (1−p)/b for win probability p and
+// payoff ratio b = avgWin/|avgLoss|, floored at zero (no bet). Without a
+// loss the ratio is undefined, and that is no bet either, not full capital.
+func kelly(p, avgWin, avgLoss float64) float64 {
+	if avgWin <= 0 || avgLoss >= 0 {
+		return 0
+	}
+	b := avgWin / -avgLoss
+	return math.Max(0, p-(1-p)/b)
+}
+
+// winsorize clips xs to its lo-th and hi-th percentiles (0–100).
+func winsorize(xs []float64, lo, hi float64) []float64 {
+	if len(xs) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), xs...)
+	sort.Float64s(sorted)
+	a, b := quantileSorted(sorted, lo/100), quantileSorted(sorted, hi/100)
+	out := make([]float64, len(xs))
+	for i, x := range xs {
+		out[i] = math.Min(math.Max(x, a), b)
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+// gapcore/stats_test.go
+package gapcore
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTradeStatsKelly(t *testing.T) {
+	repeat := func(n int, rets ...float64) []float64 {
+		var out []float64
+		for i := 0; i < n; i++ {
+			out = append(out, rets...)
+		}
+		return out
+	}
+	tests := []struct {
+		name    string
+		rets    []float64
+		full    float64
+		unsized bool
+	}{
+		// p = 0.6, b = 1: f = 0.6 − 0.4 = 0.2.
+		{"edge", repeat(10, 1, 1, 1, -1, -1), 0.2, false},
+		// p = 0.5, b = 1: no edge.
+		{"no edge", repeat(10, 1, -1), 0, false},
+		{"two winners", []float64{1, 2}, 0, true},
+		{"never lost", repeat(25, 1), 0, true},
+	}
+	for _, tt := range tests {
+		ts := TradeStatsOf(tt.rets)
+		if math.Abs(ts.KellyFull-tt.full) > 1e-9 || math.Abs(ts.KellyHalf-tt.full/2) > 1e-9 {
+			t.Errorf("%s: kelly %v / %v, want %v / %v", tt.name, ts.KellyFull, ts.KellyHalf, tt.full, tt.full/2)
+		}
+		if (ts.KellyWhy != "") != tt.unsized {
+			t.Errorf("%s: kelly_why %q", tt.name, ts.KellyWhy)
+		}
+	}
+}
+
+func TestWilson(t *testing.T) {
+	tests := []struct {
+		n, d int
+		want Interval
+	}{
+		{50, 100, Interval{40.4, 59.6}},
+		{10, 10, Interval{72.2, 100}}, // all successes: capped at 100
+		{0, 10, Interval{0, 27.8}},
+		{0, 0, Interval{}},
+	}
+	for _, tt := range tests {
+		if got := wilson(tt.n, tt.d); got != tt.want {
+			t.Errorf("wilson(%d, %d) = %v, want %v", tt.n, tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestBootstrapMeanCI(t *testing.T) {
+	// 1..100: mean 50.5, standard error 28.87/√100, so the percentile
+	// interval sits near 50.5 ± 1.96·2.887.
+	var xs []float64
+	for i := 1; i <= 100; i++ {
+		xs = append(xs, float64(i))
+	}
+	iv := bootstrapMeanCI(xs)
+	if math.Abs(iv.Low-44.84) > 1 || math.Abs(iv.High-56.16) > 1 {
+		t.Errorf("1..100: %v, want about [44.84, 56.16]", iv)
+	}
+	if again := bootstrapMeanCI(xs); again != iv {
+		t.Errorf("seeded resampling gave %v, then %v", iv, again)
+	}
+	if iv := bootstrapMeanCI([]float64{2, 2, 2}); iv != (Interval{2, 2}) {
+		t.Errorf("constant sample: %v", iv)
+	}
+	if iv := bootstrapMeanCI([]float64{1}); iv != (Interval{}) {
+		t.Errorf("one value: %v", iv)
+	}
+}
+
+func TestBetaInc(t *testing.T) {
+	tests := []struct {
+		a, b, x, want float64
+	}{
+		{2, 3, 0.5, 11.0 / 16}, // P(Binomial(4, ½) ≥ 2)
+		{1, 1, 0.3, 0.3},       // uniform
+		{5, 5, 0.5, 0.5},       // symmetric
+		{2, 3, 0, 0},
+		{2, 3, 1, 1},
+	}
+	for _, tt := range tests {
+		if got := betaInc(tt.a, tt.b, tt.x); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("betaInc(%v, %v, %v) = %v, want %v", tt.a, tt.b, tt.x, got, tt.want)
+		}
+	}
+}
+
+func TestMeanTest(t *testing.T) {
+	// scipy.stats.ttest_1samp([1, 2, 3, 4, 5], 0): t = 4.243, p = 0.0132.
+	// The sign test sees 5 of 5 positive: p = 2/32.
+	mt := meanTest([]float64{1, 2, 3, 4, 5})
+	if mt.TStat != 4.243 || mt.TPValue != 0.013 {
+		t.Errorf("t = %v, p = %v; want 4.243, 0.013", mt.TStat, mt.TPValue)
+	}
+	if mt.Positive != 5 || math.Abs(mt.SignPValue-0.0625) > 0.001 || mt.Significant {
+		t.Errorf("sign test: %+v", mt)
+	}
+
+	for name, xs := range map[string][]float64{"empty": nil, "one value": {1}, "no spread": {1, 1, 1}} {
+		if mt := meanTest(xs); mt.TPValue != 1 || mt.TStat != 0 {
+			t.Errorf("%s: t = %v, p = %v; want 0, 1", name, mt.TStat, mt.TPValue)
+		}
+	}
+}
+
+func TestGammaQ(t *testing.T) {
+	// Chi-square survival at the 5% critical values, as Q(df/2, x/2).
+	for _, tt := range []struct{ df, x float64 }{{1, 3.841}, {2, 5.991}, {5, 11.070}, {10, 18.307}} {
+		if got := gammaQ(tt.df/2, tt.x/2); math.Abs(got-0.05) > 1e-4 {
+			t.Errorf("chi2 survival(%v, df %v) = %v, want 0.05", tt.x, tt.df, got)
+		}
+	}
+	if got := gammaQ(1, 2); math.Abs(got-math.Exp(-2)) > 1e-9 {
+		t.Errorf("gammaQ(1, 2) = %v, want e⁻²", got)
+	}
+	if got := gammaQ(3, 0); got != 1 {
+		t.Errorf("gammaQ(3, 0) = %v, want 1", got)
+	}
+}
+
+func TestChiSquareRates(t *testing.T) {
+	// 30/50 vs 20/50: every expected cell is 25, so χ² = 4·25/25 = 4 on 1
+	// df, p = 0.0455.
+	got := chiSquareRates([]int{30, 20}, []int{50, 50})
+	if got.Stat != 4 || got.DF != 1 || got.PValue != 0.046 || !got.Significant || got.LowExpected {
+		t.Errorf("30/50 vs 20/50: %+v", got)
+	}
+
+	if got := chiSquareRates([]int{3, 1}, []int{4, 4}); !got.LowExpected {
+		t.Errorf("expected cells under 5 not flagged: %+v", got)
+	}
+	for name, c := range map[string][2][]int{
+		"all successes": {{10, 20}, {10, 20}},
+		"no successes":  {{0, 0}, {10, 20}},
+		"one group":     {{5, 0}, {10, 0}},
+	} {
+		if got := chiSquareRates(c[0], c[1]); got.PValue != 1 || got.DF != 0 {
+			t.Errorf("%s: %+v, want p = 1 and no test", name, got)
+		}
+	}
+}
+
+func TestShrunkRate(t *testing.T) {
+	tests := []struct {
+		n, d  int
+		prior float64
+		want  float64
+	}{
+		{0, 0, 0.5, 50},        // no data: the prior
+		{10, 10, 0.5, 66.7},    // (10 + 10) / (10 + 20)
+		{500, 1000, 0.6, 50.2}, // (500 + 12) / (1000 + 20)
+	}
+	for _, tt := range tests {
+		if got := shrunkRate(tt.n, tt.d, tt.prior); got != tt.want {
+			t.Errorf("shrunkRate(%d, %d, %v) = %v, want %v", tt.n, tt.d, tt.prior, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+// gapcore/store.go
+package gapcore
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ========================= Bar Store =========================
+
+// Stored keeps bars on disk under Dir, so they outlive the process and the
+// in-memory BarCache: a repeat analysis, even from a new CLI run, reads its
+// bars locally. A ticker's daily bars are kept in Dir/TICKER/daily.json
+// with the date range they cover; a request reaching past that range
+// fetches only the missing dates before or after it (at most two requests)
+// and merges them in. A finished session's minute bars are written once to
+// Dir/TICKER/minute/YYYY-MM-DD.json. Today's daily bar is never stored,
+// nor today's minute bars before the extended session closes, as they may
+// still change.
+//
+// The store is plain JSON files rather than SQLite, which would need a cgo
+// or third-party driver; the files are small, and each is replaced whole
+// (written to a temporary file of its own beside it, then renamed), so a
+// reader never sees half of one. A file that cannot be read is refetched,
+// and one that cannot be written only costs the next run a request.
+//
+// Several processes may share a store — the server, CLI runs, and the
+// scheduler. Each update of a ticker's daily.json holds Dir/TICKER/.lock
+// (an flock, where the platform has one), so two processes extending the
+// same range take turns rather than one overwriting the other's bars; the
+// second finds the range already extended and fetches nothing. Minute files
+// need no lock: a finished session's bars are the same whoever writes them.
+
+// Stored is a Provider that reads through a bar store at Dir; with Dir
+// empty it passes every request to Provider.
+type Stored struct {
+	Provider
+	Dir       string
+	Retention Retention // applied by Compact
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex // per ticker, around daily updates (with the ticker's lock file)
+}
+
+// storedDaily is a ticker's daily.json.
+type storedDaily struct {
+	From    string `json:"from"`    // first date covered
+	Through string `json:"through"` // last date covered, never today
+	Bars    []Bar  `json:"bars"`
+}
+
+func (s *Stored) Daily(ctx context.Context, ticker, from, to string) ([]Bar, error) {
+	if s.Dir == "" || !StorableTicker(ticker) {
+		return s.Provider.Daily(ctx, ticker, from, to)
+	}
+	defer s.lockTicker(ticker)()
+
+	path := filepath.Join(s.Dir, ticker, "daily.json")
+	var d storedDaily
+	if readStoreFile(path, &d) != nil || d.From == "" || d.Through < d.From {
+		d = storedDaily{}
+	}
+	yesterday := time.Now().In(NewYork).AddDate(0, 0, -1).Format("2006-01-02")
+
+	var live []Bar // today's, returned but not stored
+	changed := false
+	if d.From == "" {
+		bars, err := s.Provider.Daily(ctx, ticker, from, to)
+		if err != nil {
+			return nil, err
+		}
+		d.From, d.Through = from, min(to, yesterday)
+		d.Bars, live = splitDaily(bars, yesterday)
+		changed = d.Through >= d.From
+	} else {
+		if from < d.From {
+			bars, err := s.Provider.Daily(ctx, ticker, from, addDays(d.From, -1))
+			if err != nil {
+				return nil, err
+			}
+			d.Bars = mergeBars(bars, d.Bars)
+			d.From, changed = from, true
+		}
+		if to > d.Through {
+			bars, err := s.Provider.Daily(ctx, ticker, addDays(d.Through, 1), to)
+			if err != nil {
+				return nil, err
+			}
+			var done []Bar
+			done, live = splitDaily(bars, yesterday)
+			d.Bars = mergeBars(d.Bars, done)
+			if t := min(to, yesterday); t > d.Through {
+				d.Through, changed = t, true
+			}
+		}
+	}
+	if changed {
+		writeStoreFile(path, d)
+	}
+
+	var out []Bar
+	for _, b := range append(d.Bars[:len(d.Bars):len(d.Bars)], live...) {
+		if date := sessionDateNYFromDaily(b.T); date >= from && date <= to {
+			out = append(out, b)
+		}
+	}
+	return out, nil
+}
+
+func (s *Stored) Minute(ctx context.Context, ticker, date string) ([]Bar, error) {
+	if s.Dir == "" || !StorableTicker(ticker) || !sessionFinished(date) {
+		return s.Provider.Minute(ctx, ticker, date)
+	}
+	path := filepath.Join(s.Dir, ticker, "minute", date+".json")
+	var bars []Bar
+	if readStoreFile(path, &bars) == nil {
+		touch(path)
+		return bars, nil
+	}
+	bars, err := s.Provider.Minute(ctx, ticker, date)
+	if err == nil {
+		writeStoreFile(path, bars)
+	}
+	return bars, err
+}
+
+// StoreCoverage is what the store holds for a ticker.
+type StoreCoverage struct {
+	From           string `json:"from,omitempty"` // daily range covered
+	Through        string `json:"through,omitempty"`
+	MinuteSessions int    `json:"minute_sessions"`
+}
+
+// Tickers returns the tickers the store holds bars for.
+func (s *Stored) Tickers() []string {
+	if s.Dir == "" {
+		return nil
+	}
+	ents, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, e := range ents {
+		if e.IsDir() && StorableTicker(e.Name()) {
+			out = append(out, e.Name())
+		}
+	}
+	return out
+}
+
+// Coverage reports what the store holds for ticker.
+func (s *Stored) Coverage(ticker string) StoreCoverage {
+	var c StoreCoverage
+	if s.Dir == "" || !StorableTicker(ticker) {
+		return c
+	}
+	var d storedDaily
+	if readStoreFile(filepath.Join(s.Dir, ticker, "daily.json"), &d) == nil {
+		c.From, c.Through = d.From, d.Through
+	}
+	ents, _ := os.ReadDir(filepath.Join(s.Dir, ticker, "minute"))
+	for _, e := range ents {
+		if filepath.Ext(e.Name()) == ".json" {
+			c.MinuteSessions++
+		}
+	}
+	return c
+}
+
+// Forget deletes everything the store holds for ticker.
+func (s *Stored) Forget(ticker string) error {
+	if s.Dir == "" || !StorableTicker(ticker) {
+		return nil
+	}
+	defer s.lockTicker(ticker)()
+	return os.RemoveAll(filepath.Join(s.Dir, ticker))
+}
+
+// lockTicker locks ticker's daily updates against this process and others,
+// returning the unlock. A lock file that cannot be taken leaves only the
+// in-process lock.
+func (s *Stored) lockTicker(ticker string) func() {
+	l := s.lock(ticker)
+	l.Lock()
+	unlockFile, err := lockFile(filepath.Join(s.Dir, ticker, ".lock"))
+	if err != nil {
+		return l.Unlock
+	}
+	return func() {
+		unlockFile()
+		l.Unlock()
+	}
+}
+
+func (s *Stored) lock(ticker string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locks == nil {
+		s.locks = map[string]*sync.Mutex{}
+	}
+	l, ok := s.locks[ticker]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[ticker] = l
+	}
+	return l
+}
+
+// StorableTicker reports whether ticker is safe as a directory name:
+// letters, digits, '.' and '-', not starting with '.'. Requests are held
+// to the same rule, so a ticker never reaches a path it could escape.
+func StorableTicker(ticker string) bool {
+	if ticker == "" || ticker[0] == '.' {
+		return false
+	}
+	for _, r := range ticker {
+		if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// splitDaily splits daily bars into those of sessions through last and
+// the rest.
+func splitDaily(bars []Bar, last string) (done, rest []Bar) {
+	for i, b := range bars {
+		if sessionDateNYFromDaily(b.T) > last {
+			return bars[:i:i], bars[i:]
+		}
+	}
+	return bars, nil
+}
+
+// mergeBars combines two sorted bar lists, keeping b's bar where both have
+// the same time.
+func mergeBars(a, b []Bar) []Bar {
+	byT := make(map[int64]Bar, len(a)+len(b))
+	for _, x := range a {
+		byT[x.T] = x
+	}
+	for _, x := range b {
+		byT[x.T] = x
+	}
+	out := make([]Bar, 0, len(byT))
+	for _, x := range byT {
+		out = append(out, x)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].T < out[j].T })
+	return out
+}
+
+func addDays(date string, n int) string {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return date
+	}
+	return t.AddDate(0, 0, n).Format("2006-01-02")
+}
+
+func readStoreFile(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// writeStoreFile replaces path with v's JSON; errors are dropped (see the
+// top of this file).
+func writeStoreFile(path string, v any) {
+	saveStoreFile(path, v)
+}
+
+// saveStoreFile is writeStoreFile reporting failure.
+func saveStoreFile(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// A temporary name of its own: another process may be writing the
+	// same file.
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+// gapcore/storearchive.go
+package gapcore
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ========================= Bar Store Archives =========================
+
+// A bar store moves between machines as a gzipped tar of its files, laid
+// out as in Dir: TICKER/daily.json and TICKER/minute/YYYY-MM-DD.json.
+// Importing merges rather than replaces. A minute session the store
+// already has is kept (a finished session's bars never change), and daily
+// bars are merged into the stored range when the two ranges meet; when
+// they don't, the wider range is kept, since the store holds one unbroken
+// range per ticker.
+
+// ArchiveStats counts what an export wrote or an import took in.
+type ArchiveStats struct {
+	Tickers        int `json:"tickers"`
+	DailyRanges    int `json:"daily_ranges"`
+	MinuteSessions int `json:"minute_sessions"`
+	Skipped        int `json:"skipped"` // import: minute sessions already stored, daily ranges not merged
+}
+
+// Export writes the bars stored for tickers (all, if empty) to w as a
+// gzipped tar.
+func (s *Stored) Export(w io.Writer, tickers []string) (ArchiveStats, error) {
+	var st ArchiveStats
+	if s.Dir == "" {
+		return st, errors.New("no bar store directory")
+	}
+	if len(tickers) == 0 {
+		tickers = s.Tickers()
+	}
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	for _, tk := range tickers {
+		if !StorableTicker(tk) {
+			return st, fmt.Errorf("invalid ticker %q", tk)
+		}
+		n, err := s.exportTicker(tw, tk)
+		if err != nil {
+			return st, err
+		}
+		if n.DailyRanges+n.MinuteSessions > 0 {
+			st.Tickers++
+		}
+		st.DailyRanges += n.DailyRanges
+		st.MinuteSessions += n.MinuteSessions
+	}
+	if err := tw.Close(); err != nil {
+		return st, err
+	}
+	return st, zw.Close()
+}
+
+func (s *Stored) exportTicker(tw *tar.Writer, ticker string) (ArchiveStats, error) {
+	var st ArchiveStats
+	unlock := s.lockTicker(ticker)
+	daily, err := os.ReadFile(filepath.Join(s.Dir, ticker, "daily.json"))
+	unlock()
+	switch {
+	case err == nil:
+		if err := addTarFile(tw, ticker+"/daily.json", daily); err != nil {
+			return st, err
+		}
+		st.DailyRanges++
+	case !errors.Is(err, os.ErrNotExist):
+		return st, err
+	}
+	ents, _ := os.ReadDir(filepath.Join(s.Dir, ticker, "minute"))
+	for _, e := range ents {
+		if !minuteFileName(e.Name()) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(s.Dir, ticker, "minute", e.Name()))
+		if err != nil {
+			return st, err
+		}
+		if err := addTarFile(tw, ticker+"/minute/"+e.Name(), b); err != nil {
+			return st, err
+		}
+		st.MinuteSessions++
+	}
+	return st, nil
+}
+
+func addTarFile(tw *tar.Writer, name string, b []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(b)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(b)
+	return err
+}
+
+// Import merges the bars of an archive Export wrote into the store. Entries
+// that are not store files are an error, as are files that don't parse;
+// what was merged before one stays merged.
+func (s *Stored) Import(r io.Reader) (ArchiveStats, error) {
+	var st ArchiveStats
+	if s.Dir == "" {
+		return st, errors.New("no bar store directory")
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return st, err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	seen := map[string]bool{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return st, err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		ticker, kind, file, ok := archivePath(hdr.Name)
+		if !ok || hdr.Typeflag != tar.TypeReg {
+			return st, fmt.Errorf("%s: not a bar store file", hdr.Name)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return st, err
+		}
+		if !seen[ticker] {
+			seen[ticker] = true
+			st.Tickers++
+		}
+		var merged bool
+		if kind == "daily" {
+			merged, err = s.importDaily(ticker, b)
+			st.DailyRanges++
+		} else {
+			merged, err = s.importMinute(ticker, file, b)
+			st.MinuteSessions++
+		}
+		if err != nil {
+			return st, fmt.Errorf("%s: %v", hdr.Name, err)
+		}
+		if !merged {
+			st.Skipped++
+		}
+	}
+	return st, nil
+}
+
+// archivePath splits an archive entry name into its ticker, kind (daily |
+// minute), and minute file name.
+func archivePath(name string) (ticker, kind, file string, ok bool) {
+	parts := strings.Split(path.Clean(name), "/")
+	if len(parts) < 2 || !StorableTicker(parts[0]) {
+		return "", "", "", false
+	}
+	switch {
+	case len(parts) == 2 && parts[1] == "daily.json":
+		return parts[0], "daily", "", true
+	case len(parts) == 3 && parts[1] == "minute" && minuteFileName(parts[2]):
+		return parts[0], "minute", parts[2], true
+	}
+	return "", "", "", false
+}
+
+// minuteFileName reports whether name is YYYY-MM-DD.json.
+func minuteFileName(name string) bool {
+	date, ok := strings.CutSuffix(name, ".json")
+	if !ok {
+		return false
+	}
+	_, err := time.Parse("2006-01-02", date)
+	return err == nil
+}
+
+func (s *Stored) importMinute(ticker, file string, b []byte) (bool, error) {
+	var bars []Bar
+	if err := json.Unmarshal(b, &bars); err != nil {
+		return false, err
+	}
+	p := filepath.Join(s.Dir, ticker, "minute", file)
+	if _, err := os.Stat(p); err == nil {
+		return false, nil
+	}
+	return true, saveStoreFile(p, bars)
+}
+
+func (s *Stored) importDaily(ticker string, b []byte) (bool, error) {
+	var in storedDaily
+	if err := json.Unmarshal(b, &in); err != nil {
+		return false, err
+	}
+	if in.From == "" || in.Through < in.From {
+		return false, errors.New("no date range")
+	}
+	defer s.lockTicker(ticker)()
+
+	p := filepath.Join(s.Dir, ticker, "daily.json")
+	var d storedDaily
+	if readStoreFile(p, &d) != nil || d.From == "" || d.Through < d.From {
+		return true, saveStoreFile(p, in)
+	}
+	if in.From > addDays(d.Through, 1) || d.From > addDays(in.Through, 1) {
+		// Disjoint: keep the wider range.
+		if span(in) <= span(d) {
+			return false, nil
+		}
+		return true, saveStoreFile(p, in)
+	}
+	if in.From >= d.From && in.Through <= d.Through {
+		return false, nil
+	}
+	d.Bars = mergeBars(in.Bars, d.Bars)
+	d.From, d.Through = min(d.From, in.From), max(d.Through, in.Through)
+	return true, saveStoreFile(p, d)
+}
+
+// span is the number of days d covers.
+func span(d storedDaily) int {
+	from, err1 := time.Parse("2006-01-02", d.From)
+	through, err2 := time.Parse("2006-01-02", d.Through)
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	return int(through.Sub(from).Hours() / 24)
+}
@@ -0,0 +1,130 @@
+// gapcore/walkforward.go
+package gapcore
+
+import "time"
+
+// ========================= Walk-Forward Evaluation =========================
+
+// Walk-forward re-estimates the strategy on a trailing training window and
+// trades it only on the following step, so every return on the equity curve
+// is out of sample.
+
+type WFWindow struct {
+	TrainFrom     string            `json:"train_from"`
+	TestFrom      string            `json:"test_from"`
+	TestTo        string            `json:"test_to"` // exclusive
+	TrainSessions int               `json:"train_sessions"`
+	Decisions     map[string]string `json:"decisions"` // bin → FOLLOW | FADE | FLAT
+	Trades        int               `json:"trades"`
+	Return        float64           `json:"return"` // sum % over the step
+}
+
+type WalkForward struct {
+	TrainMonths int        `json:"train_months"`
+	StepMonths  int        `json:"step_months"`
+	Windows     []WFWindow `json:"windows"`
+	Dates       []string   `json:"dates"`
+	Equity      []float64  `json:"equity"` // cumulative out-of-sample % (no compounding)
+	Trades      int        `json:"trades"`
+	AvgReturn   float64    `json:"avg_return"` // % per out-of-sample trade
+	WinRate     float64    `json:"win_rate"`   // %
+	TotalReturn float64    `json:"total_return"`
+	InSampleAvg float64    `json:"in_sample_avg"` // full-sample best strategy, same dates, for comparison
+}
+
+// wfDecide picks a side for each bin from training points: the bin's own
+// average follow return when it has minRecSessions, otherwise the training
+// window's overall average.
+func wfDecide(train []GapPoint, bins []GapBin) map[string]string {
+	var sumAll float64
+	sums := map[string]float64{}
+	counts := map[string]int{}
+	for _, p := range train {
+		r := float64(p.Direction) * p.DailyReturnPct
+		sumAll += r
+		sums[p.Bin] += r
+		counts[p.Bin]++
+	}
+	side := func(sum float64) string {
+		switch {
+		case sum > 0:
+			return "FOLLOW"
+		case sum < 0:
+			return "FADE"
+		}
+		return "FLAT"
+	}
+	out := make(map[string]string, len(bins))
+	for _, b := range bins {
+		if counts[b.Label] >= MinRecSessions {
+			out[b.Label] = side(sums[b.Label])
+		} else {
+			out[b.Label] = side(sumAll)
+		}
+	}
+	return out
+}
+
+func walkForward(points []GapPoint, minGap float64, trainMonths, stepMonths int) *WalkForward {
+	wf := &WalkForward{TrainMonths: trainMonths, StepMonths: stepMonths}
+	if len(points) == 0 {
+		return wf
+	}
+	bins := DefaultBins(minGap)
+	first, _ := time.Parse("2006-01-02", points[0].Date)
+	last, _ := time.Parse("2006-01-02", points[len(points)-1].Date)
+
+	var cum, inSample float64
+	var wins int
+	full := wfDecide(points, bins)
+	for testFrom := first.AddDate(0, trainMonths, 0); !testFrom.After(last); testFrom = testFrom.AddDate(0, stepMonths, 0) {
+		trainFrom := testFrom.AddDate(0, -trainMonths, 0)
+		testTo := testFrom.AddDate(0, stepMonths, 0)
+		tf, ts, tt := trainFrom.Format("2006-01-02"), testFrom.Format("2006-01-02"), testTo.Format("2006-01-02")
+
+		var train []GapPoint
+		for _, p := range points {
+			if p.Date >= tf && p.Date < ts {
+				train = append(train, p)
+			}
+		}
+		win := WFWindow{TrainFrom: tf, TestFrom: ts, TestTo: tt, TrainSessions: len(train), Decisions: wfDecide(train, bins)}
+		for _, p := range points {
+			if p.Date < ts || p.Date >= tt {
+				continue
+			}
+			follow := float64(p.Direction) * p.DailyReturnPct
+			var r float64
+			switch win.Decisions[p.Bin] {
+			case "FOLLOW":
+				r = follow
+			case "FADE":
+				r = -follow
+			default:
+				continue
+			}
+			switch full[p.Bin] {
+			case "FOLLOW":
+				inSample += follow
+			case "FADE":
+				inSample -= follow
+			}
+			win.Trades++
+			win.Return += r
+			if r > 0 {
+				wins++
+			}
+			cum += r
+			wf.Dates = append(wf.Dates, p.Date)
+			wf.Equity = append(wf.Equity, Round3(cum))
+		}
+		win.Return = Round3(win.Return)
+		wf.Trades += win.Trades
+		wf.Windows = append(wf.Windows, win)
+	}
+	wf.TotalReturn = Round3(cum)
+	wf.AvgReturn = avg(cum, wf.Trades)
+	wf.InSampleAvg = avg(inSample, wf.Trades)
+	wf.WinRate = Rate(wins, wf.Trades)
+	return wf
+}
@@ -1,5 +1,5 @@
 module gap-analyzer
 
-go 1.23.0
+go 1.24.0
 
 require github.com/joho/godotenv v1.5.1
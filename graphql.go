@@ -0,0 +1,594 @@
+// graphql.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= GraphQL =========================
+
+// /api/graphql answers GraphQL queries over the /api/gaps analysis so a
+// client can fetch only the fields it needs:
+//
+//	{ analysis(ticker: "SPY", years: 3) { summary { sessions continuation_rate } bins_15m { label fade_avg } } }
+//
+// The schema is the JSON response itself — every object field is named as
+// in /api/gaps — and is read off the Go types by reflection. The supported
+// language is the query subset clients use for this: named or anonymous
+// queries, variables with defaults, aliases, and arguments on the root
+// field; fragments, directives, mutations, and introspection are not.
+
+// Root field arguments and the /api/gaps parameters they map to.
+var graphqlArgs = map[string]string{
+	"ticker": "ticker", "years": "years", "minGap": "minGap", "winsorize": "winsorize",
+	"walkForward": "walkForward", "trainMonths": "trainMonths", "stepMonths": "stepMonths",
+	"commission": "commission", "slippage": "slippage", "slippageUnits": "slippageUnits",
+	"resample": "resample",
+}
+
+// Most root fields in one query: each can be a full analysis, and a
+// request is metered as one call.
+const maxRootFields = 5
+
+type gqlField struct {
+	Alias string
+	Name  string
+	Args  map[string]any // values; variables are *gqlVar
+	Sel   []gqlField
+}
+
+type gqlVar struct{ Name string }
+
+type gqlVarDef struct {
+	Name    string
+	Default any
+	HasDef  bool
+}
+
+type gqlToken struct {
+	kind byte // 'n' name, 's' string, '#' number, 'p' punctuator, 0 end
+	text string
+}
+
+func gqlLex(src string) ([]gqlToken, error) {
+	var toks []gqlToken
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		switch {
+		case unicode.IsSpace(c) || c == ',' || c == '\ufeff':
+			i++
+		case c == '#':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(rs) && (rs[j] == '_' || unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j])) {
+				j++
+			}
+			toks = append(toks, gqlToken{'n', string(rs[i:j])})
+			i = j
+		case c == '-' || unicode.IsDigit(c):
+			j := i + 1
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || strings.ContainsRune(".eE+-", rs[j])) {
+				j++
+			}
+			toks = append(toks, gqlToken{'#', string(rs[i:j])})
+			i = j
+		case c == '"':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(rs) && rs[j] != '"'; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+					switch rs[j] {
+					case 'n':
+						sb.WriteRune('\n')
+					case 't':
+						sb.WriteRune('\t')
+					default:
+						sb.WriteRune(rs[j])
+					}
+					continue
+				}
+				sb.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return nil, errors.New("unterminated string")
+			}
+			toks = append(toks, gqlToken{'s', sb.String()})
+			i = j + 1
+		case c == '.' && i+2 < len(rs) && rs[i+1] == '.' && rs[i+2] == '.':
+			return nil, errors.New("fragments are not supported")
+		case strings.ContainsRune("{}():!$=[]@", c):
+			if c == '@' {
+				return nil, errors.New("directives are not supported")
+			}
+			toks = append(toks, gqlToken{'p', string(c)})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return append(toks, gqlToken{}), nil
+}
+
+type gqlParser struct {
+	toks []gqlToken
+	pos  int
+}
+
+func (p *gqlParser) peek() gqlToken { return p.toks[p.pos] }
+
+func (p *gqlParser) next() gqlToken {
+	t := p.toks[p.pos]
+	if t.kind != 0 {
+		p.pos++
+	}
+	return t
+}
+
+func (p *gqlParser) isPunct(s string) bool {
+	t := p.peek()
+	return t.kind == 'p' && t.text == s
+}
+
+func (p *gqlParser) expect(s string) error {
+	if t := p.next(); t.kind != 'p' || t.text != s {
+		return fmt.Errorf("expected %q, got %q", s, t.text)
+	}
+	return nil
+}
+
+func (p *gqlParser) name() (string, error) {
+	t := p.next()
+	if t.kind != 'n' {
+		return "", fmt.Errorf("expected a name, got %q", t.text)
+	}
+	return t.text, nil
+}
+
+// document parses "[query [Name] [($v: Type = default …)]] { … }".
+func (p *gqlParser) document() ([]gqlVarDef, []gqlField, error) {
+	var defs []gqlVarDef
+	if t := p.peek(); t.kind == 'n' {
+		if t.text != "query" {
+			return nil, nil, fmt.Errorf("%s operations are not supported", t.text)
+		}
+		p.next()
+		if p.peek().kind == 'n' {
+			p.next()
+		}
+		if p.isPunct("(") {
+			p.next()
+			for !p.isPunct(")") {
+				if err := p.expect("$"); err != nil {
+					return nil, nil, err
+				}
+				name, err := p.name()
+				if err != nil {
+					return nil, nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, nil, err
+				}
+				if err := p.skipType(); err != nil {
+					return nil, nil, err
+				}
+				d := gqlVarDef{Name: name}
+				if p.isPunct("=") {
+					p.next()
+					if d.Default, err = p.value(); err != nil {
+						return nil, nil, err
+					}
+					d.HasDef = true
+				}
+				defs = append(defs, d)
+			}
+			p.next()
+		}
+	}
+	sel, err := p.selectionSet()
+	if err != nil {
+		return nil, nil, err
+	}
+	if t := p.peek(); t.kind != 0 {
+		return nil, nil, fmt.Errorf("unexpected %q after the query (one operation per request)", t.text)
+	}
+	return defs, sel, nil
+}
+
+func (p *gqlParser) skipType() error {
+	if p.isPunct("[") {
+		p.next()
+		if err := p.skipType(); err != nil {
+			return err
+		}
+		if err := p.expect("]"); err != nil {
+			return err
+		}
+	} else if _, err := p.name(); err != nil {
+		return err
+	}
+	if p.isPunct("!") {
+		p.next()
+	}
+	return nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlField, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var out []gqlField
+	for !p.isPunct("}") {
+		if p.peek().kind == 0 {
+			return nil, errors.New("unexpected end of query")
+		}
+		f, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	p.next()
+	if len(out) == 0 {
+		return nil, errors.New("empty selection set")
+	}
+	return out, nil
+}
+
+func (p *gqlParser) field() (gqlField, error) {
+	var f gqlField
+	name, err := p.name()
+	if err != nil {
+		return f, err
+	}
+	f.Alias, f.Name = name, name
+	if p.isPunct(":") {
+		p.next()
+		if f.Name, err = p.name(); err != nil {
+			return f, err
+		}
+	}
+	if p.isPunct("(") {
+		p.next()
+		f.Args = map[string]any{}
+		for !p.isPunct(")") {
+			arg, err := p.name()
+			if err != nil {
+				return f, err
+			}
+			if err := p.expect(":"); err != nil {
+				return f, err
+			}
+			if f.Args[arg], err = p.value(); err != nil {
+				return f, err
+			}
+		}
+		p.next()
+	}
+	if p.isPunct("{") {
+		if f.Sel, err = p.selectionSet(); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+func (p *gqlParser) value() (any, error) {
+	t := p.next()
+	switch {
+	case t.kind == 'p' && t.text == "$":
+		name, err := p.name()
+		return &gqlVar{name}, err
+	case t.kind == 's':
+		return t.text, nil
+	case t.kind == '#':
+		return strconv.ParseFloat(t.text, 64)
+	case t.kind == 'n' && (t.text == "true" || t.text == "false"):
+		return t.text == "true", nil
+	case t.kind == 'n' && t.text == "null":
+		return nil, nil
+	case t.kind == 'n':
+		return t.text, nil // enum value
+	}
+	return nil, fmt.Errorf("unsupported value %q", t.text)
+}
+
+// gqlObject is a JSON object that keeps the selection order.
+type gqlObject struct {
+	keys []string
+	vals map[string]any
+}
+
+func (o *gqlObject) set(k string, v any) {
+	if o.vals == nil {
+		o.vals = map[string]any{}
+	}
+	if _, ok := o.vals[k]; !ok {
+		o.keys = append(o.keys, k)
+	}
+	o.vals[k] = v
+}
+
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		kb, _ := json.Marshal(k)
+		vb, err := json.Marshal(o.vals[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(kb)
+		buf.WriteByte(':')
+		buf.Write(vb)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonField finds the exported field of struct type t named name in JSON.
+func jsonField(t reflect.Type, name string) (int, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = sf.Name
+		}
+		if tag == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// project returns the part of v picked by sel; path names the field for
+// errors. Lists and maps apply the selection to every element.
+func project(v reflect.Value, sel []gqlField, path string) (any, error) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return project(v.Elem(), sel, path)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return []any{}, nil
+		}
+		out := make([]any, v.Len())
+		for i := range out {
+			x, err := project(v.Index(i), sel, path)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = x
+		}
+		return out, nil
+	case reflect.Map:
+		out := map[string]any{}
+		iter := v.MapRange()
+		for iter.Next() {
+			x, err := project(iter.Value(), sel, path)
+			if err != nil {
+				return nil, err
+			}
+			out[fmt.Sprint(iter.Key().Interface())] = x
+		}
+		return out, nil
+	case reflect.Struct:
+		if len(sel) == 0 {
+			return nil, fmt.Errorf("field %q of type %s needs a selection of subfields", path, v.Type().Name())
+		}
+		out := &gqlObject{}
+		for _, f := range sel {
+			if f.Args != nil {
+				return nil, fmt.Errorf("field %q takes no arguments", path+"."+f.Name)
+			}
+			if f.Name == "__typename" {
+				out.set(f.Alias, v.Type().Name())
+				continue
+			}
+			i, ok := jsonField(v.Type(), f.Name)
+			if !ok {
+				return nil, fmt.Errorf("cannot query field %q on type %s", f.Name, v.Type().Name())
+			}
+			x, err := project(v.Field(i), f.Sel, path+"."+f.Name)
+			if err != nil {
+				return nil, err
+			}
+			out.set(f.Alias, x)
+		}
+		return out, nil
+	}
+	if len(sel) > 0 {
+		return nil, fmt.Errorf("field %q is a scalar and has no subfields", path)
+	}
+	return v.Interface(), nil
+}
+
+// gqlArgValue renders an argument as its /api/gaps query value.
+func gqlArgValue(v any, vars map[string]any) (string, bool, error) {
+	if ref, ok := v.(*gqlVar); ok {
+		val, set := vars[ref.Name]
+		if !set {
+			return "", false, fmt.Errorf("variable $%s is not defined", ref.Name)
+		}
+		v = val
+	}
+	switch x := v.(type) {
+	case nil:
+		return "", false, nil
+	case string:
+		return x, true, nil
+	case float64:
+		return num(x), true, nil
+	case bool:
+		if x {
+			return "1", true, nil
+		}
+		return "", false, nil
+	}
+	return "", false, fmt.Errorf("unsupported argument value %v", v)
+}
+
+type gqlError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+type gqlResponse struct {
+	Data   *gqlObject `json:"data"`
+	Errors []gqlError `json:"errors,omitempty"`
+}
+
+// executeGraphQL runs a parsed query. Field errors null their root field
+// and are reported with its path, as GraphQL does. Canceling ctx (the
+// request's) stops the running analysis and skips the rest.
+func executeGraphQL(ctx context.Context, query string, variables map[string]any) (gqlResponse, error) {
+	toks, err := gqlLex(query)
+	if err != nil {
+		return gqlResponse{}, err
+	}
+	p := &gqlParser{toks: toks}
+	defs, sel, err := p.document()
+	if err != nil {
+		return gqlResponse{}, err
+	}
+	if len(sel) > maxRootFields {
+		return gqlResponse{}, fmt.Errorf("query has %d root fields; at most %d are allowed", len(sel), maxRootFields)
+	}
+	vars := map[string]any{}
+	for _, d := range defs {
+		if v, ok := variables[d.Name]; ok {
+			vars[d.Name] = v
+		} else if d.HasDef {
+			vars[d.Name] = d.Default
+		}
+	}
+
+	out := gqlResponse{Data: &gqlObject{}}
+	cache := map[string]gapcore.AnalyzeResponse{} // same arguments, one analysis
+	for _, f := range sel {
+		if f.Name == "__typename" {
+			out.Data.set(f.Alias, "Query")
+			continue
+		}
+		if f.Name != "analysis" {
+			return gqlResponse{}, fmt.Errorf("cannot query field %q on type Query (only analysis)", f.Name)
+		}
+		q := url.Values{}
+		for name, v := range f.Args {
+			key, ok := graphqlArgs[name]
+			if !ok {
+				return gqlResponse{}, fmt.Errorf("unknown argument %q on analysis", name)
+			}
+			s, set, err := gqlArgValue(v, vars)
+			if err != nil {
+				return gqlResponse{}, err
+			}
+			if set {
+				q.Set(key, s)
+			}
+		}
+		fieldErr := func(err error) {
+			out.Data.set(f.Alias, nil)
+			out.Errors = append(out.Errors, gqlError{Message: err.Error(), Path: []any{f.Alias}})
+		}
+		params, err := parseAnalyzeValues(q)
+		if err == nil && params.Ticker == "" {
+			err = errors.New("ticker required")
+		}
+		if err != nil {
+			fieldErr(err)
+			continue
+		}
+		resp, ok := cache[q.Encode()]
+		if !ok {
+			resp, err = analyzeProgress(ctx, params, nil)
+			if ctx.Err() != nil {
+				return gqlResponse{}, ctx.Err() // cut short: the client is gone
+			}
+			if err != nil {
+				fieldErr(err)
+				continue
+			}
+			cache[q.Encode()] = resp
+		}
+		x, err := project(reflect.ValueOf(resp), f.Sel, f.Alias)
+		if err != nil {
+			return gqlResponse{}, err
+		}
+		out.Data.set(f.Alias, x)
+	}
+	return out, nil
+}
+
+// handleGraphQL takes {"query", "variables"} as a JSON POST, or query= and
+// variables= (JSON) on a GET.
+func handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query     string         `json:"query"`
+		Variables map[string]any `json:"variables"`
+	}
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		if v := r.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeGraphQLError(w, "variables: "+err.Error())
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			writeGraphQLError(w, "request body: "+err.Error())
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		writeGraphQLError(w, "query required")
+		return
+	}
+	out, err := executeGraphQL(r.Context(), req.Query, req.Variables)
+	if err != nil {
+		writeGraphQLError(w, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// writeGraphQLError answers a request that could not be executed at all.
+func writeGraphQLError(w http.ResponseWriter, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]any{"errors": []gqlError{{Message: msg}}})
+}
@@ -0,0 +1,199 @@
+// grid.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Stop/Target Grid =========================
+
+// The grid re-runs the backtester over every stop × target pair and reports
+// expectancy per cell. A single best cell is usually noise; the plateau pick
+// favors cells whose neighbors also do well.
+
+var (
+	defaultGridPct = []float64{0.5, 1, 1.5, 2, 3}
+	defaultGridATR = []float64{0.25, 0.5, 0.75, 1, 1.5}
+)
+
+// Per-axis limit; each cell is a full replay of every session.
+const maxGridSteps = 12
+
+// GridPick is one cell of the grid. Neighborhood is the mean expectancy of
+// the cell and its (up to 8) adjacent cells.
+type GridPick struct {
+	Stop         float64 `json:"stop"`
+	Target       float64 `json:"target"`
+	Expectancy   float64 `json:"expectancy"`
+	Neighborhood float64 `json:"neighborhood"`
+}
+
+// GridResult holds [stop][target] matrices for one side.
+type GridResult struct {
+	Side         string      `json:"side"`
+	Trades       int         `json:"trades"`
+	Expectancy   [][]float64 `json:"expectancy"` // % per trade
+	WinRate      [][]float64 `json:"win_rate"`   // %
+	ProfitFactor [][]float64 `json:"profit_factor"`
+	MaxDrawdown  [][]float64 `json:"max_drawdown"` // % points, cumulative curve
+	Best         GridPick    `json:"best"`         // highest single-cell expectancy
+	Plateau      GridPick    `json:"plateau"`      // highest neighborhood expectancy
+}
+
+type GridResponse struct {
+	SchemaVersion schemaVersion          `json:"schema_version"`
+	Success       bool                   `json:"success"`
+	Error         string                 `json:"error,omitempty"`
+	Ticker        string                 `json:"ticker"`
+	Years         int                    `json:"years"`
+	MinGap        float64                `json:"min_gap"`
+	Config        gapcore.BacktestConfig `json:"config"` // stop/target levels come from the grid
+	Stops         []float64              `json:"stops"`
+	Targets       []float64              `json:"targets"`
+	Skipped       int                    `json:"skipped"`
+	Results       []GridResult           `json:"results"`
+}
+
+// parseGridAxis reads a comma-separated list of distances, or the default
+// grid for units when empty. 0 means no stop (or no target).
+func parseGridAxis(q url.Values, key, units string) ([]float64, error) {
+	v := strings.TrimSpace(q.Get(key))
+	if v == "" {
+		if units == "atr" {
+			return defaultGridATR, nil
+		}
+		return defaultGridPct, nil
+	}
+	parts := strings.Split(v, ",")
+	if len(parts) > maxGridSteps {
+		return nil, fmt.Errorf("%s: at most %d values", key, maxGridSteps)
+	}
+	out := make([]float64, 0, len(parts))
+	for _, s := range parts {
+		x, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || !gapcore.ValidDistance(x, units) {
+			return nil, gapcore.DistanceError(key, units)
+		}
+		out = append(out, x)
+	}
+	return out, nil
+}
+
+// runGrid backtests every stop × target pair for each configured side.
+func runGrid(points []gapcore.GapPoint, minutesByDate map[string][]gapcore.Bar, atr map[string]float64, cfg gapcore.BacktestConfig, stops, targets []float64) ([]GridResult, int) {
+	sessions, skipped := gapcore.SessionBars(points, minutesByDate, atr)
+	var results []GridResult
+	for _, side := range cfg.Sides {
+		res := GridResult{Side: side}
+		for _, st := range stops {
+			var exp, win, pf, dd []float64
+			for _, tg := range targets {
+				c := cfg
+				c.Stop, c.Target = st, tg
+				trades, _ := gapcore.SimulateSide(points, sessions, c, side)
+				ts := gapcore.TradeStatsOf(gapcore.ReturnsOf(trades))
+				res.Trades = ts.Trades
+				exp = append(exp, ts.Expectancy)
+				win = append(win, ts.WinRate)
+				pf = append(pf, ts.ProfitFactor)
+				dd = append(dd, gapcore.Round3(gapcore.MaxDrawdown(gapcore.Cumulative(gapcore.ReturnsOf(trades)))))
+			}
+			res.Expectancy = append(res.Expectancy, exp)
+			res.WinRate = append(res.WinRate, win)
+			res.ProfitFactor = append(res.ProfitFactor, pf)
+			res.MaxDrawdown = append(res.MaxDrawdown, dd)
+		}
+		res.Best, res.Plateau = gridPicks(res.Expectancy, stops, targets)
+		results = append(results, res)
+	}
+	return results, skipped
+}
+
+// gridPicks returns the best single cell and the best 3×3 neighborhood.
+func gridPicks(exp [][]float64, stops, targets []float64) (GridPick, GridPick) {
+	var best, plateau GridPick
+	first := true
+	for i := range exp {
+		for j := range exp[i] {
+			var sum float64
+			var n int
+			for di := -1; di <= 1; di++ {
+				for dj := -1; dj <= 1; dj++ {
+					a, b := i+di, j+dj
+					if a >= 0 && a < len(exp) && b >= 0 && b < len(exp[a]) {
+						sum += exp[a][b]
+						n++
+					}
+				}
+			}
+			cell := GridPick{Stop: stops[i], Target: targets[j], Expectancy: exp[i][j], Neighborhood: gapcore.Round3(sum / float64(n))}
+			if first || cell.Expectancy > best.Expectancy {
+				best = cell
+			}
+			if first || cell.Neighborhood > plateau.Neighborhood {
+				plateau = cell
+			}
+			first = false
+		}
+	}
+	return best, plateau
+}
+
+func handleBacktestGrid(w http.ResponseWriter, r *http.Request) {
+	if err := applyPreset(r); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	params, err := parseAnalyzeParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	cfg, err := gapcore.ParseBacktestConfig(q, htbTickers)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cfg.Costs = params.Costs
+	stops, err := parseGridAxis(q, "stops", cfg.Units)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	targets, err := parseGridAxis(q, "targets", cfg.Units)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	points, minutesByDate, atr, analysisErr, err := gapcore.BacktestInputs(context.Background(), provider, params)
+	if err != nil {
+		writeFetchError(w, err)
+		return
+	}
+	cfg.Stop, cfg.Target = 0, 0
+	cfg.StopPremarket, cfg.TargetFill = false, false
+	out := GridResponse{
+		Success: analysisErr == "",
+		Error:   analysisErr,
+		Ticker:  params.Ticker,
+		Years:   params.Years,
+		MinGap:  params.MinGap,
+		Config:  cfg,
+		Stops:   stops,
+		Targets: targets,
+	}
+	if out.Success {
+		out.Results, out.Skipped = runGrid(points, minutesByDate, atr, cfg, stops, targets)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,375 @@
+// grpc.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= gRPC API =========================
+
+// GapAnalyzer.Analyze (proto/gapanalyzer.proto) is served on the REST port:
+// the server speaks HTTP/2 cleartext next to HTTP/1.1, and gRPC is just a
+// POST to /gapanalyzer.v1.GapAnalyzer/Analyze with length-prefixed protobuf
+// messages and the status in trailers. The handful of messages are encoded
+// by pbWriter and decoded by pbReader, so no codegen is needed server-side.
+
+const grpcAnalyzePath = "/gapanalyzer.v1.GapAnalyzer/Analyze"
+
+// gRPC status codes used here.
+const (
+	grpcOK               = 0
+	grpcCanceled         = 1
+	grpcInvalidArgument  = 3
+	grpcDeadlineExceeded = 4
+	grpcNotFound         = 5
+	grpcUnimplemented    = 12
+	grpcUnavailable      = 14
+)
+
+// Largest request message accepted.
+const maxGRPCRequest = 1 << 16
+
+// Protobuf wire types.
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+// pbWriter emits proto3 fields; zero values are omitted, as proto3 does.
+type pbWriter struct {
+	buf bytes.Buffer
+}
+
+func (p *pbWriter) varint(v uint64) {
+	for v >= 0x80 {
+		p.buf.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	p.buf.WriteByte(byte(v))
+}
+
+func (p *pbWriter) tag(field int, wire int) { p.varint(uint64(field)<<3 | uint64(wire)) }
+
+func (p *pbWriter) int32(field int, v int) {
+	if v != 0 {
+		p.tag(field, pbVarint)
+		p.varint(uint64(int64(v))) // negatives take ten bytes, per the spec
+	}
+}
+
+func (p *pbWriter) bool(field int, v bool) {
+	if v {
+		p.tag(field, pbVarint)
+		p.varint(1)
+	}
+}
+
+func (p *pbWriter) double(field int, v float64) {
+	if v != 0 {
+		p.tag(field, pbFixed64)
+		p.buf.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(v)))
+	}
+}
+
+func (p *pbWriter) bytes(field int, b []byte) {
+	p.tag(field, pbBytes)
+	p.varint(uint64(len(b)))
+	p.buf.Write(b)
+}
+
+func (p *pbWriter) string(field int, s string) {
+	if s != "" {
+		p.bytes(field, []byte(s))
+	}
+}
+
+// message writes a nested message built by fn (always, even when empty).
+func (p *pbWriter) message(field int, fn func(m *pbWriter)) {
+	var m pbWriter
+	fn(&m)
+	p.bytes(field, m.buf.Bytes())
+}
+
+// pbReader walks the fields of one message.
+type pbReader struct {
+	b []byte
+}
+
+func (r *pbReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		return 0, errors.New("bad varint")
+	}
+	r.b = r.b[n:]
+	return v, nil
+}
+
+// next returns the next field number, wire type, and its varint or payload.
+func (r *pbReader) next() (int, int, uint64, []byte, error) {
+	key, err := r.varint()
+	if err != nil {
+		return 0, 0, 0, nil, err
+	}
+	field, wire := int(key>>3), int(key&7)
+	switch wire {
+	case pbVarint:
+		v, err := r.varint()
+		return field, wire, v, nil, err
+	case pbFixed64, pbFixed32:
+		n := 8
+		if wire == pbFixed32 {
+			n = 4
+		}
+		if len(r.b) < n {
+			return 0, 0, 0, nil, errors.New("truncated field")
+		}
+		b := r.b[:n]
+		r.b = r.b[n:]
+		return field, wire, 0, b, nil
+	case pbBytes:
+		n, err := r.varint()
+		if err != nil || n > uint64(len(r.b)) {
+			return 0, 0, 0, nil, errors.New("truncated field")
+		}
+		b := r.b[:n]
+		r.b = r.b[n:]
+		return field, wire, 0, b, nil
+	}
+	return 0, 0, 0, nil, fmt.Errorf("unsupported wire type %d", wire)
+}
+
+// decodeAnalyzeRequest turns an AnalyzeRequest into the query parameters
+// of /api/gaps, so it is validated exactly like the REST call.
+func decodeAnalyzeRequest(b []byte) (url.Values, error) {
+	q := url.Values{}
+	r := pbReader{b}
+	for len(r.b) > 0 {
+		field, wire, v, payload, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case field == 1 && wire == pbBytes:
+			q.Set("ticker", string(payload))
+		case field == 2 && wire == pbVarint:
+			q.Set("years", strconv.Itoa(int(int32(v))))
+		case field == 3 && wire == pbFixed64:
+			q.Set("minGap", num(math.Float64frombits(binary.LittleEndian.Uint64(payload))))
+		}
+	}
+	return q, nil
+}
+
+func pbInterval(m *pbWriter, iv gapcore.Interval) {
+	m.double(1, iv.Low)
+	m.double(2, iv.High)
+}
+
+func encodeAnalyzeResponse(resp gapcore.AnalyzeResponse) []byte {
+	var p pbWriter
+	p.bool(1, resp.Success)
+	p.string(2, resp.Error)
+	p.string(3, resp.Ticker)
+	p.int32(4, resp.Years)
+	p.double(5, resp.MinGap)
+	p.message(6, func(m *pbWriter) {
+		s := resp.Summary
+		m.int32(1, s.Sessions)
+		m.double(2, s.ContinuationRate)
+		m.message(3, func(c *pbWriter) { pbInterval(c, s.ContinuationCI) })
+		m.int32(4, s.GapUps)
+		m.int32(5, s.GapDowns)
+		m.double(6, s.MeanGap)
+		m.double(7, s.MaxGapUp)
+		m.double(8, s.MaxGapDown)
+		m.double(9, s.FadeAvg)
+		m.double(10, s.FollowAvg)
+		m.string(11, s.BestStrategy)
+		m.double(12, s.ExpectedReturn)
+	})
+	for _, b := range resp.Bins {
+		p.message(7, func(m *pbWriter) { pbBin(m, b) })
+	}
+	p.message(8, func(m *pbWriter) {
+		s := resp.Summary15
+		m.int32(1, s.Sessions)
+		m.double(2, s.ContinuationRate)
+		m.message(3, func(c *pbWriter) { pbInterval(c, s.ContinuationCI) })
+		m.double(4, s.FadeAvg)
+		m.double(5, s.FollowAvg)
+		m.string(6, s.BestStrategy)
+		m.double(7, s.ExpectedReturn)
+		m.double(8, s.GapFillBy0945Rate)
+	})
+	for _, b := range resp.Bins15 {
+		p.message(9, func(m *pbWriter) {
+			pbBin(m, gapcore.BinStat{
+				Label: b.Label, Count: b.Count, ContinuationRate: b.ContinuationRate, ContinuationCI: b.ContinuationCI,
+				ContinuationShrunk: b.ContinuationShrunk, GapFillRate: b.GapFillBy0945Rate, FadeAvg: b.FadeAvg, FollowAvg: b.FollowAvg,
+				Recommendation: b.Recommendation, RecommendationWhy: b.RecommendationWhy,
+			})
+		})
+	}
+	for _, d := range resp.Data {
+		p.message(10, func(m *pbWriter) {
+			m.string(1, d.Date)
+			m.double(2, d.GapPct)
+			m.double(3, d.DailyReturnPct)
+			m.int32(4, d.Direction)
+			m.bool(5, d.SameDir == 1)
+			m.bool(6, d.Filled == 1)
+			m.string(7, d.Bin)
+			m.double(8, d.Open)
+			m.double(9, d.Close)
+			m.double(10, d.PrevClose)
+			m.string(11, d.DayOfWeek)
+			m.double(12, d.Ret15mPct)
+			m.bool(13, d.FilledBy0945 == 1)
+		})
+	}
+	return p.buf.Bytes()
+}
+
+// pbBin writes a BinStat message; 0–15m bins pass their fill-by-09:45 rate
+// as GapFillRate.
+func pbBin(m *pbWriter, b gapcore.BinStat) {
+	m.string(1, b.Label)
+	m.int32(2, b.Count)
+	m.double(3, b.ContinuationRate)
+	m.message(4, func(c *pbWriter) { pbInterval(c, b.ContinuationCI) })
+	m.double(5, b.ContinuationShrunk)
+	m.double(6, b.GapFillRate)
+	m.double(7, b.FadeAvg)
+	m.double(8, b.FollowAvg)
+	m.string(9, b.Recommendation)
+	m.string(10, b.RecommendationWhy)
+}
+
+// grpcTimeout parses a grpc-timeout header: up to 8 digits and a unit,
+// H, M, S, m (ms), u (µs) or n (ns). ok is false when it is absent or
+// malformed.
+func grpcTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	unit, ok := map[byte]time.Duration{
+		'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond,
+	}[v[len(v)-1]]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// readGRPCMessage reads the single length-prefixed request message.
+func readGRPCMessage(body io.Reader) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(body, hdr[:]); err != nil {
+		return nil, fmt.Errorf("reading message header: %w", err)
+	}
+	if hdr[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > maxGRPCRequest {
+		return nil, fmt.Errorf("message of %d bytes is too large", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(body, b); err != nil {
+		return nil, fmt.Errorf("reading message: %w", err)
+	}
+	return b, nil
+}
+
+// writeGRPC sends msg (nil for none) followed by the status trailers.
+func writeGRPC(w http.ResponseWriter, msg []byte, code int, message string) {
+	w.Header().Set("Content-Type", "application/grpc+proto")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	if msg != nil {
+		var hdr [5]byte
+		binary.BigEndian.PutUint32(hdr[1:], uint32(len(msg)))
+		w.Write(hdr[:])
+		w.Write(msg)
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", url.PathEscape(message))
+	}
+}
+
+func handleGRPCAnalyze(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		writeError(w, http.StatusUnsupportedMediaType, "gRPC endpoint: use a gRPC client over HTTP/2")
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/grpc" && ct != "application/grpc+proto" {
+		writeGRPC(w, nil, grpcUnimplemented, "only protobuf messages are supported")
+		return
+	}
+	// A request that cannot be read or decoded is the client's fault.
+	b, err := readGRPCMessage(r.Body)
+	if err != nil {
+		writeGRPC(w, nil, grpcInvalidArgument, err.Error())
+		return
+	}
+	q, err := decodeAnalyzeRequest(b)
+	if err != nil {
+		writeGRPC(w, nil, grpcInvalidArgument, "decoding AnalyzeRequest: "+err.Error())
+		return
+	}
+	params, err := parseAnalyzeValues(q)
+	if err == nil && params.Ticker == "" {
+		err = errors.New("ticker required")
+	}
+	if err != nil {
+		writeGRPC(w, nil, grpcInvalidArgument, err.Error())
+		return
+	}
+	// The client's deadline and cancellation stop the minute fetch, so a
+	// caller that gave up does not keep spending quota.
+	ctx := r.Context()
+	if d, ok := grpcTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	resp, err := analyzeProgress(ctx, params, nil)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		writeGRPC(w, nil, grpcDeadlineExceeded, "deadline exceeded before the analysis finished")
+		return
+	}
+	if ctx.Err() != nil {
+		writeGRPC(w, nil, grpcCanceled, "request canceled")
+		return
+	}
+	if errors.Is(err, gapcore.ErrUnknownTicker) {
+		writeGRPC(w, nil, grpcNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeGRPC(w, nil, grpcUnavailable, err.Error())
+		return
+	}
+	writeGRPC(w, encodeAnalyzeResponse(resp), grpcOK, "")
+}
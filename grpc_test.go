@@ -0,0 +1,165 @@
+// grpc_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+func TestAnalyzeRequestRoundTrip(t *testing.T) {
+	var p pbWriter
+	p.string(1, "SPY")
+	p.int32(2, 4)
+	p.double(3, 0.5)
+	p.string(9, "ignored") // unknown fields are skipped
+	q, err := decodeAnalyzeRequest(p.buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"ticker": "SPY", "years": "4", "minGap": "0.5"} {
+		if got := q.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+
+	// Zero values are left out, so the REST defaults apply.
+	q, err = decodeAnalyzeRequest(nil)
+	if err != nil || len(q) != 0 {
+		t.Errorf("empty request: %v, %v", q, err)
+	}
+}
+
+func TestDecodeAnalyzeRequestMalformed(t *testing.T) {
+	for name, b := range map[string][]byte{
+		"truncated string": {0x0A, 0x05, 'S', 'P'},
+		"truncated double": {0x19, 0x00, 0x00},
+		"bad varint":       {0x10, 0xFF},
+		"bad wire type":    {0x0F},
+	} {
+		if _, err := decodeAnalyzeRequest(b); err == nil {
+			t.Errorf("%s: decoded without error", name)
+		}
+	}
+}
+
+func TestEncodeAnalyzeResponse(t *testing.T) {
+	resp := gapcore.AnalyzeResponse{
+		Success: true,
+		Ticker:  "SPY",
+		Years:   3,
+		MinGap:  0.3,
+		Data: []gapcore.GapPoint{
+			{Date: "2024-01-02", GapPct: 0.8, Direction: 1},
+			{Date: "2024-01-03", GapPct: -1.2, Direction: -1},
+		},
+	}
+	r := pbReader{encodeAnalyzeResponse(resp)}
+	var points int
+	for len(r.b) > 0 {
+		field, wire, v, payload, err := r.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch field {
+		case 1:
+			if wire != pbVarint || v != 1 {
+				t.Errorf("success: wire %d value %d", wire, v)
+			}
+		case 3:
+			if string(payload) != "SPY" {
+				t.Errorf("ticker = %q", payload)
+			}
+		case 4:
+			if v != 3 {
+				t.Errorf("years = %d", v)
+			}
+		case 5:
+			if got := math.Float64frombits(binary.LittleEndian.Uint64(payload)); got != 0.3 {
+				t.Errorf("min_gap = %v", got)
+			}
+		case 10:
+			points++
+		}
+	}
+	if points != len(resp.Data) {
+		t.Errorf("%d data points, want %d", points, len(resp.Data))
+	}
+}
+
+func TestGRPCAnalyzeMalformedRequest(t *testing.T) {
+	msg := []byte{0x0A, 0x05, 'S', 'P'} // ticker claims 5 bytes, has 2
+	body := append([]byte{0, 0, 0, 0, byte(len(msg))}, msg...)
+	req := httptest.NewRequest(http.MethodPost, grpcAnalyzePath, bytes.NewReader(body))
+	req.ProtoMajor = 2
+	req.Header.Set("Content-Type", "application/grpc")
+	rec := httptest.NewRecorder()
+	handleGRPCAnalyze(rec, req)
+	if got := rec.Result().Trailer.Get("Grpc-Status"); got != "3" {
+		t.Errorf("grpc-status %q, want 3 (INVALID_ARGUMENT)", got)
+	}
+}
+
+func TestGRPCTimeout(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"100m", 100 * time.Millisecond, true},
+		{"5S", 5 * time.Second, true},
+		{"2H", 2 * time.Hour, true},
+		{"30u", 30 * time.Microsecond, true},
+		{"", 0, false},
+		{"S", 0, false},
+		{"10x", 0, false},
+		{"123456789S", 0, false}, // more than 8 digits
+		{"-1S", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := grpcTimeout(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("grpcTimeout(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// stalledProvider answers nothing until the request gives up.
+type stalledProvider struct{}
+
+func (stalledProvider) Daily(ctx context.Context, _, _, _ string) ([]gapcore.Bar, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (stalledProvider) Minute(ctx context.Context, _, _ string) ([]gapcore.Bar, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestGRPCAnalyzeDeadline(t *testing.T) {
+	saved := provider.Provider
+	provider.Provider = stalledProvider{}
+	defer func() { provider.Provider = saved }()
+
+	var p pbWriter
+	p.string(1, "SPY")
+	msg := p.buf.Bytes()
+	body := append([]byte{0, 0, 0, 0, byte(len(msg))}, msg...)
+	req := httptest.NewRequest(http.MethodPost, grpcAnalyzePath, bytes.NewReader(body))
+	req.ProtoMajor = 2
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Grpc-Timeout", "20m")
+	rec := httptest.NewRecorder()
+	handleGRPCAnalyze(rec, req)
+	if got := rec.Result().Trailer.Get("Grpc-Status"); got != "4" {
+		t.Errorf("grpc-status %q, want 4 (DEADLINE_EXCEEDED)", got)
+	}
+}
@@ -0,0 +1,113 @@
+// health.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Health Probes =========================
+
+// /healthz is a liveness probe: it answers as long as the server can serve
+// requests. /readyz is a readiness probe: it checks that the Polygon key is
+// accepted, that the bar cache and the JSON stores work, and that the New
+// York timezone data and the clock are sane, and answers 503 otherwise.
+
+type HealthCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type ReadyResponse struct {
+	Status string                 `json:"status"` // ready | not_ready
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// checkPolygon fetches a week of SPY daily bars; the result is cached like
+// any other daily range, so probes reach Polygon at most once per daily
+// cache lifetime.
+func checkPolygon() HealthCheck {
+	if polygon.APIKey == "" {
+		return HealthCheck{Detail: "POLYGON_API_KEY not set"}
+	}
+	now := time.Now()
+	bars, err := provider.Daily(context.Background(), "SPY", now.AddDate(0, 0, -7).Format("2006-01-02"), now.Format("2006-01-02"))
+	if err != nil {
+		return HealthCheck{Detail: err.Error()}
+	}
+	return HealthCheck{OK: true, Detail: fmt.Sprintf("%d SPY daily bars", len(bars))}
+}
+
+// checkStores round-trips a cache entry and loads the JSON stores.
+func checkStores() HealthCheck {
+	detail := fmt.Sprintf("%d daily ranges, %d minute sessions cached", provider.DailyBars.Len(), provider.MinuteBars.Len())
+	probe := []gapcore.Bar{{T: 1}}
+	provider.MinuteBars.Put("readyz|probe", probe, time.Second)
+	if got, ok := provider.MinuteBars.Get("readyz|probe"); !ok || len(got) != 1 {
+		return HealthCheck{Detail: "bar cache round trip failed"}
+	}
+	presetsMu.Lock()
+	_, err := loadPresets()
+	presetsMu.Unlock()
+	if err == nil {
+		watchlistsMu.Lock()
+		_, err = loadWatchlists()
+		watchlistsMu.Unlock()
+	}
+	if err == nil {
+		alertsMu.Lock()
+		_, err = loadAlerts()
+		alertsMu.Unlock()
+	}
+	if err != nil {
+		return HealthCheck{Detail: err.Error()}
+	}
+	return HealthCheck{OK: true, Detail: detail}
+}
+
+// checkClock verifies the timezone database and that the clock is not
+// obviously wrong (session dates depend on both).
+func checkClock() HealthCheck {
+	if gapcore.NewYork == nil {
+		return HealthCheck{Detail: "America/New_York timezone data not found"}
+	}
+	now := time.Now()
+	_, off := now.In(gapcore.NewYork).Zone()
+	if off != -4*3600 && off != -5*3600 {
+		return HealthCheck{Detail: fmt.Sprintf("unexpected New York UTC offset %ds", off)}
+	}
+	if now.Year() < 2020 {
+		return HealthCheck{Detail: "system clock reads " + now.UTC().Format(time.RFC3339)}
+	}
+	return HealthCheck{OK: true, Detail: now.In(gapcore.NewYork).Format(time.RFC3339)}
+}
+
+func handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+func handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	out := ReadyResponse{
+		Status: "ready",
+		Checks: map[string]HealthCheck{
+			"polygon": checkPolygon(),
+			"stores":  checkStores(),
+			"clock":   checkClock(),
+		},
+	}
+	code := http.StatusOK
+	for _, c := range out.Checks {
+		if !c.OK {
+			out.Status, code = "not_ready", http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(out)
+}
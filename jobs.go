@@ -0,0 +1,378 @@
+// jobs.go
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Async Jobs =========================
+
+// A multi-hundred-date minute fetch can hold an HTTP request for minutes.
+// /api/jobs takes such work — a gap analysis or a scan — off the request:
+// a submit returns the job's ID at once, the job waits for one of
+// jobWorkers, and its status, progress, and result are read back by ID. A
+// canceled job stops its minute fetch between sessions. /api/gaps?async=1
+// is a shortcut that submits a gaps job. A job submitted with callback=URL
+// is POSTed to that URL when it finishes — its status and result, or with
+// callbackLink=1 only a link to the result — through the notification
+// sinks' delivery (notify.go): signed with callbackSecret, retried, and
+// logged, so other systems can chain off a long analysis.
+
+const (
+	// Jobs running at once; the rest wait in order of submission.
+	jobWorkers = 2
+
+	// Finished jobs stay readable this long.
+	jobRetention = time.Hour
+
+	// Most jobs kept (queued, running, and finished).
+	maxJobs = 200
+)
+
+// Job is the status of one job as served by /api/jobs.
+type Job struct {
+	ID        string                 `json:"id"`
+	Kind      string                 `json:"kind"` // gaps | scan
+	Query     string                 `json:"query"`
+	Status    string                 `json:"status"` // queued | running | done | failed | canceled
+	Progress  *gapcore.ProgressEvent `json:"progress,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+	Submitted string                 `json:"submitted"` // RFC3339
+	Started   string                 `json:"started,omitempty"`
+	Finished  string                 `json:"finished,omitempty"`
+	Result    string                 `json:"result"` // result URL
+	Events    string                 `json:"events"` // SSE progress URL
+
+	Callback         string    `json:"callback,omitempty"`          // URL POSTed on completion
+	CallbackDelivery *Delivery `json:"callback_delivery,omitempty"` // once the callback is sent
+}
+
+type job struct {
+	mu       sync.Mutex
+	info     Job
+	events   []gapcore.ProgressEvent
+	result   any   // *AnalyzeResponse or *ScanResponse once done
+	err      error // once failed
+	finished time.Time
+	cancel   context.CancelFunc
+	notify   chan struct{} // closed and replaced on every update
+	callback *jobCallback  // nil without callback=
+}
+
+// jobCallback is where a job reports its completion.
+type jobCallback struct {
+	sink NotifySink // an unsaved webhook sink
+	link bool       // send the result URL, not the result
+	base string     // scheme and host the job was submitted to
+}
+
+var (
+	jobsMu   sync.Mutex
+	jobs     = map[string]*job{}
+	jobSlots = make(chan struct{}, jobWorkers)
+
+	errJobsFull = fmt.Errorf("too many jobs (max %d), try again later", maxJobs)
+)
+
+// newRequestID returns a random 16-hex-digit ID.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (j *job) update(fn func()) {
+	j.mu.Lock()
+	fn()
+	close(j.notify)
+	j.notify = make(chan struct{})
+	j.mu.Unlock()
+}
+
+func (j *job) status() Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.info
+}
+
+func (j *job) done() bool {
+	switch j.info.Status {
+	case "done", "failed", "canceled":
+		return true
+	}
+	return false
+}
+
+// jobRunner validates a job's query and returns the function that runs it.
+func jobRunner(kind string, q url.Values) (func(ctx context.Context, progress func(gapcore.ProgressEvent)) (any, error), error) {
+	switch kind {
+	case "gaps":
+		params, err := parseAnalyzeValues(q)
+		if err == nil && params.Ticker == "" {
+			err = errors.New("ticker required")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, progress func(gapcore.ProgressEvent)) (any, error) {
+			resp, err := analyzeProgress(ctx, params, progress)
+			return &resp, err
+		}, nil
+	case "scan":
+		p, err := parseScanParams(q)
+		if err != nil {
+			return nil, err
+		}
+		return func(context.Context, func(gapcore.ProgressEvent)) (any, error) {
+			out, err := scan(p)
+			return &out, err
+		}, nil
+	}
+	return nil, fmt.Errorf("kind must be gaps or scan")
+}
+
+// parseJobCallback reads and removes a job's callback parameters:
+// callback (an http(s) URL), callbackSecret, and callbackLink=1. base is
+// the scheme and host result links start with.
+func parseJobCallback(q url.Values, base string) (*jobCallback, error) {
+	cb := q.Get("callback")
+	secret, link := q.Get("callbackSecret"), q.Get("callbackLink")
+	q.Del("callback")
+	q.Del("callbackSecret")
+	q.Del("callbackLink")
+	if cb == "" {
+		if secret != "" || link != "" {
+			return nil, errors.New("callbackSecret and callbackLink need a callback")
+		}
+		return nil, nil
+	}
+	s, err := parseSink("", url.Values{"url": {cb}, "secret": {secret}})
+	if err != nil {
+		return nil, fmt.Errorf("callback: %v", err)
+	}
+	if link != "" && link != "0" && link != "1" {
+		return nil, errors.New("callbackLink must be 0 or 1")
+	}
+	return &jobCallback{sink: s, link: link == "1", base: base}, nil
+}
+
+// submitJob validates and queues a job. Errors are bad parameters, or
+// errJobsFull.
+func submitJob(kind string, q url.Values, base string) (Job, error) {
+	q.Del("kind")
+	q.Del("async")
+	cb, err := parseJobCallback(q, base)
+	if err != nil {
+		return Job{}, err
+	}
+	run, err := jobRunner(kind, q)
+	if err != nil {
+		return Job{}, err
+	}
+
+	jobsMu.Lock()
+	for id, j := range jobs {
+		j.mu.Lock()
+		if j.done() && time.Since(j.finished) > jobRetention {
+			delete(jobs, id)
+		}
+		j.mu.Unlock()
+	}
+	if len(jobs) >= maxJobs {
+		jobsMu.Unlock()
+		return Job{}, errJobsFull
+	}
+	id := newRequestID()
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		info: Job{
+			ID:        id,
+			Kind:      kind,
+			Query:     q.Encode(),
+			Status:    "queued",
+			Submitted: time.Now().UTC().Format(time.RFC3339),
+			Result:    apiPrefix + "/jobs/result?id=" + id,
+			Events:    apiPrefix + "/gaps/events?id=" + id,
+		},
+		cancel:   cancel,
+		notify:   make(chan struct{}),
+		callback: cb,
+	}
+	if cb != nil {
+		cb.sink.Name = "job:" + id
+		j.info.Callback = cb.sink.URL
+	}
+	jobs[id] = j
+	jobsMu.Unlock()
+	info := j.info
+
+	go func() {
+		defer cancel()
+		select {
+		case jobSlots <- struct{}{}:
+			defer func() { <-jobSlots }()
+		case <-ctx.Done():
+		}
+		if ctx.Err() == nil {
+			j.update(func() {
+				j.info.Status, j.info.Started = "running", time.Now().UTC().Format(time.RFC3339)
+			})
+		}
+		var result any
+		var err error
+		if ctx.Err() == nil {
+			result, err = run(ctx, func(ev gapcore.ProgressEvent) {
+				j.update(func() {
+					j.events = append(j.events, ev)
+					j.info.Progress = &ev
+				})
+			})
+		}
+		j.update(func() {
+			switch {
+			case ctx.Err() != nil:
+				j.info.Status = "canceled"
+			case err != nil:
+				j.info.Status, j.info.Error, j.err = "failed", err.Error(), err
+			default:
+				j.info.Status, j.result = "done", result
+			}
+			j.finished = time.Now()
+			j.info.Finished = j.finished.UTC().Format(time.RFC3339)
+		})
+		if cb != nil {
+			j.sendCallback()
+		}
+	}()
+	return info, nil
+}
+
+// sendCallback POSTs the finished job to its callback, retrying like a
+// notification, and records the delivery on the job.
+func (j *job) sendCallback() {
+	j.mu.Lock()
+	info, result, cb := j.info, j.result, j.callback
+	j.mu.Unlock()
+	n := notification{
+		Event:     "job",
+		Sent:      time.Now().UTC().Format(time.RFC3339),
+		Job:       &info,
+		ResultURL: cb.base + info.Result,
+	}
+	if !cb.link {
+		n.Result = result
+	}
+	d := deliver(cb.sink, n, notifyRetries)
+	j.update(func() { j.info.CallbackDelivery = &d })
+}
+
+func lookupJob(id string) *job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	return jobs[id]
+}
+
+func writeJobSubmit(w http.ResponseWriter, r *http.Request, kind string, q url.Values) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	info, err := submitJob(kind, q, scheme+"://"+r.Host)
+	if err != nil {
+		code := http.StatusBadRequest
+		if err == errJobsFull {
+			code = http.StatusServiceUnavailable
+		}
+		writeError(w, code, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(info)
+}
+
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	id := q.Get("id")
+	var out any
+	switch r.Method {
+	case http.MethodGet:
+		if id == "" {
+			jobsMu.Lock()
+			list := make([]Job, 0, len(jobs))
+			for _, j := range jobs {
+				list = append(list, j.status())
+			}
+			jobsMu.Unlock()
+			sort.Slice(list, func(a, b int) bool { return list[a].Submitted > list[b].Submitted })
+			out = list
+			break
+		}
+		j := lookupJob(id)
+		if j == nil {
+			writeError(w, http.StatusNotFound, "unknown or expired job id")
+			return
+		}
+		out = j.status()
+	case http.MethodPost:
+		writeJobSubmit(w, r, q.Get("kind"), q)
+		return
+	case http.MethodDelete:
+		// Cancels a queued or running job; deletes a finished one.
+		j := lookupJob(id)
+		if j == nil {
+			writeError(w, http.StatusNotFound, "unknown or expired job id")
+			return
+		}
+		j.mu.Lock()
+		finished := j.done()
+		j.mu.Unlock()
+		if finished {
+			jobsMu.Lock()
+			delete(jobs, id)
+			jobsMu.Unlock()
+			out = map[string]string{"deleted": id}
+		} else {
+			j.cancel()
+			out = map[string]string{"canceled": id}
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func handleJobResult(w http.ResponseWriter, r *http.Request) {
+	j := lookupJob(r.URL.Query().Get("id"))
+	if j == nil {
+		writeError(w, http.StatusNotFound, "unknown or expired job id")
+		return
+	}
+	j.mu.Lock()
+	info, result, err := j.info, j.result, j.err
+	j.mu.Unlock()
+	switch info.Status {
+	case "done":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	case "failed":
+		writeFetchError(w, err)
+	default:
+		writeError(w, http.StatusConflict, "job is "+info.Status)
+	}
+}
@@ -0,0 +1,304 @@
+// model.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Continuation Model =========================
+
+// A small logistic regression of daily continuation on features that are
+// known at the open: gap size and direction, weekday, and the prior session's
+// return and relative volume. It is fitted in-sample and meant as a
+// descriptive tool, not a forecast.
+
+type ModelCoef struct {
+	Feature   string  `json:"feature"`
+	Coef      float64 `json:"coef"`       // log-odds per 1 sd (continuous) or vs Mon (weekday)
+	OddsRatio float64 `json:"odds_ratio"` // exp(coef)
+}
+
+type ModelPrediction struct {
+	Date        string  `json:"date"`
+	Probability float64 `json:"probability"` // % chance of continuation
+	Actual      int     `json:"actual"`      // 1 continuation
+}
+
+type CalibrationBin struct {
+	Label         string  `json:"label"` // predicted-probability range
+	Count         int     `json:"count"`
+	MeanPredicted float64 `json:"mean_predicted"` // %
+	Observed      float64 `json:"observed"`       // % continuation
+}
+
+type ModelResponse struct {
+	SchemaVersion schemaVersion `json:"schema_version"`
+	Success       bool          `json:"success"`
+	Error         string        `json:"error,omitempty"`
+	Ticker        string        `json:"ticker"`
+	Years         int           `json:"years"`
+	MinGap        float64       `json:"min_gap"`
+
+	Sessions     int               `json:"sessions"`
+	BaseRate     float64           `json:"base_rate"` // % continuation in sample
+	LogLoss      float64           `json:"log_loss"`
+	Accuracy     float64           `json:"accuracy"` // % correct at p = 0.5
+	Coefficients []ModelCoef       `json:"coefficients"`
+	Calibration  []CalibrationBin  `json:"calibration"`
+	Predictions  []ModelPrediction `json:"predictions"`
+}
+
+// Ridge penalty on non-intercept coefficients; keeps the fit finite when a
+// weekday dummy perfectly separates a tiny sample.
+const modelRidge = 1e-2
+
+var modelFeatures = []string{"intercept", "abs_gap", "gap_up", "prev_return_in_gap_dir", "prev_rvol", "Tue", "Wed", "Thu", "Fri"}
+
+// modelDesign builds the standardized design matrix for the gap sessions.
+func modelDesign(points []gapcore.GapPoint) [][]float64 {
+	n := len(points)
+	absGap := make([]float64, n)
+	prevRet := make([]float64, n)
+	rvol := make([]float64, n)
+	var rvolKnown []float64
+	for i, p := range points {
+		absGap[i] = math.Abs(p.GapPct)
+		prevRet[i] = float64(p.Direction) * p.PrevReturnPct
+		rvol[i] = p.PrevRVOL
+		if p.PrevRVOL > 0 {
+			rvolKnown = append(rvolKnown, p.PrevRVOL)
+		}
+	}
+	// Sessions too early in the window for RVOL get the sample mean.
+	rvolMean, _ := gapcore.MeanStd(rvolKnown)
+	for i := range rvol {
+		if rvol[i] == 0 {
+			rvol[i] = rvolMean
+		}
+	}
+	standardize(absGap)
+	standardize(prevRet)
+	standardize(rvol)
+
+	X := make([][]float64, n)
+	for i, p := range points {
+		up := 0.0
+		if p.Direction == 1 {
+			up = 1
+		}
+		row := []float64{1, absGap[i], up, prevRet[i], rvol[i], 0, 0, 0, 0}
+		for j, d := range gapcore.Weekdays[1:] {
+			if p.DayOfWeek == d {
+				row[5+j] = 1
+			}
+		}
+		X[i] = row
+	}
+	return X
+}
+
+func standardize(xs []float64) {
+	m, sd := gapcore.MeanStd(xs)
+	for i := range xs {
+		if sd > 0 {
+			xs[i] = (xs[i] - m) / sd
+		} else {
+			xs[i] = 0
+		}
+	}
+}
+
+// fitLogistic fits coefficients by Newton-Raphson (IRLS) with a small ridge.
+func fitLogistic(X [][]float64, y []float64) []float64 {
+	k := len(X[0])
+	beta := make([]float64, k)
+	for iter := 0; iter < 50; iter++ {
+		grad := make([]float64, k)
+		H := make([][]float64, k)
+		for a := range H {
+			H[a] = make([]float64, k)
+		}
+		for i, row := range X {
+			p := logistic(dot(row, beta))
+			w := p * (1 - p)
+			for a := 0; a < k; a++ {
+				grad[a] += (y[i] - p) * row[a]
+				for b := 0; b < k; b++ {
+					H[a][b] += w * row[a] * row[b]
+				}
+			}
+		}
+		for a := 1; a < k; a++ {
+			grad[a] -= modelRidge * beta[a]
+			H[a][a] += modelRidge
+		}
+		step, ok := solve(H, grad)
+		if !ok {
+			break
+		}
+		var delta float64
+		for a := range beta {
+			beta[a] += step[a]
+			delta = math.Max(delta, math.Abs(step[a]))
+		}
+		if delta < 1e-8 {
+			break
+		}
+	}
+	return beta
+}
+
+func logistic(z float64) float64 { return 1 / (1 + math.Exp(-z)) }
+
+func dot(a, b []float64) float64 {
+	var s float64
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return s
+}
+
+// solve solves A x = b by Gaussian elimination with partial pivoting.
+func solve(A [][]float64, b []float64) ([]float64, bool) {
+	n := len(b)
+	M := make([][]float64, n)
+	for i := range A {
+		M[i] = append(append([]float64(nil), A[i]...), b[i])
+	}
+	for c := 0; c < n; c++ {
+		piv := c
+		for r := c + 1; r < n; r++ {
+			if math.Abs(M[r][c]) > math.Abs(M[piv][c]) {
+				piv = r
+			}
+		}
+		if math.Abs(M[piv][c]) < 1e-12 {
+			return nil, false
+		}
+		M[c], M[piv] = M[piv], M[c]
+		for r := c + 1; r < n; r++ {
+			f := M[r][c] / M[c][c]
+			for k := c; k <= n; k++ {
+				M[r][k] -= f * M[c][k]
+			}
+		}
+	}
+	x := make([]float64, n)
+	for r := n - 1; r >= 0; r-- {
+		s := M[r][n]
+		for k := r + 1; k < n; k++ {
+			s -= M[r][k] * x[k]
+		}
+		x[r] = s / M[r][r]
+	}
+	return x, true
+}
+
+// fitContinuationModel fits the model to the gap sessions in resp and returns
+// coefficients, per-session probabilities, and a decile calibration table.
+func fitContinuationModel(resp gapcore.AnalyzeResponse) ModelResponse {
+	out := ModelResponse{
+		Success: true,
+		Ticker:  resp.Ticker,
+		Years:   resp.Years,
+		MinGap:  resp.MinGap,
+	}
+	pts := resp.Data
+	if len(pts) < len(modelFeatures)*5 {
+		out.Success = false
+		out.Error = fmt.Sprintf("not enough gap sessions to fit a model (%d)", len(pts))
+		return out
+	}
+	X := modelDesign(pts)
+	y := make([]float64, len(pts))
+	var cont int
+	for i, p := range pts {
+		y[i] = float64(p.SameDir)
+		cont += p.SameDir
+	}
+	beta := fitLogistic(X, y)
+
+	for j, name := range modelFeatures {
+		out.Coefficients = append(out.Coefficients, ModelCoef{
+			Feature:   name,
+			Coef:      gapcore.Round3(beta[j]),
+			OddsRatio: gapcore.Round3(math.Exp(beta[j])),
+		})
+	}
+
+	const calBins = 10
+	type cal struct {
+		count     int
+		sumP, obs float64
+	}
+	cals := make([]cal, calBins)
+	var logLoss float64
+	var correct int
+	for i, p := range pts {
+		prob := logistic(dot(X[i], beta))
+		pc := math.Min(math.Max(prob, 1e-12), 1-1e-12)
+		logLoss -= y[i]*math.Log(pc) + (1-y[i])*math.Log(1-pc)
+		if (prob >= 0.5) == (y[i] == 1) {
+			correct++
+		}
+		b := int(prob * calBins)
+		if b == calBins {
+			b--
+		}
+		cals[b].count++
+		cals[b].sumP += prob
+		cals[b].obs += y[i]
+		out.Predictions = append(out.Predictions, ModelPrediction{
+			Date:        p.Date,
+			Probability: gapcore.Round1(prob * 100),
+			Actual:      p.SameDir,
+		})
+	}
+	for b, c := range cals {
+		if c.count == 0 {
+			continue
+		}
+		out.Calibration = append(out.Calibration, CalibrationBin{
+			Label:         fmt.Sprintf("%d–%d%%", b*100/calBins, (b+1)*100/calBins),
+			Count:         c.count,
+			MeanPredicted: gapcore.Round1(c.sumP / float64(c.count) * 100),
+			Observed:      gapcore.Round1(c.obs / float64(c.count) * 100),
+		})
+	}
+	out.Sessions = len(pts)
+	out.BaseRate = gapcore.Rate(cont, len(pts))
+	out.LogLoss = gapcore.Round3(logLoss / float64(len(pts)))
+	out.Accuracy = gapcore.Rate(correct, len(pts))
+	return out
+}
+
+func handleModel(w http.ResponseWriter, r *http.Request) {
+	params, err := parseAnalyzeParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	from, to := params.DateRange()
+	daily, err := provider.Daily(r.Context(), params.Ticker, from, to)
+	if err == nil && len(daily) == 0 {
+		err = gapcore.UnknownTicker(params.Ticker)
+	}
+	if err != nil {
+		writeFetchError(w, err)
+		return
+	}
+	resp, _ := gapcore.AnalyzeDaily(daily, params.MinGap, params.Years, params.Ticker)
+	var out ModelResponse
+	if !resp.Success {
+		out = ModelResponse{Ticker: params.Ticker, Years: params.Years, MinGap: params.MinGap, Error: resp.Error}
+	} else {
+		out = fitContinuationModel(resp)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
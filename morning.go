@@ -0,0 +1,164 @@
+// morning.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Morning Reports =========================
+
+// A morning report is a watchlist's premarket gappers: the tickers gapping
+// at least minGap % as the report is built, largest first, each with its
+// historical bin stats and the recommended play (the /api/v1/today row).
+// Every NYSE trading day at reportAt the server sends each notification
+// sink tied to a watchlist that list's report (see notify.go);
+// GET /api/v1/morning builds one on demand.
+
+// Report time (HH:MM New York); REPORT_AT overrides it and "off" disables
+// the reports.
+var reportAt = "09:15"
+
+// Gap a ticker needs to make a report when the sink doesn't set one.
+const defaultReportGap = 1.0
+
+type MorningReport struct {
+	SchemaVersion schemaVersion     `json:"schema_version"`
+	Watchlist     string            `json:"watchlist"`
+	Date          string            `json:"date"`  // NY session date
+	AsOf          string            `json:"as_of"` // RFC3339 in New York
+	MinGap        float64           `json:"min_gap"`
+	Gappers       []TodayResponse   `json:"gappers"` // largest |gap| first
+	Quiet         int               `json:"quiet"`   // tickers gapping less
+	Failed        map[string]string `json:"failed,omitempty"`
+}
+
+// morningReport builds watchlist's report of the tickers gapping at least
+// minGap %. The error is a failed watchlist read or snapshot fetch.
+func morningReport(ctx context.Context, watchlist string, minGap float64) (MorningReport, error) {
+	now := time.Now().In(gapcore.NewYork)
+	rep := MorningReport{
+		Watchlist: watchlist,
+		Date:      now.Format("2006-01-02"),
+		AsOf:      now.Format(time.RFC3339),
+		MinGap:    minGap,
+		Gappers:   []TodayResponse{},
+		Failed:    map[string]string{},
+	}
+	tickers, err := watchlistTickers(watchlist)
+	if err != nil {
+		return rep, err
+	}
+	rows, _, err := watchlistSetups(ctx, tickers)
+	if err != nil {
+		return rep, err
+	}
+	for _, row := range rows {
+		switch {
+		case row.Price == 0:
+			rep.Failed[row.Ticker] = row.Error
+		case math.Abs(row.GapPct) >= minGap:
+			rep.Gappers = append(rep.Gappers, row)
+		default:
+			rep.Quiet++
+		}
+	}
+	return rep, nil
+}
+
+// runMorningReports sends the sinks their reports at reportAt every
+// trading day.
+func runMorningReports() {
+	at, err := time.Parse("15:04", reportAt)
+	if err != nil {
+		log.Printf("report: REPORT_AT %q is not HH:MM; reports disabled", reportAt)
+		return
+	}
+	for {
+		time.Sleep(time.Until(nextWeekdayAt(at, time.Now())))
+		if !gapcore.TradingDay(time.Now().In(gapcore.NewYork)) {
+			continue
+		}
+		if err := sendMorningReports(context.Background()); err != nil {
+			log.Printf("report: %v", err)
+		}
+	}
+}
+
+// sendMorningReports builds each watchlist's report once and delivers it
+// to the sinks tied to that list, in the background.
+func sendMorningReports(ctx context.Context) error {
+	notifyMu.Lock()
+	st, err := loadNotify()
+	notifyMu.Unlock()
+	if err != nil {
+		return err
+	}
+	reports := map[string]*MorningReport{}
+	for _, s := range st.Sinks {
+		if s.Watchlist == "" {
+			continue
+		}
+		key := s.Watchlist + "|" + strconv.FormatFloat(s.reportGap(), 'g', -1, 64)
+		rep, ok := reports[key]
+		if !ok {
+			r, err := morningReport(ctx, s.Watchlist, s.reportGap())
+			if err != nil {
+				log.Printf("report: %s: %v", s.Watchlist, err)
+				reports[key] = nil
+				continue
+			}
+			rep = &r
+			reports[key] = rep
+		}
+		if rep == nil {
+			continue
+		}
+		go deliver(s, notification{Event: "report", Sent: time.Now().UTC().Format(time.RFC3339), Report: rep}, notifyRetries)
+	}
+	return nil
+}
+
+// handleMorning builds ?watchlist='s report of tickers gapping at least
+// ?minGap= % (default 1).
+func handleMorning(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	name := q.Get("watchlist")
+	if !presetNameRE.MatchString(name) {
+		writeError(w, http.StatusBadRequest, "watchlist required")
+		return
+	}
+	minGap := defaultReportGap
+	if v := strings.TrimSpace(q.Get("minGap")); v != "" {
+		g, err := strconv.ParseFloat(v, 64)
+		if err != nil || g < 0 || g >= 100 {
+			writeError(w, http.StatusBadRequest, "minGap must be a % between 0 and 100")
+			return
+		}
+		minGap = g
+	}
+	if _, err := watchlistTickers(name); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	rep, err := morningReport(r.Context(), name, minGap)
+	if err != nil {
+		writeFetchError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,74 @@
+// ndjson.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= NDJSON Streaming =========================
+
+// With Accept: application/x-ndjson (or format=ndjson), /api/gaps streams
+// one GapPoint per line instead of the full analysis. Each point is written
+// as soon as its minute bars arrive and its 0–15m snapshot is filled in, so
+// a client can start on a long history before the intraday pass is done.
+
+func wantsNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") ||
+		strings.EqualFold(r.URL.Query().Get("format"), "ndjson")
+}
+
+// streamGaps writes params' gap points as NDJSON. A failure after the first
+// line is reported as a final {"error": ...} line.
+func streamGaps(w http.ResponseWriter, r *http.Request, params gapcore.Params) {
+	from, to := params.DateRange()
+	daily, err := provider.Daily(r.Context(), params.Ticker, from, to)
+	if err != nil {
+		writeFetchError(w, err)
+		return
+	}
+	if len(daily) == 0 {
+		writeFetchError(w, gapcore.UnknownTicker(params.Ticker))
+		return
+	}
+	resp, points := gapcore.AnalyzeDaily(daily, params.MinGap, params.Years, params.Ticker)
+	if !resp.Success {
+		writeError(w, 502, resp.Error)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	// Points are in date order; a date the provider skipped is written,
+	// without its snapshot, once a later date arrives.
+	next := 0
+	emitThrough := func(date string) {
+		for next < len(points) && points[next].Date <= date {
+			enc.Encode(points[next])
+			next++
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	dates := make([]string, len(points))
+	for i, p := range points {
+		dates[i] = p.Date
+	}
+	err = gapcore.EachMinute(r.Context(), provider, params.Ticker, dates, func(d string, bars []gapcore.Bar) {
+		for i := next; i < len(points) && points[i].Date == d; i++ {
+			if ret15, filled, ok := gapcore.Snapshot15(points[i], bars); ok {
+				points[i].Ret15mPct, points[i].FilledBy0945 = gapcore.Round3(ret15), filled
+			}
+		}
+		emitThrough(d)
+	})
+	emitThrough("9999-12-31")
+	if err != nil {
+		enc.Encode(map[string]string{"error": "intraday fetch failed: " + err.Error()})
+	}
+}
@@ -0,0 +1,500 @@
+// notify.go
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========================= Notifications =========================
+
+// Alerts that fire are pushed to notification sinks, each a named outbound
+// destination kept in notify.json with a log of its deliveries. A sink of
+// type webhook is POSTed the alert as JSON — {"event":"alert","sent":…,
+// "alert":{…}} — signed, when it has a secret, with an HMAC-SHA256 of the
+// body in X-Gap-Analyzer-Signature ("sha256=<hex>"), so the receiver can
+// drive the rest of a morning workflow. Sinks of type slack and discord
+// post to a channel's incoming webhook URL instead, formatted as chat
+// messages (chatformat.go), and email sinks mail an HTML message to a
+// mailto: URL's addresses (email.go), and telegram sinks message a chat
+// through the Telegram bot (telegram.go). A sink tied to a watchlist gets only that
+// list's alerts, plus its morning report (morning.go). Deliveries run in
+// the background after the evaluator records the alerts; a network error,
+// 429, or 5xx is retried after notifyRetries, other statuses are final.
+// Every delivery, delivered or not, is logged with its attempts and last
+// status.
+
+// File the sinks and delivery log are kept in; NOTIFY_FILE overrides it.
+var notifyFile = "notify.json"
+
+// Guards notifyFile.
+var notifyMu sync.Mutex
+
+// Most deliveries logged; the oldest are dropped first.
+const maxDeliveries = 1000
+
+// Waits before each retry of a failed delivery.
+var notifyRetries = []time.Duration{5 * time.Second, 30 * time.Second, 2 * time.Minute}
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// Sink types.
+var sinkTypes = []string{"webhook", "slack", "discord", "email", "telegram"}
+
+type NotifySink struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`             // webhook | slack | discord | email | telegram
+	URL     string `json:"url"`              // mailto:… for email, tg:CHATID for telegram
+	Secret  string `json:"secret,omitempty"` // signs webhook bodies; never returned by the API
+	Signed  bool   `json:"signed"`
+	Created string `json:"created"` // RFC3339
+
+	// Watchlist limits the sink's alerts to the list's tickers and sends it
+	// the list's morning report of tickers gapping at least MinGap %.
+	Watchlist string  `json:"watchlist,omitempty"`
+	MinGap    float64 `json:"min_gap,omitempty"` // 0 = defaultReportGap
+}
+
+type Delivery struct {
+	Sink      string `json:"sink"`
+	Event     string `json:"event"`            // alert | report | test | job
+	Subject   string `json:"subject"`          // the alert's rule, the report's watchlist, the job's kind
+	Ticker    string `json:"ticker,omitempty"` // the alert's
+	Date      string `json:"date,omitempty"`   // the alert's or report's session date
+	Attempts  int    `json:"attempts"`
+	Status    int    `json:"status,omitempty"` // last HTTP status, or SMTP reply code
+	Error     string `json:"error,omitempty"`  // last failure
+	Delivered bool   `json:"delivered"`
+	At        string `json:"at"` // RFC3339, when it succeeded or gave up
+}
+
+// notification is a webhook body.
+type notification struct {
+	Event  string         `json:"event"` // alert | report | test | job
+	Sent   string         `json:"sent"`  // RFC3339
+	Alert  *Alert         `json:"alert,omitempty"`
+	Report *MorningReport `json:"report,omitempty"`
+
+	// A finished job's status and result (the result omitted for
+	// callbackLink=1); ResultURL is where the result can be read back.
+	Job       *Job   `json:"job,omitempty"`
+	Result    any    `json:"result,omitempty"`
+	ResultURL string `json:"result_url,omitempty"`
+}
+
+type notifyStore struct {
+	Sinks      map[string]NotifySink `json:"sinks"`
+	Deliveries []Delivery            `json:"deliveries"` // oldest first
+}
+
+func loadNotify() (notifyStore, error) {
+	st := notifyStore{Sinks: map[string]NotifySink{}}
+	b, err := os.ReadFile(notifyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		return st, fmt.Errorf("%s: %w", notifyFile, err)
+	}
+	if st.Sinks == nil {
+		st.Sinks = map[string]NotifySink{}
+	}
+	return st, nil
+}
+
+// saveNotify replaces the file atomically, like savePresets.
+func saveNotify(st notifyStore) error {
+	if n := len(st.Deliveries); n > maxDeliveries {
+		st.Deliveries = st.Deliveries[n-maxDeliveries:]
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := notifyFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil { // holds webhook secrets
+		return err
+	}
+	return os.Rename(tmp, notifyFile)
+}
+
+// parseSink reads a sink from the query: type (default webhook), url,
+// secret, watchlist, and minGap.
+func parseSink(name string, q url.Values) (NotifySink, error) {
+	s := NotifySink{
+		Name:      name,
+		Type:      strings.ToLower(strings.TrimSpace(q.Get("type"))),
+		URL:       strings.TrimSpace(q.Get("url")),
+		Secret:    q.Get("secret"),
+		Watchlist: strings.TrimSpace(q.Get("watchlist")),
+	}
+	if s.Type == "" {
+		s.Type = "webhook"
+	}
+	known := false
+	for _, t := range sinkTypes {
+		known = known || s.Type == t
+	}
+	if !known {
+		return s, fmt.Errorf("type must be one of %s", strings.Join(sinkTypes, ", "))
+	}
+	switch s.Type {
+	case "email":
+		if _, err := mailRecipients(s.URL); err != nil {
+			return s, err
+		}
+	case "telegram":
+		if _, err := telegramChat(s.URL); err != nil {
+			return s, err
+		}
+	default:
+		if u, err := url.Parse(s.URL); s.URL == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return s, fmt.Errorf("url must be an http(s) URL")
+		}
+	}
+	if s.Secret != "" && (s.Type == "email" || s.Type == "telegram") {
+		return s, fmt.Errorf("secret signs webhook bodies; %s sinks take none", s.Type)
+	}
+	if s.Watchlist != "" {
+		if _, err := watchlistTickers(s.Watchlist); err != nil {
+			return s, err
+		}
+	}
+	if v := strings.TrimSpace(q.Get("minGap")); v != "" {
+		g, err := strconv.ParseFloat(v, 64)
+		if err != nil || g < 0 || g >= 100 {
+			return s, fmt.Errorf("minGap must be a %% between 0 and 100")
+		}
+		if s.Watchlist == "" {
+			return s, fmt.Errorf("minGap needs a watchlist")
+		}
+		s.MinGap = g
+	}
+	s.Signed = s.Secret != ""
+	return s, nil
+}
+
+// reportGap is the gap a ticker needs to make the sink's morning report.
+func (s NotifySink) reportGap() float64 {
+	if s.MinGap > 0 {
+		return s.MinGap
+	}
+	return defaultReportGap
+}
+
+// body renders a notification for the sink and returns its content type.
+func (s NotifySink) body(n notification) ([]byte, string, error) {
+	var b []byte
+	var err error
+	switch s.Type {
+	case "slack":
+		b, err = slackBody(n)
+	case "discord":
+		b, err = discordBody(n)
+	default:
+		b, err = json.Marshal(n)
+	}
+	return b, "application/json", err
+}
+
+// send sends one delivery attempt by the sink's transport.
+func (s NotifySink) send(n notification) (int, bool, error) {
+	switch s.Type {
+	case "email":
+		return s.mail(n)
+	case "telegram":
+		return s.telegram(n)
+	}
+	return s.post(n)
+}
+
+// post sends one delivery attempt, returning the HTTP status (0 when the
+// request failed) and whether another attempt may succeed.
+func (s NotifySink) post(n notification) (int, bool, error) {
+	body, ctype, err := s.body(n)
+	if err != nil {
+		return 0, false, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", ctype)
+	req.Header.Set("User-Agent", "gap-analyzer")
+	req.Header.Set("X-Gap-Analyzer-Event", n.Event)
+	if s.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Gap-Analyzer-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode/100 == 2 {
+		return resp.StatusCode, false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	return resp.StatusCode, retry, err
+}
+
+// deliver sends n to the sink, waiting out retries between failed
+// attempts, and logs the delivery.
+func deliver(s NotifySink, n notification, retries []time.Duration) Delivery {
+	d := Delivery{Sink: s.Name, Event: n.Event, Subject: n.Event}
+	if a := n.Alert; a != nil {
+		d.Subject, d.Ticker, d.Date = a.Rule, a.Ticker, a.Date
+	}
+	if r := n.Report; r != nil {
+		d.Subject, d.Date = r.Watchlist, r.Date
+	}
+	if j := n.Job; j != nil {
+		d.Subject = j.Kind
+	}
+	for {
+		d.Attempts++
+		status, retry, err := s.send(n)
+		d.Status = status
+		if err == nil {
+			d.Delivered, d.Error = true, ""
+			break
+		}
+		d.Error = err.Error()
+		if !retry || d.Attempts > len(retries) {
+			break
+		}
+		time.Sleep(retries[d.Attempts-1])
+	}
+	d.At = time.Now().UTC().Format(time.RFC3339)
+	if !d.Delivered {
+		log.Printf("notify: %s: %s %s not delivered after %d attempts: %s", s.Name, d.Event, d.Subject, d.Attempts, d.Error)
+	}
+
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+	st, err := loadNotify()
+	if err == nil {
+		st.Deliveries = append(st.Deliveries, d)
+		err = saveNotify(st)
+	}
+	if err != nil {
+		log.Printf("notify: logging delivery: %v", err)
+	}
+	return d
+}
+
+// notifyAlerts delivers alerts to every sink in the background, each
+// sink's in order; a sink tied to a watchlist gets those on its tickers.
+func notifyAlerts(alerts []Alert) {
+	if len(alerts) == 0 {
+		return
+	}
+	notifyMu.Lock()
+	st, err := loadNotify()
+	notifyMu.Unlock()
+	if err != nil {
+		log.Printf("notify: %v", err)
+		return
+	}
+	sent := time.Now().UTC().Format(time.RFC3339)
+	for _, s := range st.Sinks {
+		var on map[string]bool
+		if s.Watchlist != "" {
+			tickers, err := watchlistTickers(s.Watchlist)
+			if err != nil {
+				log.Printf("notify: %s: %v", s.Name, err)
+				continue
+			}
+			on = map[string]bool{}
+			for _, tk := range tickers {
+				on[tk] = true
+			}
+		}
+		go func() {
+			for i := range alerts {
+				if on == nil || on[alerts[i].Ticker] {
+					deliver(s, notification{Event: "alert", Sent: sent, Alert: &alerts[i]}, notifyRetries)
+				}
+			}
+		}()
+	}
+}
+
+// publicSink is a sink as the API shows it, without its secret.
+func publicSink(s NotifySink) NotifySink {
+	s.Secret = ""
+	return s
+}
+
+// handleNotify lists sinks (GET), returns one (GET ?name=), saves one from
+// the query (POST; an existing name is replaced), or deletes one (DELETE).
+func handleNotify(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("name")
+	if r.Method != http.MethodGet || name != "" {
+		if !presetNameRE.MatchString(name) {
+			writeError(w, http.StatusBadRequest, "name must be 1-64 letters, digits, '.', '_' or '-'")
+			return
+		}
+	}
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+	st, err := loadNotify()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var out any
+	switch r.Method {
+	case http.MethodGet:
+		if name != "" {
+			s, ok := st.Sinks[name]
+			if !ok {
+				writeError(w, http.StatusNotFound, fmt.Sprintf("unknown sink %q", name))
+				return
+			}
+			out = publicSink(s)
+			break
+		}
+		list := make([]NotifySink, 0, len(st.Sinks))
+		for _, s := range st.Sinks {
+			list = append(list, publicSink(s))
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		out = list
+	case http.MethodPost:
+		s, err := parseSink(name, q)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.Created = time.Now().UTC().Format(time.RFC3339)
+		st.Sinks[name] = s
+		out = publicSink(s)
+	case http.MethodDelete:
+		if _, ok := st.Sinks[name]; !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("unknown sink %q", name))
+			return
+		}
+		delete(st.Sinks, name)
+		out = map[string]string{"deleted": name}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if r.Method != http.MethodGet {
+		if err := saveNotify(st); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handleDeliveries returns logged deliveries, newest first, optionally for
+// one sink (sink=) or only failed ones (failed=1).
+func handleDeliveries(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	notifyMu.Lock()
+	st, err := loadNotify()
+	notifyMu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sink, failed := q.Get("sink"), q.Get("failed") == "1"
+	out := []Delivery{}
+	for i := len(st.Deliveries) - 1; i >= 0; i-- {
+		d := st.Deliveries[i]
+		if (sink == "" || d.Sink == sink) && !(failed && d.Delivered) {
+			out = append(out, d)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handleNotifyTest sends sink ?name= a test notification now (POST), once,
+// and returns the delivery.
+func handleNotifyTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := r.URL.Query().Get("name")
+	notifyMu.Lock()
+	st, err := loadNotify()
+	notifyMu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s, ok := st.Sinks[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown sink %q", name))
+		return
+	}
+	d := deliver(s, notification{Event: "test", Sent: time.Now().UTC().Format(time.RFC3339)}, nil)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// handleNotifyReport builds sink ?name='s morning report now and sends it
+// (POST), once, returning the delivery.
+func handleNotifyReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := r.URL.Query().Get("name")
+	notifyMu.Lock()
+	st, err := loadNotify()
+	notifyMu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s, ok := st.Sinks[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown sink %q", name))
+		return
+	}
+	if s.Watchlist == "" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("sink %q has no watchlist", name))
+		return
+	}
+	rep, err := morningReport(r.Context(), s.Watchlist, s.reportGap())
+	if err != nil {
+		writeFetchError(w, err)
+		return
+	}
+	d := deliver(s, notification{Event: "report", Sent: time.Now().UTC().Format(time.RFC3339), Report: &rep}, nil)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
@@ -0,0 +1,396 @@
+// openapi.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= OpenAPI Spec =========================
+
+// /api/v1/spec.json describes the REST API as OpenAPI 3.0 so clients can
+// generate typed SDKs. The operations and their query parameters are listed
+// in apiOps below; the response schemas are derived at startup from the
+// response structs themselves (their json tags, omitempty marking optional
+// fields), so they cannot drift from what the handlers encode.
+
+// apiParam is one query parameter.
+type apiParam struct {
+	Name     string
+	Type     string // string | number | integer | boolean
+	Desc     string
+	Required bool
+	Enum     []string
+}
+
+// apiOp is one method on one path. Response is a value of the JSON body's
+// type; Alt, if set, is another shape the body may take.
+type apiOp struct {
+	Method, Path string
+	ID, Summary  string
+	Params       []apiParam
+	Response     any
+	Alt          any
+	Files        []string // other content types of the 200 response
+}
+
+func joinParams(groups ...[]apiParam) []apiParam {
+	var out []apiParam
+	for _, g := range groups {
+		out = append(out, g...)
+	}
+	return out
+}
+
+var (
+	tickerParam = []apiParam{{Name: "ticker", Type: "string", Desc: "Symbol, e.g. AAPL", Required: true}}
+	idParam     = []apiParam{{Name: "id", Type: "string", Desc: "Job ID", Required: true}}
+
+	lookbackParams = []apiParam{
+		{Name: "years", Type: "integer", Desc: "Lookback in years, 1-5 (default 3)"},
+		{Name: "minGap", Type: "number", Desc: "Smallest |gap| in %, above 0 and below 20 (default 0.3)"},
+	}
+	costParams = []apiParam{
+		{Name: "commission", Type: "number", Desc: "$ per share, charged on entry and exit"},
+		{Name: "slippage", Type: "number", Desc: "Slippage per side, in slippageUnits"},
+		{Name: "slippageUnits", Type: "string", Desc: "Units of slippage (default cents)", Enum: []string{"cents", "bps", "spread"}},
+	}
+	windowParams = []apiParam{
+		{Name: "trainMonths", Type: "integer", Desc: "Walk-forward training window, 1-48 (default 12)"},
+		{Name: "stepMonths", Type: "integer", Desc: "Walk-forward step, 1-12 (default 1)"},
+	}
+	analyzeParamSpecs = joinParams(lookbackParams, []apiParam{
+		{Name: "winsorize", Type: "string", Desc: "Percentiles lo,hi (or p) to clip per-trade returns at"},
+		{Name: "walkForward", Type: "string", Desc: "1 adds the walk_forward block", Enum: []string{"1"}},
+		{Name: "resample", Type: "string", Desc: "1 adds the monte_carlo block", Enum: []string{"1"}},
+	}, windowParams, costParams)
+	sheetParam     = []apiParam{{Name: "sheet", Type: "string", Desc: "CSV sheet (default points)", Enum: []string{"points", "summary"}}}
+	fieldsParam    = []apiParam{{Name: "fields", Type: "string", Desc: "Comma-separated top-level fields to return"}}
+	gapsParamSpecs = joinParams(tickerParam, analyzeParamSpecs, sheetParam, fieldsParam, []apiParam{
+		{Name: "format", Type: "string", Desc: "Download format instead of JSON", Enum: []string{"csv", "xlsx", "parquet", "ndjson"}},
+		{Name: "limit", Type: "integer", Desc: "Page size of data, 1-5000"},
+		{Name: "offset", Type: "integer", Desc: "First data element of the page"},
+		{Name: "async", Type: "string", Desc: "1 submits a background job and returns 202", Enum: []string{"1"}},
+		{Name: "callback", Type: "string", Desc: "With async=1, URL POSTed the job when it finishes"},
+	})
+	backtestParamSpecs = joinParams(lookbackParams, costParams, []apiParam{
+		{Name: "preset", Type: "string", Desc: "Saved preset to start from"},
+		{Name: "side", Type: "string", Desc: "Strategies to test (default both)", Enum: []string{"fade", "follow", "both"}},
+		{Name: "entry", Type: "string", Desc: "Entry (default 0930)", Enum: []string{"0930", "0945", "vwap"}},
+		{Name: "first15", Type: "string", Desc: "09:45 confirmation filter", Enum: []string{"confirm", "reject"}},
+		{Name: "units", Type: "string", Desc: "Units of stop and target (default pct)", Enum: []string{"pct", "atr"}},
+		{Name: "stop", Type: "string", Desc: "Stop distance in units, or premarket"},
+		{Name: "target", Type: "string", Desc: "Target distance in units, or fill"},
+		{Name: "trail", Type: "number", Desc: "Trailing-stop distance in trailUnits"},
+		{Name: "trailUnits", Type: "string", Desc: "Units of trail (default pct)", Enum: []string{"pct", "usd", "atr"}},
+		{Name: "reentry", Type: "integer", Desc: "Re-entries after a stop-out per session, 0-3"},
+		{Name: "ambiguity", Type: "string", Desc: "How a bar touching stop and target exits (default stop)", Enum: []string{"stop", "target", "open"}},
+		{Name: "exit", Type: "string", Desc: "Time exit HH:MM ET (default 16:00)"},
+		{Name: "exits", Type: "string", Desc: "Comma-separated exit times to compare"},
+		{Name: "scale", Type: "string", Desc: "Scale-out scheme SIZE@TARGET,...; repeat to compare"},
+		{Name: "htb", Type: "string", Desc: "Comma-separated hard-to-borrow tickers"},
+		{Name: "locate", Type: "number", Desc: "Short locate fee, $ per share"},
+		{Name: "borrow", Type: "number", Desc: "Short borrow rate, annual %"},
+		{Name: "sizing", Type: "string", Desc: "Position sizing model", Enum: []string{"fixed_dollar", "fixed_fractional", "atr"}},
+		{Name: "capital", Type: "number", Desc: "Starting capital (default 100000)"},
+		{Name: "dollars", Type: "number", Desc: "Notional per trade for fixed_dollar"},
+		{Name: "risk", Type: "number", Desc: "% of equity risked per trade"},
+		{Name: "atrMult", Type: "number", Desc: "ATR multiple for atr sizing"},
+		{Name: "benchmark", Type: "string", Desc: "Index to compare against (default SPY, or none)"},
+	})
+	gridParamSpecs = []apiParam{
+		{Name: "stops", Type: "string", Desc: "Comma-separated stop distances"},
+		{Name: "targets", Type: "string", Desc: "Comma-separated target distances"},
+	}
+	basketParams = []apiParam{
+		{Name: "tickers", Type: "string", Desc: "Comma-separated symbols"},
+		{Name: "watchlist", Type: "string", Desc: "Saved watchlist to use instead of tickers"},
+	}
+	nameParam = []apiParam{{Name: "name", Type: "string", Desc: "Name (omit to list all)"}}
+	userParam = []apiParam{{Name: "user", Type: "string", Desc: "User name (default \"default\")"}}
+)
+
+// deleted is the body of a successful DELETE.
+type deleted struct {
+	Deleted string `json:"deleted"`
+}
+
+var apiOps = []apiOp{
+	{Method: "GET", Path: "/gaps", ID: "getGaps", Summary: "Gap analysis for a ticker", Params: gapsParamSpecs, Response: gapcore.AnalyzeResponse{},
+		Files: []string{"text/csv", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "application/vnd.apache.parquet", "application/x-ndjson"}},
+	{Method: "GET", Path: "/gaps.csv", ID: "getGapsCSV", Summary: "Gap analysis as CSV", Params: joinParams(tickerParam, analyzeParamSpecs, sheetParam), Files: []string{"text/csv"}},
+	{Method: "GET", Path: "/gaps/events", ID: "getGapsEvents", Summary: "Server-sent progress and result of a job", Params: idParam, Files: []string{"text/event-stream"}},
+	{Method: "GET", Path: "/report.pdf", ID: "getReportPDF", Summary: "Gap analysis as a PDF report", Params: joinParams(tickerParam, analyzeParamSpecs), Files: []string{"application/pdf"}},
+	{Method: "GET", Path: "/model", ID: "getModel", Summary: "Continuation model", Params: joinParams(tickerParam, lookbackParams), Response: ModelResponse{}},
+	{Method: "GET", Path: "/today", ID: "getToday", Summary: "Today's premarket gap and call", Params: joinParams(tickerParam, lookbackParams), Response: TodayResponse{}},
+	{Method: "GET", Path: "/dashboard", ID: "getDashboard", Summary: "Live premarket gap table of the dashboard watchlist", Response: DashboardResponse{}},
+	{Method: "GET", Path: "/compare", ID: "getCompare", Summary: "Gap analysis of several tickers side by side", Params: joinParams(basketParams, analyzeParamSpecs), Response: CompareResponse{}},
+	{Method: "GET", Path: "/scan", ID: "getScan", Summary: "Market-wide gap scan", Params: []apiParam{
+		{Name: "date", Type: "string", Desc: "Session YYYY-MM-DD (default latest)"},
+		{Name: "minGap", Type: "number", Desc: "Smallest |gap| in % (default 2)"},
+		{Name: "side", Type: "string", Desc: "Gap direction (default both)", Enum: []string{"up", "down", "both"}},
+		{Name: "minPrice", Type: "number", Desc: "Lowest open (default 5)"},
+		{Name: "maxPrice", Type: "number", Desc: "Highest open (default none)"},
+		{Name: "minVolume", Type: "number", Desc: "Lowest session volume (default 500000)"},
+		{Name: "limit", Type: "integer", Desc: "Most hits returned, 1-500 (default 50)"},
+		{Name: "watchlist", Type: "string", Desc: "Keep only hits on this watchlist"},
+	}, Response: ScanResponse{}},
+	{Method: "GET", Path: "/backtest", ID: "getBacktest", Summary: "Rule-based intraday backtest", Params: joinParams(tickerParam, backtestParamSpecs), Response: gapcore.BacktestResponse{}, Files: []string{"text/csv"}},
+	{Method: "GET", Path: "/backtest/grid", ID: "getBacktestGrid", Summary: "Stop x target sweep", Params: joinParams(tickerParam, backtestParamSpecs, gridParamSpecs), Response: GridResponse{}},
+	{Method: "GET", Path: "/backtest/walkforward", ID: "getBacktestWalkForward", Summary: "Walk-forward optimization", Params: joinParams(tickerParam, backtestParamSpecs, gridParamSpecs, windowParams), Response: WFOResponse{}},
+	{Method: "GET", Path: "/backtest/portfolio", ID: "getBacktestPortfolio", Summary: "Basket backtest on one account", Params: joinParams(basketParams, backtestParamSpecs, []apiParam{
+		{Name: "maxPositions", Type: "integer", Desc: "Positions per day, 1-50 (default 5)"},
+	}), Response: PortfolioResponse{}},
+	{Method: "GET", Path: "/presets", ID: "getPresets", Summary: "List presets, or get one", Params: nameParam, Response: []Preset{}, Alt: Preset{}},
+	{Method: "POST", Path: "/presets", ID: "savePreset", Summary: "Save the request's backtest parameters as a preset", Params: joinParams([]apiParam{{Name: "name", Type: "string", Required: true}}, backtestParamSpecs), Response: Preset{}},
+	{Method: "DELETE", Path: "/presets", ID: "deletePreset", Summary: "Delete a preset", Params: []apiParam{{Name: "name", Type: "string", Required: true}}, Response: deleted{}},
+	{Method: "GET", Path: "/watchlists", ID: "getWatchlists", Summary: "List watchlists, or get one", Params: nameParam, Response: []Watchlist{}, Alt: Watchlist{}},
+	{Method: "POST", Path: "/watchlists", ID: "createWatchlist", Summary: "Create a watchlist", Params: []apiParam{{Name: "name", Type: "string", Required: true}, {Name: "tickers", Type: "string", Desc: "Comma-separated symbols", Required: true}}, Response: Watchlist{}},
+	{Method: "PUT", Path: "/watchlists", ID: "updateWatchlist", Summary: "Replace or edit a watchlist's tickers", Params: []apiParam{
+		{Name: "name", Type: "string", Required: true},
+		{Name: "tickers", Type: "string", Desc: "Comma-separated symbols replacing the list"},
+		{Name: "add", Type: "string", Desc: "Comma-separated symbols to add"},
+		{Name: "remove", Type: "string", Desc: "Comma-separated symbols to remove"},
+	}, Response: Watchlist{}},
+	{Method: "DELETE", Path: "/watchlists", ID: "deleteWatchlist", Summary: "Delete a watchlist", Params: []apiParam{{Name: "name", Type: "string", Required: true}}, Response: deleted{}},
+	{Method: "GET", Path: "/alerts", ID: "getAlertRules", Summary: "List alert rules, or get one", Params: nameParam, Response: []AlertRule{}, Alt: AlertRule{}},
+	{Method: "POST", Path: "/alerts", ID: "saveAlertRule", Summary: "Save an alert rule", Params: joinParams([]apiParam{{Name: "name", Type: "string", Required: true}}, tickerParam, []apiParam{
+		{Name: "gap", Type: "number", Desc: "Trigger when |gap| >= this %", Required: true},
+		{Name: "minExpectancy", Type: "number", Desc: "Smallest expectancy in the gap's bin, % per trade", Required: true},
+		{Name: "side", Type: "string", Desc: "Gap direction (default both)", Enum: []string{"up", "down", "both"}},
+		{Name: "strategy", Type: "string", Desc: "Strategy (default fade)", Enum: []string{"fade", "follow"}},
+		{Name: "horizon", Type: "string", Desc: "Horizon (default daily)", Enum: []string{"daily", "15m"}},
+		{Name: "years", Type: "integer", Desc: "Lookback in years (default 3)"},
+	}), Response: AlertRule{}},
+	{Method: "DELETE", Path: "/alerts", ID: "deleteAlertRule", Summary: "Delete an alert rule", Params: []apiParam{{Name: "name", Type: "string", Required: true}}, Response: deleted{}},
+	{Method: "GET", Path: "/alerts/triggered", ID: "getTriggeredAlerts", Summary: "Alerts raised, newest first", Params: []apiParam{
+		{Name: "rule", Type: "string", Desc: "Only this rule's alerts"},
+		{Name: "since", Type: "string", Desc: "Only sessions on or after YYYY-MM-DD"},
+	}, Response: []Alert{}},
+	{Method: "POST", Path: "/alerts/evaluate", ID: "evaluateAlerts", Summary: "Check every rule now", Response: struct {
+		Added  []Alert           `json:"added"`
+		Failed map[string]string `json:"failed"`
+	}{}},
+	{Method: "GET", Path: "/notify", ID: "getSinks", Summary: "List notification sinks, or get one", Params: nameParam, Response: []NotifySink{}, Alt: NotifySink{}},
+	{Method: "POST", Path: "/notify", ID: "saveSink", Summary: "Save a notification sink", Params: []apiParam{
+		{Name: "name", Type: "string", Required: true},
+		{Name: "url", Type: "string", Desc: "Destination URL", Required: true},
+		{Name: "type", Type: "string", Desc: "Sink type (default webhook)", Enum: sinkTypes},
+		{Name: "secret", Type: "string", Desc: "Key to sign webhook bodies with (HMAC-SHA256)"},
+		{Name: "watchlist", Type: "string", Desc: "Only alerts on this watchlist, plus its morning report"},
+		{Name: "minGap", Type: "number", Desc: "Gap % a ticker needs to make the morning report (default 1)"},
+	}, Response: NotifySink{}},
+	{Method: "DELETE", Path: "/notify", ID: "deleteSink", Summary: "Delete a notification sink", Params: []apiParam{{Name: "name", Type: "string", Required: true}}, Response: deleted{}},
+	{Method: "GET", Path: "/notify/deliveries", ID: "getDeliveries", Summary: "Notification deliveries, newest first", Params: []apiParam{
+		{Name: "sink", Type: "string", Desc: "Only this sink's deliveries"},
+		{Name: "failed", Type: "string", Desc: "1 for undelivered only"},
+	}, Response: []Delivery{}},
+	{Method: "POST", Path: "/notify/test", ID: "testSink", Summary: "Send a sink a test notification", Params: []apiParam{{Name: "name", Type: "string", Required: true}}, Response: Delivery{}},
+	{Method: "POST", Path: "/notify/report", ID: "sendSinkReport", Summary: "Send a sink its watchlist's morning report now", Params: []apiParam{{Name: "name", Type: "string", Required: true}}, Response: Delivery{}},
+	{Method: "GET", Path: "/morning", ID: "getMorning", Summary: "A watchlist's premarket gappers with bin stats and calls", Params: []apiParam{
+		{Name: "watchlist", Type: "string", Required: true},
+		{Name: "minGap", Type: "number", Desc: "Smallest |gap| % reported (default 1)"},
+	}, Response: MorningReport{}},
+	{Method: "GET", Path: "/jobs", ID: "getJobs", Summary: "List jobs, or get one", Params: []apiParam{{Name: "id", Type: "string", Desc: "Job ID (omit to list all)"}}, Response: []Job{}, Alt: Job{}},
+	{Method: "POST", Path: "/jobs", ID: "submitJob", Summary: "Submit a background gaps or scan job (plus that endpoint's parameters)", Params: []apiParam{
+		{Name: "kind", Type: "string", Required: true, Enum: []string{"gaps", "scan"}},
+		{Name: "callback", Type: "string", Desc: "URL POSTed the job and its result when it finishes"},
+		{Name: "callbackSecret", Type: "string", Desc: "Key to sign the callback body with (HMAC-SHA256)"},
+		{Name: "callbackLink", Type: "string", Desc: "1 sends the result URL instead of the result", Enum: []string{"0", "1"}},
+	}, Response: Job{}},
+	{Method: "DELETE", Path: "/jobs", ID: "cancelJob", Summary: "Cancel a pending job or delete a finished one", Params: idParam, Response: map[string]string{}},
+	{Method: "GET", Path: "/jobs/result", ID: "getJobResult", Summary: "A finished job's gaps or scan JSON", Params: idParam, Response: gapcore.AnalyzeResponse{}, Alt: ScanResponse{}},
+	{Method: "GET", Path: "/runs", ID: "getRuns", Summary: "Saved analysis runs, newest first, or one run's result", Params: []apiParam{
+		{Name: "id", Type: "string", Desc: "Run ID (omit to list all)"},
+		{Name: "ticker", Type: "string", Desc: "List only this ticker's runs"},
+	}, Response: []RunInfo{}, Alt: gapcore.AnalyzeResponse{}},
+	{Method: "DELETE", Path: "/runs", ID: "deleteRun", Summary: "Delete a saved run", Params: []apiParam{{Name: "id", Type: "string", Required: true}}, Response: deleted{}},
+	{Method: "GET", Path: "/runs/diff", ID: "getRunDiff", Summary: "How a ticker's later saved run moved from an earlier one", Params: []apiParam{
+		{Name: "from", Type: "string", Desc: "Earlier run ID", Required: true},
+		{Name: "to", Type: "string", Desc: "Later run ID", Required: true},
+	}, Response: RunDiffResponse{}},
+	{Method: "GET", Path: "/settings", ID: "getSettings", Summary: "A user's saved defaults", Params: userParam, Response: Settings{}},
+	{Method: "PUT", Path: "/settings", ID: "updateSettings", Summary: "Change the settings the request sets (empty clears one)", Params: joinParams(userParam, lookbackParams, windowParams, []apiParam{
+		{Name: "bins", Type: "string", Desc: "Comma-separated bin edges in %, as GAP_BINS"},
+		{Name: "theme", Type: "string", Desc: "UI theme", Enum: settingsThemes},
+	}), Response: Settings{}},
+	{Method: "DELETE", Path: "/settings", ID: "deleteSettings", Summary: "Clear a user's settings", Params: userParam, Response: deleted{}},
+	{Method: "GET", Path: "/cache", ID: "getCache", Summary: "Bar cache stats and per-ticker coverage", Params: []apiParam{
+		{Name: "ticker", Type: "string", Desc: "Only this ticker's coverage"},
+	}, Response: CacheResponse{}},
+	{Method: "DELETE", Path: "/cache", ID: "purgeCache", Summary: "Purge a ticker's cached and stored bars, or the whole in-memory cache", Params: []apiParam{
+		{Name: "ticker", Type: "string", Desc: "Ticker to purge"},
+		{Name: "all", Type: "string", Desc: "1 empties the in-memory cache instead", Enum: []string{"1"}},
+	}, Response: CachePurge{}},
+	{Method: "GET", Path: "/quality", ID: "getQuality", Summary: "Data-quality audit of a ticker's bars", Params: joinParams(tickerParam, lookbackParams), Response: gapcore.QualityReport{}},
+	{Method: "GET", Path: "/graphql", ID: "getGraphQL", Summary: "GraphQL query over the gap analysis", Params: []apiParam{
+		{Name: "query", Type: "string", Required: true},
+		{Name: "variables", Type: "string", Desc: "JSON object"},
+	}, Response: map[string]any{}},
+	{Method: "POST", Path: "/graphql", ID: "postGraphQL", Summary: "GraphQL query over the gap analysis (body: {query, variables})", Response: map[string]any{}},
+	{Method: "GET", Path: "/spec.json", ID: "getSpec", Summary: "This OpenAPI description", Response: map[string]any{}},
+}
+
+// specSchemas builds component schemas from Go types.
+type specSchemas map[string]any
+
+func (s specSchemas) of(t reflect.Type) map[string]any {
+	if t == reflect.TypeOf(schemaVersion(0)) {
+		return map[string]any{"type": "integer", "enum": []int{apiSchemaVersion}}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		// Unexported types (apiError) get exported-looking names.
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := s[name]; !ok {
+			s[name] = nil // placeholder: recursive types stop here
+			s[name] = s.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+func (s specSchemas) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				walk(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = s.of(f.Type)
+			if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+	out := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		out["required"] = required
+	}
+	return out
+}
+
+var (
+	specOnce sync.Once
+	specJSON []byte
+)
+
+// buildSpec assembles the OpenAPI document.
+func buildSpec() map[string]any {
+	schemas := specSchemas{}
+	errRef := schemas.of(reflect.TypeOf(errorResponse{}))
+	paths := map[string]map[string]any{}
+	for _, op := range apiOps {
+		var ps []any
+		for _, p := range op.Params {
+			sch := map[string]any{"type": p.Type}
+			if len(p.Enum) > 0 {
+				sch["enum"] = p.Enum
+			}
+			param := map[string]any{"name": p.Name, "in": "query", "required": p.Required, "schema": sch}
+			if p.Desc != "" {
+				param["description"] = p.Desc
+			}
+			ps = append(ps, param)
+		}
+		content := map[string]any{}
+		if op.Response != nil {
+			sch := schemas.of(reflect.TypeOf(op.Response))
+			if op.Alt != nil {
+				sch = map[string]any{"oneOf": []any{sch, schemas.of(reflect.TypeOf(op.Alt))}}
+			}
+			content["application/json"] = map[string]any{"schema": sch}
+		}
+		for _, ct := range op.Files {
+			content[ct] = map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}
+		}
+		ok := map[string]any{"description": "OK", "content": content}
+		if len(content) == 0 {
+			delete(ok, "content")
+		}
+		operation := map[string]any{
+			"operationId": op.ID,
+			"summary":     op.Summary,
+			"responses": map[string]any{
+				"200":     ok,
+				"default": map[string]any{"$ref": "#/components/responses/Error"},
+			},
+		}
+		if len(ps) > 0 {
+			operation["parameters"] = ps
+		}
+		path := apiPrefix + op.Path
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(op.Method)] = operation
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "US Stocks Gap Analyzer API",
+			"version": "v1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"responses": map[string]any{
+				"Error": map[string]any{
+					"description": "Error",
+					"content":     map[string]any{"application/json": map[string]any{"schema": errRef}},
+				},
+			},
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+		// Only enforced when the server has API tokens.
+		"security": []any{map[string]any{"bearer": []string{}}, map[string]any{}},
+	}
+}
+
+func handleSpec(w http.ResponseWriter, _ *http.Request) {
+	specOnce.Do(func() {
+		specJSON, _ = json.MarshalIndent(buildSpec(), "", "  ")
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(specJSON)
+}
@@ -0,0 +1,254 @@
+// parquet.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Parquet Export =========================
+
+// format=parquet writes the per-session points as a Parquet file: one row
+// group, one uncompressed PLAIN data page per column, every column required.
+// That is the simplest valid layout and loads as-is in DuckDB, pandas and
+// Polars. The footer is Thrift compact protocol, written by thriftWriter.
+
+// Parquet physical and converted types used here.
+const (
+	pqInt32     = 1
+	pqDouble    = 5
+	pqByteArray = 6
+
+	pqConvNone = -1
+	pqConvUTF8 = 0
+	pqConvDate = 6
+)
+
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32
+	data      bytes.Buffer // PLAIN-encoded values
+}
+
+func (c *parquetColumn) putInt32(v int32) {
+	binary.Write(&c.data, binary.LittleEndian, v)
+}
+
+func (c *parquetColumn) putDouble(v float64) {
+	binary.Write(&c.data, binary.LittleEndian, math.Float64bits(v))
+}
+
+func (c *parquetColumn) putString(s string) {
+	binary.Write(&c.data, binary.LittleEndian, uint32(len(s)))
+	c.data.WriteString(s)
+}
+
+// gapColumns lays the points out column by column, named like the CSV.
+func gapColumns(points []gapcore.GapPoint) []*parquetColumn {
+	col := func(name string, typ, conv int32) *parquetColumn {
+		return &parquetColumn{name: name, typ: typ, converted: conv}
+	}
+	date := col("date", pqInt32, pqConvDate)
+	dow := col("dow", pqByteArray, pqConvUTF8)
+	gap := col("gap_pct", pqDouble, pqConvNone)
+	dir := col("direction", pqInt32, pqConvNone)
+	bin := col("bin", pqByteArray, pqConvUTF8)
+	prevClose := col("prev_close", pqDouble, pqConvNone)
+	open := col("open", pqDouble, pqConvNone)
+	cls := col("close", pqDouble, pqConvNone)
+	dailyRet := col("daily_return_pct", pqDouble, pqConvNone)
+	sameDir := col("same_dir", pqInt32, pqConvNone)
+	filled := col("filled", pqInt32, pqConvNone)
+	prevRet := col("prev_return_pct", pqDouble, pqConvNone)
+	rvol := col("prev_rvol", pqDouble, pqConvNone)
+	ret15 := col("ret_15m_pct", pqDouble, pqConvNone)
+	filled15 := col("filled_by_0945", pqInt32, pqConvNone)
+	for _, p := range points {
+		d, _ := time.Parse("2006-01-02", p.Date)
+		date.putInt32(int32(d.Unix() / 86400)) // days since the epoch
+		dow.putString(p.DayOfWeek)
+		gap.putDouble(p.GapPct)
+		dir.putInt32(int32(p.Direction))
+		bin.putString(p.Bin)
+		prevClose.putDouble(p.PrevClose)
+		open.putDouble(p.Open)
+		cls.putDouble(p.Close)
+		dailyRet.putDouble(p.DailyReturnPct)
+		sameDir.putInt32(int32(p.SameDir))
+		filled.putInt32(int32(p.Filled))
+		prevRet.putDouble(p.PrevReturnPct)
+		rvol.putDouble(p.PrevRVOL)
+		ret15.putDouble(p.Ret15mPct)
+		filled15.putInt32(int32(p.FilledBy0945))
+	}
+	return []*parquetColumn{date, dow, gap, dir, bin, prevClose, open, cls, dailyRet, sameDir, filled, prevRet, rvol, ret15, filled15}
+}
+
+// Thrift compact protocol type ids.
+const (
+	thI32    = 5
+	thI64    = 6
+	thBinary = 8
+	thList   = 9
+	thStruct = 12
+)
+
+// thriftWriter emits Thrift compact protocol; last holds the previous field
+// id of each open struct, since field ids are delta-encoded.
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	for v >= 0x80 {
+		t.buf.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	t.buf.WriteByte(byte(v))
+}
+
+func (t *thriftWriter) zigzag(v int64) { t.varint(uint64((v << 1) ^ (v >> 63))) }
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	prev := &t.last[len(t.last)-1]
+	if d := id - *prev; d > 0 && d <= 15 {
+		t.buf.WriteByte(byte(d)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.zigzag(int64(id))
+	}
+	*prev = id
+}
+
+func (t *thriftWriter) begin() { t.last = append(t.last, 0) }
+
+func (t *thriftWriter) end() {
+	t.buf.WriteByte(0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) i32(id int16, v int32) { t.field(id, thI32); t.zigzag(int64(v)) }
+func (t *thriftWriter) i64(id int16, v int64) { t.field(id, thI64); t.zigzag(v) }
+
+func (t *thriftWriter) str(s string) {
+	t.varint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) binary(id int16, s string) { t.field(id, thBinary); t.str(s) }
+
+func (t *thriftWriter) list(id int16, elem byte, n int) {
+	t.field(id, thList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+	} else {
+		t.buf.WriteByte(0xF0 | elem)
+		t.varint(uint64(n))
+	}
+}
+
+func (t *thriftWriter) structField(id int16) { t.field(id, thStruct); t.begin() }
+
+// writeParquet writes cols, each holding rows values, as a Parquet file.
+func writeParquet(w io.Writer, cols []*parquetColumn, rows int) error {
+	var out bytes.Buffer
+	out.WriteString("PAR1")
+	offsets := make([]int64, len(cols))
+	sizes := make([]int64, len(cols))
+	for i, c := range cols {
+		var ph thriftWriter // PageHeader
+		ph.begin()
+		ph.i32(1, 0) // DATA_PAGE
+		ph.i32(2, int32(c.data.Len()))
+		ph.i32(3, int32(c.data.Len()))
+		ph.structField(5) // DataPageHeader
+		ph.i32(1, int32(rows))
+		ph.i32(2, 0) // PLAIN
+		ph.i32(3, 3) // RLE definition levels (none: required)
+		ph.i32(4, 3) // RLE repetition levels (none: flat)
+		ph.end()
+		ph.end()
+		offsets[i] = int64(out.Len())
+		sizes[i] = int64(ph.buf.Len() + c.data.Len())
+		out.Write(ph.buf.Bytes())
+		out.Write(c.data.Bytes())
+	}
+
+	var fm thriftWriter // FileMetaData
+	fm.begin()
+	fm.i32(1, 1)
+	fm.list(2, thStruct, len(cols)+1)
+	fm.begin()
+	fm.binary(4, "schema")
+	fm.i32(5, int32(len(cols)))
+	fm.end()
+	for _, c := range cols {
+		fm.begin()
+		fm.i32(1, c.typ)
+		fm.i32(3, 0) // REQUIRED
+		fm.binary(4, c.name)
+		if c.converted != pqConvNone {
+			fm.i32(6, c.converted)
+		}
+		fm.end()
+	}
+	fm.i64(3, int64(rows))
+	fm.list(4, thStruct, 1)
+	fm.begin() // RowGroup
+	fm.list(1, thStruct, len(cols))
+	var total int64
+	for i, c := range cols {
+		fm.begin() // ColumnChunk
+		fm.i64(2, offsets[i])
+		fm.structField(3) // ColumnMetaData
+		fm.i32(1, c.typ)
+		fm.list(2, thI32, 1)
+		fm.zigzag(0) // PLAIN
+		fm.list(3, thBinary, 1)
+		fm.str(c.name)
+		fm.i32(4, 0) // UNCOMPRESSED
+		fm.i64(5, int64(rows))
+		fm.i64(6, sizes[i])
+		fm.i64(7, sizes[i])
+		fm.i64(9, offsets[i])
+		fm.end()
+		fm.end()
+		total += sizes[i]
+	}
+	fm.i64(2, total)
+	fm.i64(3, int64(rows))
+	fm.end()
+	fm.binary(6, "gap-analyzer")
+	fm.end()
+
+	out.Write(fm.buf.Bytes())
+	binary.Write(&out, binary.LittleEndian, uint32(fm.buf.Len()))
+	out.WriteString("PAR1")
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// writeGapsParquet sends resp's points as a Parquet download.
+func writeGapsParquet(w http.ResponseWriter, resp gapcore.AnalyzeResponse) {
+	if !resp.Success && len(resp.Data) == 0 {
+		writeError(w, http.StatusBadGateway, resp.Error)
+		return
+	}
+	var buf bytes.Buffer
+	if err := writeParquet(&buf, gapColumns(resp.Data), len(resp.Data)); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s_gaps.parquet"`, resp.Ticker))
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,59 @@
+// parquet_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gap-analyzer/gapcore"
+)
+
+func TestWriteParquetLayout(t *testing.T) {
+	points := []gapcore.GapPoint{
+		{Date: "2024-01-02", GapPct: 0.8, DailyReturnPct: -0.4, Direction: 1, Bin: "0.5-1%", DayOfWeek: "Tue"},
+		{Date: "2024-01-03", GapPct: -1.2, DailyReturnPct: 0.6, Direction: -1, SameDir: 1, Bin: "1-2%", DayOfWeek: "Wed"},
+	}
+	var buf bytes.Buffer
+	if err := writeParquet(&buf, gapColumns(points), len(points)); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	if len(b) < 12 {
+		t.Fatalf("file is %d bytes", len(b))
+	}
+	if string(b[:4]) != "PAR1" || string(b[len(b)-4:]) != "PAR1" {
+		t.Fatalf("magic: got %q ... %q, want PAR1 at both ends", b[:4], b[len(b)-4:])
+	}
+
+	// The footer (FileMetaData) sits right before its 4-byte length and
+	// the trailing magic, after the column chunks.
+	n := int(binary.LittleEndian.Uint32(b[len(b)-8 : len(b)-4]))
+	start := len(b) - 8 - n
+	if n == 0 || start <= 4 {
+		t.Fatalf("footer length %d does not fit a %d-byte file", n, len(b))
+	}
+	footer := b[start : len(b)-8]
+	// Field 1 (version), compact i32, zigzag 1.
+	if footer[0] != 0x15 || footer[1] != 0x02 {
+		t.Errorf("footer starts % x, want version 1 (15 02)", footer[:2])
+	}
+	if footer[len(footer)-1] != 0 {
+		t.Errorf("footer does not end with a struct stop")
+	}
+	for _, want := range []string{"schema", "date", "gap_pct", "gap-analyzer"} {
+		if !bytes.Contains(footer, []byte(want)) {
+			t.Errorf("footer is missing %q", want)
+		}
+	}
+}
+
+func TestWriteGapsParquetFailedAnalysis(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeGapsParquet(rec, gapcore.AnalyzeResponse{Ticker: "SPY", Error: "intraday fetch failed"})
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+}
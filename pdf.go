@@ -0,0 +1,205 @@
+// pdf.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= PDF Report =========================
+
+// /api/report.pdf renders the /api/gaps analysis as a printable one-page
+// summary: headline recommendation, daily and 0–15m summary figures, and
+// both bin tables. The PDF is written by hand with the standard Helvetica
+// fonts, which every viewer has, so nothing is embedded.
+
+// US Letter, in points.
+const (
+	pdfWidth  = 612.0
+	pdfHeight = 792.0
+	pdfMargin = 48.0
+)
+
+// pdfPage accumulates one page's content stream.
+type pdfPage struct {
+	buf bytes.Buffer
+	y   float64 // baseline of the next line, from the bottom
+}
+
+// pdfWinAnsi maps the few non-ASCII characters the report uses to
+// WinAnsiEncoding; anything else outside ASCII becomes '?'.
+var pdfWinAnsi = map[rune]byte{'–': 0x96, '—': 0x97, '…': 0x85, '·': 0xB7, '×': 0xD7}
+
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x80:
+			b.WriteRune(r)
+		default:
+			c, ok := pdfWinAnsi[r]
+			if !ok {
+				c = '?'
+			}
+			fmt.Fprintf(&b, "\\%03o", c)
+		}
+	}
+	return b.String()
+}
+
+func (p *pdfPage) text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.buf, "BT /%s %.1f Tf %.1f %.1f Td (%s) Tj ET\n", font, size, x, y, pdfString(s))
+}
+
+func (p *pdfPage) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.buf, "%.1f %.1f m %.1f %.1f l S\n", x1, y1, x2, y2)
+}
+
+// row writes cells at the column offsets xs and moves down one line.
+func (p *pdfPage) row(xs []float64, size float64, bold bool, cells ...string) {
+	for i, c := range cells {
+		p.text(pdfMargin+xs[i], p.y, size, bold, c)
+	}
+	p.y -= size + 4
+}
+
+// table writes a bold header, a rule, and the rows.
+func (p *pdfPage) table(xs []float64, header []string, rows [][]string) {
+	p.row(xs, 8.5, true, header...)
+	p.line(pdfMargin, p.y+9, pdfWidth-pdfMargin, p.y+9)
+	for _, r := range rows {
+		p.row(xs, 8.5, false, r...)
+	}
+	p.y -= 8
+}
+
+func (p *pdfPage) heading(s string) {
+	p.text(pdfMargin, p.y, 12, true, s)
+	p.y -= 18
+}
+
+// writePDF wraps one page stream into a complete PDF file.
+func writePDF(w io.Writer, content []byte) error {
+	var out bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	out.WriteString("%PDF-1.4\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj("<< /Type /Pages /Kids [3 0 R] /Count 1 >>")
+	obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %g %g] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>", pdfWidth, pdfHeight))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+func pdfPct(f float64) string          { return fmt.Sprintf("%.1f%%", f) }
+func pdfRet(f float64) string          { return fmt.Sprintf("%+.3f%%", f) }
+func pdfCI(iv gapcore.Interval) string { return fmt.Sprintf("%.1f–%.1f", iv.Low, iv.High) }
+
+// reportSummaryRows are the report's summary table: a row per metric with
+// its daily and 0–15m values.
+func reportSummaryRows(resp gapcore.AnalyzeResponse) [][]string {
+	s, s15 := resp.Summary, resp.Summary15
+	return [][]string{
+		{"Sessions", fmt.Sprint(s.Sessions), fmt.Sprint(s15.Sessions)},
+		{"Continuation rate (95% CI)", pdfPct(s.ContinuationRate) + " (" + pdfCI(s.ContinuationCI) + ")", pdfPct(s15.ContinuationRate) + " (" + pdfCI(s15.ContinuationCI) + ")"},
+		{"Fade avg / trade", pdfRet(s.FadeAvg), pdfRet(s15.FadeAvg)},
+		{"Follow avg / trade", pdfRet(s.FollowAvg), pdfRet(s15.FollowAvg)},
+		{"Fade win rate", pdfPct(s.FadeStats.WinRate), pdfPct(s15.FadeStats.WinRate)},
+		{"Follow vs fade t-test p", fmt.Sprintf("%.3f", s.FollowVsFade.TPValue), fmt.Sprintf("%.3f", s15.FollowVsFade.TPValue)},
+		{"Gap-ups / gap-downs", fmt.Sprintf("%d / %d", s.GapUps, s.GapDowns), ""},
+		{"Gap fill by 09:45", "", pdfPct(s15.GapFillBy0945Rate)},
+	}
+}
+
+// reportBinRows are the report's bin tables, daily and 0–15m; the 0–15m
+// Fill column is the fill by 09:45.
+func reportBinRows(resp gapcore.AnalyzeResponse) (header []string, daily, first15 [][]string) {
+	header = []string{"Bin", "n", "Cont.", "95% CI", "Fill", "Fade avg", "Follow avg", "Rec."}
+	for _, b := range resp.Bins {
+		daily = append(daily, []string{b.Label, fmt.Sprint(b.Count), pdfPct(b.ContinuationRate), pdfCI(b.ContinuationCI), pdfPct(b.GapFillRate), pdfRet(b.FadeAvg), pdfRet(b.FollowAvg), b.Recommendation})
+	}
+	for _, b := range resp.Bins15 {
+		first15 = append(first15, []string{b.Label, fmt.Sprint(b.Count), pdfPct(b.ContinuationRate), pdfCI(b.ContinuationCI), pdfPct(b.GapFillBy0945Rate), pdfRet(b.FadeAvg), pdfRet(b.FollowAvg), b.Recommendation})
+	}
+	return header, daily, first15
+}
+
+// reportContent lays out the one-pager for resp.
+func reportContent(resp gapcore.AnalyzeResponse) []byte {
+	p := &pdfPage{y: pdfHeight - pdfMargin - 10}
+	p.text(pdfMargin, p.y, 18, true, resp.Ticker+" gap report")
+	p.y -= 18
+	p.text(pdfMargin, p.y, 9, false, fmt.Sprintf("%d year(s) · min gap %.2f%% · %d sessions · generated %s",
+		resp.Years, resp.MinGap, resp.Summary.Sessions, time.Now().Format("2006-01-02")))
+	p.y -= 26
+
+	s, s15 := resp.Summary, resp.Summary15
+	p.heading("Recommendation")
+	p.text(pdfMargin, p.y, 11, false, fmt.Sprintf("Daily (open to close): %s, expected %s per trade", s.BestStrategy, pdfRet(s.ExpectedReturn)))
+	p.y -= 15
+	p.text(pdfMargin, p.y, 11, false, fmt.Sprintf("First 15 minutes (09:30–09:45): %s, expected %s per trade", s15.BestStrategy, pdfRet(s15.ExpectedReturn)))
+	p.y -= 24
+
+	p.heading("Summary")
+	p.table([]float64{0, 200, 330}, []string{"", "Daily", "0–15m"}, reportSummaryRows(resp))
+
+	binXs := []float64{0, 70, 105, 160, 235, 290, 355, 420}
+	header, daily, first15 := reportBinRows(resp)
+	p.heading("Daily bins")
+	p.table(binXs, header, daily)
+	header[4] = "Fill 09:45"
+	p.heading("0–15m bins")
+	p.table(binXs, header, first15)
+
+	if !resp.Success && resp.Error != "" {
+		p.text(pdfMargin, p.y, 9, true, "Note: "+resp.Error)
+	}
+	p.text(pdfMargin, pdfMargin-16, 7.5, false, "Recommendations are heuristic and for research only. Returns are idealized open-to-close and 09:30-to-09:45 trades before costs.")
+	return p.buf.Bytes()
+}
+
+// handleReportPDF serves /api/report.pdf with the /api/gaps parameters.
+func handleReportPDF(w http.ResponseWriter, r *http.Request) {
+	params, err := parseAnalyzeParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	resp, err := analyze(params)
+	if err != nil {
+		writeFetchError(w, err)
+		return
+	}
+	if !resp.Success && len(resp.Data) == 0 {
+		writeError(w, 502, resp.Error)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s_gap_report.pdf"`, resp.Ticker))
+	writePDF(w, reportContent(resp))
+}
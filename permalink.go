@@ -0,0 +1,66 @@
+// permalink.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ========================= Permalinks =========================
+
+// Every saved run has a short ID, the first 40 bits of the SHA-256 of its
+// run ID in lowercase base32, and /r/SHORTID serves the web UI with that
+// run's result already loaded: a link to send a colleague so they see
+// exactly the analysis you are looking at. The short ID is derived rather
+// than stored, so runs saved before permalinks existed have one too. The
+// page itself carries no data; the UI reads the run from /api/v1/runs, so
+// an API token still applies.
+
+var (
+	shortIDEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+	shortIDRE       = regexp.MustCompile(`^[a-z2-7]{8}$`)
+)
+
+// runShortID returns the permalink ID of run id.
+func runShortID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return strings.ToLower(shortIDEncoding.EncodeToString(sum[:5]))
+}
+
+// findRun returns the ID of the saved run whose short ID is short.
+func findRun(short string) (string, bool) {
+	ents, err := os.ReadDir(artifactsDir)
+	if err != nil {
+		return "", false
+	}
+	for _, e := range ents {
+		if e.IsDir() && runIDRE.MatchString(e.Name()) && runShortID(e.Name()) == short {
+			return e.Name(), true
+		}
+	}
+	return "", false
+}
+
+// handlePermalink serves the UI for /r/SHORTID, telling it which run to
+// load.
+func handlePermalink(w http.ResponseWriter, r *http.Request) {
+	short := strings.TrimPrefix(r.URL.Path, "/r/")
+	var id string
+	ok := artifactsDir != "" && shortIDRE.MatchString(short)
+	if ok {
+		id, ok = findRun(short)
+	}
+	if !ok {
+		http.Error(w, "unknown or deleted run", http.StatusNotFound)
+		return
+	}
+	b, _ := json.Marshal(id)
+	page := strings.Replace(indexHTML, "</head>", "<script>window.permalinkRun = "+string(b)+";</script>\n</head>", 1)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
@@ -0,0 +1,257 @@
+// portfolio.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Portfolio Backtest =========================
+
+// A portfolio run simulates every ticker with the same rules, then replays
+// the trades day by day against one account. On each day the largest gaps
+// are taken first, up to maxPositions; positions are intraday, so the day's
+// P&L is booked at the close and the next day sizes off the new equity.
+
+// Basket size limit; every ticker costs a daily fetch plus one minute fetch
+// per gap session.
+const maxPortfolioTickers = 25
+
+type TickerContribution struct {
+	Trades  int     `json:"trades"`
+	PnL     float64 `json:"pnl"`      // $
+	WinRate float64 `json:"win_rate"` // %
+}
+
+type PortfolioResult struct {
+	Side         string                        `json:"side"`
+	Dates        []string                      `json:"dates"`   // days with at least one position
+	Capital      []float64                     `json:"capital"` // $ equity after each day
+	Drawdown     []float64                     `json:"drawdown"`
+	Episodes     []gapcore.DrawdownEpisode     `json:"drawdown_episodes"`
+	Trades       []gapcore.Trade               `json:"trades"`
+	Stats        gapcore.TradeStats            `json:"stats"` // per trade, % of entry
+	Risk         gapcore.RiskStats             `json:"risk"`  // per day, % of equity
+	EndCapital   float64                       `json:"end_capital"`
+	TotalReturn  float64                       `json:"total_return"` // % on capital
+	MaxDrawdown  float64                       `json:"max_drawdown"` // % below peak equity
+	MaxPerDay    int                           `json:"max_per_day"`  // most positions held on one day
+	LimitSkipped int                           `json:"limit_skipped"`
+	ByTicker     map[string]TickerContribution `json:"by_ticker"`
+}
+
+type PortfolioResponse struct {
+	SchemaVersion schemaVersion          `json:"schema_version"`
+	Success       bool                   `json:"success"`
+	Error         string                 `json:"error,omitempty"`
+	Tickers       []string               `json:"tickers"`
+	Years         int                    `json:"years"`
+	MinGap        float64                `json:"min_gap"`
+	Config        gapcore.BacktestConfig `json:"config"`
+	Capital       float64                `json:"capital"`
+	MaxPositions  int                    `json:"max_positions"`
+	Failed        map[string]string      `json:"failed,omitempty"` // ticker → error
+	Results       []PortfolioResult      `json:"results"`
+}
+
+// parseTickers reads a comma-separated basket of at most limit tickers,
+// upper-cased and de-duplicated.
+func parseTickers(s string, limit int) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for _, t := range strings.Split(s, ",") {
+		t = strings.ToUpper(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		if !gapcore.StorableTicker(t) {
+			return nil, fmt.Errorf("%q: %v", t, errBadTicker)
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("tickers required")
+	}
+	if len(out) > limit {
+		return nil, fmt.Errorf("at most %d tickers", limit)
+	}
+	return out, nil
+}
+
+// runPortfolio books trades (already simulated per ticker) into one account.
+// Without a sizing model each position gets an equal 1/maxPositions share
+// of equity. Total notional per day is capped at maxIntradayLeverage × equity.
+func runPortfolio(side string, trades []gapcore.Trade, sz gapcore.Sizing, capital float64, maxPositions int) PortfolioResult {
+	res := PortfolioResult{Side: side, ByTicker: map[string]TickerContribution{}}
+	byDate := map[string][]gapcore.Trade{}
+	for _, t := range trades {
+		byDate[t.Date] = append(byDate[t.Date], t)
+	}
+	dates := make([]string, 0, len(byDate))
+	for d := range byDate {
+		dates = append(dates, d)
+	}
+	sort.Strings(dates)
+
+	equity := capital
+	var dayRets, rets []float64
+	wins := map[string]int{}
+	for _, d := range dates {
+		day := byDate[d]
+		sort.SliceStable(day, func(i, j int) bool { return math.Abs(day[i].GapPct) > math.Abs(day[j].GapPct) })
+		if len(day) > maxPositions {
+			res.LimitSkipped += len(day) - maxPositions
+			day = day[:maxPositions]
+		}
+		budget := equity * gapcore.MaxIntradayLeverage
+		var pnl float64
+		held := 0
+		for _, t := range day {
+			if sz.Model != "" {
+				t.Shares = sz.Shares(equity, t)
+			} else {
+				t.Shares = int(equity / float64(maxPositions) / t.EntryPrice)
+			}
+			if n := int(budget / t.EntryPrice); t.Shares > n {
+				t.Shares = n
+			}
+			if t.Shares <= 0 {
+				continue
+			}
+			notional := float64(t.Shares) * t.EntryPrice
+			budget -= notional
+			t.PnL = gapcore.Round2(notional * t.ReturnPct / 100)
+			pnl += t.PnL
+			held++
+
+			res.Trades = append(res.Trades, t)
+			rets = append(rets, t.ReturnPct)
+			c := res.ByTicker[t.Ticker]
+			c.Trades++
+			c.PnL = gapcore.Round2(c.PnL + t.PnL)
+			res.ByTicker[t.Ticker] = c
+			if t.ReturnPct > 0 {
+				wins[t.Ticker]++
+			}
+		}
+		if held == 0 {
+			continue
+		}
+		res.MaxPerDay = max(res.MaxPerDay, held)
+		dayRets = append(dayRets, pnl/equity*100)
+		equity += pnl
+		res.Dates = append(res.Dates, d)
+		res.Capital = append(res.Capital, gapcore.Round2(equity))
+		if equity <= 0 {
+			break
+		}
+	}
+	for tk, c := range res.ByTicker {
+		c.WinRate = gapcore.Rate(wins[tk], c.Trades)
+		res.ByTicker[tk] = c
+	}
+	res.Stats = gapcore.TradeStatsOf(rets)
+	res.Risk = gapcore.RiskStatsOf(dayRets, res.Dates)
+	res.Drawdown, res.Episodes = gapcore.Drawdowns(res.Capital, res.Dates, capital)
+	for _, v := range res.Drawdown {
+		res.MaxDrawdown = math.Max(res.MaxDrawdown, v)
+	}
+	res.EndCapital = gapcore.Round2(equity)
+	res.TotalReturn = gapcore.Round3((equity - capital) / capital * 100)
+	return res
+}
+
+func handlePortfolio(w http.ResponseWriter, r *http.Request) {
+	if err := applyPreset(r); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	params, err := parseAnalyzeValues(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tickers, err := basketParam(q, maxPortfolioTickers)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cfg, err := gapcore.ParseBacktestConfig(q, htbTickers)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cfg.Costs = params.Costs
+	if cfg.Sizing, err = gapcore.ParseSizing(q, cfg.Stop > 0 || cfg.StopPremarket || cfg.Trail > 0); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	capital := 100000.0
+	if cfg.Sizing.Model != "" {
+		capital = cfg.Sizing.Capital
+	} else if v := strings.TrimSpace(q.Get("capital")); v != "" {
+		if capital, err = strconv.ParseFloat(v, 64); err != nil || capital < 100 {
+			writeError(w, http.StatusBadRequest, "capital must be at least 100")
+			return
+		}
+	}
+	maxPositions := 5
+	if v := strings.TrimSpace(q.Get("maxPositions")); v != "" {
+		if maxPositions, err = strconv.Atoi(v); err != nil || maxPositions < 1 || maxPositions > 50 {
+			writeError(w, http.StatusBadRequest, "maxPositions must be between 1 and 50")
+			return
+		}
+	}
+
+	out := PortfolioResponse{
+		Success:      true,
+		Tickers:      tickers,
+		Years:        params.Years,
+		MinGap:       params.MinGap,
+		Config:       cfg,
+		Capital:      capital,
+		MaxPositions: maxPositions,
+		Failed:       map[string]string{},
+	}
+	bySide := map[string][]gapcore.Trade{}
+	for _, tk := range tickers {
+		p := params
+		p.Ticker = tk
+		points, minutesByDate, atr, analysisErr, err := gapcore.BacktestInputs(context.Background(), provider, p)
+		if err != nil || analysisErr != "" {
+			out.Failed[tk] = analysisErr
+			if err != nil {
+				out.Failed[tk] = err.Error()
+			}
+			continue
+		}
+		sessions, _ := gapcore.SessionBars(points, minutesByDate, atr)
+		c := cfg.ForTicker(tk)
+		for _, side := range cfg.Sides {
+			trades, _ := gapcore.SimulateSide(points, sessions, c, side)
+			for i := range trades {
+				trades[i].Ticker = tk
+			}
+			bySide[side] = append(bySide[side], trades...)
+		}
+	}
+	if len(out.Failed) == len(tickers) {
+		out.Success = false
+		out.Error = "no ticker could be simulated"
+	}
+	for _, side := range cfg.Sides {
+		out.Results = append(out.Results, runPortfolio(side, bySide[side], cfg.Sizing, capital, maxPositions))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,198 @@
+// presets.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Strategy Presets =========================
+
+// A preset is a named set of backtest query parameters (entry, exits,
+// filters, sizing, costs, …) kept in a JSON file on the server. Passing
+// preset=NAME to a backtest endpoint fills in every parameter the request
+// does not set itself, so a saved playbook can be re-run on any ticker.
+
+// File the presets are kept in; PRESETS_FILE overrides it.
+var presetsFile = "presets.json"
+
+// Guards presetsFile between concurrent requests.
+var presetsMu sync.Mutex
+
+var presetNameRE = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// Request parameters that are never part of a preset.
+var presetExcluded = map[string]bool{"name": true, "preset": true, "ticker": true, "tickers": true, "watchlist": true, "format": true,
+	"fields": true, "limit": true, "offset": true, "async": true}
+
+type Preset struct {
+	Name   string     `json:"name"`
+	Params url.Values `json:"params"`
+	Saved  string     `json:"saved"` // RFC3339
+}
+
+func loadPresets() (map[string]Preset, error) {
+	out := map[string]Preset{}
+	b, err := os.ReadFile(presetsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("%s: %w", presetsFile, err)
+	}
+	return out, nil
+}
+
+// savePresets replaces the file atomically so a crash never leaves it
+// half written.
+func savePresets(all map[string]Preset) error {
+	b, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := presetsFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, presetsFile)
+}
+
+// validatePreset parses params the way the backtest endpoints will, so a
+// preset that could never run is rejected when it is saved.
+func validatePreset(params url.Values) error {
+	if _, err := parseAnalyzeValues(params); err != nil {
+		return err
+	}
+	cfg, err := gapcore.ParseBacktestConfig(params, htbTickers)
+	if err != nil {
+		return err
+	}
+	if _, err := gapcore.ParseSizing(params, cfg.Stop > 0 || cfg.StopPremarket || cfg.Trail > 0); err != nil {
+		return err
+	}
+	if _, err := gapcore.ParseExits(params, cfg.Entry); err != nil {
+		return err
+	}
+	_, err = gapcore.ParseScaleSchemes(params, cfg.Units)
+	return err
+}
+
+// applyPreset merges the preset named by preset=NAME into r's query; values
+// the request sets itself win. Without preset it does nothing.
+func applyPreset(r *http.Request) error {
+	q := r.URL.Query()
+	name := q.Get("preset")
+	if name == "" {
+		return nil
+	}
+	presetsMu.Lock()
+	all, err := loadPresets()
+	presetsMu.Unlock()
+	if err != nil {
+		return err
+	}
+	p, ok := all[name]
+	if !ok {
+		return fmt.Errorf("unknown preset %q", name)
+	}
+	for k, vs := range p.Params {
+		if _, set := q[k]; !set {
+			q[k] = vs
+		}
+	}
+	r.URL.RawQuery = q.Encode()
+	return nil
+}
+
+// handlePresets lists presets (GET), returns one (GET ?name=), saves the
+// request's other query parameters under name (POST), or deletes one
+// (DELETE ?name=).
+func handlePresets(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("name")
+	if r.Method != http.MethodGet || name != "" {
+		if !presetNameRE.MatchString(name) {
+			writeError(w, http.StatusBadRequest, "name must be 1-64 letters, digits, '.', '_' or '-'")
+			return
+		}
+	}
+	presetsMu.Lock()
+	defer presetsMu.Unlock()
+	all, err := loadPresets()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var out any
+	switch r.Method {
+	case http.MethodGet:
+		if name != "" {
+			p, ok := all[name]
+			if !ok {
+				writeError(w, http.StatusNotFound, fmt.Sprintf("unknown preset %q", name))
+				return
+			}
+			out = p
+			break
+		}
+		list := make([]Preset, 0, len(all))
+		for _, p := range all {
+			list = append(list, p)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		out = list
+	case http.MethodPost:
+		params := url.Values{}
+		for k, vs := range q {
+			if !presetExcluded[k] {
+				params[k] = vs
+			}
+		}
+		if len(params) == 0 {
+			writeError(w, http.StatusBadRequest, "no parameters to save")
+			return
+		}
+		if err := validatePreset(params); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		p := Preset{Name: name, Params: params, Saved: time.Now().UTC().Format(time.RFC3339)}
+		all[name] = p
+		if err := savePresets(all); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = p
+	case http.MethodDelete:
+		if _, ok := all[name]; !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("unknown preset %q", name))
+			return
+		}
+		delete(all, name)
+		if err := savePresets(all); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = map[string]string{"deleted": name}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,32 @@
+// quality.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Data Quality =========================
+
+// /api/quality audits the bars behind a ticker's statistics over the same
+// lookback and minimum gap as /api/gaps: missing sessions, zero-volume
+// days, gap sessions without minute data, and suspected bad prints, with
+// the share of sessions that came through clean.
+
+func handleQuality(w http.ResponseWriter, r *http.Request) {
+	params, err := parseAnalyzeParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	out, err := gapcore.Audit(context.Background(), provider, params)
+	if err != nil {
+		writeFetchError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
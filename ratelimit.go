@@ -0,0 +1,163 @@
+// ratelimit.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========================= Rate Limiting =========================
+
+// Each /api/gaps call can cost hundreds of Polygon requests, so a runaway
+// script can burn the quota in minutes. Every call that fetches from the
+// provider is metered per client — its API token when auth is on, its IP
+// otherwise — by a token bucket holding RATE_BURST calls and refilled at
+// RATE_LIMIT per minute; an empty bucket answers 429 with Retry-After. A
+// WebSocket connection is charged once more for each analysis it starts.
+
+const (
+	defaultRateLimit = 30 // calls per minute
+	defaultRateBurst = 10
+
+	// Buckets idle (and full) this long are dropped.
+	rateIdle = 10 * time.Minute
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	perSec  float64
+	burst   float64
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	return &rateLimiter{
+		perSec:  float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: map[string]*bucket{},
+		swept:   time.Now(),
+	}
+}
+
+// allow takes one call from key's bucket. It returns the calls left, or
+// how long until the next one is allowed.
+func (l *rateLimiter) allow(key string) (int, time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.swept) > rateIdle {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > rateIdle {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.perSec)
+	b.last = now
+	if b.tokens < 1 {
+		return 0, time.Duration((1 - b.tokens) / l.perSec * float64(time.Second)), false
+	}
+	b.tokens--
+	return int(b.tokens), 0, true
+}
+
+// meteredPaths are the routes that fetch from the provider; each also
+// covers the routes under it (/gaps.csv, /backtest/grid).
+var meteredPaths = []string{
+	apiPrefix + "/gaps", apiPrefix + "/report.pdf", apiPrefix + "/model",
+	apiPrefix + "/backtest", apiPrefix + "/compare", apiPrefix + "/scan",
+	apiPrefix + "/today", apiPrefix + "/dashboard", apiPrefix + "/morning",
+	apiPrefix + "/quality", apiPrefix + "/alerts/evaluate", apiPrefix + "/notify/report",
+	apiPrefix + "/graphql", "/ws", grpcAnalyzePath,
+}
+
+// rateLimited reports whether r is metered: a metered route, or a job
+// submission (POST /jobs runs any of them).
+func rateLimited(r *http.Request) bool {
+	path := r.URL.Path
+	if path == apiPrefix+"/gaps/events" {
+		return false // follows a job already charged
+	}
+	if path == apiPrefix+"/jobs" {
+		return r.Method == http.MethodPost
+	}
+	for _, p := range meteredPaths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+type rateChargeKey struct{}
+
+// chargeRate takes one more call from the bucket of the client behind ctx,
+// for a request that fetches again after it was let in (a WebSocket
+// connection starting an analysis). It returns the wait when the bucket is
+// empty; without a limiter it always allows.
+func chargeRate(ctx context.Context) (time.Duration, bool) {
+	charge, _ := ctx.Value(rateChargeKey{}).(func() (time.Duration, bool))
+	if charge == nil {
+		return 0, true
+	}
+	return charge()
+}
+
+// clientKey identifies r's client: its valid API token, else its IP.
+func clientKey(r *http.Request, tokens []string) string {
+	if t := requestToken(r); len(tokens) > 0 && validToken(tokens, t) {
+		return "token:" + t
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// withRateLimit wraps next with l on the metered paths (l nil: no limit).
+func withRateLimit(next http.Handler, l *rateLimiter, tokens []string) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rateLimited(r) || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := clientKey(r, tokens)
+		left, wait, ok := l.allow(key)
+		w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%g/min", l.perSec*60))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(left))
+		if !ok {
+			secs := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			writeError(w, http.StatusTooManyRequests, fmt.Sprintf("rate limit exceeded, retry in %ds", secs))
+			return
+		}
+		charge := func() (time.Duration, bool) {
+			_, wait, ok := l.allow(key)
+			return wait, ok
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rateChargeKey{}, charge)))
+	})
+}
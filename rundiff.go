@@ -0,0 +1,271 @@
+// rundiff.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Run Diff =========================
+
+// /api/v1/runs/diff sets two saved runs of a ticker side by side — from,
+// the earlier, and to, the later — and reports how the later one moved:
+// the change in the summaries, the change per bin, the sessions it added
+// or no longer has, and the bins whose recommendation flipped. Runs a month
+// apart show how the edge drifts. Runs with different parameters can be
+// diffed too; params_changed says which differ, since those deltas mix
+// drift with the change of setup.
+
+// RunDelta is one figure in both runs and its change (to - from).
+type RunDelta struct {
+	From   float64 `json:"from"`
+	To     float64 `json:"to"`
+	Change float64 `json:"change"`
+}
+
+func runDelta(from, to float64) RunDelta {
+	return RunDelta{From: from, To: to, Change: gapcore.Round3(to - from)}
+}
+
+type SummaryDiff struct {
+	Sessions         RunDelta `json:"sessions"`
+	ContinuationRate RunDelta `json:"continuation_rate"`
+	FadeAvg          RunDelta `json:"fade_avg"`
+	FollowAvg        RunDelta `json:"follow_avg"`
+	ExpectedReturn   RunDelta `json:"expected_return"`
+	BestFrom         string   `json:"best_strategy_from"`
+	BestTo           string   `json:"best_strategy_to"`
+}
+
+type BinDiff struct {
+	Label            string   `json:"label"`
+	Count            RunDelta `json:"count"`
+	ContinuationRate RunDelta `json:"continuation_rate"`
+	FadeAvg          RunDelta `json:"fade_avg"`
+	FollowAvg        RunDelta `json:"follow_avg"`
+	RecFrom          string   `json:"recommendation_from"`
+	RecTo            string   `json:"recommendation_to"`
+	Flipped          bool     `json:"flipped"`
+}
+
+// RecommendationFlip is a bin whose recommendation changed.
+type RecommendationFlip struct {
+	Horizon string `json:"horizon"` // daily | 15m
+	Label   string `json:"label"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+}
+
+type RunDiffResponse struct {
+	SchemaVersion   schemaVersion        `json:"schema_version"`
+	Ticker          string               `json:"ticker"`
+	From            RunInfo              `json:"from"`
+	To              RunInfo              `json:"to"`
+	ParamsChanged   []string             `json:"params_changed"`
+	Summary         SummaryDiff          `json:"summary"`
+	Summary15       SummaryDiff          `json:"summary_15m"`
+	Bins            []BinDiff            `json:"bins"`
+	Bins15          []BinDiff            `json:"bins_15m"`
+	NewSessions     []string             `json:"new_sessions"`     // in to, not from
+	DroppedSessions []string             `json:"dropped_sessions"` // in from, not to (aged out of the lookback)
+	Flips           []RecommendationFlip `json:"recommendation_flips"`
+}
+
+// readRunResult reads run id's config and result; the status is the HTTP
+// status of the error.
+func readRunResult(id string) (RunInfo, gapcore.AnalyzeResponse, int, error) {
+	var resp gapcore.AnalyzeResponse
+	if !runIDRE.MatchString(id) {
+		return RunInfo{}, resp, http.StatusBadRequest, fmt.Errorf("%q is not a run ID, as /api/v1/runs lists them", id)
+	}
+	info, err := readRun(id)
+	if err != nil {
+		return info, resp, http.StatusNotFound, fmt.Errorf("unknown run %q", id)
+	}
+	b, err := os.ReadFile(filepath.Join(artifactsDir, id, "result.json"))
+	if err != nil {
+		return info, resp, http.StatusNotFound, fmt.Errorf("run %q has no result", id)
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return info, resp, http.StatusInternalServerError, fmt.Errorf("run %q: %v", id, err)
+	}
+	return info, resp, 0, nil
+}
+
+// diffRuns compares run from with the later run to.
+func diffRuns(fromInfo RunInfo, from gapcore.AnalyzeResponse, toInfo RunInfo, to gapcore.AnalyzeResponse) RunDiffResponse {
+	out := RunDiffResponse{
+		Ticker:        toInfo.Ticker,
+		From:          fromInfo,
+		To:            toInfo,
+		ParamsChanged: paramsChanged(fromInfo.runConfig, toInfo.runConfig),
+		Summary: SummaryDiff{
+			Sessions:         runDelta(float64(from.Summary.Sessions), float64(to.Summary.Sessions)),
+			ContinuationRate: runDelta(from.Summary.ContinuationRate, to.Summary.ContinuationRate),
+			FadeAvg:          runDelta(from.Summary.FadeAvg, to.Summary.FadeAvg),
+			FollowAvg:        runDelta(from.Summary.FollowAvg, to.Summary.FollowAvg),
+			ExpectedReturn:   runDelta(from.Summary.ExpectedReturn, to.Summary.ExpectedReturn),
+			BestFrom:         from.Summary.BestStrategy,
+			BestTo:           to.Summary.BestStrategy,
+		},
+		Summary15: SummaryDiff{
+			Sessions:         runDelta(float64(from.Summary15.Sessions), float64(to.Summary15.Sessions)),
+			ContinuationRate: runDelta(from.Summary15.ContinuationRate, to.Summary15.ContinuationRate),
+			FadeAvg:          runDelta(from.Summary15.FadeAvg, to.Summary15.FadeAvg),
+			FollowAvg:        runDelta(from.Summary15.FollowAvg, to.Summary15.FollowAvg),
+			ExpectedReturn:   runDelta(from.Summary15.ExpectedReturn, to.Summary15.ExpectedReturn),
+			BestFrom:         from.Summary15.BestStrategy,
+			BestTo:           to.Summary15.BestStrategy,
+		},
+		NewSessions:     []string{},
+		DroppedSessions: []string{},
+		Flips:           []RecommendationFlip{},
+	}
+
+	type cell struct {
+		count              int
+		cont, fade, follow float64
+		rec                string
+	}
+	bins := func(b []gapcore.BinStat) ([]string, map[string]cell) {
+		var labels []string
+		m := map[string]cell{}
+		for _, s := range b {
+			labels = append(labels, s.Label)
+			m[s.Label] = cell{s.Count, s.ContinuationRate, s.FadeAvg, s.FollowAvg, s.Recommendation}
+		}
+		return labels, m
+	}
+	bins15 := func(b []gapcore.BinStat15) ([]string, map[string]cell) {
+		var labels []string
+		m := map[string]cell{}
+		for _, s := range b {
+			labels = append(labels, s.Label)
+			m[s.Label] = cell{s.Count, s.ContinuationRate, s.FadeAvg, s.FollowAvg, s.Recommendation}
+		}
+		return labels, m
+	}
+	diff := func(horizon string, fromLabels []string, fromCells map[string]cell, toLabels []string, toCells map[string]cell) []BinDiff {
+		// The later run's bins in order, then any only the earlier had.
+		labels := toLabels
+		for _, l := range fromLabels {
+			if _, ok := toCells[l]; !ok {
+				labels = append(labels, l)
+			}
+		}
+		rows := []BinDiff{}
+		for _, l := range labels {
+			a, b := fromCells[l], toCells[l]
+			for _, c := range []*cell{&a, &b} {
+				if c.rec == "" {
+					c.rec = "NEUTRAL"
+				}
+			}
+			d := BinDiff{
+				Label:            l,
+				Count:            runDelta(float64(a.count), float64(b.count)),
+				ContinuationRate: runDelta(a.cont, b.cont),
+				FadeAvg:          runDelta(a.fade, b.fade),
+				FollowAvg:        runDelta(a.follow, b.follow),
+				RecFrom:          a.rec,
+				RecTo:            b.rec,
+				Flipped:          a.rec != b.rec,
+			}
+			if d.Flipped {
+				out.Flips = append(out.Flips, RecommendationFlip{Horizon: horizon, Label: l, From: a.rec, To: b.rec})
+			}
+			rows = append(rows, d)
+		}
+		return rows
+	}
+	fl, fc := bins(from.Bins)
+	tl, tc := bins(to.Bins)
+	out.Bins = diff("daily", fl, fc, tl, tc)
+	fl, fc = bins15(from.Bins15)
+	tl, tc = bins15(to.Bins15)
+	out.Bins15 = diff("15m", fl, fc, tl, tc)
+
+	had := map[string]bool{}
+	for _, p := range from.Data {
+		had[p.Date] = true
+	}
+	has := map[string]bool{}
+	for _, p := range to.Data {
+		has[p.Date] = true
+		if !had[p.Date] {
+			out.NewSessions = append(out.NewSessions, p.Date)
+		}
+	}
+	for _, p := range from.Data {
+		if !has[p.Date] {
+			out.DroppedSessions = append(out.DroppedSessions, p.Date)
+		}
+	}
+	return out
+}
+
+// paramsChanged names the settings that differ between two runs.
+func paramsChanged(a, b runConfig) []string {
+	out := []string{}
+	eq := func(x, y any) bool {
+		bx, _ := json.Marshal(x)
+		by, _ := json.Marshal(y)
+		return string(bx) == string(by)
+	}
+	for _, f := range []struct {
+		name string
+		x, y any
+	}{
+		{"years", a.Years, b.Years},
+		{"min_gap", a.MinGap, b.MinGap},
+		{"bins", a.Bins, b.Bins},
+		{"winsorize", a.Winsorize, b.Winsorize},
+		{"walk_forward", a.WalkForward, b.WalkForward},
+		{"resample", a.Resample, b.Resample},
+		{"costs", a.Costs, b.Costs},
+	} {
+		if !eq(f.x, f.y) {
+			out = append(out, f.name)
+		}
+	}
+	return out
+}
+
+// handleRunDiff diffs saved runs ?from= and ?to= of one ticker.
+func handleRunDiff(w http.ResponseWriter, r *http.Request) {
+	if artifactsDir == "" {
+		writeError(w, http.StatusNotFound, "no run history: ARTIFACTS_DIR is not set")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("from") == "" || q.Get("to") == "" {
+		writeError(w, http.StatusBadRequest, "from and to run IDs required")
+		return
+	}
+	fromInfo, from, status, err := readRunResult(q.Get("from"))
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	toInfo, to, status, err := readRunResult(q.Get("to"))
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	if fromInfo.Ticker != toInfo.Ticker {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("runs are of different tickers (%s, %s)", fromInfo.Ticker, toInfo.Ticker))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diffRuns(fromInfo, from, toInfo, to))
+}
@@ -0,0 +1,136 @@
+// runs.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ========================= Run History =========================
+
+// /api/v1/runs reads back the analyses saved as run artifacts, so last
+// month's study can be revisited without refetching or recomputing it.
+// A run's ID is its directory name under ARTIFACTS_DIR; the list is built
+// from each run's config.json, and fetching a run returns its result.json
+// as saved. Without ARTIFACTS_DIR nothing is saved, so there is no history.
+
+var runIDRE = regexp.MustCompile(`^[0-9]{8}-[0-9]{6}-[A-Za-z0-9._:-]+$`)
+
+// RunInfo is one saved run as listed by /api/v1/runs.
+type RunInfo struct {
+	ID string `json:"id"`
+	runConfig
+	Result    string `json:"result,omitempty"`    // result URL; absent for a failed run
+	Permalink string `json:"permalink,omitempty"` // UI link (see permalink.go); absent for a failed run
+}
+
+// listRuns returns the saved runs, newest first, optionally only ticker's.
+func listRuns(ticker string) ([]RunInfo, error) {
+	ents, err := os.ReadDir(artifactsDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []RunInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := []RunInfo{}
+	for _, e := range ents {
+		if !e.IsDir() || !runIDRE.MatchString(e.Name()) {
+			continue
+		}
+		info, err := readRun(e.Name())
+		if err != nil || ticker != "" && info.Ticker != ticker {
+			continue
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID > out[j].ID })
+	return out, nil
+}
+
+// readRun reads the config.json of run id.
+func readRun(id string) (RunInfo, error) {
+	info := RunInfo{ID: id}
+	b, err := os.ReadFile(filepath.Join(artifactsDir, id, "config.json"))
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(b, &info.runConfig); err != nil {
+		return info, err
+	}
+	if info.Error == "" {
+		info.Result = apiPrefix + "/runs?id=" + id
+		info.Permalink = "/r/" + runShortID(id)
+	}
+	return info, nil
+}
+
+// handleRuns lists saved runs (GET, optionally ?ticker=), returns one
+// run's result (GET ?id=), or deletes a run (DELETE ?id=).
+func handleRuns(w http.ResponseWriter, r *http.Request) {
+	if artifactsDir == "" {
+		writeError(w, http.StatusNotFound, "no run history: ARTIFACTS_DIR is not set")
+		return
+	}
+	q := r.URL.Query()
+	id := q.Get("id")
+	if r.Method != http.MethodGet || id != "" {
+		if !runIDRE.MatchString(id) {
+			writeError(w, http.StatusBadRequest, "id must be a run ID, as /api/v1/runs lists them")
+			return
+		}
+		if _, err := os.Stat(filepath.Join(artifactsDir, id)); err != nil {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("unknown run %q", id))
+			return
+		}
+	}
+
+	var out any
+	switch r.Method {
+	case http.MethodGet:
+		if id == "" {
+			runs, err := listRuns(strings.ToUpper(strings.TrimSpace(q.Get("ticker"))))
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			out = runs
+			break
+		}
+		b, err := os.ReadFile(filepath.Join(artifactsDir, id, "result.json"))
+		if errors.Is(err, os.ErrNotExist) {
+			msg := fmt.Sprintf("run %q has no result", id)
+			if info, err := readRun(id); err == nil && info.Error != "" {
+				msg += ": " + info.Error
+			}
+			writeError(w, http.StatusNotFound, msg)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+		return
+	case http.MethodDelete:
+		if err := os.RemoveAll(filepath.Join(artifactsDir, id)); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		out = map[string]string{"deleted": id}
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,268 @@
+// scan.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Market Scanner =========================
+
+// The scanner reads Polygon's grouped daily bars (every US stock for one
+// session in a single request) for a session and the one before it, and
+// ranks the tickers by their open gap. Each hit links to /api/gaps so the
+// list feeds straight into the per-ticker analysis.
+
+// Calendar days searched back for the previous session (weekends, holidays).
+const scanLookback = 7
+
+// Grouped results carry the ticker in "T"; "t" (the timestamp) must have its
+// own field or encoding/json would match it to Ticker case-insensitively.
+type groupedBar struct {
+	Ticker string  `json:"T"`
+	Time   int64   `json:"t"`
+	O      float64 `json:"o"`
+	C      float64 `json:"c"`
+	V      float64 `json:"v"`
+}
+
+type ScanHit struct {
+	Ticker         string  `json:"ticker"`
+	GapPct         float64 `json:"gap_pct"`
+	Direction      int     `json:"direction"`
+	Open           float64 `json:"open"`
+	PrevClose      float64 `json:"prev_close"`
+	Close          float64 `json:"close"`
+	DailyReturnPct float64 `json:"daily_return_pct"`
+	Volume         float64 `json:"volume"`
+	Analyze        string  `json:"analyze"` // /api/gaps link for this ticker
+}
+
+type ScanResponse struct {
+	SchemaVersion schemaVersion `json:"schema_version"`
+	Success       bool          `json:"success"`
+	Date          string        `json:"date"`
+	PrevDate      string        `json:"prev_date"`
+	MinGap        float64       `json:"min_gap"`
+	Side          string        `json:"side"`
+	MinPrice      float64       `json:"min_price"`
+	MaxPrice      float64       `json:"max_price,omitempty"`
+	MinVolume     float64       `json:"min_volume"`
+	Watchlist     string        `json:"watchlist,omitempty"`
+	Matched       int           `json:"matched"` // before limit
+	Hits          []ScanHit     `json:"hits"`    // largest |gap| first
+}
+
+func fetchPolygonGrouped(date string) ([]groupedBar, error) {
+	url := fmt.Sprintf(
+		"https://api.polygon.io/v2/aggs/grouped/locale/us/market/stocks/%s?adjusted=false&apiKey=%s",
+		date, polygon.APIKey,
+	)
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, gapcore.NewProviderError(resp)
+	}
+	var pr struct {
+		Results []groupedBar `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+		return nil, err
+	}
+	return pr.Results, nil
+}
+
+// groupedBefore walks back from date (exclusive when skip is set) to the
+// nearest session with grouped bars.
+func groupedBefore(date time.Time, skip bool) (string, []groupedBar, error) {
+	from := date.Format("2006-01-02")
+	if skip {
+		date = date.AddDate(0, 0, -1)
+	}
+	for i := 0; i < scanLookback; i, date = i+1, date.AddDate(0, 0, -1) {
+		if wd := date.Weekday(); wd == time.Saturday || wd == time.Sunday {
+			continue
+		}
+		d := date.Format("2006-01-02")
+		bars, err := fetchPolygonGrouped(d)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(bars) > 0 {
+			return d, bars, nil
+		}
+	}
+	return "", nil, fmt.Errorf("no session found within %d days of %s", scanLookback, from)
+}
+
+// scanGaps ranks the session's gaps against the previous closes.
+func scanGaps(cur, prev []groupedBar, p scanParams) []ScanHit {
+	prevClose := make(map[string]float64, len(prev))
+	for _, b := range prev {
+		prevClose[b.Ticker] = b.C
+	}
+	var hits []ScanHit
+	for _, b := range cur {
+		if p.only != nil && !p.only[b.Ticker] {
+			continue
+		}
+		pc := prevClose[b.Ticker]
+		if pc <= 0 || b.O <= 0 || b.O < p.MinPrice || (p.MaxPrice > 0 && b.O > p.MaxPrice) || b.V < p.MinVolume {
+			continue
+		}
+		gap := (b.O - pc) / pc * 100
+		dir := gapcore.Sign(gap)
+		if math.Abs(gap) < p.MinGap || (p.Side == "up" && dir < 0) || (p.Side == "down" && dir > 0) {
+			continue
+		}
+		hits = append(hits, ScanHit{
+			Ticker:         b.Ticker,
+			GapPct:         gapcore.Round3(gap),
+			Direction:      dir,
+			Open:           b.O,
+			PrevClose:      pc,
+			Close:          b.C,
+			DailyReturnPct: gapcore.Round3((b.C - b.O) / b.O * 100),
+			Volume:         b.V,
+			Analyze:        apiPrefix + "/gaps?ticker=" + url.QueryEscape(b.Ticker),
+		})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := math.Abs(hits[i].GapPct), math.Abs(hits[j].GapPct)
+		if a != b {
+			return a > b
+		}
+		return hits[i].Ticker < hits[j].Ticker
+	})
+	return hits
+}
+
+// scanParams are the scanner's query parameters.
+type scanParams struct {
+	Date      string // YYYY-MM-DD; empty: latest session
+	MinGap    float64
+	Side      string // up | down | both
+	MinPrice  float64
+	MaxPrice  float64 // 0: no cap
+	MinVolume float64
+	Limit     int
+	Watchlist string          // restrict hits to this list
+	only      map[string]bool // its tickers
+}
+
+func parseScanParams(q url.Values) (scanParams, error) {
+	p := scanParams{MinGap: 2, Side: "both", MinPrice: 5, MinVolume: 500000, Limit: 50}
+	if v := strings.TrimSpace(q.Get("date")); v != "" {
+		if _, err := time.Parse("2006-01-02", v); err != nil {
+			return p, fmt.Errorf("date must be YYYY-MM-DD")
+		}
+		p.Date = v
+	}
+	for _, f := range []struct {
+		key      string
+		dst      *float64
+		min, max float64
+	}{
+		{"minGap", &p.MinGap, 0.1, 100},
+		{"minPrice", &p.MinPrice, 0, 1e5},
+		{"maxPrice", &p.MaxPrice, 0, 1e5},
+		{"minVolume", &p.MinVolume, 0, 1e10},
+	} {
+		if v := strings.TrimSpace(q.Get(f.key)); v != "" {
+			x, err := strconv.ParseFloat(v, 64)
+			if err != nil || x < f.min || x > f.max {
+				return p, fmt.Errorf("%s must be between %g and %g", f.key, f.min, f.max)
+			}
+			*f.dst = x
+		}
+	}
+	switch s := strings.ToLower(strings.TrimSpace(q.Get("side"))); s {
+	case "":
+	case "up", "down", "both":
+		p.Side = s
+	default:
+		return p, fmt.Errorf("side must be up, down, or both")
+	}
+	if v := strings.TrimSpace(q.Get("limit")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			return p, fmt.Errorf("limit must be between 1 and 500")
+		}
+		p.Limit = n
+	}
+	if p.Watchlist = q.Get("watchlist"); p.Watchlist != "" {
+		tickers, err := watchlistTickers(p.Watchlist)
+		if err != nil {
+			return p, err
+		}
+		p.only = map[string]bool{}
+		for _, t := range tickers {
+			p.only[t] = true
+		}
+	}
+	return p, nil
+}
+
+// scan runs the scanner. The error is a failed or empty grouped fetch.
+func scan(p scanParams) (ScanResponse, error) {
+	out := ScanResponse{
+		Success:   true,
+		Date:      p.Date,
+		MinGap:    p.MinGap,
+		Side:      p.Side,
+		MinPrice:  p.MinPrice,
+		MaxPrice:  p.MaxPrice,
+		MinVolume: p.MinVolume,
+		Watchlist: p.Watchlist,
+	}
+	var cur []groupedBar
+	var err error
+	if p.Date != "" {
+		if cur, err = fetchPolygonGrouped(p.Date); err == nil && len(cur) == 0 {
+			err = fmt.Errorf("no grouped bars for %s (not a session, or not published yet)", p.Date)
+		}
+	} else {
+		out.Date, cur, err = groupedBefore(time.Now().In(gapcore.NewYork), false)
+	}
+	if err != nil {
+		return out, err
+	}
+	day, _ := time.Parse("2006-01-02", out.Date)
+	var prev []groupedBar
+	if out.PrevDate, prev, err = groupedBefore(day, true); err != nil {
+		return out, err
+	}
+	out.Hits = scanGaps(cur, prev, p)
+	out.Matched = len(out.Hits)
+	if len(out.Hits) > p.Limit {
+		out.Hits = out.Hits[:p.Limit]
+	}
+	return out, nil
+}
+
+func handleScan(w http.ResponseWriter, r *http.Request) {
+	p, err := parseScanParams(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	out, err := scan(p)
+	if err != nil {
+		writeFetchError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
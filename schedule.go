@@ -0,0 +1,222 @@
+// schedule.go
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Scheduler =========================
+
+// gap-analyzer schedule runs the jobs in SCHEDULE_FILE (schedule.json) on
+// their cron expressions until it is stopped, so a morning scan needs no
+// crontab:
+//
+//	[
+//	  {"name": "premarket-scan", "cron": "45 8 * * mon-fri", "kind": "scan",
+//	   "query": "minGap=3&side=up", "notify": "notify-send gaps \"$GAP_RESULT\""},
+//	  {"name": "tsla", "cron": "30 16 * * fri", "kind": "gaps", "query": "ticker=TSLA&years=5"}
+//	]
+//
+// A job is one of the API's reports, taking the endpoint's query
+// parameters: gaps, scan, today, or backtest — or alerts, which evaluates
+// the alert rules. Each run's result is saved as
+// SCHEDULE_DIR/NAME/YYYYMMDD-HHMM.json (runs/ by default, New York time),
+// and the job's notify command, if any, runs afterwards with GAP_JOB,
+// GAP_STATUS (ok | failed), GAP_ERROR, and GAP_RESULT (the saved file) in
+// its environment.
+
+var (
+	scheduleFile = "schedule.json"
+	scheduleDir  = "runs"
+)
+
+// scheduleKinds are the report jobs and the handlers that produce them.
+var scheduleKinds = map[string]http.HandlerFunc{
+	"gaps":     handleAnalyze,
+	"scan":     handleScan,
+	"today":    handleToday,
+	"backtest": handleBacktest,
+}
+
+type ScheduleJob struct {
+	Name   string `json:"name"`
+	Cron   string `json:"cron"`             // minute hour day month weekday, New York time
+	Kind   string `json:"kind"`             // gaps | scan | today | backtest | alerts
+	Query  string `json:"query,omitempty"`  // the endpoint's query parameters
+	Notify string `json:"notify,omitempty"` // shell command run after each run
+
+	spec  cronSpec
+	query url.Values
+}
+
+// loadSchedule reads and checks the job file.
+func loadSchedule(path string) ([]ScheduleJob, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []ScheduleJob
+	if err := json.Unmarshal(b, &jobs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for i := range jobs {
+		j := &jobs[i]
+		if !presetNameRE.MatchString(j.Name) || seen[j.Name] {
+			return nil, fmt.Errorf("%s: job %d: name must be unique, 1-64 letters, digits, '.', '_' or '-'", path, i+1)
+		}
+		seen[j.Name] = true
+		if j.spec, err = parseCron(j.Cron); err != nil {
+			return nil, fmt.Errorf("%s: job %s: %v", path, j.Name, err)
+		}
+		if _, ok := scheduleKinds[j.Kind]; !ok && j.Kind != "alerts" {
+			return nil, fmt.Errorf("%s: job %s: kind must be gaps, scan, today, backtest, or alerts", path, j.Name)
+		}
+		if j.query, err = url.ParseQuery(j.Query); err != nil {
+			return nil, fmt.Errorf("%s: job %s: query: %v", path, j.Name, err)
+		}
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("%s: no jobs", path)
+	}
+	return jobs, nil
+}
+
+// runScheduledJob runs j once and returns the file its result was saved in.
+func runScheduledJob(j ScheduleJob, at time.Time) (string, error) {
+	dir := filepath.Join(scheduleDir, j.Name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	ext := ".json"
+	if strings.EqualFold(j.query.Get("format"), "csv") {
+		ext = ".csv"
+	}
+	path := filepath.Join(dir, at.In(gapcore.NewYork).Format("20060102-1504")+ext)
+	if j.Kind == "alerts" {
+		added, failed, err := evaluateAlerts()
+		if err != nil {
+			return "", err
+		}
+		return path, writeJSONOutput(path, struct {
+			Fired  []Alert           `json:"fired"`
+			Failed map[string]string `json:"failed,omitempty"`
+		}{added, failed})
+	}
+	q := url.Values{}
+	for k, v := range j.query {
+		q[k] = append([]string(nil), v...)
+	}
+	if err := runHandler(scheduleKinds[j.Kind], q, path, false); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// notifyJob runs j's notify command with the run's outcome.
+func notifyJob(j ScheduleJob, path string, runErr error) error {
+	status, msg := "ok", ""
+	if runErr != nil {
+		status, msg = "failed", runErr.Error()
+	}
+	cmd := exec.Command("sh", "-c", j.Notify)
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/c", j.Notify)
+	}
+	cmd.Env = append(os.Environ(), "GAP_JOB="+j.Name, "GAP_STATUS="+status, "GAP_ERROR="+msg, "GAP_RESULT="+path)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	return cmd.Run()
+}
+
+// runJob runs j, logs the outcome, and notifies.
+func runJob(j ScheduleJob, at time.Time) {
+	path, err := runScheduledJob(j, at)
+	if err != nil {
+		log.Printf("schedule: %s failed: %v", j.Name, err)
+	} else {
+		log.Printf("schedule: %s wrote %s", j.Name, path)
+	}
+	if j.Notify != "" {
+		if err := notifyJob(j, path, err); err != nil {
+			log.Printf("schedule: %s: notify: %v", j.Name, err)
+		}
+	}
+}
+
+func runScheduleCmd(args []string) error {
+	fs := flag.NewFlagSet("schedule", flag.ContinueOnError)
+	file := fs.String("file", "", "job file (overrides SCHEDULE_FILE; default schedule.json)")
+	dir := fs.String("dir", "", "directory results are saved under (overrides SCHEDULE_DIR; default runs)")
+	apiKey := fs.String("apikey", "", "Polygon.io API key (overrides .env)")
+	only := fs.String("run", "", "run this job once now and exit")
+	fs.Usage = commandUsage(fs, "schedule")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	for _, s := range []struct {
+		dst       *string
+		flag, env string
+	}{{&scheduleFile, *file, "SCHEDULE_FILE"}, {&scheduleDir, *dir, "SCHEDULE_DIR"}} {
+		if s.flag != "" {
+			*s.dst = s.flag
+		} else if v := os.Getenv(s.env); v != "" {
+			*s.dst = v
+		}
+	}
+	if err := setPolygonKey(*apiKey); err != nil {
+		return err
+	}
+	jobs, err := loadSchedule(scheduleFile)
+	if err != nil {
+		return configError(err)
+	}
+
+	if *only != "" {
+		for _, j := range jobs {
+			if j.Name == *only {
+				path, err := runScheduledJob(j, time.Now())
+				if j.Notify != "" {
+					if nerr := notifyJob(j, path, err); nerr != nil && err == nil {
+						err = fmt.Errorf("notify: %v", nerr)
+					}
+				}
+				if err == nil {
+					notef("wrote %s", path)
+				}
+				return err
+			}
+		}
+		return fmt.Errorf("no job %q in %s", *only, scheduleFile)
+	}
+
+	for _, j := range jobs {
+		now := time.Now().In(gapcore.NewYork)
+		next := j.spec.next(now)
+		if next.IsZero() {
+			return fmt.Errorf("job %s: cron %q never runs", j.Name, j.Cron)
+		}
+		log.Printf("schedule: %s (%s) next runs %s", j.Name, j.Kind, next.Format("Mon 2006-01-02 15:04 MST"))
+		go func(j ScheduleJob) {
+			for {
+				next := j.spec.next(time.Now().In(gapcore.NewYork))
+				time.Sleep(time.Until(next))
+				runJob(j, next)
+			}
+		}(j)
+	}
+	select {}
+}
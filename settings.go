@@ -0,0 +1,213 @@
+// settings.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= User Settings =========================
+
+// Settings are a user's analysis defaults (lookback, minimum gap, bins,
+// walk-forward windows) and the UI's theme, kept in a JSON file by user
+// name so they follow the user between the browser and the CLI. The web UI
+// loads them on start and fills in its controls; the CLI's analyze and tui
+// use them for every parameter not given as a flag. Bins are process-wide
+// (GAP_BINS) on a server, so only the CLI applies a user's bins. The user
+// is "default" unless ?user= (API), the UI's stored name, or
+// GAP_ANALYZER_USER (CLI) says otherwise.
+
+// File the settings are kept in; SETTINGS_FILE overrides it.
+var settingsFile = "settings.json"
+
+// Guards settingsFile between concurrent requests.
+var settingsMu sync.Mutex
+
+const defaultSettingsUser = "default"
+
+var settingsThemes = []string{"dark", "light"}
+
+type Settings struct {
+	User        string    `json:"user"`
+	Years       int       `json:"years,omitempty"`
+	MinGap      float64   `json:"min_gap,omitempty"`
+	Bins        []float64 `json:"bins,omitempty"` // bin edges, as GAP_BINS
+	TrainMonths int       `json:"train_months,omitempty"`
+	StepMonths  int       `json:"step_months,omitempty"`
+	Theme       string    `json:"theme,omitempty"`
+	Updated     string    `json:"updated,omitempty"` // RFC3339
+}
+
+func loadSettings() (map[string]Settings, error) {
+	out := map[string]Settings{}
+	b, err := os.ReadFile(settingsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("%s: %w", settingsFile, err)
+	}
+	return out, nil
+}
+
+// saveSettings replaces the file atomically, like savePresets.
+func saveSettings(all map[string]Settings) error {
+	b, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := settingsFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, settingsFile)
+}
+
+// updateSettings sets each setting q has (years, minGap, bins,
+// trainMonths, stepMonths, theme) on s; an empty value clears it.
+func updateSettings(s *Settings, q url.Values) error {
+	for _, f := range []struct {
+		key    string
+		lo, hi int
+		dst    *int
+	}{{"years", 1, 5, &s.Years}, {"trainMonths", 1, 48, &s.TrainMonths}, {"stepMonths", 1, 12, &s.StepMonths}} {
+		if !q.Has(f.key) {
+			continue
+		}
+		*f.dst = 0
+		if v := strings.TrimSpace(q.Get(f.key)); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < f.lo || n > f.hi {
+				return fmt.Errorf("%s must be %d-%d", f.key, f.lo, f.hi)
+			}
+			*f.dst = n
+		}
+	}
+	if q.Has("minGap") {
+		s.MinGap = 0
+		if v := strings.TrimSpace(q.Get("minGap")); v != "" {
+			x, err := strconv.ParseFloat(v, 64)
+			if err != nil || x <= 0 || x >= 20 {
+				return errors.New("minGap must be above 0 and below 20")
+			}
+			s.MinGap = x
+		}
+	}
+	if q.Has("bins") {
+		s.Bins = nil
+		if v := strings.TrimSpace(q.Get("bins")); v != "" {
+			edges, err := parseBinEdges(v)
+			if err != nil {
+				return fmt.Errorf("bins %v", err)
+			}
+			s.Bins = edges
+		}
+	}
+	if q.Has("theme") {
+		s.Theme = strings.ToLower(strings.TrimSpace(q.Get("theme")))
+		if s.Theme != "" && !contains(settingsThemes, s.Theme) {
+			return fmt.Errorf("theme must be one of %s", strings.Join(settingsThemes, ", "))
+		}
+	}
+	return nil
+}
+
+// handleSettings returns a user's settings (GET ?user=), changes the ones
+// the request sets (PUT), or clears them all (DELETE).
+func handleSettings(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	user := q.Get("user")
+	if user == "" {
+		user = defaultSettingsUser
+	}
+	if !presetNameRE.MatchString(user) {
+		writeError(w, http.StatusBadRequest, "user must be 1-64 letters, digits, '.', '_' or '-'")
+		return
+	}
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	all, err := loadSettings()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s, exists := all[user]
+	s.User = user
+
+	var out any
+	switch r.Method {
+	case http.MethodGet:
+		out = s
+	case http.MethodPut:
+		if err := updateSettings(&s, q); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.Updated = time.Now().UTC().Format(time.RFC3339)
+		all[user], out = s, s
+		if err := saveSettings(all); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	case http.MethodDelete:
+		if exists {
+			delete(all, user)
+			if err := saveSettings(all); err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		out = map[string]string{"deleted": user}
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// applyUserSettings fills the analysis parameters q lacks from the
+// settings of GAP_ANALYZER_USER (or the default user), and sets their bins
+// unless GAP_BINS is set. A missing settings file changes nothing.
+func applyUserSettings(q url.Values) error {
+	user := os.Getenv("GAP_ANALYZER_USER")
+	if user == "" {
+		user = defaultSettingsUser
+	}
+	all, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	s, ok := all[user]
+	if !ok {
+		return nil
+	}
+	for key, v := range map[string]string{
+		"years":       strconv.Itoa(s.Years),
+		"minGap":      strconv.FormatFloat(s.MinGap, 'f', -1, 64),
+		"trainMonths": strconv.Itoa(s.TrainMonths),
+		"stepMonths":  strconv.Itoa(s.StepMonths),
+	} {
+		if v != "0" && !q.Has(key) {
+			q.Set(key, v)
+		}
+	}
+	if len(s.Bins) > 0 && os.Getenv("GAP_BINS") == "" {
+		gapcore.BinEdges = s.Bins
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// sse.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= SSE Progress Stream =========================
+
+// For clients that cannot open a WebSocket, /api/gaps?async=1 submits the
+// analysis as a job (see jobs.go) and answers at once with its ID. The
+// progress of any job is then a server-sent event stream at
+// /api/gaps/events?id=…: the same progress events as /ws, then a final
+// result (or error) event. Events carry their index as the SSE id, so a
+// reconnecting EventSource resumes where it left off.
+
+func writeSSE(w http.ResponseWriter, id int, event string, v any) {
+	b, _ := json.Marshal(v)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, b)
+}
+
+func handleGapsEvents(w http.ResponseWriter, r *http.Request) {
+	j := lookupJob(r.URL.Query().Get("id"))
+	if j == nil {
+		writeError(w, http.StatusNotFound, "unknown or expired request id")
+		return
+	}
+	// Resume after the last event the client saw.
+	next := 0
+	if n, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil && n >= 0 {
+		next = n + 1
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	for {
+		j.mu.Lock()
+		var events []gapcore.ProgressEvent
+		if next < len(j.events) {
+			events = append(events, j.events[next:]...)
+		}
+		done, info, result, notify := j.done(), j.info, j.result, j.notify
+		j.mu.Unlock()
+
+		for _, ev := range events {
+			writeSSE(w, next, "progress", ev)
+			next++
+		}
+		switch {
+		case !done:
+		case info.Status == "done":
+			writeSSE(w, next, "result", result)
+		case info.Status == "canceled":
+			writeSSE(w, next, "error", map[string]string{"error": "job canceled"})
+		default:
+			writeSSE(w, next, "error", map[string]string{"error": info.Error})
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if done {
+			return
+		}
+		select {
+		case <-notify:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
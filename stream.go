@@ -0,0 +1,370 @@
+// stream.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========================= Polygon Stream =========================
+
+// With POLYGON_STREAM set, the server holds a WebSocket to Polygon's stocks
+// feed (realtime, or delayed 15 minutes for plans without realtime data)
+// subscribed to the trades and quotes of every watchlist ticker. Their last
+// trade, bid, and ask are kept in memory, and /api/v1/today, live mode on
+// /ws, and the premarket dashboard price a watchlist ticker's gap from the
+// streamed trade whenever it is newer than the snapshot's, instead of
+// waiting for the next poll. The watchlists are reread every minute and
+// the subscriptions follow them. A dropped connection is redialed with
+// backoff; meanwhile prices come from snapshots as without the stream.
+
+// Feeds POLYGON_STREAM selects.
+var streamFeeds = map[string]string{
+	"realtime": "wss://socket.polygon.io/stocks",
+	"delayed":  "wss://delayed.polygon.io/stocks",
+}
+
+const (
+	// How often the subscriptions are matched to the watchlists.
+	streamResubscribe = time.Minute
+	// Silence after which the connection is taken for dead; pings keep a
+	// quiet feed talking.
+	streamTimeout = 90 * time.Second
+	streamPing    = 30 * time.Second
+	// Largest feed message read; the feed batches events into arrays.
+	maxStreamMessage = 8 << 20
+	// Longest wait before redialing.
+	maxStreamBackoff = 2 * time.Minute
+)
+
+// streamQuote is a ticker's latest streamed trade and quote.
+type streamQuote struct {
+	Price     float64 // last trade
+	TradeTime int64   // ns epoch
+	Bid, Ask  float64
+	QuoteTime int64 // ns epoch
+}
+
+// polygonStream is the feed connection and what it has received.
+type polygonStream struct {
+	url string
+
+	mu         sync.Mutex
+	conn       *wsConn // nil while disconnected
+	subscribed map[string]bool
+	quotes     map[string]streamQuote
+}
+
+// quoteStream is the server's feed; nil unless POLYGON_STREAM is set.
+var quoteStream *polygonStream
+
+// setQuoteStream reads POLYGON_STREAM: realtime, delayed, or a feed URL.
+func setQuoteStream() error {
+	v := strings.TrimSpace(os.Getenv("POLYGON_STREAM"))
+	if v == "" || v == "off" {
+		return nil
+	}
+	u, ok := streamFeeds[v]
+	if !ok {
+		p, err := url.Parse(v)
+		if err != nil || (p.Scheme != "wss" && p.Scheme != "ws") || p.Host == "" {
+			return fmt.Errorf("POLYGON_STREAM must be realtime, delayed, or a ws(s):// URL, got %q", v)
+		}
+		u = v
+	}
+	quoteStream = &polygonStream{url: u, quotes: map[string]streamQuote{}}
+	return nil
+}
+
+// run keeps the feed connected and subscribed until the process exits.
+func (s *polygonStream) run() {
+	go s.followWatchlists()
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := s.session()
+		if time.Since(start) > maxStreamBackoff {
+			backoff = time.Second // it had been up a while
+		}
+		log.Printf("stream: %v; reconnecting in %s", err, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxStreamBackoff)
+	}
+}
+
+// session dials the feed, authenticates, subscribes, and reads events
+// until the connection fails.
+func (s *polygonStream) session() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	c, err := dialWS(ctx, s.url)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+	c.limit, c.timeout = maxStreamMessage, streamTimeout
+
+	if err := c.writeJSON(map[string]string{"action": "auth", "params": polygon.APIKey}); err != nil {
+		return err
+	}
+	// The feed greets with "connected", then answers the auth.
+	for authed := false; !authed; {
+		msg, err := c.readMessage()
+		if err != nil {
+			return err
+		}
+		var evs []struct {
+			Ev      string `json:"ev"`
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(msg, &evs); err != nil {
+			return fmt.Errorf("feed: %v", err)
+		}
+		for _, ev := range evs {
+			switch ev.Status {
+			case "auth_success":
+				authed = true
+			case "auth_failed":
+				return fmt.Errorf("feed refused the API key: %s", ev.Message)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.conn, s.subscribed = c, map[string]bool{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+	}()
+	log.Printf("stream: connected to %s", s.url)
+	if err := s.resubscribe(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		t := time.NewTicker(streamPing)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				c.writeFrame(wsPing, nil)
+			}
+		}
+	}()
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			return err
+		}
+		s.handle(msg)
+	}
+}
+
+// handle records the trades and quotes in a feed message.
+func (s *polygonStream) handle(msg []byte) {
+	var evs []struct {
+		Ev     string  `json:"ev"`
+		Sym    string  `json:"sym"`
+		Price  float64 `json:"p"`
+		Bid    float64 `json:"bp"`
+		Ask    float64 `json:"ap"`
+		T      int64   `json:"t"` // ms epoch
+		Status string  `json:"status"`
+		Msg    string  `json:"message"`
+	}
+	if err := json.Unmarshal(msg, &evs); err != nil {
+		log.Printf("stream: %v", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ev := range evs {
+		q := s.quotes[ev.Sym]
+		ts := ev.T * int64(time.Millisecond)
+		switch ev.Ev {
+		case "T":
+			if ev.Price > 0 && ts >= q.TradeTime {
+				q.Price, q.TradeTime = ev.Price, ts
+			}
+		case "Q":
+			if ts >= q.QuoteTime {
+				q.Bid, q.Ask, q.QuoteTime = ev.Bid, ev.Ask, ts
+			}
+		case "status":
+			if ev.Status != "success" {
+				log.Printf("stream: %s: %s", ev.Status, ev.Msg)
+			}
+			continue
+		default:
+			continue
+		}
+		s.quotes[ev.Sym] = q
+	}
+}
+
+// followWatchlists matches the subscriptions to the watchlists every
+// streamResubscribe.
+func (s *polygonStream) followWatchlists() {
+	for range time.Tick(streamResubscribe) {
+		if err := s.resubscribe(); err != nil {
+			log.Printf("stream: %v", err)
+		}
+	}
+}
+
+// resubscribe subscribes to the watchlist tickers not yet subscribed and
+// unsubscribes from those no longer on any list, dropping their quotes.
+func (s *polygonStream) resubscribe() error {
+	tickers, err := allWatchlistTickers()
+	if err != nil {
+		return err
+	}
+	want := map[string]bool{}
+	for _, tk := range tickers {
+		want[tk] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil // subscribed on connecting
+	}
+	var add, drop []string
+	for tk := range want {
+		if !s.subscribed[tk] {
+			add = append(add, "T."+tk, "Q."+tk)
+		}
+	}
+	for tk := range s.subscribed {
+		if !want[tk] {
+			drop = append(drop, "T."+tk, "Q."+tk)
+			delete(s.quotes, tk)
+		}
+	}
+	sort.Strings(add)
+	sort.Strings(drop)
+	for _, a := range []struct {
+		action  string
+		channel []string
+	}{{"subscribe", add}, {"unsubscribe", drop}} {
+		if len(a.channel) == 0 {
+			continue
+		}
+		if err := s.conn.writeJSON(map[string]string{"action": a.action, "params": strings.Join(a.channel, ",")}); err != nil {
+			return err
+		}
+	}
+	s.subscribed = want
+	return nil
+}
+
+// quote returns ticker's latest streamed trade and quote.
+func (s *polygonStream) quote(ticker string) (streamQuote, bool) {
+	if s == nil {
+		return streamQuote{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.quotes[ticker]
+	return q, ok
+}
+
+// connected reports whether the feed is up.
+func (s *polygonStream) connected() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn != nil
+}
+
+// streamed updates a snapshot with the streamed trade when that is newer,
+// returning the streamed bid and ask (zero without a streamed quote).
+func streamed(st *polygonTickerSnapshot) (bid, ask float64) {
+	q, ok := quoteStream.quote(st.Ticker)
+	if !ok {
+		return 0, 0
+	}
+	if q.Price > 0 && q.TradeTime > st.LastTrade.T {
+		st.LastTrade.P, st.LastTrade.T = q.Price, q.TradeTime
+	}
+	return q.Bid, q.Ask
+}
+
+// dialWS opens a client WebSocket to a ws:// or wss:// URL.
+func dialWS(ctx context.Context, rawURL string) (*wsConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), map[string]string{"ws": "80", "wss": "443"}[u.Scheme])
+	}
+	var conn net.Conn
+	switch u.Scheme {
+	case "wss":
+		conn, err = (&tls.Dialer{Config: &tls.Config{ServerName: u.Hostname()}}).DialContext(ctx, "tcp", host)
+	case "ws":
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("%s: not a WebSocket URL", rawURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
+	req.Host = u.Host
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	if err := req.Write(rw); err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(rw.Reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		conn.Close()
+		return nil, fmt.Errorf("%s: WebSocket handshake refused (%s)", u.Host, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return &wsConn{conn: conn, rw: rw, client: true}, nil
+}
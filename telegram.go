@@ -0,0 +1,391 @@
+// telegram.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Telegram Bot =========================
+
+// With TELEGRAM_BOT_TOKEN set, the server also runs as a Telegram bot,
+// long-polling for messages. Sending "TSLA 0.5" (a ticker, optionally a
+// minGap and any analysis key=value) answers with the analysis's
+// recommendation, summary, and daily bins, as the TUI shows them;
+// /today, /morning, /subscribe, and /unsubscribe cover today's setup and
+// the morning reports. A subscribed chat is a notification sink of type
+// telegram (url tg:CHATID), so it gets its watchlist's alerts and morning
+// report like any other sink, and the API can add one too. Only the chats
+// in TELEGRAM_CHATS are answered, since every analysis spends API quota;
+// any other chat is told its ID, to add it.
+
+// Bot API base URL.
+var telegramAPI = "https://api.telegram.org"
+
+// Telegram messages hold 4,096 characters; the table gets what the title
+// and note leave.
+const telegramTextLimit = 4096
+
+// Longest wait of one getUpdates long poll.
+const telegramPoll = 50 * time.Second
+
+const telegramHelp = `<b>Gap Analyzer</b>
+<code>TSLA</code> — TSLA's gap analysis: recommendation, summary, and daily bins
+<code>TSLA 0.5</code> — the same with min gap 0.5%; add key=value for other parameters, e.g. <code>TSLA 1 years=5</code>
+/today TSLA — today's gap, its bin, and the recommended play
+/morning WATCHLIST [minGap] — the watchlist's gappers now
+/subscribe WATCHLIST [minGap] — this chat gets the watchlist's morning report and alerts
+/unsubscribe — stop them`
+
+var (
+	telegramToken string
+	telegramChats map[int64]bool
+)
+
+var telegramClient = &http.Client{Timeout: telegramPoll + 15*time.Second}
+
+// setTelegram reads TELEGRAM_BOT_TOKEN and TELEGRAM_CHATS, the
+// comma-separated chat IDs the bot answers.
+func setTelegram() error {
+	telegramToken = strings.TrimSpace(os.Getenv("TELEGRAM_BOT_TOKEN"))
+	telegramChats = map[int64]bool{}
+	for _, f := range strings.FieldsFunc(os.Getenv("TELEGRAM_CHATS"), func(r rune) bool { return r == ',' || r == ' ' }) {
+		id, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return fmt.Errorf("TELEGRAM_CHATS: %q is not a chat ID", f)
+		}
+		telegramChats[id] = true
+	}
+	return nil
+}
+
+// telegramCall calls Bot API method with a JSON body into out, returning
+// the HTTP status.
+func telegramCall(ctx context.Context, method string, body, out any) (int, error) {
+	if telegramToken == "" {
+		return 0, errors.New("TELEGRAM_BOT_TOKEN is not set")
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telegramAPI+"/bot"+telegramToken+"/"+method, bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := telegramClient.Do(req)
+	if err != nil {
+		// The URL holds the token; keep it out of logs.
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var r struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return resp.StatusCode, fmt.Errorf("telegram %s: %s", method, resp.Status)
+	}
+	if !r.OK {
+		return resp.StatusCode, fmt.Errorf("telegram %s: %s", method, r.Description)
+	}
+	if out != nil {
+		return resp.StatusCode, json.Unmarshal(r.Result, out)
+	}
+	return resp.StatusCode, nil
+}
+
+// telegramSend posts an HTML message to chat.
+func telegramSend(ctx context.Context, chat string, text string) (int, error) {
+	return telegramCall(ctx, "sendMessage", map[string]any{
+		"chat_id":                  chat,
+		"text":                     text,
+		"parse_mode":               "HTML",
+		"disable_web_page_preview": true,
+	}, nil)
+}
+
+// telegramChat returns the chat ID of a tg:CHATID URL.
+func telegramChat(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err == nil && u.Scheme == "tg" {
+		if _, err := strconv.ParseInt(u.Opaque, 10, 64); err == nil {
+			return u.Opaque, nil
+		}
+	}
+	return "", fmt.Errorf("url must be tg:CHATID")
+}
+
+// telegram sends one delivery attempt, returning the HTTP status (0 when
+// the request failed) and whether another attempt may succeed.
+func (s NotifySink) telegram(n notification) (int, bool, error) {
+	chat, err := telegramChat(s.URL)
+	if err != nil {
+		return 0, false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyClient.Timeout)
+	defer cancel()
+	status, err := telegramSend(ctx, chat, telegramText(n))
+	retry := err != nil && (status == 0 || status == http.StatusTooManyRequests || status >= 500)
+	if telegramToken == "" {
+		retry = false
+	}
+	return status, retry, err
+}
+
+// telegramText renders a notification as a Telegram HTML message.
+func telegramText(n notification) string {
+	m := chatLayout(n)
+	title, note := "<b>"+html.EscapeString(m.title)+"</b>", "<i>"+html.EscapeString(m.note)+"</i>"
+	for i, l := range m.table {
+		m.table[i] = html.EscapeString(l)
+	}
+	text := title
+	if t := m.fenced("<pre>", "</pre>", telegramTextLimit-len([]rune(title+note))-2); t != "" {
+		text += "\n" + t
+	}
+	return text + "\n" + note
+}
+
+// runTelegramBot answers the bot's messages until the process exits.
+func runTelegramBot() {
+	var offset int64
+	backoff := time.Second
+	for {
+		var updates []struct {
+			UpdateID int64 `json:"update_id"`
+			Message  *struct {
+				Chat struct {
+					ID int64 `json:"id"`
+				} `json:"chat"`
+				Text string `json:"text"`
+			} `json:"message"`
+		}
+		_, err := telegramCall(context.Background(), "getUpdates", map[string]any{
+			"offset":          offset,
+			"timeout":         int(telegramPoll / time.Second),
+			"allowed_updates": []string{"message"},
+		}, &updates)
+		if err != nil {
+			log.Printf("telegram: %v; retrying in %s", err, backoff)
+			time.Sleep(backoff)
+			backoff = min(backoff*2, time.Minute)
+			continue
+		}
+		backoff = time.Second
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if m := u.Message; m != nil && m.Text != "" {
+				go telegramReply(m.Chat.ID, m.Text)
+			}
+		}
+	}
+}
+
+// telegramReply answers one message.
+func telegramReply(chatID int64, text string) {
+	chat := strconv.FormatInt(chatID, 10)
+	reply := func(s string) {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyClient.Timeout)
+		defer cancel()
+		if _, err := telegramSend(ctx, chat, s); err != nil {
+			log.Printf("telegram: %s: %v", chat, err)
+		}
+	}
+	if !telegramChats[chatID] {
+		reply(fmt.Sprintf("This chat isn't allowed. Add its ID, <code>%s</code>, to TELEGRAM_CHATS.", chat))
+		return
+	}
+	f := strings.Fields(text)
+	if len(f) == 0 {
+		return
+	}
+	cmd, _, _ := strings.Cut(strings.ToLower(f[0]), "@") // /cmd@BotName in groups
+	args := f[1:]
+	switch cmd {
+	case "/start", "/help", "?":
+		reply(telegramHelp)
+	case "/today":
+		if len(args) == 0 {
+			reply("Usage: /today TICKER [minGap]")
+			return
+		}
+		params, err := telegramParams(args)
+		if err != nil {
+			reply(html.EscapeString(err.Error()))
+			return
+		}
+		out, err := todaySetup(params)
+		if err != nil {
+			reply(html.EscapeString(err.Error()))
+			return
+		}
+		reply(telegramSetup(out))
+	case "/morning", "/subscribe":
+		if len(args) == 0 || len(args) > 2 {
+			reply("Usage: " + cmd + " WATCHLIST [minGap]")
+			return
+		}
+		q := url.Values{"type": {"telegram"}, "url": {"tg:" + chat}, "watchlist": {args[0]}}
+		if len(args) == 2 {
+			q.Set("minGap", args[1])
+		}
+		s, err := parseSink("tg"+chat, q)
+		if err != nil {
+			reply(html.EscapeString(err.Error()))
+			return
+		}
+		if cmd == "/subscribe" {
+			if err := saveTelegramSink(s, false); err != nil {
+				reply(html.EscapeString(err.Error()))
+				return
+			}
+			reply(fmt.Sprintf("Subscribed: %s's morning report (gaps of at least %g%%) at %s ET on trading days, and its alerts.", html.EscapeString(s.Watchlist), s.reportGap(), reportAt))
+			return
+		}
+		rep, err := morningReport(context.Background(), s.Watchlist, s.reportGap())
+		if err != nil {
+			reply(html.EscapeString(err.Error()))
+			return
+		}
+		reply(telegramText(notification{Event: "report", Report: &rep}))
+	case "/unsubscribe":
+		if err := saveTelegramSink(NotifySink{Name: "tg" + chat}, true); err != nil {
+			reply(html.EscapeString(err.Error()))
+			return
+		}
+		reply("Unsubscribed.")
+	default:
+		if strings.HasPrefix(cmd, "/") {
+			reply(telegramHelp)
+			return
+		}
+		params, err := telegramParams(f)
+		if err != nil {
+			reply(html.EscapeString(err.Error()) + "\nSend /help for the commands.")
+			return
+		}
+		reply(fmt.Sprintf("Analyzing %s…", params.Ticker))
+		resp, err := analyze(params)
+		if err != nil {
+			reply(html.EscapeString(err.Error()))
+			return
+		}
+		reply(telegramAnalysis(resp))
+	}
+}
+
+// telegramParams reads "TICKER [minGap] [key=value …]" over the user
+// settings, as the TUI reads its lines.
+func telegramParams(f []string) (gapcore.Params, error) {
+	q := url.Values{}
+	if err := applyUserSettings(q); err != nil {
+		return gapcore.Params{}, err
+	}
+	for i, tok := range f {
+		k, v, ok := strings.Cut(tok, "=")
+		switch {
+		case ok:
+			q.Set(k, v)
+		case i == 0:
+			q.Set("ticker", strings.ToUpper(tok))
+		case i == 1:
+			q.Set("minGap", tok)
+		default:
+			return gapcore.Params{}, fmt.Errorf("%q: want key=value", tok)
+		}
+	}
+	return parseAnalyzeValues(q)
+}
+
+// saveTelegramSink saves a chat's sink, or deletes it.
+func saveTelegramSink(s NotifySink, remove bool) error {
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+	st, err := loadNotify()
+	if err != nil {
+		return err
+	}
+	if remove {
+		if _, ok := st.Sinks[s.Name]; !ok {
+			return errors.New("this chat isn't subscribed")
+		}
+		delete(st.Sinks, s.Name)
+	} else {
+		s.Created = time.Now().UTC().Format(time.RFC3339)
+		st.Sinks[s.Name] = s
+	}
+	return saveNotify(st)
+}
+
+// telegramAnalysis renders an analysis as the TUI's daily view.
+func telegramAnalysis(r gapcore.AnalyzeResponse) string {
+	var b strings.Builder
+	s, s15 := r.Summary, r.Summary15
+	fmt.Fprintf(&b, "<b>%s</b> · %d year(s) · min gap %.2f%% · %d sessions\n", html.EscapeString(r.Ticker), r.Years, r.MinGap, s.Sessions)
+	fmt.Fprintf(&b, "Daily: <b>%s</b>, expected %s per trade\n", s.BestStrategy, pdfRet(s.ExpectedReturn))
+	fmt.Fprintf(&b, "First 15 min: <b>%s</b>, expected %s per trade\n", s15.BestStrategy, pdfRet(s15.ExpectedReturn))
+
+	var tables bytes.Buffer
+	writeTextTable(&tables, false, []string{"", "Daily", "0–15m"}, reportSummaryRows(r))
+	header, daily, _ := reportBinRows(r)
+	keep := []int{0, 1, 2, 5, 6, 7} // Bin, n, Cont., Fade avg, Follow avg, Rec.
+	pick := func(row []string) []string {
+		out := make([]string, len(keep))
+		for i, k := range keep {
+			out[i] = row[k]
+		}
+		return out
+	}
+	var bins [][]string
+	for _, row := range daily {
+		bins = append(bins, pick(row))
+	}
+	tables.WriteString("\n")
+	writeTextTable(&tables, false, pick(header), bins)
+	fmt.Fprintf(&b, "<pre>%s</pre>", html.EscapeString(truncateRunes(tables.String(), telegramTextLimit-b.Len()-200)))
+	if !r.Success && r.Error != "" {
+		fmt.Fprintf(&b, "\n<i>%s</i>", html.EscapeString(r.Error))
+	}
+	return b.String()
+}
+
+// telegramSetup renders a /today answer.
+func telegramSetup(t TodayResponse) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>%s</b> gapping %+.2f%% (%.2f vs %.2f close, %s)\n", html.EscapeString(t.Ticker), t.GapPct, t.Price, t.PrevClose, t.PriceSource)
+	if t.Bin == "" {
+		fmt.Fprintf(&b, "Below min gap %.2f%%: no play.", t.MinGap)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "Bin %s: <b>%s</b> → %s\n", html.EscapeString(t.Bin), t.Recommendation, t.Action)
+	if bs := t.BinStats; bs != nil {
+		fmt.Fprintf(&b, "%d sessions · continuation %s · fade %s · follow %s\n", bs.Count, pdfPct(bs.ContinuationRate), pdfRet(bs.FadeAvg), pdfRet(bs.FollowAvg))
+	}
+	if t.BinStats15 != nil {
+		fmt.Fprintf(&b, "First 15 min: <b>%s</b> → %s\n", t.Recommendation15, t.Action15)
+	}
+	if t.RecommendationWhy != "" {
+		fmt.Fprintf(&b, "<i>%s</i>", html.EscapeString(t.RecommendationWhy))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
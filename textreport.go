@@ -0,0 +1,133 @@
+// textreport.go
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Text Report =========================
+
+// analyze -format table prints the PDF report's content — recommendation,
+// summary, daily and 0–15m bins — as aligned plain-text tables for reading
+// in a terminal, and -format markdown as Markdown tables to paste into a
+// trading journal. Both use the report's rounding and labels.
+
+var textFormats = []string{"json", "table", "markdown"}
+
+// writeTextReport writes resp as format (table or markdown) to path, or to
+// stdout if path is "".
+func writeTextReport(path, format string, resp gapcore.AnalyzeResponse) error {
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	md := format == "markdown"
+	s, s15 := resp.Summary, resp.Summary15
+	title := resp.Ticker + " gap report"
+	sub := fmt.Sprintf("%d year(s) · min gap %.2f%% · %d sessions", resp.Years, resp.MinGap, s.Sessions)
+	if md {
+		fmt.Fprintf(bw, "# %s\n\n_%s_\n", title, sub)
+	} else {
+		fmt.Fprintf(bw, "%s\n%s\n", title, sub)
+	}
+
+	section := func(name string) {
+		if md {
+			fmt.Fprintf(bw, "\n## %s\n\n", name)
+		} else {
+			fmt.Fprintf(bw, "\n%s\n", name)
+		}
+	}
+	section("Recommendation")
+	for _, line := range []string{
+		fmt.Sprintf("Daily (open to close): %s, expected %s per trade", s.BestStrategy, pdfRet(s.ExpectedReturn)),
+		fmt.Sprintf("First 15 minutes (09:30–09:45): %s, expected %s per trade", s15.BestStrategy, pdfRet(s15.ExpectedReturn)),
+	} {
+		if md {
+			fmt.Fprintf(bw, "- %s\n", line)
+		} else {
+			fmt.Fprintf(bw, "  %s\n", line)
+		}
+	}
+
+	section("Summary")
+	writeTextTable(bw, md, []string{"", "Daily", "0–15m"}, reportSummaryRows(resp))
+	header, daily, first15 := reportBinRows(resp)
+	section("Daily bins")
+	writeTextTable(bw, md, header, daily)
+	header[4] = "Fill 09:45"
+	section("0–15m bins")
+	writeTextTable(bw, md, header, first15)
+
+	if !resp.Success && resp.Error != "" {
+		fmt.Fprintf(bw, "\nNote: %s\n", resp.Error)
+	}
+	return bw.Flush()
+}
+
+// writeTextTable writes one table: columns padded to their widest cell,
+// the first left-aligned and the rest right-aligned, or a Markdown table
+// aligned the same way.
+func writeTextTable(w io.Writer, md bool, header []string, rows [][]string) {
+	widths := make([]int, len(header))
+	if md {
+		for i := range widths {
+			widths[i] = 3 // a rule needs ---
+		}
+	}
+	for _, r := range append([][]string{header}, rows...) {
+		for i, c := range r {
+			widths[i] = max(widths[i], utf8.RuneCountInString(c))
+		}
+	}
+	line := func(r []string) {
+		cells := make([]string, len(r))
+		for i, c := range r {
+			pad := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(c))
+			if i == 0 {
+				cells[i] = c + pad
+			} else {
+				cells[i] = pad + c
+			}
+		}
+		if md {
+			fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | "))
+		} else {
+			fmt.Fprintf(w, "  %s\n", strings.TrimRight(strings.Join(cells, "  "), " "))
+		}
+	}
+	line(header)
+	if md {
+		rule := make([]string, len(header))
+		for i, n := range widths {
+			if i == 0 {
+				rule[i] = strings.Repeat("-", n+2)
+			} else {
+				rule[i] = strings.Repeat("-", n+1) + ":"
+			}
+		}
+		fmt.Fprintf(w, "|%s|\n", strings.Join(rule, "|"))
+	} else {
+		rule := make([]string, len(header))
+		for i, n := range widths {
+			rule[i] = strings.Repeat("-", n)
+		}
+		fmt.Fprintf(w, "  %s\n", strings.Join(rule, "  "))
+	}
+	for _, r := range rows {
+		line(r)
+	}
+}
@@ -0,0 +1,321 @@
+// today.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Today's Setup =========================
+
+// /api/today prices the current gap from Polygon's ticker snapshot — the
+// official open once the session has started, the last trade before that —
+// and looks it up in the historical bin tables, turning the bin's
+// recommendation into a concrete long/short call for today's direction.
+
+type polygonSnapshot struct {
+	Ticker polygonTickerSnapshot `json:"ticker"`
+}
+
+type polygonTickerSnapshot struct {
+	Ticker string `json:"ticker"`
+	Day    struct {
+		O float64 `json:"o"`
+	} `json:"day"`
+	PrevDay struct {
+		C float64 `json:"c"`
+	} `json:"prevDay"`
+	LastTrade struct {
+		P float64 `json:"p"`
+		T int64   `json:"t"` // ns epoch
+	} `json:"lastTrade"`
+	Min struct {
+		C float64 `json:"c"`
+	} `json:"min"`
+	Updated int64 `json:"updated"` // ns epoch
+}
+
+type TodayResponse struct {
+	SchemaVersion schemaVersion `json:"schema_version"`
+	Success       bool          `json:"success"`
+	Error         string        `json:"error,omitempty"`
+	Ticker        string        `json:"ticker"`
+	Years         int           `json:"years"`
+	MinGap        float64       `json:"min_gap"`
+	AsOf          string        `json:"as_of"` // snapshot time, RFC3339 in New York
+	Price         float64       `json:"price"`
+	PriceSource   string        `json:"price_source"` // open | last_trade | last_minute
+	PrevClose     float64       `json:"prev_close"`
+	GapPct        float64       `json:"gap_pct"`
+	Direction     int           `json:"direction"`
+	Bid           float64       `json:"bid,omitempty"` // streamed quote (POLYGON_STREAM), watchlist tickers only
+	Ask           float64       `json:"ask,omitempty"`
+	Bin           string        `json:"bin,omitempty"` // empty when |gap| < minGap
+
+	BinStats          *gapcore.BinStat   `json:"bin_stats,omitempty"`
+	BinStats15        *gapcore.BinStat15 `json:"bin_stats_15m,omitempty"`
+	Recommendation    string             `json:"recommendation"` // FOLLOW | FADE | NEUTRAL
+	RecommendationWhy string             `json:"recommendation_why"`
+	Action            string             `json:"action"` // long | short | none (open → close)
+	Recommendation15  string             `json:"recommendation_15m"`
+	Action15          string             `json:"action_15m"` // long | short | none (09:30 → 09:45)
+}
+
+func fetchPolygonSnapshot(ticker string) (polygonSnapshot, error) {
+	var snap polygonSnapshot
+	url := fmt.Sprintf(
+		"https://api.polygon.io/v2/snapshot/locale/us/markets/stocks/tickers/%s?apiKey=%s",
+		ticker, polygon.APIKey,
+	)
+	resp, err := http.Get(url)
+	if err != nil {
+		return snap, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return snap, gapcore.NewProviderError(resp)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&snap)
+	return snap, err
+}
+
+// fetchPolygonSnapshots fetches the snapshots of several tickers in one
+// request. Tickers Polygon has no snapshot for are missing from the map.
+func fetchPolygonSnapshots(tickers []string) (map[string]polygonTickerSnapshot, error) {
+	url := fmt.Sprintf(
+		"https://api.polygon.io/v2/snapshot/locale/us/markets/stocks/tickers?tickers=%s&apiKey=%s",
+		strings.Join(tickers, ","), polygon.APIKey,
+	)
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, gapcore.NewProviderError(resp)
+	}
+	var body struct {
+		Tickers []polygonTickerSnapshot `json:"tickers"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	out := make(map[string]polygonTickerSnapshot, len(body.Tickers))
+	for _, st := range body.Tickers {
+		out[st.Ticker] = st
+	}
+	return out, nil
+}
+
+// action turns a FOLLOW/FADE call into the trade for a gap direction.
+func action(rec string, dir int) string {
+	switch {
+	case rec == "FOLLOW" && dir > 0, rec == "FADE" && dir < 0:
+		return "long"
+	case rec == "FOLLOW" && dir < 0, rec == "FADE" && dir > 0:
+		return "short"
+	}
+	return "none"
+}
+
+// todaySetup prices params.Ticker's current gap and looks it up in the
+// historical analysis. The error is a failed snapshot or daily fetch.
+func todaySetup(params gapcore.Params) (TodayResponse, error) {
+	snap, err := fetchPolygonSnapshot(params.Ticker)
+	if err != nil {
+		return newTodayResponse(params), err
+	}
+	out, err := priceGap(params, snap.Ticker)
+	if err != nil {
+		return out, err
+	}
+	resp, err := analyze(params)
+	if err != nil {
+		return out, err
+	}
+	matchBin(&out, params, resp)
+	return out, nil
+}
+
+func newTodayResponse(params gapcore.Params) TodayResponse {
+	return TodayResponse{
+		Ticker:           params.Ticker,
+		Years:            params.Years,
+		MinGap:           params.MinGap,
+		Recommendation:   "NEUTRAL",
+		Recommendation15: "NEUTRAL",
+		Action:           "none",
+		Action15:         "none",
+	}
+}
+
+// priceGap prices the gap in a ticker snapshot: the official open once the
+// session has started, the last trade (or minute) before that — streamed,
+// when the stream has a newer one.
+func priceGap(params gapcore.Params, st polygonTickerSnapshot) (TodayResponse, error) {
+	out := newTodayResponse(params)
+	out.Bid, out.Ask = streamed(&st)
+	out.PrevClose = st.PrevDay.C
+	switch {
+	case st.Day.O > 0:
+		out.Price, out.PriceSource = st.Day.O, "open"
+	case st.LastTrade.P > 0:
+		out.Price, out.PriceSource = st.LastTrade.P, "last_trade"
+	case st.Min.C > 0:
+		out.Price, out.PriceSource = st.Min.C, "last_minute"
+	}
+	if out.Price <= 0 || out.PrevClose <= 0 {
+		return out, fmt.Errorf("snapshot has no current price or previous close for %s", params.Ticker)
+	}
+	if ts := max(st.Updated, st.LastTrade.T); ts > 0 {
+		out.AsOf = gapcore.ToNY(time.Unix(0, ts)).Format(time.RFC3339)
+	}
+	gap := (out.Price - out.PrevClose) / out.PrevClose * 100
+	out.GapPct, out.Direction = gapcore.Round3(gap), gapcore.Sign(gap)
+	return out, nil
+}
+
+// matchBin fills in the bin a priced gap falls in and that bin's
+// historical stats and calls from resp.
+func matchBin(out *TodayResponse, params gapcore.Params, resp gapcore.AnalyzeResponse) {
+	gap := (out.Price - out.PrevClose) / out.PrevClose * 100
+	out.Success, out.Error = resp.Success, resp.Error
+	if math.Abs(gap) < params.MinGap {
+		out.RecommendationWhy = fmt.Sprintf("gap %.2f%% is below minGap %.2f%%", gap, params.MinGap)
+	} else {
+		out.Bin = gapcore.LabelFor(math.Abs(gap), gapcore.DefaultBins(params.MinGap))
+	}
+	for i, b := range resp.Bins {
+		if b.Label == out.Bin {
+			out.BinStats = &resp.Bins[i]
+			out.Recommendation, out.RecommendationWhy = b.Recommendation, b.RecommendationWhy
+			out.Action = action(b.Recommendation, out.Direction)
+		}
+	}
+	for i, b := range resp.Bins15 {
+		if b.Label == out.Bin {
+			out.BinStats15 = &resp.Bins15[i]
+			out.Recommendation15 = b.Recommendation
+			out.Action15 = action(b.Recommendation, out.Direction)
+		}
+	}
+}
+
+func handleToday(w http.ResponseWriter, r *http.Request) {
+	params, err := parseAnalyzeParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	out, err := todaySetup(params)
+	if err != nil {
+		writeFetchError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// ========================= Watchlist Setups =========================
+
+// watchlistSetups prices the current gaps of many tickers at once — the
+// premarket dashboard's table, the morning reports — from one snapshot
+// request. A ticker's historical bins don't change before the open, so its
+// analysis is run once per session date, on first use, and reused after;
+// unlike todaySetup's, it is not recorded as a run.
+
+var (
+	dayAnalysesMu sync.Mutex
+	dayAnalyses   = map[string]dayAnalysis{} // by ticker
+)
+
+// dayAnalysis is a ticker's historical analysis for one session date.
+type dayAnalysis struct {
+	date string
+	resp gapcore.AnalyzeResponse
+}
+
+// watchlistSetups prices tickers' gaps, largest first, returning the
+// snapshots they were priced from. The error is a failed snapshot fetch; a
+// ticker whose price or analysis fails gets a row with its error.
+func watchlistSetups(ctx context.Context, tickers []string) ([]TodayResponse, map[string]polygonTickerSnapshot, error) {
+	snaps := map[string]polygonTickerSnapshot{}
+	if len(tickers) > 0 {
+		var err error
+		if snaps, err = fetchPolygonSnapshots(tickers); err != nil {
+			return nil, nil, fmt.Errorf("snapshot: %w", err)
+		}
+	}
+	rows := make([]TodayResponse, 0, len(tickers))
+	for _, tk := range tickers {
+		out, _ := setupRow(ctx, tk, snaps, true)
+		rows = append(rows, out)
+	}
+	sortSetups(rows)
+	return rows, snaps, nil
+}
+
+// setupRow prices ticker's gap from its snapshot and matches it to the
+// day's analysis, running that analysis if need be and analyze is set. It
+// reports whether the row is complete; an incomplete one carries its error.
+func setupRow(ctx context.Context, ticker string, snaps map[string]polygonTickerSnapshot, analyze bool) (TodayResponse, bool) {
+	params, err := parseAnalyzeValues(url.Values{"ticker": {ticker}})
+	if err != nil {
+		return TodayResponse{Ticker: ticker, Error: err.Error()}, false
+	}
+	st, ok := snaps[ticker]
+	if !ok {
+		out := newTodayResponse(params)
+		out.Error = "no snapshot for " + ticker
+		return out, false
+	}
+	out, err := priceGap(params, st)
+	if err != nil {
+		out.Error = err.Error()
+		return out, false
+	}
+	today := time.Now().In(gapcore.NewYork).Format("2006-01-02")
+	dayAnalysesMu.Lock()
+	h, ok := dayAnalyses[ticker]
+	dayAnalysesMu.Unlock()
+	if !ok || h.date != today {
+		if !analyze {
+			out.Error = "no analysis yet"
+			return out, false
+		}
+		resp, err := gapcore.Analyze(ctx, provider, params, nil)
+		if err != nil {
+			out.Error = err.Error()
+			return out, false
+		}
+		h = dayAnalysis{today, resp}
+		// A partial analysis is retried next time.
+		if resp.Success {
+			dayAnalysesMu.Lock()
+			for tk, a := range dayAnalyses {
+				if a.date != today {
+					delete(dayAnalyses, tk)
+				}
+			}
+			dayAnalyses[ticker] = h
+			dayAnalysesMu.Unlock()
+		}
+	}
+	matchBin(&out, params, h.resp)
+	return out, true
+}
+
+// sortSetups orders rows largest |gap| first.
+func sortSetups(rows []TodayResponse) {
+	sort.SliceStable(rows, func(i, j int) bool { return math.Abs(rows[i].GapPct) > math.Abs(rows[j].GapPct) })
+}
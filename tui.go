@@ -0,0 +1,184 @@
+// tui.go
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Terminal UI =========================
+
+// gap-analyzer tui is the dashboard for a terminal — over SSH, say, where
+// there is no browser. Type a ticker (with any analysis parameters, as
+// "TSLA years=5 minGap=1") and it shows the summary and bin tables for one
+// horizon; h flips between daily and the first 15 minutes. It is line
+// driven, so it works in any terminal and with piped input; the screen is
+// only cleared between views when stdout is a terminal.
+
+const tuiHelp = `  TICKER [key=value …]  analyze a ticker, e.g. TSLA years=5 minGap=1
+  key=value …           change parameters and rerun
+  h                     flip between daily and 0–15m
+  daily | 15m           show that horizon
+  r                     rerun
+  q                     quit`
+
+type tuiState struct {
+	w       io.Writer
+	clear   bool
+	q       url.Values // the current analysis parameters
+	resp    *gapcore.AnalyzeResponse
+	first15 bool
+	status  string
+}
+
+func runTUICmd(args []string) error {
+	fs := paramFlagSet("tui", analyzeParamSpecs)
+	ticker := fs.String("ticker", "", "ticker to start with")
+	apiKey := fs.String("apikey", "", "Polygon.io API key (overrides .env)")
+	fs.Usage = commandUsage(fs, "tui")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	q := flagValues(fs, "apikey", "ticker")
+	if err := applyUserSettings(q); err != nil {
+		return configError(err)
+	}
+	if _, err := parseAnalyzeValues(q); err != nil {
+		return configError(err)
+	}
+	if err := setPolygonKey(*apiKey); err != nil {
+		return err
+	}
+	fi, err := os.Stdout.Stat()
+	st := &tuiState{w: os.Stdout, clear: err == nil && fi.Mode()&os.ModeCharDevice != 0, q: q}
+	if *ticker != "" {
+		st.command(*ticker)
+	} else {
+		st.status = "Type a ticker, or ? for help."
+	}
+	st.render()
+
+	in := bufio.NewScanner(os.Stdin)
+	for in.Scan() {
+		if !st.command(in.Text()) {
+			return nil
+		}
+		st.render()
+	}
+	fmt.Fprintln(st.w)
+	return in.Err()
+}
+
+// command runs one input line; false means quit.
+func (st *tuiState) command(line string) bool {
+	f := strings.Fields(line)
+	st.status = ""
+	if len(f) == 0 {
+		return true
+	}
+	switch strings.ToLower(f[0]) {
+	case "q", "quit", "exit":
+		return false
+	case "?", "help":
+		st.status = tuiHelp
+		return true
+	case "h":
+		st.first15 = !st.first15
+		return true
+	case "daily":
+		st.first15 = false
+		return true
+	case "15m":
+		st.first15 = true
+		return true
+	case "r":
+		if st.q.Get("ticker") == "" {
+			st.status = "No ticker yet."
+			return true
+		}
+		f = nil
+	}
+
+	q := url.Values{}
+	for k, v := range st.q {
+		q[k] = append([]string(nil), v...)
+	}
+	for i, tok := range f {
+		k, v, ok := strings.Cut(tok, "=")
+		switch {
+		case ok:
+			q.Set(k, v)
+		case i == 0:
+			q.Set("ticker", tok)
+		default:
+			st.status = fmt.Sprintf("%q: want key=value", tok)
+			return true
+		}
+	}
+	params, err := parseAnalyzeValues(q)
+	if err == nil && params.Ticker == "" {
+		err = fmt.Errorf("no ticker yet")
+	}
+	if err != nil {
+		st.status = err.Error()
+		return true
+	}
+	fmt.Fprintf(st.w, "Analyzing %s…\n", params.Ticker)
+	resp, err := analyze(params)
+	if err != nil {
+		st.status = err.Error()
+		return true
+	}
+	st.q, st.resp = q, &resp
+	if !resp.Success {
+		st.status = resp.Error
+	}
+	return true
+}
+
+// render draws the current view, the status line, and the prompt.
+func (st *tuiState) render() {
+	bw := bufio.NewWriter(st.w)
+	if st.clear {
+		bw.WriteString("\x1b[H\x1b[2J")
+	}
+	if r := st.resp; r != nil {
+		col, horizon, best, expected := 1, "daily (open to close)", r.Summary.BestStrategy, r.Summary.ExpectedReturn
+		if st.first15 {
+			col, horizon, best, expected = 2, "first 15 minutes (09:30–09:45)", r.Summary15.BestStrategy, r.Summary15.ExpectedReturn
+		}
+		fmt.Fprintf(bw, "%s · %s\n", r.Ticker, horizon)
+		fmt.Fprintf(bw, "%d year(s) · min gap %.2f%% · %d sessions\n\n", r.Years, r.MinGap, r.Summary.Sessions)
+		fmt.Fprintf(bw, "Recommendation: %s, expected %s per trade\n\n", best, pdfRet(expected))
+
+		var rows [][]string
+		for _, m := range reportSummaryRows(*r) {
+			if m[col] != "" {
+				rows = append(rows, []string{m[0], m[col]})
+			}
+		}
+		fmt.Fprintln(bw, "Summary")
+		writeTextTable(bw, false, []string{"", "Value"}, rows)
+
+		header, daily, first15 := reportBinRows(*r)
+		bins := daily
+		if st.first15 {
+			header[4], bins = "Fill 09:45", first15
+		}
+		fmt.Fprintln(bw, "\nBins")
+		writeTextTable(bw, false, header, bins)
+		fmt.Fprintln(bw)
+	}
+	if st.status != "" {
+		fmt.Fprintln(bw, st.status)
+	}
+	bw.WriteString("[h] horizon  [r] rerun  [?] help  [q] quit\n> ")
+	bw.Flush()
+}
@@ -0,0 +1,187 @@
+// warehouse.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Research Warehouse =========================
+
+// With WAREHOUSE_DIR set, every analysis's gap points and every backtest's
+// trades are also written as Parquet files, partitioned by ticker the way
+// DuckDB, Polars and pyarrow read Hive-style datasets:
+//
+//	WAREHOUSE_DIR/gaps/ticker=TSLA/YYYYMMDD-HHMMSS.parquet
+//	WAREHOUSE_DIR/trades/ticker=TSLA/YYYYMMDD-HHMMSS.parquet
+//
+// one file per run (New York time; -2, -3, … for runs in the same second),
+// never rewritten. Each row carries its run's time and parameters, so a
+// query picks the runs it wants, e.g. the latest per ticker. Each file is
+// written under a hidden name and then linked into place, so a query
+// running meanwhile never reads half of one. A DuckDB file would need a cgo driver; DuckDB
+// reads the directory directly instead. The ticker names a directory, so
+// one outside gapcore.StorableTicker is refused rather than written.
+
+// warehouseDir is the warehouse root; "" writes nothing.
+var warehouseDir string
+
+// warehouseGaps mirrors an analysis's points into the warehouse.
+func warehouseGaps(params gapcore.Params, resp gapcore.AnalyzeResponse) error {
+	runAt := time.Now().In(gapcore.NewYork)
+	n := len(resp.Data)
+	cols := append(runColumns(runAt, params, n), gapColumns(resp.Data)...)
+	return writeWarehouseFile("gaps", params.Ticker, runAt, cols, n)
+}
+
+// warehouseTrades mirrors a backtest's trades, every side, into the
+// warehouse; config is the backtest's rules as JSON.
+func warehouseTrades(params gapcore.Params, out gapcore.BacktestResponse) error {
+	runAt := time.Now().In(gapcore.NewYork)
+	var trades []gapcore.Trade
+	for _, res := range out.Results {
+		trades = append(trades, res.Trades...)
+	}
+	n := len(trades)
+	if n == 0 {
+		return nil
+	}
+	cfg, err := json.Marshal(out.Config)
+	if err != nil {
+		return err
+	}
+	cols := runColumns(runAt, params, n)
+	config := &parquetColumn{name: "config", typ: pqByteArray, converted: pqConvUTF8}
+	for range n {
+		config.putString(string(cfg))
+	}
+	cols = append(cols, config)
+	cols = append(cols, tradeColumns(trades)...)
+	return writeWarehouseFile("trades", params.Ticker, runAt, cols, n)
+}
+
+// runColumns repeats a run's time and lookback on each of its rows.
+func runColumns(runAt time.Time, params gapcore.Params, rows int) []*parquetColumn {
+	at := &parquetColumn{name: "run_at", typ: pqByteArray, converted: pqConvUTF8}
+	years := &parquetColumn{name: "years", typ: pqInt32, converted: pqConvNone}
+	minGap := &parquetColumn{name: "min_gap", typ: pqDouble, converted: pqConvNone}
+	for range rows {
+		at.putString(runAt.Format(time.RFC3339))
+		years.putInt32(int32(params.Years))
+		minGap.putDouble(params.MinGap)
+	}
+	return []*parquetColumn{at, years, minGap}
+}
+
+// tradeColumns lays the trades out column by column, named like the
+// trades CSV.
+func tradeColumns(trades []gapcore.Trade) []*parquetColumn {
+	col := func(name string, typ, conv int32) *parquetColumn {
+		return &parquetColumn{name: name, typ: typ, converted: conv}
+	}
+	date := col("date", pqInt32, pqConvDate)
+	side := col("side", pqByteArray, pqConvUTF8)
+	dir := col("direction", pqByteArray, pqConvUTF8)
+	gap := col("gap_pct", pqDouble, pqConvNone)
+	entryTime := col("entry_time", pqByteArray, pqConvUTF8)
+	entryPrice := col("entry_price", pqDouble, pqConvNone)
+	exitTime := col("exit_time", pqByteArray, pqConvUTF8)
+	exitPrice := col("exit_price", pqDouble, pqConvNone)
+	reason := col("exit_reason", pqByteArray, pqConvUTF8)
+	stop := col("stop_price", pqDouble, pqConvNone)
+	r := col("r_multiple", pqDouble, pqConvNone)
+	reentry := col("reentry", pqInt32, pqConvNone)
+	shares := col("shares", pqInt32, pqConvNone)
+	pnl := col("pnl", pqDouble, pqConvNone)
+	ret := col("return_pct", pqDouble, pqConvNone)
+	cost := col("cost_pct", pqDouble, pqConvNone)
+	hold := col("hold_mins", pqInt32, pqConvNone)
+	ambiguous := col("ambiguous", pqInt32, pqConvNone)
+	for _, t := range trades {
+		d, _ := time.Parse("2006-01-02", t.Date)
+		date.putInt32(int32(d.Unix() / 86400))
+		side.putString(t.Side)
+		if t.Long {
+			dir.putString("long")
+		} else {
+			dir.putString("short")
+		}
+		gap.putDouble(t.GapPct)
+		entryTime.putString(t.EntryTime)
+		entryPrice.putDouble(t.EntryPrice)
+		exitTime.putString(t.ExitTime)
+		exitPrice.putDouble(t.ExitPrice)
+		reason.putString(t.ExitReason)
+		stop.putDouble(t.StopPrice)
+		r.putDouble(t.RMultiple)
+		reentry.putInt32(int32(t.Reentry))
+		shares.putInt32(int32(t.Shares))
+		pnl.putDouble(t.PnL)
+		ret.putDouble(t.ReturnPct)
+		cost.putDouble(t.CostPct)
+		hold.putInt32(int32(t.HoldMins))
+		if t.Ambiguous {
+			ambiguous.putInt32(1)
+		} else {
+			ambiguous.putInt32(0)
+		}
+	}
+	return []*parquetColumn{date, side, dir, gap, entryTime, entryPrice, exitTime, exitPrice, reason, stop, r, reentry, shares, pnl, ret, cost, hold, ambiguous}
+}
+
+// writeWarehouseFile writes cols as the table's next file for ticker.
+func writeWarehouseFile(table, ticker string, runAt time.Time, cols []*parquetColumn, rows int) error {
+	if !gapcore.StorableTicker(ticker) {
+		return errBadTicker // never a path from an unchecked ticker
+	}
+	dir := filepath.Join(warehouseDir, table, "ticker="+ticker)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := writeParquet(tmp, cols, rows); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	base := filepath.Join(dir, runAt.Format("20060102-150405"))
+	for i := 1; ; i++ {
+		name := base + ".parquet"
+		if i > 1 {
+			name = fmt.Sprintf("%s-%d.parquet", base, i)
+		}
+		// Link rather than rename: it fails on a taken name instead of
+		// replacing that run.
+		err := os.Link(tmp.Name(), name)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	}
+}
+
+// recordBacktest mirrors a backtest into the warehouse if WAREHOUSE_DIR is
+// set, logging rather than failing the backtest when it cannot.
+func recordBacktest(params gapcore.Params, out gapcore.BacktestResponse) {
+	if warehouseDir == "" || !out.Success {
+		return
+	}
+	if err := warehouseTrades(params, out); err != nil {
+		log.Printf("warehouse: %s: %v", params.Ticker, err)
+	}
+}
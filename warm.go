@@ -0,0 +1,101 @@
+// warm.go
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Cache Warmer =========================
+
+// Every weekday at warmAt the server analyzes each ticker on any watchlist
+// with the default parameters, one at a time, so the day's new gap session
+// and anything evicted are back in the bar cache (and the bar store, when
+// BAR_STORE_DIR is set) before the next morning's first query. A session's
+// minute bars are only cached once they are final, after the extended
+// session closes at 20:00, hence the default; an earlier time warms
+// everything up to the previous session.
+
+// Post-close warm time (HH:MM New York); WARM_AT overrides it and "off"
+// disables the warmer.
+var warmAt = "20:15"
+
+// runCacheWarmer warms the watchlist tickers at warmAt every weekday.
+func runCacheWarmer() {
+	at, err := time.Parse("15:04", warmAt)
+	if err != nil {
+		log.Printf("warm: WARM_AT %q is not HH:MM; warmer disabled", warmAt)
+		return
+	}
+	for {
+		time.Sleep(time.Until(nextWeekdayAt(at, time.Now())))
+		start := time.Now()
+		warmed, failed, err := warmWatchlists(context.Background())
+		if err != nil {
+			log.Printf("warm: %v", err)
+			continue
+		}
+		for tk, e := range failed {
+			log.Printf("warm: %s: %s", tk, e)
+		}
+		log.Printf("warm: %d tickers analyzed in %s", warmed, time.Since(start).Round(time.Second))
+	}
+}
+
+// warmWatchlists analyzes every watchlist ticker, returning how many
+// succeeded and the error of each that failed.
+func warmWatchlists(ctx context.Context) (int, map[string]string, error) {
+	tickers, err := allWatchlistTickers()
+	if err != nil {
+		return 0, nil, err
+	}
+	warmed, failed := warmTickers(ctx, tickers)
+	return warmed, failed, nil
+}
+
+// warmTickers analyzes tickers with the default parameters, returning how
+// many succeeded and the error of each that failed.
+func warmTickers(ctx context.Context, tickers []string) (int, map[string]string) {
+	warmed, failed := 0, map[string]string{}
+	for _, tk := range tickers {
+		params, err := parseAnalyzeValues(url.Values{"ticker": {tk}})
+		if err != nil {
+			failed[tk] = err.Error()
+			continue
+		}
+		resp, err := gapcore.Analyze(ctx, provider, params, nil)
+		switch {
+		case err != nil:
+			failed[tk] = err.Error()
+		case !resp.Success:
+			failed[tk] = resp.Error
+		default:
+			warmed++
+		}
+	}
+	return warmed, failed
+}
+
+// ========================= Startup Precompute =========================
+
+// PRECOMPUTE_TICKERS (or serve -precompute) names core symbols to analyze
+// in the background as soon as the server starts, the same way the warmer
+// does, so the first request for one does not wait minutes for its minute
+// bars. The server answers requests meanwhile; one for a ticker still being
+// precomputed fetches whatever is not cached yet itself. Only bars are
+// cached, not responses: an analysis from cached bars takes well under a
+// second, and its daily range moves with the date anyway.
+
+// precomputeTickers analyzes tickers in the background and logs the result.
+func precomputeTickers(tickers []string) {
+	start := time.Now()
+	warmed, failed := warmTickers(context.Background(), tickers)
+	for tk, e := range failed {
+		log.Printf("precompute: %s: %s", tk, e)
+	}
+	log.Printf("precompute: %d of %d tickers ready in %s", warmed, len(tickers), time.Since(start).Round(time.Second))
+}
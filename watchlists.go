@@ -0,0 +1,259 @@
+// watchlists.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ========================= Watchlists =========================
+
+// Watchlists are named symbol lists kept in a JSON file next to the presets.
+// Endpoints that take a basket accept watchlist=NAME in place of tickers=…,
+// and the scanner can restrict its hits to a list.
+//
+// The store is one JSON file rather than SQLite, which would need a cgo or
+// third-party driver; a few hundred small lists fit in it easily. Each
+// write replaces the file whole (a temporary file, then a rename), so a
+// reader never sees half of it. Updates are read-modify-write under
+// watchlistsMu, which serializes them within one server only: two
+// processes writing the same WATCHLISTS_FILE can lose each other's
+// changes, so give each server its own.
+
+// File the watchlists are kept in; WATCHLISTS_FILE overrides it.
+var watchlistsFile = "watchlists.json"
+
+// Guards watchlistsFile between concurrent requests.
+var watchlistsMu sync.Mutex
+
+const maxWatchlistTickers = 500
+
+type Watchlist struct {
+	Name    string   `json:"name"`
+	Tickers []string `json:"tickers"`
+	Created string   `json:"created"` // RFC3339
+	Updated string   `json:"updated"` // RFC3339
+}
+
+func loadWatchlists() (map[string]Watchlist, error) {
+	out := map[string]Watchlist{}
+	b, err := os.ReadFile(watchlistsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("%s: %w", watchlistsFile, err)
+	}
+	return out, nil
+}
+
+// saveWatchlists replaces the file atomically, like savePresets.
+func saveWatchlists(all map[string]Watchlist) error {
+	b, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := watchlistsFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, watchlistsFile)
+}
+
+// allWatchlistTickers returns every ticker on any watchlist, sorted.
+func allWatchlistTickers() ([]string, error) {
+	watchlistsMu.Lock()
+	all, err := loadWatchlists()
+	watchlistsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var tickers []string
+	for _, wl := range all {
+		for _, tk := range wl.Tickers {
+			if !seen[tk] {
+				seen[tk] = true
+				tickers = append(tickers, tk)
+			}
+		}
+	}
+	sort.Strings(tickers)
+	return tickers, nil
+}
+
+// watchlistTickers returns the symbols of the named list.
+func watchlistTickers(name string) ([]string, error) {
+	watchlistsMu.Lock()
+	all, err := loadWatchlists()
+	watchlistsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	wl, ok := all[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown watchlist %q", name)
+	}
+	return wl.Tickers, nil
+}
+
+// basketParam reads the tickers of a multi-ticker request: the watchlist
+// named by watchlist=NAME, or the comma-separated tickers=… list.
+func basketParam(q url.Values, limit int) ([]string, error) {
+	name := q.Get("watchlist")
+	if name == "" {
+		return parseTickers(q.Get("tickers"), limit)
+	}
+	tickers, err := watchlistTickers(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(tickers) > limit {
+		return nil, fmt.Errorf("watchlist %q has %d tickers; at most %d here", name, len(tickers), limit)
+	}
+	return tickers, nil
+}
+
+// editTickers applies add=… and remove=… to a list, keeping its order.
+func editTickers(list []string, add, remove string) ([]string, error) {
+	drop := map[string]bool{}
+	if remove != "" {
+		rm, err := parseTickers(remove, maxWatchlistTickers)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range rm {
+			drop[t] = true
+		}
+	}
+	var out []string
+	seen := map[string]bool{}
+	for _, t := range list {
+		if !drop[t] {
+			out = append(out, t)
+			seen[t] = true
+		}
+	}
+	if add != "" {
+		more, err := parseTickers(add, maxWatchlistTickers)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range more {
+			if !seen[t] {
+				out = append(out, t)
+				seen[t] = true
+			}
+		}
+	}
+	if len(out) > maxWatchlistTickers {
+		return nil, fmt.Errorf("at most %d tickers", maxWatchlistTickers)
+	}
+	return out, nil
+}
+
+// handleWatchlists lists watchlists (GET), returns one (GET ?name=),
+// creates one from tickers=… (POST), replaces its tickers or applies
+// add=…/remove=… (PUT), or deletes one (DELETE ?name=).
+func handleWatchlists(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("name")
+	if r.Method != http.MethodGet || name != "" {
+		if !presetNameRE.MatchString(name) {
+			writeError(w, http.StatusBadRequest, "name must be 1-64 letters, digits, '.', '_' or '-'")
+			return
+		}
+	}
+	watchlistsMu.Lock()
+	defer watchlistsMu.Unlock()
+	all, err := loadWatchlists()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	wl, exists := all[name]
+	var out any
+	switch r.Method {
+	case http.MethodGet:
+		if name != "" {
+			if !exists {
+				writeError(w, http.StatusNotFound, fmt.Sprintf("unknown watchlist %q", name))
+				return
+			}
+			out = wl
+			break
+		}
+		list := make([]Watchlist, 0, len(all))
+		for _, wl := range all {
+			list = append(list, wl)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		out = list
+	case http.MethodPost:
+		if exists {
+			writeError(w, http.StatusConflict, fmt.Sprintf("watchlist %q exists; use PUT to change it", name))
+			return
+		}
+		tickers, err := parseTickers(q.Get("tickers"), maxWatchlistTickers)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		wl = Watchlist{Name: name, Tickers: tickers, Created: now, Updated: now}
+		all[name], out = wl, wl
+	case http.MethodPut:
+		if !exists {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("unknown watchlist %q", name))
+			return
+		}
+		tickers := wl.Tickers
+		if s := strings.TrimSpace(q.Get("tickers")); s != "" {
+			if tickers, err = parseTickers(s, maxWatchlistTickers); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if tickers, err = editTickers(tickers, q.Get("add"), q.Get("remove")); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(tickers) == 0 {
+			writeError(w, http.StatusBadRequest, "a watchlist needs at least one ticker")
+			return
+		}
+		wl.Tickers, wl.Updated = tickers, now
+		all[name], out = wl, wl
+	case http.MethodDelete:
+		if !exists {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("unknown watchlist %q", name))
+			return
+		}
+		delete(all, name)
+		out = map[string]string{"deleted": name}
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if r.Method != http.MethodGet {
+		if err := saveWatchlists(all); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,368 @@
+// websocket.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= WebSocket Channel =========================
+
+// /ws is a WebSocket (RFC 6455) the UI runs its analyses over: a request
+// message starts an analysis, and the server answers with progress events
+// (daily fetch, each minute-bar session, 0–15m analysis) before the result,
+// so a long minute fetch shows a progress bar instead of a frozen page. In
+// live mode the server also pushes the ticker's premarket gap (/api/today)
+// whenever it changes.
+//
+// Client → server: {"type":"analyze"|"live"|"stop", "id":..., "query":...}
+// with query in /api/gaps form ("ticker=SPY&years=3"). Server → client:
+// progress, result, premarket, and error messages carrying the same id.
+//
+// Browsers let any page open a WebSocket to localhost, so a handshake from
+// a page is only accepted from the server's own host or a CORS origin.
+// A connection runs one analysis at a time, and closing it cancels the
+// analysis, so a client that goes away stops spending API quota.
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// Largest client message accepted.
+	maxWSMessage = 1 << 16
+
+	// How often live mode re-prices the premarket gap.
+	liveInterval = 30 * time.Second
+)
+
+// WebSocket opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+type wsRequest struct {
+	Type  string `json:"type"` // analyze | live | stop
+	ID    string `json:"id,omitempty"`
+	Query string `json:"query,omitempty"`
+}
+
+type wsMessage struct {
+	Type      string                   `json:"type"` // progress | result | premarket | error
+	ID        string                   `json:"id,omitempty"`
+	Progress  *gapcore.ProgressEvent   `json:"progress,omitempty"`
+	Result    *gapcore.AnalyzeResponse `json:"result,omitempty"`
+	Premarket *TodayResponse           `json:"premarket,omitempty"`
+	Error     string                   `json:"error,omitempty"`
+}
+
+// wsConn is a WebSocket connection, the server's end unless client is set;
+// writes are serialized so analyses and live updates can share it.
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	mu   sync.Mutex
+
+	client  bool          // we dialed: frames we send are masked, frames we read are not
+	limit   int           // largest message read; 0 is maxWSMessage
+	timeout time.Duration // read deadline for each frame; 0 is none
+}
+
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// upgradeWS completes the opening handshake; on failure it has already
+// answered the request.
+func upgradeWS(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") || key == "" {
+		writeError(w, http.StatusBadRequest, "WebSocket endpoint: connect with a WebSocket client")
+		return nil, errors.New("not a WebSocket handshake")
+	}
+	if !wsOriginAllowed(r) {
+		writeError(w, http.StatusForbidden, "WebSocket origin not allowed")
+		return nil, errors.New("origin not allowed")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeError(w, http.StatusUpgradeRequired, "unsupported WebSocket version")
+		return nil, errors.New("unsupported WebSocket version")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "WebSocket upgrade not supported on this connection")
+		return nil, errors.New("connection cannot be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", wsAccept(key))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+// wsOriginAllowed reports whether a handshake may come from its Origin:
+// none (not a browser), the server's own host, or an allowed CORS origin.
+func wsOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || allowedOrigins["*"] || allowedOrigins[origin] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// writeFrame sends one unfragmented frame, masked if we are the client.
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	hdr := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		hdr = append(hdr, maskBit|byte(n))
+	case n <= 0xFFFF:
+		hdr = append(hdr, maskBit|126)
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr = append(hdr, maskBit|127)
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		hdr = append(hdr, mask[:]...)
+		masked := make([]byte, len(payload))
+		for i, b := range payload {
+			masked[i] = b ^ mask[i%4]
+		}
+		payload = masked
+	}
+	c.rw.Write(hdr)
+	c.rw.Write(payload)
+	return c.rw.Flush()
+}
+
+func (c *wsConn) send(m wsMessage) error {
+	return c.writeJSON(m)
+}
+
+func (c *wsConn) writeJSON(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsText, b)
+}
+
+// readMessage returns the next text message, answering pings on the way.
+// Close frames and protocol errors end the connection with an error.
+func (c *wsConn) readMessage() ([]byte, error) {
+	limit := uint64(maxWSMessage)
+	if c.limit > 0 {
+		limit = uint64(c.limit)
+	}
+	var msg []byte
+	for {
+		if c.timeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+		}
+		var hdr [2]byte
+		if _, err := io.ReadFull(c.rw, hdr[:]); err != nil {
+			return nil, err
+		}
+		fin, op := hdr[0]&0x80 != 0, hdr[0]&0x0F
+		masked := hdr[1]&0x80 != 0
+		switch {
+		case !c.client && !masked:
+			return nil, errors.New("client frame is not masked")
+		case c.client && masked:
+			return nil, errors.New("server frame is masked")
+		}
+		n := uint64(hdr[1] & 0x7F)
+		switch n {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+				return nil, err
+			}
+			n = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+				return nil, err
+			}
+			n = binary.BigEndian.Uint64(ext[:])
+		}
+		if n > limit || uint64(len(msg))+n > limit {
+			return nil, fmt.Errorf("message larger than %d bytes", limit)
+		}
+		var mask [4]byte
+		if masked {
+			if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+				return nil, err
+			}
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(c.rw, payload); err != nil {
+			return nil, err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+		switch op {
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			return nil, io.EOF
+		case wsText, wsContinuation:
+			msg = append(msg, payload...)
+		default:
+			return nil, fmt.Errorf("unsupported opcode %d", op)
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// wsAnalyze runs one analysis, streaming its progress, until ctx (the
+// connection's) is canceled.
+func wsAnalyze(ctx context.Context, c *wsConn, req wsRequest, params gapcore.Params) {
+	resp, err := analyzeProgress(ctx, params, func(ev gapcore.ProgressEvent) {
+		c.send(wsMessage{Type: "progress", ID: req.ID, Progress: &ev})
+	})
+	if err != nil {
+		c.send(wsMessage{Type: "error", ID: req.ID, Error: err.Error()})
+		return
+	}
+	c.send(wsMessage{Type: "result", ID: req.ID, Result: &resp})
+}
+
+// wsLive pushes params' premarket gap now and whenever its price changes,
+// until stop is closed.
+func wsLive(c *wsConn, req wsRequest, params gapcore.Params, stop <-chan struct{}) {
+	var last TodayResponse
+	tick := time.NewTicker(liveInterval)
+	defer tick.Stop()
+	for {
+		out, err := todaySetup(params)
+		switch {
+		case err != nil:
+			c.send(wsMessage{Type: "error", ID: req.ID, Error: err.Error()})
+		case out.Price != last.Price || out.PrevClose != last.PrevClose || out.AsOf != last.AsOf:
+			last = out
+			c.send(wsMessage{Type: "premarket", ID: req.ID, Premarket: &out})
+		}
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+func handleWS(w http.ResponseWriter, r *http.Request) {
+	c, err := upgradeWS(w, r)
+	if err != nil {
+		return
+	}
+	defer c.conn.Close()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()                 // stops the running analysis when the client goes
+	busy := make(chan struct{}, 1) // holds the running analysis
+
+	var live chan struct{} // closed to stop the running live feed
+	stopLive := func() {
+		if live != nil {
+			close(live)
+			live = nil
+		}
+	}
+	defer stopLive()
+
+	for {
+		b, err := c.readMessage()
+		if err != nil {
+			return
+		}
+		var req wsRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			c.send(wsMessage{Type: "error", Error: "bad message: " + err.Error()})
+			continue
+		}
+		if req.Type == "stop" {
+			stopLive()
+			continue
+		}
+		if req.Type != "analyze" && req.Type != "live" {
+			c.send(wsMessage{Type: "error", ID: req.ID, Error: fmt.Sprintf("unknown message type %q", req.Type)})
+			continue
+		}
+		q, err := url.ParseQuery(req.Query)
+		var params gapcore.Params
+		if err == nil {
+			params, err = parseAnalyzeValues(q)
+		}
+		if err == nil && params.Ticker == "" {
+			err = errors.New("ticker required")
+		}
+		if err != nil {
+			c.send(wsMessage{Type: "error", ID: req.ID, Error: err.Error()})
+			continue
+		}
+		if wait, ok := chargeRate(ctx); !ok {
+			c.send(wsMessage{Type: "error", ID: req.ID, Error: fmt.Sprintf("rate limit exceeded, retry in %ds", int(math.Ceil(wait.Seconds())))})
+			continue
+		}
+		if req.Type == "analyze" {
+			select {
+			case busy <- struct{}{}:
+			default:
+				c.send(wsMessage{Type: "error", ID: req.ID, Error: "an analysis is already running on this connection; wait for its result"})
+				continue
+			}
+			go func() {
+				defer func() { <-busy }()
+				wsAnalyze(ctx, c, req, params)
+			}()
+			continue
+		}
+		stopLive() // one live feed per connection
+		live = make(chan struct{})
+		go wsLive(c, req, params, live)
+	}
+}
@@ -0,0 +1,274 @@
+// wfopt.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Walk-Forward Optimizer =========================
+
+// The optimizer repeats the stop/target grid on a trailing training window,
+// keeps the plateau cell, and trades it only over the following step, so the
+// resulting curve never uses parameters chosen with its own trades.
+
+type WFOWindow struct {
+	TrainFrom       string  `json:"train_from"`
+	TestFrom        string  `json:"test_from"`
+	TestTo          string  `json:"test_to"` // exclusive
+	TrainTrades     int     `json:"train_trades"`
+	Stop            float64 `json:"stop"`
+	Target          float64 `json:"target"`
+	TrainExpectancy float64 `json:"train_expectancy"` // plateau neighborhood, % per trade
+	TestTrades      int     `json:"test_trades"`
+	TestReturn      float64 `json:"test_return"` // sum % over the step
+}
+
+type ParamCount struct {
+	Stop    float64 `json:"stop"`
+	Target  float64 `json:"target"`
+	Windows int     `json:"windows"`
+}
+
+// ParamStability describes how much the chosen parameters move between
+// windows; frequent jumps across the grid suggest the optimum is noise.
+type ParamStability struct {
+	Windows      int          `json:"windows"` // windows that chose parameters
+	Changes      int          `json:"changes"` // windows whose choice differs from the previous one
+	Distinct     int          `json:"distinct"`
+	ModeShare    float64      `json:"mode_share"` // % of windows on the most common pair
+	StopStdDev   float64      `json:"stop_std_dev"`
+	TargetStdDev float64      `json:"target_std_dev"`
+	Counts       []ParamCount `json:"counts"` // most common first
+}
+
+type WFOResult struct {
+	Side      string                    `json:"side"`
+	Windows   []WFOWindow               `json:"windows"`
+	Dates     []string                  `json:"dates"`
+	Equity    []float64                 `json:"equity"` // cumulative out-of-sample %
+	Drawdown  []float64                 `json:"drawdown"`
+	Episodes  []gapcore.DrawdownEpisode `json:"drawdown_episodes"`
+	Stats     gapcore.TradeStats        `json:"stats"`
+	Risk      gapcore.RiskStats         `json:"risk"`
+	Stability ParamStability            `json:"stability"`
+	InSample  GridPick                  `json:"in_sample"` // full-sample plateau, for comparison
+}
+
+type WFOResponse struct {
+	SchemaVersion schemaVersion          `json:"schema_version"`
+	Success       bool                   `json:"success"`
+	Error         string                 `json:"error,omitempty"`
+	Ticker        string                 `json:"ticker"`
+	Years         int                    `json:"years"`
+	MinGap        float64                `json:"min_gap"`
+	Config        gapcore.BacktestConfig `json:"config"`
+	Stops         []float64              `json:"stops"`
+	Targets       []float64              `json:"targets"`
+	TrainMonths   int                    `json:"train_months"`
+	StepMonths    int                    `json:"step_months"`
+	Skipped       int                    `json:"skipped"`
+	Results       []WFOResult            `json:"results"`
+}
+
+// gridExpectancy builds the [stop][target] expectancy matrix from the trades
+// of each cell that fall in [from, to).
+func gridExpectancy(cells [][][]gapcore.Trade, from, to string) ([][]float64, int) {
+	exp := make([][]float64, len(cells))
+	n := 0
+	for i, row := range cells {
+		exp[i] = make([]float64, len(row))
+		for j, trades := range row {
+			var rets []float64
+			for _, t := range trades {
+				if t.Date >= from && t.Date < to {
+					rets = append(rets, t.ReturnPct)
+				}
+			}
+			exp[i][j] = gapcore.TradeStatsOf(rets).Expectancy
+			n = max(n, len(rets))
+		}
+	}
+	return exp, n
+}
+
+func paramStability(wins []WFOWindow) ParamStability {
+	var st ParamStability
+	counts := map[[2]float64]int{}
+	var stops, targets []float64
+	var prev [2]float64
+	for _, w := range wins {
+		if w.TrainTrades < gapcore.MinRecSessions {
+			continue // too little history to choose
+		}
+		k := [2]float64{w.Stop, w.Target}
+		if st.Windows > 0 && k != prev {
+			st.Changes++
+		}
+		prev = k
+		st.Windows++
+		counts[k]++
+		stops = append(stops, w.Stop)
+		targets = append(targets, w.Target)
+	}
+	for k, c := range counts {
+		st.Counts = append(st.Counts, ParamCount{Stop: k[0], Target: k[1], Windows: c})
+	}
+	sort.Slice(st.Counts, func(i, j int) bool {
+		a, b := st.Counts[i], st.Counts[j]
+		if a.Windows != b.Windows {
+			return a.Windows > b.Windows
+		}
+		if a.Stop != b.Stop {
+			return a.Stop < b.Stop
+		}
+		return a.Target < b.Target
+	})
+	st.Distinct = len(st.Counts)
+	if st.Distinct > 0 {
+		st.ModeShare = gapcore.Rate(st.Counts[0].Windows, st.Windows)
+	}
+	_, sd := gapcore.MeanStd(stops)
+	st.StopStdDev = gapcore.Round3(sd)
+	_, sd = gapcore.MeanStd(targets)
+	st.TargetStdDev = gapcore.Round3(sd)
+	return st
+}
+
+// runWFO walks the grid forward for each configured side.
+func runWFO(points []gapcore.GapPoint, minutesByDate map[string][]gapcore.Bar, atr map[string]float64, cfg gapcore.BacktestConfig, stops, targets []float64, trainMonths, stepMonths int) ([]WFOResult, int) {
+	sessions, skipped := gapcore.SessionBars(points, minutesByDate, atr)
+	var results []WFOResult
+	if len(points) == 0 {
+		return results, skipped
+	}
+	first, _ := time.Parse("2006-01-02", points[0].Date)
+	last, _ := time.Parse("2006-01-02", points[len(points)-1].Date)
+
+	for _, side := range cfg.Sides {
+		// Every cell is simulated once over the whole sample.
+		cells := make([][][]gapcore.Trade, len(stops))
+		for i, st := range stops {
+			cells[i] = make([][]gapcore.Trade, len(targets))
+			for j, tg := range targets {
+				c := cfg
+				c.Stop, c.Target = st, tg
+				cells[i][j], _ = gapcore.SimulateSide(points, sessions, c, side)
+			}
+		}
+		res := WFOResult{Side: side}
+		full, _ := gridExpectancy(cells, "", "9999")
+		_, res.InSample = gridPicks(full, stops, targets)
+
+		var rets []float64
+		var cum float64
+		for testFrom := first.AddDate(0, trainMonths, 0); !testFrom.After(last); testFrom = testFrom.AddDate(0, stepMonths, 0) {
+			trainFrom := testFrom.AddDate(0, -trainMonths, 0)
+			testTo := testFrom.AddDate(0, stepMonths, 0)
+			w := WFOWindow{
+				TrainFrom: trainFrom.Format("2006-01-02"),
+				TestFrom:  testFrom.Format("2006-01-02"),
+				TestTo:    testTo.Format("2006-01-02"),
+			}
+			exp, n := gridExpectancy(cells, w.TrainFrom, w.TestFrom)
+			w.TrainTrades = n
+			if n < gapcore.MinRecSessions {
+				res.Windows = append(res.Windows, w)
+				continue
+			}
+			_, pick := gridPicks(exp, stops, targets)
+			w.Stop, w.Target, w.TrainExpectancy = pick.Stop, pick.Target, pick.Neighborhood
+			for _, t := range cells[indexOf(stops, pick.Stop)][indexOf(targets, pick.Target)] {
+				if t.Date < w.TestFrom || t.Date >= w.TestTo {
+					continue
+				}
+				w.TestTrades++
+				w.TestReturn += t.ReturnPct
+				cum += t.ReturnPct
+				rets = append(rets, t.ReturnPct)
+				res.Dates = append(res.Dates, t.Date)
+				res.Equity = append(res.Equity, gapcore.Round3(cum))
+			}
+			w.TestReturn = gapcore.Round3(w.TestReturn)
+			res.Windows = append(res.Windows, w)
+		}
+		res.Stats = gapcore.TradeStatsOf(rets)
+		res.Risk = gapcore.RiskStatsOf(rets, res.Dates)
+		res.Drawdown, res.Episodes = gapcore.Drawdowns(res.Equity, res.Dates, 0)
+		res.Stability = paramStability(res.Windows)
+		results = append(results, res)
+	}
+	return results, skipped
+}
+
+func indexOf(xs []float64, x float64) int {
+	for i, v := range xs {
+		if v == x {
+			return i
+		}
+	}
+	return -1
+}
+
+func handleBacktestWFO(w http.ResponseWriter, r *http.Request) {
+	if err := applyPreset(r); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	params, err := parseAnalyzeParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	cfg, err := gapcore.ParseBacktestConfig(q, htbTickers)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cfg.Costs = params.Costs
+	stops, err := parseGridAxis(q, "stops", cfg.Units)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	targets, err := parseGridAxis(q, "targets", cfg.Units)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if params.TrainMonths >= params.Years*12 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("trainMonths must be shorter than the %d-year sample", params.Years))
+		return
+	}
+	points, minutesByDate, atr, analysisErr, err := gapcore.BacktestInputs(context.Background(), provider, params)
+	if err != nil {
+		writeFetchError(w, err)
+		return
+	}
+	cfg.Stop, cfg.Target = 0, 0
+	cfg.StopPremarket, cfg.TargetFill = false, false
+	out := WFOResponse{
+		Success:     analysisErr == "",
+		Error:       analysisErr,
+		Ticker:      params.Ticker,
+		Years:       params.Years,
+		MinGap:      params.MinGap,
+		Config:      cfg,
+		Stops:       stops,
+		Targets:     targets,
+		TrainMonths: params.TrainMonths,
+		StepMonths:  params.StepMonths,
+	}
+	if out.Success {
+		out.Results, out.Skipped = runWFO(points, minutesByDate, atr, cfg, stops, targets, params.TrainMonths, params.StepMonths)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,222 @@
+// xlsx.go
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"gap-analyzer/gapcore"
+)
+
+// ========================= Excel Export =========================
+
+// format=xlsx writes the /api/gaps analysis as a workbook: Summary, Bins,
+// DOW, 0–15m, and Points sheets. The file is assembled directly from its
+// SpreadsheetML parts (no shared strings, three cell styles), which is all
+// Excel, LibreOffice and pandas need.
+
+// Cell values: string, int, or float64. Floats get three decimals.
+type xlsxSheet struct {
+	name string
+	rows [][]any
+}
+
+// Cell style indexes into styles.xml cellXfs.
+const (
+	xlsxStyleDefault = 0
+	xlsxStyleDecimal = 1
+	xlsxStyleHeader  = 2
+)
+
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="1"><numFmt numFmtId="164" formatCode="0.000"/></numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>
+</styleSheet>`
+
+// xlsxColumn converts a 0-based column index to its letters (0 → A, 26 → AA).
+func xlsxColumn(i int) string {
+	s := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		s = string(rune('A'+(i-1)%26)) + s
+	}
+	return s
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// writeSheetXML renders one worksheet; the first row is a bold header.
+func writeSheetXML(w io.Writer, rows [][]any) {
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+"\n")
+	fmt.Fprint(w, `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	fmt.Fprint(w, `<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews><sheetData>`)
+	for r, row := range rows {
+		fmt.Fprintf(w, `<row r="%d">`, r+1)
+		for c, v := range row {
+			ref := xlsxColumn(c) + strconv.Itoa(r+1)
+			switch x := v.(type) {
+			case nil:
+			case int:
+				fmt.Fprintf(w, `<c r="%s"><v>%d</v></c>`, ref, x)
+			case float64:
+				fmt.Fprintf(w, `<c r="%s" s="%d"><v>%s</v></c>`, ref, xlsxStyleDecimal, num(x))
+			default:
+				style := xlsxStyleDefault
+				if r == 0 {
+					style = xlsxStyleHeader
+				}
+				fmt.Fprintf(w, `<c r="%s" t="inlineStr" s="%d"><is><t>%s</t></is></c>`, ref, style, xmlEscape(fmt.Sprint(x)))
+			}
+		}
+		fmt.Fprint(w, `</row>`)
+	}
+	fmt.Fprint(w, `</sheetData></worksheet>`)
+}
+
+// writeXLSX writes sheets as a workbook to w.
+func writeXLSX(w io.Writer, sheets []xlsxSheet) error {
+	zw := zip.NewWriter(w)
+	part := func(name, body string) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(f, body)
+		return err
+	}
+	var types, books, rels bytes.Buffer
+	for i, sh := range sheets {
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+		fmt.Fprintf(&books, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(sh.name), i+1, i+1)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+	}
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(sheets)+1)
+	const head = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", head + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			types.String() + `</Types>`},
+		{"_rels/.rels", head + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", head + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` +
+			books.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", head + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + rels.String() + `</Relationships>`},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, p := range parts {
+		if err := part(p.name, p.body); err != nil {
+			return err
+		}
+	}
+	for i, sh := range sheets {
+		f, err := zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1))
+		if err != nil {
+			return err
+		}
+		writeSheetXML(f, sh.rows)
+	}
+	return zw.Close()
+}
+
+// cellValue turns a CSV field back into a number where it is one.
+func cellValue(s string) any {
+	if i, err := strconv.Atoi(s); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func dowRows(by map[string]gapcore.DowStat) [][]any {
+	rows := [][]any{{"dow", "count", "continuation_rate", "ci_low", "ci_high", "continuation_rate_shrunk", "fade_avg", "follow_avg"}}
+	for _, d := range gapcore.Weekdays {
+		s := by[d]
+		rows = append(rows, []any{d, s.Count, s.ContinuationRate, s.ContinuationCI.Low, s.ContinuationCI.High, s.ContinuationShrunk, s.FadeAvg, s.FollowAvg})
+	}
+	return rows
+}
+
+// workbookSheets lays resp out as the export's sheets.
+func workbookSheets(resp gapcore.AnalyzeResponse) []xlsxSheet {
+	summary := [][]any{{"metric", "daily", "first_15m"}}
+	for _, r := range summaryRows(resp) {
+		summary = append(summary, []any{r[0], cellValue(r[1]), cellValue(r[2])})
+	}
+
+	bins := [][]any{{"bin", "count", "continuation_rate", "ci_low", "ci_high", "continuation_rate_shrunk", "gap_fill_rate",
+		"fade_avg", "follow_avg", "fade_win_rate", "follow_win_rate", "recommendation", "why"}}
+	for _, b := range resp.Bins {
+		bins = append(bins, []any{b.Label, b.Count, b.ContinuationRate, b.ContinuationCI.Low, b.ContinuationCI.High, b.ContinuationShrunk,
+			b.GapFillRate, b.FadeAvg, b.FollowAvg, b.FadeStats.WinRate, b.FollowStats.WinRate, b.Recommendation, b.RecommendationWhy})
+	}
+
+	first15 := [][]any{{"bin", "count", "continuation_rate", "ci_low", "ci_high", "continuation_rate_shrunk", "gap_fill_by_0945_rate",
+		"fade_avg", "follow_avg", "fade_win_rate", "follow_win_rate", "recommendation", "why"}}
+	for _, b := range resp.Bins15 {
+		first15 = append(first15, []any{b.Label, b.Count, b.ContinuationRate, b.ContinuationCI.Low, b.ContinuationCI.High, b.ContinuationShrunk,
+			b.GapFillBy0945Rate, b.FadeAvg, b.FollowAvg, b.FadeStats.WinRate, b.FollowStats.WinRate, b.Recommendation, b.RecommendationWhy})
+	}
+	first15 = append(first15, nil)
+	first15 = append(first15, dowRows(resp.ByDOW15)...)
+
+	points := [][]any{make([]any, len(gapCSVHeader))}
+	for i, h := range gapCSVHeader {
+		points[0][i] = h
+	}
+	for _, p := range resp.Data {
+		row := gapCSVRow(p)
+		cells := make([]any, len(row))
+		for i, v := range row {
+			cells[i] = v
+			if i != 0 { // dates stay text
+				cells[i] = cellValue(v)
+			}
+		}
+		points = append(points, cells)
+	}
+
+	return []xlsxSheet{
+		{"Summary", summary},
+		{"Bins", bins},
+		{"DOW", dowRows(resp.ByDOW)},
+		{"0–15m", first15},
+		{"Points", points},
+	}
+}
+
+// writeGapsXLSX sends resp as a workbook download.
+func writeGapsXLSX(w http.ResponseWriter, resp gapcore.AnalyzeResponse) {
+	if !resp.Success && len(resp.Data) == 0 {
+		writeError(w, 502, resp.Error)
+		return
+	}
+	var buf bytes.Buffer
+	if err := writeXLSX(&buf, workbookSheets(resp)); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s_gaps.xlsx"`, resp.Ticker))
+	w.Write(buf.Bytes())
+}